@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	_ "github.com/Scorzoner/effective-mobile-test/docs"
 	"github.com/Scorzoner/effective-mobile-test/internal/server"
 )
@@ -11,5 +13,11 @@ import (
 // @host		localhost:8080
 // @BasePath	/
 func main() {
+	// music-library import [flags] <file> imports songs instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		server.Import(os.Args[2:])
+		return
+	}
+
 	server.Run()
 }
@@ -1,19 +1,24 @@
 package badresponses
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
 	"github.com/Scorzoner/effective-mobile-test/internal/logger"
 )
 
+// Non-standard status (nginx convention) for requests whose client went away before getting a response
+const StatusClientClosedRequest = 499
+
 func SendBadResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	errors := map[string]any{"errors": message}
 	finalMessage := fmt.Errorf("encountered errors while processing %s %s request: %v",
 		r.Method, r.URL.String(), message)
 	switch status {
-	case http.StatusInternalServerError:
+	case http.StatusInternalServerError, http.StatusServiceUnavailable:
 		logger.Zap.Error(finalMessage)
 	default:
 		logger.Zap.Debug(finalMessage)
@@ -35,6 +40,11 @@ func NotFoundResponse(w http.ResponseWriter, r *http.Request) {
 	SendBadResponse(w, r, http.StatusNotFound, message)
 }
 
+// Unlike [NotFoundResponse] (unknown route) reports a missing record of an existing resource
+func ResourceNotFoundResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusNotFound, fmt.Sprintf("%v", message))
+}
+
 func FailedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	SendBadResponse(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("%v", errors))
 }
@@ -44,6 +54,61 @@ func MethodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	SendBadResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
+func NotAcceptableResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusNotAcceptable, fmt.Sprintf("%v", message))
+}
+
+func UnsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusUnsupportedMediaType, fmt.Sprintf("%v", message))
+}
+
+func ConflictResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusConflict, fmt.Sprintf("%v", message))
+}
+
+func PreconditionFailedResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusPreconditionFailed, fmt.Sprintf("%v", message))
+}
+
+func BadGatewayResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusBadGateway, fmt.Sprintf("%v", message))
+}
+
 func InternalServerErrorResponse(w http.ResponseWriter, r *http.Request, message any) {
 	SendBadResponse(w, r, http.StatusInternalServerError, fmt.Sprintf("%v", message))
 }
+
+func ServiceUnavailableResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, http.StatusServiceUnavailable, fmt.Sprintf("%v", message))
+}
+
+func ClientClosedRequestResponse(w http.ResponseWriter, r *http.Request, message any) {
+	SendBadResponse(w, r, StatusClientClosedRequest, fmt.Sprintf("%v", message))
+}
+
+// Picks response status for errors returned by database queries:
+// duplicates, trashed songs, groups that still have albums or songs, songs kept by playlists and taken idempotency keys
+// are reported as 409, stale versions as 412, timeouts as 503, canceled requests as 499, everything else as 500
+func DatabaseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrSongAlreadyExists), errors.Is(err, database.ErrSongTrashed),
+		errors.Is(err, database.ErrGroupAlreadyExists), errors.Is(err, database.ErrGroupNotEmpty),
+		errors.Is(err, database.ErrAlbumAlreadyExists), errors.Is(err, database.ErrTrackAlreadyExists),
+		errors.Is(err, database.ErrPlaylistAlreadyExists), errors.Is(err, database.ErrSongInPlaylist),
+		errors.Is(err, database.ErrIdempotencyKeyExists):
+		return http.StatusConflict
+	case errors.Is(err, database.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, database.ErrQueryTimeout):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, database.ErrQueryCanceled):
+		return StatusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Responds to errors returned by database queries with status picked by [DatabaseErrorStatus]
+func DatabaseErrorResponse(w http.ResponseWriter, r *http.Request, message string, err error) {
+	SendBadResponse(w, r, DatabaseErrorStatus(err), fmt.Sprintf("%s: %s", message, err.Error()))
+}
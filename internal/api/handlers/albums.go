@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func newAlbumResult(album *models.Album, tracks []models.AlbumTrack) AlbumResult {
+	res := AlbumResult{
+		Id:        album.Id,
+		GroupId:   album.GroupId,
+		GroupName: album.GroupName,
+		Title:     album.Title,
+		CreatedAt: album.CreatedAt,
+		Tracks:    []AlbumTrackResult{},
+	}
+	if album.ReleaseDate.Valid {
+		res.ReleaseDate = time.Time.Format(album.ReleaseDate.Time, "02.01.2006")
+	}
+	if album.CoverLink.Valid {
+		res.Cover = album.CoverLink.String
+	}
+	for _, track := range tracks {
+		res.Tracks = append(res.Tracks, AlbumTrackResult{Position: track.Position, Song: newListRowResult(&track.Song)})
+	}
+	return res
+}
+
+// Responds with the album and its tracks, status is 200 unless given
+func (hq *HandleQueries) writeAlbum(w http.ResponseWriter, r *http.Request, albumId int64, status int) {
+	var (
+		album  *models.Album
+		tracks []models.AlbumTrack
+	)
+	// album and tracks are read in one transaction so that they match
+	err := hq.q.WithTx(r.Context(), func(tx database.SongStore) error {
+		var err error
+		album, err = tx.GetAlbum(r.Context(), albumId)
+		if err != nil {
+			return err
+		}
+		tracks, err = tx.GetAlbumTracks(r.Context(), albumId)
+		return err
+	})
+	if err == database.ErrAlbumNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get album: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get album", err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, status, newAlbumResult(album, tracks), nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches album with its tracks
+// @Tags			albums
+// @Description	Tracks are ordered by position, tracks of songs in trash are left out but keep their positions
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"album id"
+// @Success		200	{object}	AlbumResult
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/albums/{id} [get]
+func (hq *HandleQueries) GetAlbum(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	albumId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hq.writeAlbum(w, r, albumId, http.StatusOK)
+}
+
+// @Summary		Adds new album
+// @Tags			albums
+// @Description	Album titles are unique within a group, release date has DD.MM.YYYY format,
+// @Description	both release date and cover are optional. Returns the created album without tracks
+// @Accept			json
+// @Produce		json
+// @Param			AlbumRequestJSON	body		AlbumRequestJSON	true	"album info"
+// @Success		201					{object}	AlbumResult
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		404					{object}	models.ErrorResponse
+// @Failure		409					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/albums [post]
+func (hq *HandleQueries) AddAlbum(w http.ResponseWriter, r *http.Request) {
+	var requestJSON AlbumRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to add album: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	v.check(requestJSON.GroupId > 0, "groupId", "should be a positive id")
+	v.check(len(requestJSON.Title) > 0, "title", "should be provided")
+	v.check(len(requestJSON.Title) <= hq.cfg.MaxSongNameLen, "title",
+		fmt.Sprintf("should be no more than %v characters long, current length %v",
+			hq.cfg.MaxSongNameLen, len(requestJSON.Title)))
+	v.check(len(requestJSON.Cover) <= hq.cfg.MaxSongLinkLen, "cover",
+		fmt.Sprintf("should be no more than %v characters long, current length %v",
+			hq.cfg.MaxSongLinkLen, len(requestJSON.Cover)))
+	album := models.Album{
+		GroupId:   requestJSON.GroupId,
+		Title:     requestJSON.Title,
+		CoverLink: sql.NullString{String: requestJSON.Cover, Valid: requestJSON.Cover != ""},
+	}
+	if requestJSON.ReleaseDate != "" {
+		album.ReleaseDate.Time = convertAndValidateStringToDate(v, requestJSON.ReleaseDate, "releaseDate")
+		album.ReleaseDate.Valid = true
+	}
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.AddAlbum(r.Context(), &album)
+	if err == database.ErrGroupNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to add album: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to add album", err)
+		return
+	}
+
+	hq.writeAlbum(w, r, album.Id, http.StatusCreated)
+}
+
+// @Summary		Deletes album
+// @Tags			albums
+// @Description	Songs of the album stay in the library, returns provided id on success
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"album id"
+// @Success		200	{object}	models.IdResponse
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/albums/{id} [delete]
+func (hq *HandleQueries) DeleteAlbum(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	albumId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.DeleteAlbum(r.Context(), albumId)
+	if err == database.ErrAlbumNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to delete album: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to delete album", err)
+		return
+	}
+
+	result := map[string]any{"id": albumId}
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Adds song to album
+// @Tags			albums
+// @Description	Song is put at the given position and the following tracks move down, without position it's appended.
+// @Description	A song can be on several albums but only once on each of them. Returns the album with its tracks
+// @Accept			json
+// @Produce		json
+// @Param			id						path		int						true	"album id"
+// @Param			AlbumTrackRequestJSON	body		AlbumTrackRequestJSON	true	"song and its position"
+// @Success		200						{object}	AlbumResult
+// @Failure		400						{object}	models.ErrorResponse
+// @Failure		404						{object}	models.ErrorResponse
+// @Failure		409						{object}	models.ErrorResponse
+// @Failure		422						{object}	models.ErrorResponse
+// @Failure		500						{object}	models.ErrorResponse
+// @Failure		503						{object}	models.ErrorResponse
+// @Router			/music-library/albums/{id}/tracks [post]
+func (hq *HandleQueries) AddAlbumTrack(w http.ResponseWriter, r *http.Request) {
+	var requestJSON AlbumTrackRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to add track: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	albumId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	v.check(requestJSON.SongId > 0, "songId", "should be a positive id")
+	v.check(requestJSON.Position >= 0, "position", "should be positive")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.AddAlbumTrack(r.Context(), albumId, requestJSON.SongId, requestJSON.Position)
+	if err == database.ErrAlbumNotFound || err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to add track: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to add track", err)
+		return
+	}
+
+	hq.writeAlbum(w, r, albumId, http.StatusOK)
+}
+
+// @Summary		Reorders album tracks
+// @Tags			albums
+// @Description	songs lists every track of the album (as shown by GET /music-library/albums/{id}) once, in the new order.
+// @Description	Tracks of songs in trash keep their place after the listed ones. Returns the album with its tracks
+// @Accept			json
+// @Produce		json
+// @Param			id							path		int							true	"album id"
+// @Param			ReorderTracksRequestJSON	body		ReorderTracksRequestJSON	true	"song ids in new order"
+// @Success		200							{object}	AlbumResult
+// @Failure		400							{object}	models.ErrorResponse
+// @Failure		404							{object}	models.ErrorResponse
+// @Failure		422							{object}	models.ErrorResponse
+// @Failure		500							{object}	models.ErrorResponse
+// @Failure		503							{object}	models.ErrorResponse
+// @Router			/music-library/albums/{id}/tracks [put]
+func (hq *HandleQueries) ReorderAlbumTracks(w http.ResponseWriter, r *http.Request) {
+	var requestJSON ReorderTracksRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to reorder tracks: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	albumId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	v.check(requestJSON.Songs != nil, "songs", "should be provided")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.ReorderAlbumTracks(r.Context(), albumId, requestJSON.Songs)
+	if err == database.ErrAlbumNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to reorder tracks: %s", err.Error()))
+		return
+	}
+	if err == database.ErrInvalidTrackOrder {
+		v.addError("songs", err.Error())
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to reorder tracks", err)
+		return
+	}
+
+	hq.writeAlbum(w, r, albumId, http.StatusOK)
+}
+
+// @Summary		Removes song from album
+// @Tags			albums
+// @Description	The following tracks move up, the song stays in the library. Returns the album with its tracks
+// @Accept			plain
+// @Produce		json
+// @Param			id		path		int	true	"album id"
+// @Param			songId	path		int	true	"song id"
+// @Success		200		{object}	AlbumResult
+// @Failure		404		{object}	models.ErrorResponse
+// @Failure		422		{object}	models.ErrorResponse
+// @Failure		500		{object}	models.ErrorResponse
+// @Failure		503		{object}	models.ErrorResponse
+// @Router			/music-library/albums/{id}/tracks/{songId} [delete]
+func (hq *HandleQueries) RemoveAlbumTrack(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	albumId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "songId"), "songId")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.RemoveAlbumTrack(r.Context(), albumId, songId)
+	if err == database.ErrAlbumNotFound || err == database.ErrTrackNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to remove track: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to remove track", err)
+		return
+	}
+
+	hq.writeAlbum(w, r, albumId, http.StatusOK)
+}
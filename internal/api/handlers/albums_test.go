@@ -0,0 +1,147 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+)
+
+func decodeAlbum(t *testing.T, w *httptest.ResponseRecorder) handlers.AlbumResult {
+	t.Helper()
+	var album handlers.AlbumResult
+	decode(t, w, &album)
+	return album
+}
+
+// Song ids of album tracks in their order, positions should follow the order
+func trackIds(t *testing.T, album handlers.AlbumResult) []int64 {
+	t.Helper()
+	var ids []int64
+	for i, track := range album.Tracks {
+		if i > 0 && track.Position <= album.Tracks[i-1].Position {
+			t.Fatalf("got tracks %+v, want them ordered by position", album.Tracks)
+		}
+		ids = append(ids, int64(track.Song.Id))
+	}
+	return ids
+}
+
+// Adds Origin of Symmetry album of Muse with the songs, returns album id and song ids
+func (api *testAPI) addAlbum(songs ...string) (int64, []int64) {
+	api.t.Helper()
+	ids := api.addSongs("Muse", songs...)
+	group := api.getSong(ids[0]).GroupId
+
+	w := api.expect(http.StatusCreated, http.MethodPost, "/music-library/albums",
+		fmt.Sprintf(`{"groupId":%d,"title":"Origin of Symmetry","releaseDate":"17.07.2001","cover":"https://example.com/oos.jpg"}`, group))
+	album := decodeAlbum(api.t, w)
+	for _, id := range ids {
+		api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/albums/%d/tracks", album.Id), fmt.Sprintf(`{"songId":%d}`, id))
+	}
+	return album.Id, ids
+}
+
+func TestAlbumCRUD(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "New Born", "Bliss")
+	group := api.getSong(ids[0]).GroupId
+
+	w := api.expect(http.StatusCreated, http.MethodPost, "/music-library/albums",
+		fmt.Sprintf(`{"groupId":%d,"title":"Origin of Symmetry","releaseDate":"17.07.2001"}`, group))
+	album := decodeAlbum(t, w)
+	if album.GroupName != "Muse" || album.Title != "Origin of Symmetry" || album.ReleaseDate != "17.07.2001" ||
+		album.Cover != "" || len(album.Tracks) != 0 {
+		t.Errorf("got album %+v, want Origin of Symmetry without tracks", album)
+	}
+
+	// titles are unique within a group only
+	body := fmt.Sprintf(`{"groupId":%d,"title":"Origin of Symmetry"}`, group)
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/albums", body)
+	placebo := api.addGroup("Placebo")
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/albums", fmt.Sprintf(`{"groupId":%d,"title":"Origin of Symmetry"}`, placebo.Id))
+	api.expect(http.StatusNotFound, http.MethodPost, "/music-library/albums", fmt.Sprintf(`{"groupId":%d,"title":"Absolution"}`, placebo.Id+10))
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/albums",
+		fmt.Sprintf(`{"groupId":%d,"title":"Absolution","releaseDate":"2003-09-15"}`, group))
+
+	api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/albums/%d/tracks", album.Id), fmt.Sprintf(`{"songId":%d}`, ids[0]))
+	w = api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/albums/%d", album.Id), "")
+	if got := decodeAlbum(t, w); len(got.Tracks) != 1 || got.Tracks[0].Song.SongName != "New Born" || got.Tracks[0].Position != 1 {
+		t.Errorf("got album %+v, want New Born as its first track", got)
+	}
+
+	// group with albums can't be deleted, even without songs
+	api.expect(http.StatusConflict, http.MethodDelete, fmt.Sprintf("/music-library/groups/%d", placebo.Id), "")
+
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/albums/%d", album.Id), "")
+	api.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/music-library/albums/%d", album.Id), "")
+	api.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/music-library/albums/%d", album.Id), "")
+	// songs of deleted album stay in the library
+	api.getSong(ids[0])
+}
+
+func TestAlbumTracks(t *testing.T) {
+	api := newTestAPI(t)
+	albumId, ids := api.addAlbum("New Born", "Bliss", "Space Dementia")
+	tracks := fmt.Sprintf("/music-library/albums/%d/tracks", albumId)
+
+	hyperMusic := api.addSongs("Muse", "Hyper Music")[0]
+	w := api.expect(http.StatusOK, http.MethodPost, tracks, fmt.Sprintf(`{"songId":%d,"position":2}`, hyperMusic))
+	if got, want := trackIds(t, decodeAlbum(t, w)), []int64{ids[0], hyperMusic, ids[1], ids[2]}; !slices.Equal(got, want) {
+		t.Errorf("got tracks %v, want %v", got, want)
+	}
+
+	api.expect(http.StatusConflict, http.MethodPost, tracks, fmt.Sprintf(`{"songId":%d}`, hyperMusic))
+	api.expect(http.StatusNotFound, http.MethodPost, tracks, fmt.Sprintf(`{"songId":%d}`, hyperMusic+10))
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, tracks, fmt.Sprintf(`{"songId":%d,"position":-1}`, hyperMusic))
+
+	w = api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("%s/%d", tracks, ids[1]), "")
+	if got, want := trackIds(t, decodeAlbum(t, w)), []int64{ids[0], hyperMusic, ids[2]}; !slices.Equal(got, want) {
+		t.Errorf("got tracks %v, want %v", got, want)
+	}
+	api.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("%s/%d", tracks, ids[1]), "")
+	// removed song stays in the library
+	api.getSong(ids[1])
+}
+
+func TestReorderAlbumTracks(t *testing.T) {
+	api := newTestAPI(t)
+	albumId, ids := api.addAlbum("New Born", "Bliss", "Space Dementia", "Hyper Music")
+	tracks := fmt.Sprintf("/music-library/albums/%d/tracks", albumId)
+
+	w := api.expect(http.StatusOK, http.MethodPut, tracks, fmt.Sprintf(`{"songs":[%d,%d,%d,%d]}`, ids[3], ids[1], ids[0], ids[2]))
+	album := decodeAlbum(t, w)
+	if got, want := trackIds(t, album), []int64{ids[3], ids[1], ids[0], ids[2]}; !slices.Equal(got, want) {
+		t.Errorf("got tracks %v, want %v", got, want)
+	}
+	for i, track := range album.Tracks {
+		if track.Position != i+1 {
+			t.Errorf("got track %d at position %d, want %d", track.Song.Id, track.Position, i+1)
+		}
+	}
+
+	// trashed songs are hidden but keep their tracks, which go last when tracks are reordered
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", ids[1]), "")
+	w = api.expect(http.StatusOK, http.MethodPut, tracks, fmt.Sprintf(`{"songs":[%d,%d,%d]}`, ids[0], ids[2], ids[3]))
+	if got, want := trackIds(t, decodeAlbum(t, w)), []int64{ids[0], ids[2], ids[3]}; !slices.Equal(got, want) {
+		t.Errorf("got tracks %v, want %v", got, want)
+	}
+	api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/trash/%d/restore", ids[1]), "")
+	w = api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/albums/%d", albumId), "")
+	if got, want := trackIds(t, decodeAlbum(t, w)), []int64{ids[0], ids[2], ids[3], ids[1]}; !slices.Equal(got, want) {
+		t.Errorf("got tracks %v after restore, want %v", got, want)
+	}
+
+	for _, songs := range []string{
+		fmt.Sprintf(`[%d,%d,%d]`, ids[0], ids[1], ids[2]),
+		fmt.Sprintf(`[%d,%d,%d,%d,%d]`, ids[0], ids[1], ids[2], ids[3], ids[3]),
+		fmt.Sprintf(`[%d,%d,%d,%d]`, ids[0], ids[1], ids[2], ids[3]+10),
+	} {
+		api.expect(http.StatusUnprocessableEntity, http.MethodPut, tracks, `{"songs":`+songs+`}`)
+	}
+	api.expect(http.StatusUnprocessableEntity, http.MethodPut, tracks, `{}`)
+	api.expect(http.StatusNotFound, http.MethodPut, fmt.Sprintf("/music-library/albums/%d/tracks", albumId+1), `{"songs":[]}`)
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Modes of batches, atomic batch is rolled back if any operation fails,
+// failed operations of best effort batch are rolled back one by one
+const (
+	batchAtomic     = "atomic"
+	batchBestEffort = "bestEffort"
+)
+
+// Operations of batches
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+const maxBatchOperations = 1000
+
+// Every operation of a best effort batch runs in a savepoint. Postgres keeps ids of subtransactions
+// in a per-backend cache of 64 entries until the transaction ends and slows down snapshots of every session
+// once it overflows, [database.MemoryStore] copies its whole data for each savepoint
+const maxBestEffortOperations = 64
+
+// Messages failed operations are reported with, same as those of the single requests
+var batchErrorMessages = map[string]string{
+	batchCreate: "failed to add song",
+	batchUpdate: "failed to update song info",
+	batchDelete: "failed to delete song",
+}
+
+func validateBatchOperation(v *validator, op *BatchOperationJSON, cfg *config.Config) {
+	switch op.Op {
+	case batchCreate:
+		validateBasicSongInfoJSON(v, &BasicSongInfoJSON{Group: op.Group, Song: op.Song}, cfg)
+		details := additionalSongInfoJSON{ReleaseDate: op.ReleaseDate, Text: op.Text, Link: op.Link}
+		if details != (additionalSongInfoJSON{}) {
+			validateAdditionalSongInfoJSON(v, &details, cfg)
+		}
+	case batchUpdate:
+		validateUpdateRequestJSON(v, &UpdateRequestJSON{Id: op.Id, ReleaseDate: op.ReleaseDate, Text: op.Text, Link: op.Link}, cfg)
+	case batchDelete:
+		v.check(op.Id > 0, "id", "should be positive")
+	default:
+		v.addError("op", fmt.Sprintf("should be one of %s, %s, %s", batchCreate, batchUpdate, batchDelete))
+	}
+	v.check(op.IfVersion >= 0, "ifVersion", "should not be negative")
+}
+
+// Applies valid operation to the store, writing its status and song id into result on success
+func runBatchOperation(ctx context.Context, tx database.SongStore, op *BatchOperationJSON, result *BatchOperationResult) error {
+	rd, _ := time.Parse("02.01.2006", op.ReleaseDate)
+	asi := models.AdditionalSongInfo{ReleaseDate: rd, SongLyrics: op.Text, Link: op.Link}
+
+	switch op.Op {
+	case batchCreate:
+		bsi := models.BasicSongInfo{GroupName: op.Group, SongName: op.Song}
+		err := tx.AddSong(ctx, &bsi)
+		if err != nil {
+			return err
+		}
+		if op.ReleaseDate != "" || op.Text != "" || op.Link != "" {
+			err = tx.UpdateSongInfo(ctx, bsi.Id, &asi, 0)
+			if err != nil {
+				return err
+			}
+		}
+		result.Status, result.Id = http.StatusCreated, bsi.Id
+	case batchUpdate:
+		err := tx.UpdateSongInfo(ctx, op.Id, &asi, op.IfVersion)
+		if err != nil {
+			return err
+		}
+		result.Status, result.Id = http.StatusOK, op.Id
+	case batchDelete:
+		err := tx.DeleteSong(ctx, op.Id, op.IfVersion)
+		if err != nil {
+			return err
+		}
+		result.Status, result.Id = http.StatusOK, op.Id
+	}
+	return nil
+}
+
+// Reports failed operation like the single request would, missing songs are reported as 400
+func batchOperationError(op string, err error) (int, string) {
+	status := badresponses.DatabaseErrorStatus(err)
+	if errors.Is(err, database.ErrSongNotFound) {
+		status = http.StatusBadRequest
+	}
+	return status, fmt.Sprintf("%s: %s", batchErrorMessages[op], err.Error())
+}
+
+// @Summary		Creates, updates and deletes songs in a single transaction
+// @Tags			music-library
+// @Description	Operations are run in order, each takes the fields of the single request doing the same:
+// @Description	create (group, song and optional releaseDate, text and link like POST /music-library/song),
+// @Description	update (id, releaseDate, text and link like PUT /music-library/song) and delete (id like DELETE /music-library/song),
+// @Description	ifVersion makes update and delete apply only while the song has this version.
+// @Description	Atomic batch (the default) is saved only if every operation succeeds, otherwise it responds with status of the failed operation
+// @Description	and the other operations get 424. Best effort batch saves operations that succeeded and responds with 200,
+// @Description	it takes up to 64 operations.
+// @Description	Result of every operation has the status the single request would get and the error it would respond with
+// @Accept			json
+// @Produce		json
+// @Param			BatchRequestJSON	body		BatchRequestJSON	true	"mode and up to 1000 operations (64 in bestEffort mode)"
+// @Success		200					{object}	BatchResponse
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		409					{object}	BatchResponse
+// @Failure		412					{object}	BatchResponse
+// @Failure		422					{object}	BatchResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/batch [post]
+func (hq *HandleQueries) BatchSongs(w http.ResponseWriter, r *http.Request) {
+	var requestJSON BatchRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to run batch: %s", err.Error()))
+		return
+	}
+	if requestJSON.Mode == "" {
+		requestJSON.Mode = batchAtomic
+	}
+	ops := requestJSON.Operations
+
+	v := newValidator()
+	v.check(requestJSON.Mode == batchAtomic || requestJSON.Mode == batchBestEffort, "mode",
+		fmt.Sprintf("should be %s or %s", batchAtomic, batchBestEffort))
+	v.check(len(ops) > 0, "operations", "should be provided")
+	v.check(len(ops) <= maxBatchOperations, "operations",
+		fmt.Sprintf("should be no more than %d, operations provided: %d", maxBatchOperations, len(ops)))
+	if requestJSON.Mode == batchBestEffort {
+		v.check(len(ops) <= maxBestEffortOperations, "operations",
+			fmt.Sprintf("should be no more than %d in %s mode, operations provided: %d",
+				maxBestEffortOperations, batchBestEffort, len(ops)))
+	}
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+	atomic := requestJSON.Mode == batchAtomic
+
+	response := BatchResponse{Results: make([]BatchOperationResult, len(ops))}
+	invalid := -1
+	for i := range ops {
+		response.Results[i].Index = i
+
+		v := newValidator()
+		validateBatchOperation(v, &ops[i], &hq.cfg)
+		if !v.valid() {
+			response.Results[i].Status, response.Results[i].Error = http.StatusUnprocessableEntity, v.Errors
+			if invalid < 0 {
+				invalid = i
+			}
+		}
+	}
+	if atomic && invalid >= 0 {
+		writeBatchResponse(w, r, &response, invalid)
+		return
+	}
+
+	failed := -1 // Operation atomic batch was rolled back at
+	err = hq.q.WithTx(r.Context(), func(tx database.SongStore) error {
+		for i := range ops {
+			result := &response.Results[i]
+			if result.Status != 0 {
+				continue
+			}
+
+			run := func(tx database.SongStore) error { return runBatchOperation(r.Context(), tx, &ops[i], result) }
+			var err error
+			if atomic {
+				err = run(tx)
+			} else {
+				// savepoint keeps the operations that succeeded when this one fails
+				err = tx.WithTx(r.Context(), run)
+			}
+			if err != nil {
+				result.Status, result.Error = batchOperationError(ops[i].Op, err)
+				if atomic {
+					failed = i
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil && failed < 0 {
+		badresponses.DatabaseErrorResponse(w, r, "failed to run batch", err)
+		return
+	}
+
+	response.Committed = failed < 0
+	writeBatchResponse(w, r, &response, failed)
+}
+
+// Responds with results of the batch. If failed operation rolled back atomic batch,
+// the response takes its status and the operations that didn't fail themselves are reported as not applied
+func writeBatchResponse(w http.ResponseWriter, r *http.Request, response *BatchResponse, failed int) {
+	status := http.StatusOK
+	if failed >= 0 {
+		status = response.Results[failed].Status
+		for i := range response.Results {
+			if response.Results[i].Error == nil {
+				response.Results[i] = BatchOperationResult{Index: i, Status: http.StatusFailedDependency,
+					Error: fmt.Sprintf("not applied, operation %d failed", failed)}
+			}
+		}
+	}
+
+	err := jsonutil.WriteJSON(w, status, response, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
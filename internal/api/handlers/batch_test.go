@@ -0,0 +1,130 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+)
+
+const batchOperations = `[
+	{"op":"create","group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"},
+	{"op":"update","id":1,"releaseDate":"14.09.2009","text":"Is our secret safe tonight","link":"https://example.com"},
+	{"op":"delete","id":9},
+	{"op":"delete","id":1,"ifVersion":2}
+]`
+
+func (api *testAPI) batch(status int, mode, operations string) handlers.BatchResponse {
+	api.t.Helper()
+	w := api.expect(status, http.MethodPost, "/music-library/batch", fmt.Sprintf(`{"mode":%q,"operations":%s}`, mode, operations))
+
+	var response handlers.BatchResponse
+	decode(api.t, w, &response)
+	return response
+}
+
+func checkStatuses(t *testing.T, response *handlers.BatchResponse, want ...int) {
+	t.Helper()
+	if len(response.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(response.Results), len(want))
+	}
+	for i, result := range response.Results {
+		if result.Index != i || result.Status != want[i] {
+			t.Errorf("result %d: got index %d and status %d, want status %d, error: %v",
+				i, result.Index, result.Status, want[i], result.Error)
+		}
+	}
+}
+
+func TestAtomicBatchRollsBack(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Resistance")
+
+	response := api.batch(http.StatusBadRequest, "atomic", batchOperations)
+	if response.Committed {
+		t.Error("batch with a failed operation was committed")
+	}
+	checkStatuses(t, &response, http.StatusFailedDependency, http.StatusFailedDependency,
+		http.StatusBadRequest, http.StatusFailedDependency)
+
+	if total := api.page("page=1&pageSize=10").TotalCount; total != 1 {
+		t.Errorf("got %d songs after rolled back batch, want 1", total)
+	}
+	if song := api.getSong(1); song.Version != 1 || song.Text != "" {
+		t.Errorf("rolled back update changed the song: %+v", song)
+	}
+}
+
+func TestAtomicBatchCommits(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Resistance")
+
+	response := api.batch(http.StatusOK, "", strings.Replace(batchOperations, `{"op":"delete","id":9},`, "", 1))
+	if !response.Committed {
+		t.Error("batch was not committed")
+	}
+	checkStatuses(t, &response, http.StatusCreated, http.StatusOK, http.StatusOK)
+
+	api.expect(http.StatusNotFound, http.MethodGet, "/music-library/song/1", "")
+	if song := api.getSong(response.Results[0].Id); song.Text != "Far away" {
+		t.Errorf("created song has text %q, want the given one", song.Text)
+	}
+}
+
+func TestAtomicBatchValidatesEveryOperationFirst(t *testing.T) {
+	api := newTestAPI(t)
+
+	response := api.batch(http.StatusUnprocessableEntity, "atomic", `[
+		{"op":"create","group":"Muse","song":"Starlight"},
+		{"op":"update","id":1,"releaseDate":"2009-09-14","text":"Is our secret safe tonight","link":"https://example.com"},
+		{"op":"rename","id":1}
+	]`)
+	checkStatuses(t, &response, http.StatusFailedDependency, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity)
+	if total := api.page("page=1&pageSize=10").TotalCount; total != 0 {
+		t.Errorf("got %d songs after invalid batch, want 0", total)
+	}
+}
+
+func TestBestEffortBatchKeepsSucceededOperations(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Resistance")
+
+	// the update bumps the version, so the delete expecting the one before fails
+	response := api.batch(http.StatusOK, "bestEffort", strings.Replace(batchOperations, `"ifVersion":2`, `"ifVersion":1`, 1))
+	if !response.Committed {
+		t.Error("best effort batch was not committed")
+	}
+	checkStatuses(t, &response, http.StatusCreated, http.StatusOK, http.StatusBadRequest, http.StatusPreconditionFailed)
+
+	if total := api.page("page=1&pageSize=10").TotalCount; total != 2 {
+		t.Errorf("got %d songs, want 2", total)
+	}
+	if song := api.getSong(1); song.Version != 2 || song.Text != "Is our secret safe tonight" {
+		t.Errorf("got song %+v, want it updated once", song)
+	}
+}
+
+func TestBatchLimitsOperations(t *testing.T) {
+	api := newTestAPI(t)
+
+	operations := func(n int) string {
+		ops := make([]string, n)
+		for i := range ops {
+			ops[i] = fmt.Sprintf(`{"op":"create","group":"Muse","song":"Song %d"}`, i)
+		}
+		return "[" + strings.Join(ops, ",") + "]"
+	}
+
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/batch", `{"operations":[]}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/batch", `{"mode":"all","operations":`+operations(1)+`}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/batch", `{"mode":"bestEffort","operations":`+operations(65)+`}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/batch", `{"operations":`+operations(1001)+`}`)
+
+	api.batch(http.StatusOK, "bestEffort", operations(64))
+	response := api.batch(http.StatusOK, "atomic", strings.Replace(operations(1000), `"Song `, `"Other song `, -1))
+	if !response.Committed || len(response.Results) != 1000 {
+		t.Errorf("batch of 1000 operations: committed %v with %d results", response.Committed, len(response.Results))
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Strong ETag of a song, it changes together with song's version
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// Validators of a song representation, see [notModified] and [HandleQueries.ifMatchVersion]
+func songHeaders(song *models.FullSongInfo) http.Header {
+	headers := http.Header{}
+	headers.Set("ETag", versionETag(song.Version))
+	headers.Set("Last-Modified", song.UpdatedAt.UTC().Format(http.TimeFormat))
+	return headers
+}
+
+// Turns If-Match header into version expected by conditional writes of [database.SongStore],
+// 0 means no precondition. Only strong ETags match (RFC 9110, section 13.1.1), if several are listed
+// the current version is looked up. Returns [database.ErrVersionMismatch] if nothing can match
+func (hq *HandleQueries) ifMatchVersion(ctx context.Context, r *http.Request, songId int64) (int64, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, nil
+	}
+
+	var versions []int64
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return 0, nil
+		}
+		if len(candidate) < 2 || !strings.HasPrefix(candidate, `"`) || !strings.HasSuffix(candidate, `"`) {
+			continue
+		}
+		version, err := strconv.ParseInt(candidate[1:len(candidate)-1], 10, 64)
+		if err != nil || version <= 0 {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	switch len(versions) {
+	case 0:
+		return 0, database.ErrVersionMismatch
+	case 1:
+		return versions[0], nil
+	}
+
+	song, err := hq.q.GetSong(ctx, songId)
+	if err != nil {
+		return 0, err
+	}
+	for _, version := range versions {
+		if version == song.Version {
+			return version, nil
+		}
+	}
+	return 0, database.ErrVersionMismatch
+}
+
+// Reports whether client's cached copy is still fresh according to If-None-Match,
+// or If-Modified-Since when If-None-Match is absent (RFC 9110, section 13.2.2)
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagListContains(ifNoneMatch, etag)
+	}
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	// Last-Modified header has a precision of one second
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// Weak comparison of etag against a comma separated header value, "*" matches anything
+func etagListContains(headerValue, etag string) bool {
+	for _, candidate := range strings.Split(headerValue, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+const updateBody = `{"id":1,"releaseDate":"16.07.2006","text":"Ooh baby","link":"https://example.com"}`
+
+func TestGetSongNotModified(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising")
+
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/song/1", "")
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag != `"1"` || lastModified == "" {
+		t.Fatalf("got ETag %s and Last-Modified %q, want \"1\" and a date", etag, lastModified)
+	}
+
+	w = api.expect(http.StatusNotModified, http.MethodGet, "/music-library/song/1", "", "If-None-Match", `"0", W/"1"`)
+	if w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("304 response has body %q and ETag %s, want no body and ETag %s", w.Body.String(), w.Header().Get("ETag"), etag)
+	}
+	api.expect(http.StatusNotModified, http.MethodGet, "/music-library/song/1", "", "If-Modified-Since", lastModified)
+
+	// If-None-Match takes precedence over If-Modified-Since
+	api.expect(http.StatusOK, http.MethodGet, "/music-library/song/1", "",
+		"If-None-Match", `"0"`, "If-Modified-Since", lastModified)
+
+	api.expect(http.StatusOK, http.MethodPut, "/music-library/song", updateBody)
+	api.expect(http.StatusOK, http.MethodGet, "/music-library/song/1", "", "If-None-Match", etag)
+	api.expect(http.StatusOK, http.MethodGet, "/music-library/song/1", "",
+		"If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+}
+
+func TestGetMissingSong(t *testing.T) {
+	api := newTestAPI(t)
+
+	api.expect(http.StatusNotFound, http.MethodGet, "/music-library/song/1", "")
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/song/first", "")
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+	}{
+		{"current version", `"1"`, http.StatusOK},
+		{"any version", `*`, http.StatusOK},
+		{"current among others", `"7", "1"`, http.StatusOK},
+		{"stale version", `"2"`, http.StatusPreconditionFailed},
+		{"stale versions", `"2", "3"`, http.StatusPreconditionFailed},
+		{"weak etag", `W/"1"`, http.StatusPreconditionFailed},
+		{"malformed", `1`, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			api.addSongs("Muse", "Uprising")
+
+			w := api.do(http.MethodPut, "/music-library/song", updateBody, "If-Match", tt.ifMatch)
+			if w.Code != tt.status {
+				t.Fatalf("PUT: got status %d, want %d, body: %s", w.Code, tt.status, w.Body.String())
+			}
+			want := int64(1)
+			if tt.status == http.StatusOK {
+				want = 2
+			}
+			if song := api.getSong(1); song.Version != want {
+				t.Errorf("got version %d, want %d", song.Version, want)
+			}
+		})
+	}
+}
+
+func TestIfMatchOnPatchAndDelete(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising")
+
+	api.expect(http.StatusPreconditionFailed, http.MethodPatch, "/music-library/song?id=1", `{"text":"Ooh"}`,
+		"Content-Type", mergePatch, "If-Match", `"2"`)
+	api.expect(http.StatusPreconditionFailed, http.MethodPatch, "/music-library/song?id=1",
+		`[{"op":"add","path":"/text","value":"Ooh"}]`, "Content-Type", jsonPatch, "If-Match", `"2"`)
+	w := api.expect(http.StatusOK, http.MethodPatch, "/music-library/song?id=1", `{"text":"Ooh"}`,
+		"Content-Type", mergePatch, "If-Match", `"1"`)
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Fatalf("got ETag %s after patch, want \"2\"", etag)
+	}
+
+	api.expect(http.StatusPreconditionFailed, http.MethodDelete, "/music-library/song?id=1", "", "If-Match", `"1"`)
+	api.expect(http.StatusOK, http.MethodDelete, "/music-library/song?id=1", "", "If-Match", `"2"`)
+}
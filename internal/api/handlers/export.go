@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Media types of export formats
+var exportMediaTypes = map[string]string{
+	FormatCSV:    csvMediaType,
+	FormatNDJSON: ndjsonMediaType,
+	FormatJSON:   jsonMediaType,
+}
+
+// Columns of CSV exports unless fields are given, search match can't be exported as CSV
+var csvExportFields = []string{database.FieldId, database.FieldGroupId, database.FieldGroup, database.FieldSong,
+	database.FieldReleaseDate, database.FieldText, database.FieldLink, database.FieldEnrichmentStatus, database.FieldVersion}
+
+// Time between flushes of the response, rows are written in between however many of them there are
+const exportFlushInterval = 5 * time.Second
+
+// Write deadline of export responses is pushed forward by this much before streaming and on every flush,
+// so long exports aren't cut by the server's WriteTimeout while the client keeps reading
+const exportIdleTimeout = 20 * time.Second
+
+// Picks export format from format parameter or from Accept header, preferring media types of higher quality.
+// JSON is exported when neither is given. Reports false if none of the acceptable media types can be exported
+func exportFormatFromRequest(v *validator, r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, known := exportMediaTypes[format]
+		v.check(known, "format", fmt.Sprintf("should be one of %s, %s, %s", FormatCSV, FormatNDJSON, FormatJSON))
+		return format, true
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return FormatJSON, true
+	}
+
+	type acceptedType struct {
+		mediaType string
+		quality   float64
+	}
+	var accepted []acceptedType
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, exists := params["q"]; exists {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedType{mediaType, quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+
+	for _, t := range accepted {
+		switch t.mediaType {
+		case csvMediaType, "text/*":
+			return FormatCSV, true
+		case ndjsonMediaType:
+			return FormatNDJSON, true
+		case jsonMediaType, "application/*", "*/*":
+			return FormatJSON, true
+		}
+	}
+	return "", false
+}
+
+// Writes exported songs one at a time, output is buffered until flush or end
+type songExporter interface {
+	begin() error
+	write(song *models.FullSongInfo) error
+	flush() error
+	// Completes the output and flushes it
+	end() error
+}
+
+func newSongExporter(format string, w io.Writer, fields []string) songExporter {
+	buffered := bufio.NewWriter(w)
+	if format == FormatCSV {
+		if fields == nil {
+			fields = csvExportFields
+		}
+		return &csvExporter{w: csv.NewWriter(buffered), buffered: buffered, fields: fields}
+	}
+	return &jsonExporter{w: buffered, fields: fields, array: format == FormatJSON}
+}
+
+// Writes songs as CSV with a header row naming the fields
+type csvExporter struct {
+	w        *csv.Writer
+	buffered *bufio.Writer
+	fields   []string
+}
+
+func (e *csvExporter) begin() error {
+	return e.w.Write(e.fields)
+}
+
+func (e *csvExporter) write(song *models.FullSongInfo) error {
+	row := newListRowResult(song)
+	record := make([]string, len(e.fields))
+	for i, field := range e.fields {
+		switch field {
+		case database.FieldId:
+			record[i] = strconv.FormatInt(int64(row.Id), 10)
+		case database.FieldGroupId:
+			record[i] = strconv.FormatInt(row.GroupId, 10)
+		case database.FieldGroup:
+			record[i] = row.GroupName
+		case database.FieldSong:
+			record[i] = row.SongName
+		case database.FieldReleaseDate:
+			record[i] = row.ReleaseDate
+		case database.FieldText:
+			record[i] = row.Text
+		case database.FieldLink:
+			record[i] = row.Link
+		case database.FieldEnrichmentStatus:
+			record[i] = row.EnrichmentStatus
+		case database.FieldVersion:
+			record[i] = strconv.FormatInt(row.Version, 10)
+		case database.FieldDeletedAt:
+			if row.DeletedAt != nil {
+				record[i] = row.DeletedAt.Format(time.RFC3339)
+			}
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) flush() error {
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+	return e.buffered.Flush()
+}
+
+func (e *csvExporter) end() error {
+	return e.flush()
+}
+
+// Writes songs as rows of the list, either as a JSON array with a row per line or as NDJSON
+type jsonExporter struct {
+	w      *bufio.Writer
+	fields []string
+	array  bool
+	count  int
+}
+
+func (e *jsonExporter) begin() error {
+	if !e.array {
+		return nil
+	}
+	_, err := e.w.WriteString("[")
+	return err
+}
+
+func (e *jsonExporter) write(song *models.FullSongInfo) error {
+	data, err := json.Marshal(sparseRow(newListRowResult(song), e.fields))
+	if err != nil {
+		return err
+	}
+
+	separator := "\n"
+	if e.array && e.count > 0 {
+		separator = ",\n"
+	}
+	e.count++
+
+	if e.array {
+		_, err = e.w.WriteString(separator)
+		if err == nil {
+			_, err = e.w.Write(data)
+		}
+		return err
+	}
+	_, err = e.w.Write(data)
+	if err == nil {
+		_, err = e.w.WriteString(separator)
+	}
+	return err
+}
+
+func (e *jsonExporter) flush() error {
+	return e.w.Flush()
+}
+
+func (e *jsonExporter) end() error {
+	if e.array {
+		closing := "]\n"
+		if e.count > 0 {
+			closing = "\n]\n"
+		}
+		_, err := e.w.WriteString(closing)
+		if err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// @Summary		Exports songs of the library
+// @Tags			music-library
+// @Description	Streams every song matching the filters of /music-library/list (page, pageSize and cursor aren't used) in the list order.
+// @Description	Format is taken from format parameter or Accept header: CSV with a header row (text/csv),
+// @Description	NDJSON with a song per line (application/x-ndjson) or JSON array of songs (application/json, the default).
+// @Description	fields picks the fields of songs, CSV has columns of every field but match by default and can't export match.
+// @Description	Songs are read through a database cursor and the export sees the library as it was when it started.
+// @Description	If the export fails after songs started streaming, the connection is closed without completing the response
+// @Accept			plain
+// @Produce		json
+// @Produce		text/csv
+// @Produce		application/x-ndjson
+// @Param			format				query		string		false	"overrides Accept header"	Enums(csv, ndjson, json)
+// @Param			groupId				query		[]int		false	"group id"		collectionFormat(multi)
+// @Param			albumId				query		[]int		false	"album id"		collectionFormat(multi)
+// @Param			genre				query		[]string	false	"genre name"	collectionFormat(multi)
+// @Param			tag					query		[]string	false	"tag name"		collectionFormat(multi)
+// @Param			tagMatch			query		string		false	"songs need any (default) or all of the genres and tags"	Enums(any, all)
+// @Param			group				query		[]string	false	"group name"	collectionFormat(multi)
+// @Param			song				query		[]string	false	"song name"		collectionFormat(multi)
+// @Param			releaseDateLower	query		string		false	"dates before this will not show up"
+// @Param			releaseDateUpper	query		string		false	"dates after this will not show up"
+// @Param			text				query		[]string	false	"lyrics"		collectionFormat(multi)
+// @Param			hasLyrics			query		bool		false	"whether song has lyrics"
+// @Param			hasLink				query		bool		false	"whether song has link"
+// @Param			search				query		string		false	"full-text search of lyrics"
+// @Param			lang				query		string		false	"language of search"	Enums(english, russian, simple)
+// @Param			sort				query		string		false	"fields of id, group, song, releaseDate and relevance"
+// @Param			fields				query		string		false	"comma separated fields of songs, e.g. id,group,song"
+// @Success		200					{array}		ListRowResult
+// @Failure		406					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/export [get]
+func (hq *HandleQueries) ExportSongs(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	dbFilter := hq.songFilterFromQuery(v, r.URL.Query())
+	format, acceptable := exportFormatFromRequest(v, r)
+	v.check(format != FormatCSV || !slices.Contains(dbFilter.Fields, database.FieldMatch), "fields",
+		"match can't be exported as csv")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if !acceptable {
+		badresponses.NotAcceptableResponse(w, r,
+			fmt.Sprintf("expected Accept of %s, %s or %s", csvMediaType, ndjsonMediaType, jsonMediaType))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	exporter := newSongExporter(format, w, dbFilter.Fields)
+
+	// not every ResponseWriter supports deadlines and flushing, output is sent as buffers fill up then
+	var flushed time.Time
+	extendDeadline := func() {
+		flushed = time.Now()
+		_ = rc.SetWriteDeadline(flushed.Add(exportIdleTimeout))
+	}
+
+	// response starts with the first song, so failures before it still get an error response
+	started := false
+	start := func() error {
+		started = true
+		extendDeadline()
+		w.Header().Set("Content-Type", exportMediaTypes[format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="songs.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		return exporter.begin()
+	}
+
+	exported := 0
+	err := hq.q.ExportSongs(r.Context(), &dbFilter, func(song *models.FullSongInfo) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		exported++
+		if time.Since(flushed) >= exportFlushInterval {
+			extendDeadline()
+			err := exporter.flush()
+			if err != nil {
+				return err
+			}
+			_ = rc.Flush()
+		}
+		return exporter.write(song)
+	})
+	if err != nil && !started {
+		badresponses.DatabaseErrorResponse(w, r, "failed to export songs", err)
+		return
+	}
+
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = exporter.end()
+	}
+	if err != nil {
+		// status is already sent, closing the connection tells the client the export is incomplete
+		logger.Zap.Error(fmt.Errorf("failed to export songs, stopped after %d songs: %w", exported, err))
+		panic(http.ErrAbortHandler)
+	}
+}
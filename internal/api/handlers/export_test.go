@@ -0,0 +1,141 @@
+package handlers_test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportNegotiatesFormat(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising")
+
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{"default", "", "", http.StatusOK, "application/json"},
+		{"csv", "", "text/csv", http.StatusOK, "text/csv"},
+		{"ndjson", "", "application/x-ndjson", http.StatusOK, "application/x-ndjson"},
+		{"any", "", "*/*", http.StatusOK, "application/json"},
+		{"any text", "", "text/*", http.StatusOK, "text/csv"},
+		{"by quality", "", "application/x-ndjson;q=0.5, text/csv;q=0.9, image/png", http.StatusOK, "text/csv"},
+		{"first of equal quality", "", "application/x-ndjson, text/csv", http.StatusOK, "application/x-ndjson"},
+		{"refused type", "", "text/csv;q=0, application/json;q=0.1", http.StatusOK, "application/json"},
+		{"unsupported", "", "image/png, text/html", http.StatusNotAcceptable, "application/json"},
+		{"format overrides accept", "?format=ndjson", "text/csv", http.StatusOK, "application/x-ndjson"},
+		{"unknown format", "?format=xml", "", http.StatusUnprocessableEntity, "application/json"},
+		{"csv with match", "?format=csv&fields=id,match", "", http.StatusUnprocessableEntity, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(http.MethodGet, "/music-library/export"+tt.query, "", "Accept", tt.accept)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d, body: %s", w.Code, tt.status, w.Body.String())
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != tt.contentType {
+				t.Errorf("got Content-Type %q, want %q", contentType, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising", "Starlight")
+	api.addSongWithDetails("Muse", "Hysteria")
+
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/export?format=csv&sort=song", "")
+	if disposition := w.Header().Get("Content-Disposition"); disposition != `attachment; filename="songs.csv"` {
+		t.Errorf("got Content-Disposition %q", disposition)
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read csv: %v", err)
+	}
+	wantHeader := []string{"id", "groupId", "group", "song", "releaseDate", "text", "link", "enrichmentStatus", "version"}
+	if len(records) != 4 || !slices.Equal(records[0], wantHeader) {
+		t.Fatalf("got csv %v, want header %v and 3 songs", records, wantHeader)
+	}
+	if hysteria := records[1]; hysteria[3] != "Hysteria" || hysteria[4] != "16.07.2006" || hysteria[5] != "Ooh baby" {
+		t.Errorf("got csv row %v, want Hysteria with its details", hysteria)
+	}
+
+	w = api.expect(http.StatusOK, http.MethodGet, "/music-library/export?format=ndjson&fields=id,song&song=r", "")
+	var songs []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("failed to decode ndjson line %q: %v", scanner.Text(), err)
+		}
+		if len(row) != 2 {
+			t.Errorf("got row %v, want only id and song", row)
+		}
+		songs = append(songs, row["song"].(string))
+	}
+	if !slices.Equal(songs, []string{"Uprising", "Starlight", "Hysteria"}) {
+		t.Errorf("got songs %v", songs)
+	}
+
+	w = api.expect(http.StatusOK, http.MethodGet, "/music-library/export?song=Uprising", "")
+	var rows []struct {
+		Id   int64  `json:"id"`
+		Song string `json:"song"`
+	}
+	decode(t, w, &rows)
+	if len(rows) != 1 || rows[0].Song != "Uprising" {
+		t.Errorf("got rows %+v, want Uprising only", rows)
+	}
+}
+
+func TestExportEmptyLibrary(t *testing.T) {
+	api := newTestAPI(t)
+
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/export", "")
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("got body %q, want empty array", body)
+	}
+
+	w = api.expect(http.StatusOK, http.MethodGet, "/music-library/export", "", "Accept", "text/csv")
+	if body := strings.TrimSpace(w.Body.String()); body != "id,groupId,group,song,releaseDate,text,link,enrichmentStatus,version" {
+		t.Errorf("got body %q, want the header row only", body)
+	}
+}
+
+// Recorder keeping write deadlines set through [http.ResponseController], along with output written by then
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadlines []time.Time
+	written   []int
+}
+
+func (w *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	w.deadlines = append(w.deadlines, deadline)
+	w.written = append(w.written, w.Body.Len())
+	return nil
+}
+
+func TestExportExtendsWriteDeadline(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising", "Starlight")
+
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+	api.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/music-library/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, body: %s", w.Code, w.Body.String())
+	}
+	// a quick export is streamed within the deadline set before its first song
+	if len(w.deadlines) != 1 || w.written[0] != 0 || time.Until(w.deadlines[0]) < 10*time.Second {
+		t.Errorf("got deadlines %v set after %v bytes, want one set before streaming", w.deadlines, w.written)
+	}
+}
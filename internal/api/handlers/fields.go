@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+)
+
+// Parses comma separated fields of [ListRowResult] to respond with, nil means every field
+func fieldsFromQuery(v *validator, fields string) []string {
+	if fields == "" {
+		return nil
+	}
+
+	var result []string
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(database.ListFields, field) {
+			v.addError("fields", fmt.Sprintf("unknown field %q, should be one of %s",
+				field, strings.Join(database.ListFields, ", ")))
+			continue
+		}
+		if !slices.Contains(result, field) {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// Leaves only requested fields of the row, see [fieldsFromQuery]
+func sparseRow(row ListRowResult, fields []string) any {
+	if fields == nil {
+		return row
+	}
+
+	// marshalling keeps omitempty of ListRowResult, so absent values stay absent
+	data, _ := json.Marshal(row)
+	var members map[string]json.RawMessage
+	_ = json.Unmarshal(data, &members)
+	for name := range members {
+		if !slices.Contains(fields, name) {
+			delete(members, name)
+		}
+	}
+	return members
+}
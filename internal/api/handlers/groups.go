@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func newGroupResult(group *models.Group) GroupResult {
+	return GroupResult{Id: group.Id, Name: group.Name, SongCount: group.SongCount, CreatedAt: group.CreatedAt}
+}
+
+func validateGroupRequestJSON(v *validator, group *GroupRequestJSON, maxLen int) {
+	v.check(len(group.Name) > 0, "name", "should be provided")
+	v.check(len(group.Name) <= maxLen, "name",
+		fmt.Sprintf("should be no more than %v characters long, current length %v", maxLen, len(group.Name)))
+}
+
+// Responds with the group, status is 200 unless given
+func (hq *HandleQueries) writeGroup(w http.ResponseWriter, r *http.Request, groupId int64, status int) {
+	group, err := hq.q.GetGroup(r.Context(), groupId)
+	if err == database.ErrGroupNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get group: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get group", err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, status, newGroupResult(group), nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches groups in pages
+// @Tags			groups
+// @Description	Groups are ordered by name, name filter works like group filter of /music-library/list
+// @Description	(e.g. name[prefix]=The), each group carries the number of its songs that aren't in trash
+// @Accept			plain
+// @Produce		json
+// @Param			name		query		[]string	false	"group name"	collectionFormat(multi)
+// @Param			page		query		int			true	"page number"
+// @Param			pageSize	query		int			true	"number of groups displayed per page"	maximum(1000)
+// @Success		200			{object}	GroupListResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
+// @Router			/music-library/groups [get]
+func (hq *HandleQueries) GetGroups(w http.ResponseWriter, r *http.Request) {
+	rq := r.URL.Query()
+
+	v := newValidator()
+	page := convertAndValidateStringToInt64(v, rq.Get("page"), "page")
+	pageSize := convertAndValidateStringToInt64(v, rq.Get("pageSize"), "pageSize")
+	limit, offset := pageLimitOffset(v, page, pageSize)
+	filter := database.GroupFilter{Name: textFiltersFromQuery(v, rq, "name")}
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// one extra group tells whether there's a next page
+	filter.Limit = limit + 1
+	filter.Offset = offset
+	groups, err := hq.q.GetGroups(r.Context(), &filter)
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get groups", err)
+		return
+	}
+
+	result := GroupListResponse{Groups: []GroupResult{}, Page: page, PageSize: pageSize}
+	if len(groups) > int(pageSize) {
+		groups = groups[:pageSize]
+		result.HasNext = true
+	}
+	for _, group := range groups {
+		result.Groups = append(result.Groups, newGroupResult(&group))
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches a single group
+// @Tags			groups
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"group id"
+// @Success		200	{object}	GroupResult
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/groups/{id} [get]
+func (hq *HandleQueries) GetGroup(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	groupId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hq.writeGroup(w, r, groupId, http.StatusOK)
+}
+
+// @Summary		Adds new group
+// @Tags			groups
+// @Description	Songs added with the group's name join it, returns the created group
+// @Accept			json
+// @Produce		json
+// @Param			GroupRequestJSON	body		GroupRequestJSON	true	"group name"
+// @Success		201					{object}	GroupResult
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		409					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/groups [post]
+func (hq *HandleQueries) AddGroup(w http.ResponseWriter, r *http.Request) {
+	var requestJSON GroupRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to add group: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	validateGroupRequestJSON(v, &requestJSON, hq.cfg.MaxGroupNameLen)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	groupId, err := hq.q.AddGroup(r.Context(), requestJSON.Name)
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to add group", err)
+		return
+	}
+
+	hq.writeGroup(w, r, groupId, http.StatusCreated)
+}
+
+// @Summary		Renames group
+// @Tags			groups
+// @Description	Every song of the group gets the new name, which is recorded in their history
+// @Accept			json
+// @Produce		json
+// @Param			id					path		int					true	"group id"
+// @Param			GroupRequestJSON	body		GroupRequestJSON	true	"new group name"
+// @Success		200					{object}	GroupResult
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		404					{object}	models.ErrorResponse
+// @Failure		409					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/groups/{id} [put]
+func (hq *HandleQueries) RenameGroup(w http.ResponseWriter, r *http.Request) {
+	var requestJSON GroupRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to rename group: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	groupId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	validateGroupRequestJSON(v, &requestJSON, hq.cfg.MaxGroupNameLen)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.RenameGroup(r.Context(), groupId, requestJSON.Name)
+	if err == database.ErrGroupNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to rename group: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to rename group", err)
+		return
+	}
+
+	hq.writeGroup(w, r, groupId, http.StatusOK)
+}
+
+// @Summary		Deletes group
+// @Tags			groups
+// @Description	Only groups without songs (trashed ones included) can be deleted, returns provided id on success
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"group id"
+// @Success		200	{object}	models.IdResponse
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		409	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/groups/{id} [delete]
+func (hq *HandleQueries) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	groupId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.DeleteGroup(r.Context(), groupId)
+	if err == database.ErrGroupNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to delete group: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to delete group", err)
+		return
+	}
+
+	result := map[string]any{"id": groupId}
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Merges duplicate groups into one
+// @Tags			groups
+// @Description	Songs of the listed groups (trashed ones included) move into the group in path and the listed groups are deleted.
+// @Description	Fails with 409 and changes nothing if the group already has a song named like one being moved
+// @Accept			json
+// @Produce		json
+// @Param			id						path		int						true	"id of the group that stays"
+// @Param			MergeGroupsRequestJSON	body		MergeGroupsRequestJSON	true	"ids of duplicate groups"
+// @Success		200						{object}	GroupResult
+// @Failure		400						{object}	models.ErrorResponse
+// @Failure		404						{object}	models.ErrorResponse
+// @Failure		409						{object}	models.ErrorResponse
+// @Failure		422						{object}	models.ErrorResponse
+// @Failure		500						{object}	models.ErrorResponse
+// @Failure		503						{object}	models.ErrorResponse
+// @Router			/music-library/groups/{id}/merge [post]
+func (hq *HandleQueries) MergeGroups(w http.ResponseWriter, r *http.Request) {
+	var requestJSON MergeGroupsRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to merge groups: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	groupId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	v.check(len(requestJSON.Groups) > 0, "groups", "should be provided")
+	for _, sourceId := range requestJSON.Groups {
+		v.check(sourceId != groupId, "groups", "should not contain the group merged into")
+		v.check(sourceId > 0, "groups", "should contain positive ids")
+	}
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.MergeGroups(r.Context(), groupId, requestJSON.Groups)
+	if err == database.ErrGroupNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to merge groups: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to merge groups", err)
+		return
+	}
+
+	hq.writeGroup(w, r, groupId, http.StatusOK)
+}
@@ -0,0 +1,154 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+)
+
+func (api *testAPI) groups(query string) handlers.GroupListResponse {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/groups?"+query, "")
+
+	var response handlers.GroupListResponse
+	decode(api.t, w, &response)
+	return response
+}
+
+func (api *testAPI) group(id int64) handlers.GroupResult {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/groups/%d", id), "")
+
+	var group handlers.GroupResult
+	decode(api.t, w, &group)
+	return group
+}
+
+func (api *testAPI) addGroup(name string) handlers.GroupResult {
+	api.t.Helper()
+	w := api.expect(http.StatusCreated, http.MethodPost, "/music-library/groups", fmt.Sprintf(`{"name":%q}`, name))
+
+	var group handlers.GroupResult
+	decode(api.t, w, &group)
+	return group
+}
+
+func TestGroupCRUD(t *testing.T) {
+	api := newTestAPI(t)
+	queen := api.addGroup("Queen")
+	if queen.Name != "Queen" || queen.SongCount != 0 || queen.CreatedAt.IsZero() {
+		t.Errorf("got group %+v, want empty Queen", queen)
+	}
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/groups", `{"name":"Queen"}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/groups", `{"name":""}`)
+
+	// songs join groups by name, groups are created for them when needed
+	ids := api.addSongs("Muse", "Uprising", "Starlight")
+	muse := api.getSong(ids[0]).GroupId
+	if got := api.group(muse); got.Name != "Muse" || got.SongCount != 2 {
+		t.Errorf("got group %+v, want Muse with two songs", got)
+	}
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", ids[1]), "")
+	if got := api.group(muse); got.SongCount != 1 {
+		t.Errorf("got %d songs, want trashed ones left out of the count", got.SongCount)
+	}
+
+	list := api.groups("page=1&pageSize=1")
+	if len(list.Groups) != 1 || list.Groups[0].Name != "Muse" || !list.HasNext {
+		t.Errorf("got groups %+v, want Muse followed by more groups", list)
+	}
+	if list := api.groups("page=1&pageSize=10&name[prefix]=q"); len(list.Groups) != 1 || list.Groups[0].Id != queen.Id || list.HasNext {
+		t.Errorf("got groups %+v, want Queen only", list)
+	}
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/groups?page=1&pageSize=1001", "")
+
+	// group with songs, even trashed ones, can't be deleted
+	api.expect(http.StatusConflict, http.MethodDelete, fmt.Sprintf("/music-library/groups/%d", muse), "")
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/groups/%d", queen.Id), "")
+	api.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/music-library/groups/%d", queen.Id), "")
+	api.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/music-library/groups/%d", queen.Id), "")
+}
+
+func TestRenameGroup(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight")
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", ids[1]), "")
+	muse := api.getSong(ids[0]).GroupId
+	api.addGroup("Queen")
+
+	w := api.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/music-library/groups/%d", muse), `{"name":"MUSE"}`)
+	var renamed handlers.GroupResult
+	decode(t, w, &renamed)
+	if renamed.Id != muse || renamed.Name != "MUSE" {
+		t.Errorf("got group %+v, want Muse renamed", renamed)
+	}
+
+	// songs carry the new name, trashed ones too
+	if song := api.getSong(ids[0]); song.GroupName != "MUSE" || song.GroupId != muse {
+		t.Errorf("got song %+v, want it in the renamed group", song)
+	}
+	if rows := api.trash("page=1&pageSize=10"); len(rows) != 1 || rows[0].GroupName != "MUSE" {
+		t.Errorf("got trash %+v, want trashed song renamed as well", rows)
+	}
+
+	api.expect(http.StatusConflict, http.MethodPut, fmt.Sprintf("/music-library/groups/%d", muse), `{"name":"Queen"}`)
+	api.expect(http.StatusNotFound, http.MethodPut, fmt.Sprintf("/music-library/groups/%d", muse+10), `{"name":"Placebo"}`)
+}
+
+func TestMergeGroups(t *testing.T) {
+	api := newTestAPI(t)
+	muse := api.addSongs("Muse", "Uprising", "Starlight")
+	duplicate := api.addSongs("MUSE", "Hysteria", "Madness")
+	misspelled := api.addSongs("Mues", "Resistance")
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", duplicate[1]), "")
+	target := api.getSong(muse[0]).GroupId
+	sources := fmt.Sprintf(`{"groups":[%d,%d]}`, api.getSong(duplicate[0]).GroupId, api.getSong(misspelled[0]).GroupId)
+
+	w := api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/groups/%d/merge", target), sources)
+	var merged handlers.GroupResult
+	decode(t, w, &merged)
+	if merged.Id != target || merged.Name != "Muse" || merged.SongCount != 4 {
+		t.Errorf("got group %+v, want Muse with four songs", merged)
+	}
+
+	for _, id := range []int64{duplicate[0], misspelled[0]} {
+		if song := api.getSong(id); song.GroupId != target || song.GroupName != "Muse" {
+			t.Errorf("got song %+v, want it moved into Muse", song)
+		}
+	}
+	if rows := api.trash("page=1&pageSize=10"); len(rows) != 1 || rows[0].GroupId != target {
+		t.Errorf("got trash %+v, want trashed song moved as well", rows)
+	}
+	if list := api.groups("page=1&pageSize=10"); len(list.Groups) != 1 {
+		t.Errorf("got groups %+v, want merged ones gone", list.Groups)
+	}
+	// moved songs are found by their new group
+	if rows := api.list(fmt.Sprintf("groupId=%d&page=1&pageSize=10", target)); len(rows) != 4 {
+		t.Errorf("got rows %+v, want every song of the merged group", rows)
+	}
+
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/groups/%d/merge", target), sources)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, fmt.Sprintf("/music-library/groups/%d/merge", target),
+		fmt.Sprintf(`{"groups":[%d]}`, target))
+}
+
+func TestMergeGroupsWithSameSongs(t *testing.T) {
+	api := newTestAPI(t)
+	muse := api.addSongs("Muse", "Uprising")[0]
+	duplicate := api.addSongs("MUSE", "Uprising", "Starlight")
+	target := api.getSong(muse).GroupId
+	source := api.getSong(duplicate[0]).GroupId
+
+	api.expect(http.StatusConflict, http.MethodPost, fmt.Sprintf("/music-library/groups/%d/merge", target),
+		fmt.Sprintf(`{"groups":[%d]}`, source))
+
+	// nothing is moved
+	if song := api.getSong(duplicate[1]); song.GroupId != source || song.GroupName != "MUSE" {
+		t.Errorf("got song %+v, want it left in its group", song)
+	}
+	if group := api.group(source); group.SongCount != 2 {
+		t.Errorf("got group %+v, want it kept with its songs", group)
+	}
+}
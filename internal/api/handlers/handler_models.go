@@ -1,5 +1,7 @@
 package handlers
 
+import "time"
+
 type BasicSongInfoJSON struct {
 	Group string `json:"group"`
 	Song  string `json:"song"`
@@ -19,29 +21,252 @@ type UpdateRequestJSON struct {
 }
 
 type FilterRequest struct {
-	GroupName             string
-	SongName              string
 	ReleaseDateLowerBound string
 	ReleaseDateUpperBound string
-	Text                  string
 	Page                  int64
 	PageSize              int64
 }
 
 type ListRowResult struct {
-	Id          int32  `json:"id"`
-	GroupName   string `json:"group"`
-	SongName    string `json:"song"`
-	ReleaseDate string `json:"releaseDate,omitempty"`
-	Text        string `json:"text,omitempty"`
-	Link        string `json:"link,omitempty"`
+	Id               int32              `json:"id"`
+	GroupId          int64              `json:"groupId"`
+	GroupName        string             `json:"group"`
+	SongName         string             `json:"song"`
+	ReleaseDate      string             `json:"releaseDate,omitempty"`
+	Text             string             `json:"text,omitempty"`
+	Link             string             `json:"link,omitempty"`
+	EnrichmentStatus string             `json:"enrichmentStatus"`
+	Version          int64              `json:"version"`
+	DeletedAt        *time.Time         `json:"deletedAt,omitempty"` // Only set for songs in trash
+	Match            *SearchMatchResult `json:"match,omitempty"`     // Only set for songs found by search
+}
+
+type SearchMatchResult struct {
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`                     // Fragments of lyrics, matched words are wrapped into <b></b>
+	Verse   int     `json:"verse,omitempty" example:"2"` // First matched verse, same as page of /music-library/lyrics with pageSize=1
+}
+
+type AddSongResponse struct {
+	Id               int32  `json:"id"`
+	EnrichmentStatus string `json:"enrichmentStatus" enums:"pending,enriched"`
 }
 
-type AddSongFailedExternalAPIResponse struct {
-	Id          int32  `json:"id"`
-	SongDetails string `json:"songDetails"`
+// Song with details added or updated by POST /music-library/song?onConflict=update
+type UpsertSongJSON struct {
+	BasicSongInfoJSON
+	additionalSongInfoJSON
 }
 
+type UpsertSongResponse struct {
+	Id     int64  `json:"id"`
+	Status string `json:"status" enums:"created,updated"`
+}
+
+// Cursors point at songs next to the page, they're absent when there's nothing to list in that direction.
+// Page is absent for pages listed by cursor
 type FilteredListResponse struct {
-	FilteredRows any `json:"filteredRows"`
+	FilteredRows        any    `json:"filteredRows"`
+	NextCursor          string `json:"nextCursor,omitempty"`
+	PrevCursor          string `json:"prevCursor,omitempty"`
+	Page                int64  `json:"page,omitempty"`
+	PageSize            int64  `json:"pageSize"`
+	TotalCount          int64  `json:"totalCount"`
+	TotalCountEstimated bool   `json:"totalCountEstimated,omitempty"`
+	TotalPages          int64  `json:"totalPages"`
+	HasNext             bool   `json:"hasNext"`
+}
+
+// Song change, old and new hold the song document (see PATCH /music-library/song) before and after it
+type RevisionResult struct {
+	Revision      int64          `json:"revision"`
+	Version       int64          `json:"version"`
+	Operation     string         `json:"operation" enums:"create,update,delete,restore,purge"`
+	Actor         string         `json:"actor"`
+	ChangedAt     time.Time      `json:"changedAt"`
+	ChangedFields []string       `json:"changedFields"`
+	Old           map[string]any `json:"old"`
+	New           map[string]any `json:"new"`
+}
+
+type SongHistoryResponse struct {
+	Revisions []RevisionResult `json:"revisions"`
+}
+
+type GroupRequestJSON struct {
+	Name string `json:"name"`
+}
+
+type MergeGroupsRequestJSON struct {
+	Groups []int64 `json:"groups"` // Ids of groups merged into the one in path
+}
+
+type GroupResult struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	SongCount int64     `json:"songCount"` // Songs of the group that aren't in trash
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GroupListResponse struct {
+	Groups   []GroupResult `json:"groups"`
+	Page     int64         `json:"page"`
+	PageSize int64         `json:"pageSize"`
+	HasNext  bool          `json:"hasNext"`
+}
+
+type AlbumRequestJSON struct {
+	GroupId     int64  `json:"groupId"`
+	Title       string `json:"title"`
+	ReleaseDate string `json:"releaseDate"`
+	Cover       string `json:"cover"` // Link to cover image
+}
+
+type AlbumTrackRequestJSON struct {
+	SongId   int64 `json:"songId"`
+	Position int   `json:"position,omitempty"` // Track is appended if omitted
+}
+
+type ReorderTracksRequestJSON struct {
+	Songs []int64 `json:"songs"` // Song ids in the new order of tracks
+}
+
+type AlbumTrackResult struct {
+	Position int           `json:"position"`
+	Song     ListRowResult `json:"song"`
+}
+
+// Tracks of songs in trash are hidden, their positions are skipped
+type AlbumResult struct {
+	Id          int64              `json:"id"`
+	GroupId     int64              `json:"groupId"`
+	GroupName   string             `json:"group"`
+	Title       string             `json:"title"`
+	ReleaseDate string             `json:"releaseDate,omitempty"`
+	Cover       string             `json:"cover,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	Tracks      []AlbumTrackResult `json:"tracks"`
+}
+
+type PlaylistRequestJSON struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type PlaylistItemRequestJSON struct {
+	SongId   int64 `json:"songId"`
+	Position int   `json:"position,omitempty"` // Item is appended if omitted
+}
+
+type MoveItemRequestJSON struct {
+	Position int `json:"position"` // Item is moved to the end if 0
+}
+
+type PlaylistItemResult struct {
+	Id       int64         `json:"id"`
+	Position int           `json:"position"`
+	AddedAt  time.Time     `json:"addedAt"`
+	Song     ListRowResult `json:"song"`
+}
+
+type PlaylistResult struct {
+	Id          int64     `json:"id"`
+	Owner       string    `json:"owner"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ItemCount   int64     `json:"itemCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Playlist with its items ordered by position
+type PlaylistDetailsResult struct {
+	PlaylistResult
+	Items []PlaylistItemResult `json:"items"`
+}
+
+type PlaylistListResponse struct {
+	Playlists []PlaylistResult `json:"playlists"`
+	Page      int64            `json:"page"`
+	PageSize  int64            `json:"pageSize"`
+	HasNext   bool             `json:"hasNext"`
+}
+
+type TagsRequestJSON struct {
+	Genres []string `json:"genres"`
+	Tags   []string `json:"tags"`
+}
+
+type TagResult struct {
+	Id   int64  `json:"id"`
+	Kind string `json:"kind" enums:"genre,tag"`
+	Name string `json:"name"`
+}
+
+type TagCountResult struct {
+	TagResult
+	SongCount int64 `json:"songCount"` // Songs with the tag that aren't in trash
+}
+
+type SongTagsResponse struct {
+	Tags []TagResult `json:"tags"`
+}
+
+type TagListResponse struct {
+	Tags     []TagCountResult `json:"tags"`
+	Page     int64            `json:"page"`
+	PageSize int64            `json:"pageSize"`
+	HasNext  bool             `json:"hasNext"`
+}
+
+// Row of a bulk import, details are optional but should be provided together
+type importSongJSON struct {
+	BasicSongInfoJSON
+	additionalSongInfoJSON
+}
+
+// Outcome of a bulk import, rows are listed in the order they were read
+type ImportReport struct {
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+	Error   string            `json:"errors,omitempty"` // Set when saving songs failed, the import stops then
+}
+
+type ImportRowResult struct {
+	Line   int    `json:"line"` // Line of the source the row starts at
+	Status string `json:"status" enums:"created,updated,skipped,failed"`
+	Id     int64  `json:"id,omitempty"`
+	Reason string `json:"reason,omitempty"` // Why the row was skipped or failed
+}
+
+type BatchRequestJSON struct {
+	Mode       string               `json:"mode" enums:"atomic,bestEffort"` // atomic by default
+	Operations []BatchOperationJSON `json:"operations"`
+}
+
+// Operation of a batch, fields are those of the single request doing the same
+type BatchOperationJSON struct {
+	Op          string `json:"op" enums:"create,update,delete"`
+	Id          int64  `json:"id"`    // Song to update or delete
+	Group       string `json:"group"` // Names of created song
+	Song        string `json:"song"`
+	ReleaseDate string `json:"releaseDate"` // Details of updated song, optional for created one
+	Text        string `json:"text"`
+	Link        string `json:"link"`
+	IfVersion   int64  `json:"ifVersion"` // Update or delete only while the song has this version, like If-Match does
+}
+
+type BatchResponse struct {
+	Committed bool                   `json:"committed"` // Whether changes of the batch were saved
+	Results   []BatchOperationResult `json:"results"`
+}
+
+type BatchOperationResult struct {
+	Index  int   `json:"index"`
+	Status int   `json:"status"` // Status the operation would get as a single request
+	Id     int64 `json:"id,omitempty"`
+	Error  any   `json:"error,omitempty"` // Message or validation errors by field
 }
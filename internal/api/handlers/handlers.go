@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"maps"
+	"mime"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,21 +18,22 @@ import (
 	"github.com/Scorzoner/effective-mobile-test/internal/config"
 	"github.com/Scorzoner/effective-mobile-test/internal/database"
 	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+	"github.com/Scorzoner/effective-mobile-test/internal/metadata"
 	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/go-chi/chi/v5"
 )
 
 type HandleQueries struct {
-	connections *sql.DB
-	q           *database.Queries
-	cfg         config.Config
+	q       database.SongStore
+	details metadata.Provider
+	cfg     config.Config
 }
 
-func NewHandlerQueries(connections *sql.DB, cfg config.Config) (*HandleQueries, error) {
-	queries, err := database.NewQueries(connections)
-	if err != nil {
-		return nil, err
-	}
-	return &HandleQueries{connections: connections, q: queries, cfg: cfg}, nil
+// Accepts any [database.SongStore] implementation,
+// e.g. [database.Queries] for postgres or [database.MemoryStore] to run without a database,
+// song details are acquired from the given [metadata.Provider]
+func NewHandlerQueries(store database.SongStore, details metadata.Provider, cfg config.Config) *HandleQueries {
+	return &HandleQueries{q: store, details: details, cfg: cfg}
 }
 
 func (hq *HandleQueries) RequestLogging(h http.Handler) http.Handler {
@@ -45,6 +49,34 @@ func (hq *HandleQueries) RequestLogging(h http.Handler) http.Handler {
 	})
 }
 
+// Header naming who makes the request, recorded in song history and as owner of created playlists
+const actorHeader = "X-Actor"
+
+// Actor recorded for requests without [actorHeader]
+const anonymousActor = "anonymous"
+
+const maxActorLen = 255
+
+// Binds request context to the actor from [actorHeader], see [database.WithActor]
+func (hq *HandleQueries) Actor(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := strings.TrimSpace(r.Header.Get(actorHeader))
+		if actor == "" {
+			actor = anonymousActor
+		}
+
+		v := newValidator()
+		v.check(len(actor) <= maxActorLen, actorHeader,
+			fmt.Sprintf("should be no more than %v characters long, current length %v", maxActorLen, len(actor)))
+		if !v.valid() {
+			badresponses.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(database.WithActor(r.Context(), actor)))
+	})
+}
+
 func (hq *HandleQueries) ResponseLogging(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		lw := logResponseWriter{
@@ -81,57 +113,73 @@ func (r *logResponseWriter) WriteHeader(statusCode int) {
 	r.status = statusCode
 }
 
-func fetchSongDetails(bsi BasicSongInfoJSON, externalAPIURL string) (*additionalSongInfoJSON, error) {
-	parsedURL, err := url.Parse(externalAPIURL)
-	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
-		return nil, fmt.Errorf("invalid or unsupported URL scheme: %s", externalAPIURL)
-	}
-
-	if parsedURL.Host == "" {
-		return nil, fmt.Errorf("URL must have a valid host: %s", externalAPIURL)
-	}
-
-	fullURL := fmt.Sprintf("%s/info?group=%s&song=%s",
-		parsedURL.String(), url.PathEscape(bsi.Group), url.PathEscape(bsi.Song))
+// Lets [http.ResponseController] reach the wrapped writer, e.g. to flush streamed responses
+func (r *logResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
 
-	resp, errResp := http.Get(fullURL)
-	if errResp != nil {
-		return nil, errResp
+// Fetches song details from external api and validates them
+func (hq *HandleQueries) fetchAdditionalSongInfo(ctx context.Context, bsi BasicSongInfoJSON) (*models.AdditionalSongInfo, error) {
+	details, err := hq.details.SongDetails(ctx, bsi.Group, bsi.Song)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch song details from external api: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %s", resp.Status)
-	}
+	externalResponseJSON := additionalSongInfoJSON(*details)
 
-	body, errBody := io.ReadAll(resp.Body)
-	if errBody != nil {
-		return nil, errBody
+	v := newValidator()
+	validateAdditionalSongInfoJSON(v, &externalResponseJSON, &hq.cfg)
+	if !v.valid() {
+		return nil, fmt.Errorf("failed to validate song details from external api: %v", v.Errors)
 	}
 
-	var asi additionalSongInfoJSON
-	errJSON := json.Unmarshal(body, &asi)
-	if errJSON != nil {
-		return nil, errJSON
-	}
+	rd, _ := time.Parse("02.01.2006", externalResponseJSON.ReleaseDate)
+	return &models.AdditionalSongInfo{
+		ReleaseDate: rd,
+		SongLyrics:  externalResponseJSON.Text,
+		Link:        externalResponseJSON.Link}, nil
+}
 
-	return &asi, nil
+// Acquires song details from external api, used by background enrichment workers
+func (hq *HandleQueries) EnrichSong(ctx context.Context, song models.BasicSongInfo) (*models.AdditionalSongInfo, error) {
+	return hq.fetchAdditionalSongInfo(ctx, BasicSongInfoJSON{Group: song.GroupName, Song: song.SongName})
 }
 
 // @Summary		Adds song into library
 // @Tags			music-library
-// @Description	Makes a request into externalAPIURL, if it fails, returns status 201,
-// @Description saves basic song info and writes encountered errors into response body (field "songDetails")
+// @Description	Saves basic song info and returns immediately, details are acquired from externalAPIURL
+// @Description in background (enrichmentStatus is "pending" until they are, "failed" if retries run out).
+// @Description With requireDetails=true details are acquired before responding and song is saved only if that succeeded,
+// @Description otherwise returns status 502 and nothing is saved, song insertion and details are committed in a single transaction.
+// @Description With Idempotency-Key the successful response is saved together with the song for IDEMPOTENCY_KEY_TTL,
+// @Description retries with the key and the same body and parameters get the saved response with Idempotent-Replayed header,
+// @Description the key used with a different request gets 422. Failed responses aren't saved, so the request can be retried,
+// @Description requests with the key made while the first one is in progress wait for it and get its response.
+// @Description With onConflict=update the body takes details too (UpsertSongJSON), song is created with them (201)
+// @Description or details of the existing song are replaced (200) in a single statement, the response says which happened.
+// @Description Songs in trash aren't updated, 409 is returned for them until they are restored
 // @Accept			json
 // @Produce		json
-// @Param			BasicSongInfoJSON	body		BasicSongInfoJSON	true	"group and song names"
-// @Success		200					{object}	models.IdResponse
-// @Success		201					{object}	AddSongFailedExternalAPIResponse
+// @Param			BasicSongInfoJSON	body		BasicSongInfoJSON	true	"group and song names, with onConflict=update also releaseDate, text and link"
+// @Param			requireDetails		query		bool				false	"fail instead of saving song without details"
+// @Param			onConflict			query		string				false	"update details of the song if it exists"	Enums(update)
+// @Param			Idempotency-Key		header		string				false	"unique key of the request, up to 255 characters"
+// @Success		200					{object}	UpsertSongResponse
+// @Success		201					{object}	AddSongResponse
 // @Failure		400					{object}	models.ErrorResponse
+// @Failure		409					{object}	models.ErrorResponse
 // @Failure		422					{object}	models.ErrorResponse
 // @Failure		500					{object}	models.ErrorResponse
+// @Failure		502					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
 // @Router			/music-library/song [post]
 func (hq *HandleQueries) AddSong(w http.ResponseWriter, r *http.Request) {
+	onConflict := r.URL.Query().Get("onConflict")
+	if onConflict == onConflictUpdate {
+		hq.upsertSong(w, r)
+		return
+	}
+
 	var requestJSON BasicSongInfoJSON
 	err := jsonutil.ReadJSON(w, r, &requestJSON)
 	if err != nil {
@@ -142,80 +190,115 @@ func (hq *HandleQueries) AddSong(w http.ResponseWriter, r *http.Request) {
 
 	v := newValidator()
 	validateBasicSongInfoJSON(v, &requestJSON, &hq.cfg)
+	requireDetails := convertAndValidateStringToBool(v, r.URL.Query().Get("requireDetails"), "requireDetails")
+	v.check(onConflict == "", "onConflict", fmt.Sprintf("expected %s, value provided: %v", onConflictUpdate, onConflict))
+	key := validateIdempotencyKey(v, r)
 	if !v.valid() {
 		badresponses.FailedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	bsi := models.BasicSongInfo{Id: 0, GroupName: requestJSON.Group, SongName: requestJSON.Song}
-
-	err = hq.q.AddSong(&bsi)
-	if err != nil {
-		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to add song: %s", err.Error()))
+	idempotent := key != "" && hq.cfg.IdempotencyKeyTTL > 0
+	fingerprint := requestFingerprint(r, requestJSON)
+	if idempotent && hq.replayIdempotentResponse(w, r, key, fingerprint) {
 		return
 	}
 
-	sendOnlyBasicDataWasWrittenResponse := func(externalErr error) {
-		result := map[string]any{
-			"id":          bsi.Id,
-			"songDetails": fmt.Sprintf("were not acquired due to: %s", externalErr.Error())}
-		err = jsonutil.WriteJSON(w, http.StatusCreated, result, nil)
+	// details are acquired before any transaction, so it doesn't stay open during external api calls
+	var asi *models.AdditionalSongInfo
+	if requireDetails {
+		// an existing song is reported as a conflict even while external api is unavailable
+		_, err = hq.q.FindSong(r.Context(), requestJSON.Group, requestJSON.Song)
+		if err == nil {
+			err = database.ErrSongAlreadyExists
+		}
+		if err != database.ErrSongNotFound {
+			badresponses.DatabaseErrorResponse(w, r, "failed to add song", err)
+			return
+		}
+
+		asi, err = hq.fetchAdditionalSongInfo(r.Context(), requestJSON)
 		if err != nil {
-			badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+			badresponses.BadGatewayResponse(w, r, fmt.Sprintf("song was not added: %s", err.Error()))
+			return
+		}
+	}
+
+	add := func(w http.ResponseWriter, store database.SongStore) {
+		if asi != nil {
+			hq.addSongWithDetails(w, r, store, requestJSON, asi)
 			return
 		}
+		hq.addSong(w, r, store, requestJSON)
+	}
+
+	if !idempotent {
+		add(w, hq.q)
+		return
 	}
+	hq.withIdempotencyKey(w, r, key, fingerprint, add)
+}
 
-	externalResponseJSON, err := fetchSongDetails(requestJSON, hq.cfg.ExternalAPIURL)
+func (hq *HandleQueries) addSong(w http.ResponseWriter, r *http.Request, store database.SongStore, requestJSON BasicSongInfoJSON) {
+	bsi := models.BasicSongInfo{Id: 0, GroupName: requestJSON.Group, SongName: requestJSON.Song}
+
+	err := store.AddSong(r.Context(), &bsi)
 	if err != nil {
-		errorResult := fmt.Errorf("failed to fetch song details from external api: %w", err)
-		logger.Zap.Debug(errorResult)
-		sendOnlyBasicDataWasWrittenResponse(errorResult)
+		badresponses.DatabaseErrorResponse(w, r, "failed to add song", err)
 		return
 	}
 
-	validateAdditionalSongInfoJSON(v, externalResponseJSON, &hq.cfg)
-	if !v.valid() {
-		errorResult := fmt.Errorf("failed to validate song details from external api: %v", v.Errors)
-		logger.Zap.Debug(errorResult)
-		sendOnlyBasicDataWasWrittenResponse(errorResult)
+	result := map[string]any{"id": bsi.Id, "enrichmentStatus": models.EnrichmentPending}
+	err = jsonutil.WriteJSON(w, http.StatusCreated, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
 		return
 	}
+}
 
-	rd, _ := time.Parse("02.01.2006", externalResponseJSON.ReleaseDate)
-	asi := models.AdditionalSongInfo{
-		ReleaseDate: rd,
-		SongLyrics:  externalResponseJSON.Text,
-		Link:        externalResponseJSON.Link}
+// Adds song and its details acquired from external api as a single transaction
+func (hq *HandleQueries) addSongWithDetails(w http.ResponseWriter, r *http.Request, store database.SongStore,
+	requestJSON BasicSongInfoJSON, asi *models.AdditionalSongInfo) {
+	bsi := models.BasicSongInfo{Id: 0, GroupName: requestJSON.Group, SongName: requestJSON.Song}
 
-	err = hq.q.UpdateSongInfo(bsi.Id, &asi)
+	err := store.WithTx(r.Context(), func(tx database.SongStore) error {
+		err := tx.AddSong(r.Context(), &bsi)
+		if err != nil {
+			return err
+		}
+		return tx.UpdateSongInfo(r.Context(), bsi.Id, asi, 0)
+	})
 	if err != nil {
-		errorResult := fmt.Errorf("failed to add additional info from external source: %w", err)
-		logger.Zap.Debug(errorResult)
-		sendOnlyBasicDataWasWrittenResponse(errorResult)
+		badresponses.DatabaseErrorResponse(w, r, "failed to add song", err)
 		return
 	}
 
-	result := map[string]any{"id": bsi.Id}
-	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	result := map[string]any{"id": bsi.Id, "enrichmentStatus": models.EnrichmentEnriched}
+	err = jsonutil.WriteJSON(w, http.StatusCreated, result, nil)
 	if err != nil {
-		errorResult := fmt.Errorf("failed writing response: %w", err)
-		logger.Zap.Error(fmt.Errorf("failed writing response: %w", err))
-		sendOnlyBasicDataWasWrittenResponse(errorResult)
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
 		return
 	}
 }
 
-// @Summary		Deletes song from library
+// @Summary		Moves song to trash
 // @Tags			music-library
-// @Description	Returns provided id if deletion succeeded
+// @Description	Trashed songs can be restored until they are purged, see /music-library/trash.
+// @Description	Returns provided id if deletion succeeded,
+// @Description	with If-Match the song is deleted only if its ETag still matches, 412 is returned otherwise.
+// @Description	Song is removed from playlists, unless PLAYLIST_SONG_DELETE_POLICY is restrict, then 409 is returned while it's in any
 // @Accept			plain
 // @Produce		json
-// @Param			id	query		int	true	"song id"
-// @Success		200	{object}	models.IdResponse
-// @Failure		400	{object}	models.ErrorResponse
-// @Failure		422	{object}	models.ErrorResponse
-// @Failure		500	{object}	models.ErrorResponse
+// @Param			id			query		int		true	"song id"
+// @Param			If-Match	header		string	false	"ETag of the song"
+// @Success		200			{object}	models.IdResponse
+// @Failure		400			{object}	models.ErrorResponse
+// @Failure		404			{object}	models.ErrorResponse
+// @Failure		409			{object}	models.ErrorResponse
+// @Failure		412			{object}	models.ErrorResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
 // @Router			/music-library/song [delete]
 func (hq *HandleQueries) DeleteSong(w http.ResponseWriter, r *http.Request) {
 	stringId := r.URL.Query().Get("id")
@@ -227,13 +310,16 @@ func (hq *HandleQueries) DeleteSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := hq.q.DeleteSong(songId)
+	ifVersion, err := hq.ifMatchVersion(r.Context(), r, songId)
+	if err == nil {
+		err = hq.q.DeleteSong(r.Context(), songId, ifVersion)
+	}
 	if err == database.ErrSongNotFound {
-		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to delete song: %s", err.Error()))
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to delete song: %s", err.Error()))
 		return
 	}
 	if err != nil {
-		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed to delete song: %w", err))
+		badresponses.DatabaseErrorResponse(w, r, "failed to delete song", err)
 		return
 	}
 
@@ -255,8 +341,10 @@ func (hq *HandleQueries) DeleteSong(w http.ResponseWriter, r *http.Request) {
 // @Param			pageSize	query		int	true	"number of verses per page"
 // @Success		200			{object}	models.VersesResponse
 // @Failure		400			{object}	models.ErrorResponse
+// @Failure		404			{object}	models.ErrorResponse
 // @Failure		422			{object}	models.ErrorResponse
 // @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
 // @Router			/music-library/lyrics [get]
 func (hq *HandleQueries) GetSongLyrics(w http.ResponseWriter, r *http.Request) {
 	stringId := r.URL.Query().Get("id")
@@ -273,18 +361,18 @@ func (hq *HandleQueries) GetSongLyrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var lyrics string
-	err := hq.q.GetLyrics(songId, &lyrics)
+	err := hq.q.GetLyrics(r.Context(), songId, &lyrics)
 	logger.Zap.Debug(fmt.Sprintf("lyrics fetched: %s", lyrics))
 	if err == database.ErrSongHasNoLyrics {
 		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to fetch song lyrics: %s", err.Error()))
 		return
 	}
 	if err == database.ErrSongNotFound {
-		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to fetch song lyrics: %s", err.Error()))
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to fetch song lyrics: %s", err.Error()))
 		return
 	}
 	if err != nil {
-		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed to get song lyrics: %w", err))
+		badresponses.DatabaseErrorResponse(w, r, "failed to get song lyrics", err)
 		return
 	}
 
@@ -323,52 +411,277 @@ func (hq *HandleQueries) GetSongLyrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func newListRowResult(row *models.FullSongInfo) ListRowResult {
+	var res ListRowResult
+	res.Id = int32(row.Id)
+	res.GroupId = row.GroupId
+	res.GroupName = row.GroupName
+	res.SongName = row.SongName
+	if row.ReleaseDate.Valid {
+		res.ReleaseDate = time.Time.Format(row.ReleaseDate.Time, "02.01.2006")
+	}
+	if row.SongLyrics.Valid {
+		res.Text = row.SongLyrics.String
+	}
+	if row.Link.Valid {
+		res.Link = row.Link.String
+	}
+	res.EnrichmentStatus = row.EnrichmentStatus
+	res.Version = row.Version
+	if row.Match != nil {
+		res.Match = &SearchMatchResult{Rank: row.Match.Rank, Snippet: row.Match.Snippet, Verse: row.Match.Verse}
+	}
+	if row.DeletedAt.Valid {
+		res.DeletedAt = &row.DeletedAt.Time
+	}
+	return res
+}
+
+// @Summary		Fetches a single song
+// @Tags			music-library
+// @Description	Responds with ETag (song version) and Last-Modified headers,
+// @Description	if the song didn't change since (If-None-Match/If-Modified-Since) returns status 304 without body.
+// @Description	fields leaves only the listed fields of the song in the response
+// @Accept			plain
+// @Produce		json
+// @Param			id					path		int		true	"song id"
+// @Param			fields				query		string	false	"comma separated fields to respond with, e.g. id,group,song"
+// @Param			If-None-Match		header		string	false	"ETag of a cached copy"
+// @Param			If-Modified-Since	header		string	false	"Last-Modified of a cached copy"
+// @Success		200					{object}	ListRowResult
+// @Success		304
+// @Failure		404					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/song/{id} [get]
+func (hq *HandleQueries) GetSong(w http.ResponseWriter, r *http.Request) {
+	stringId := chi.URLParam(r, "id")
+
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, stringId, "id")
+	fields := fieldsFromQuery(v, r.URL.Query().Get("fields"))
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	song, err := hq.q.GetSong(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get song: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get song", err)
+		return
+	}
+
+	result := sparseRow(newListRowResult(song), fields)
+	headers := songHeaders(song)
+
+	if notModified(r, headers.Get("ETag"), song.UpdatedAt) {
+		for key, value := range headers {
+			w.Header()[key] = value
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, headers)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
 // @Summary		Fetches song data in pages
 // @Tags			music-library
-// @Description	page and pageSize are required, every other field is a filter, if it's empty, it is treated as absence of filter
+// @Description	page and pageSize are required, every other field is a filter, if it's empty, it is treated as absence of filter.
+// @Description	group, song and text look for substrings ignoring case and accents, repeated they match any of the values.
+// @Description	Operator in brackets changes the match: group[exact], group[prefix], negations group[not], group[notExact] and group[notPrefix].
+// @Description	Instead of page, cursor can be given to get songs next to those of the previous response (nextCursor or prevCursor),
+// @Description	cursor pages are stable when songs are added or deleted between requests and stay fast on deep pages.
+// @Description	search looks for words of lyrics (e.g. `love -war`, `"yellow submarine"`, `sun or moon`) with stemming for lang,
+// @Description	found songs are ordered by relevance and carry highlighted fragments of lyrics and the number of the first matched verse.
+// @Description	sort lists fields to order songs by, - before a field reverses its order (e.g. `-releaseDate,group`),
+// @Description	songs are ordered by id when sort is empty, missing release dates count as later than any date.
 // @Accept			plain
 // @Produce		json
-// @Param			group				query		string	false	"group name"
-// @Param			song				query		string	false	"song name"
+// @Param			groupId				query		[]int		false	"group id"		collectionFormat(multi)
+// @Param			albumId				query		[]int		false	"album id"		collectionFormat(multi)
+// @Param			genre				query		[]string	false	"genre name"	collectionFormat(multi)
+// @Param			tag					query		[]string	false	"tag name"		collectionFormat(multi)
+// @Param			tagMatch			query		string		false	"songs need any (default) or all of the genres and tags"	Enums(any, all)
+// @Param			group				query		[]string	false	"group name"	collectionFormat(multi)
+// @Param			song				query		[]string	false	"song name"		collectionFormat(multi)
 // @Param			releaseDateLower	query		string	false	"dates before this will not show up"
 // @Param			releaseDateUpper	query		string	false	"dates after this will not show up"
-// @Param			text				query		string	false	"lyrics"
-// @Param			page				query		int	true	"page number"
-// @Param			pageSize			query		int	true	"number of songs displayed per page"
+// @Param			text				query		[]string	false	"lyrics"		collectionFormat(multi)
+// @Param			hasLyrics			query		bool		false	"whether song has lyrics"
+// @Param			hasLink				query		bool		false	"whether song has link"
+// @Param			search				query		string	false	"full-text search of lyrics"
+// @Param			lang				query		string	false	"language of search"	Enums(english, russian, simple)
+// @Param			sort				query		string	false	"fields of id, group, song, releaseDate and relevance"
+// @Param			fields				query		string	false	"comma separated fields of rows, e.g. id,group,song, lyrics aren't read unless listed"
+// @Param			page				query		int		false	"page number, required without cursor"
+// @Param			cursor				query		string	false	"nextCursor or prevCursor of the previous response"
+// @Param			pageSize			query		int		true	"number of songs displayed per page"	maximum(1000)
+// @Param			count				query		string	false	"estimated takes number of songs from table statistics when the list isn't filtered"	Enums(exact, estimated)
+// @Header			200					{string}	Link	"first, prev, next and last pages (RFC 8288)"
 // @Success		200					{object}	FilteredListResponse
 // @Failure		400					{object}	models.ErrorResponse
 // @Failure		422					{object}	models.ErrorResponse
 // @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
 // @Router			/music-library/list [get]
 func (hq *HandleQueries) GetFilteredList(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	dbFilter := hq.listFilterFromQuery(v, r.URL.Query())
+	estimate := countEstimateFromQuery(v, r.URL.Query())
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	response, err := hq.listPage(r.Context(), &dbFilter, estimate)
+	if err == database.ErrInvalidCursor {
+		badresponses.FailedValidationResponse(w, r, map[string]string{"cursor": err.Error()})
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get filtered list", err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, response, paginationLinks(r.URL, response))
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// Operators of text filters, written in brackets after the parameter, e.g. group[prefix]=The.
+// Parameter without operator looks for substrings
+var textFilterOperators = map[string]database.TextFilter{
+	"":          {Mode: database.MatchContains},
+	"contains":  {Mode: database.MatchContains},
+	"exact":     {Mode: database.MatchExact},
+	"prefix":    {Mode: database.MatchPrefix},
+	"not":       {Mode: database.MatchContains, Negate: true},
+	"notExact":  {Mode: database.MatchExact, Negate: true},
+	"notPrefix": {Mode: database.MatchPrefix, Negate: true},
+}
+
+// Collects filters of param, repeated parameter matches any of its values (none of them for negations),
+// different operators must all match. Empty values are ignored
+func textFiltersFromQuery(v *validator, rq url.Values, param string) []database.TextFilter {
+	var filters []database.TextFilter
+	for _, key := range slices.Sorted(maps.Keys(rq)) {
+		operator, found := strings.CutPrefix(key, param)
+		if !found {
+			continue
+		}
+		if operator != "" {
+			if !strings.HasPrefix(operator, "[") || !strings.HasSuffix(operator, "]") {
+				continue
+			}
+			operator = operator[1 : len(operator)-1]
+		}
+
+		filter, known := textFilterOperators[operator]
+		if !known {
+			v.addError(key, "unknown operator, should be one of contains, exact, prefix, not, notExact, notPrefix")
+			continue
+		}
+		for _, value := range rq[key] {
+			if value != "" {
+				filter.Values = append(filter.Values, value)
+			}
+		}
+		if len(filter.Values) > 0 {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+// Parses comma separated sort fields, each optionally prefixed with - for descending order
+// or + for ascending (e.g. -releaseDate,group)
+func sortFromQuery(v *validator, sort string, search bool) []database.SortKey {
+	var keys []database.SortKey
+	for _, field := range strings.Split(sort, ",") {
+		key := database.SortKey{Field: strings.TrimSpace(field)}
+		if strings.HasPrefix(key.Field, "-") {
+			key.Field, key.Desc = key.Field[1:], true
+		} else {
+			key.Field = strings.TrimPrefix(key.Field, "+")
+		}
+
+		if !slices.Contains(database.SortFields, key.Field) {
+			v.addError("sort", fmt.Sprintf("unknown field %q, should be one of %s",
+				key.Field, strings.Join(database.SortFields, ", ")))
+			continue
+		}
+		v.check(key.Field != database.SortRelevance || search, "sort",
+			fmt.Sprintf("%s is only available with search", database.SortRelevance))
+		v.check(!slices.ContainsFunc(keys, func(k database.SortKey) bool { return k.Field == key.Field }),
+			"sort", fmt.Sprintf("field %s is listed more than once", key.Field))
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Text search configurations that lyrics are indexed with, see migration 007_add_lyrics_search
+var searchLanguages = []string{"english", "russian", "simple"}
+
+// Converts list query parameters into [database.ListFilter], empty parameters mean absence of filter
+func (hq *HandleQueries) listFilterFromQuery(v *validator, rq url.Values) database.ListFilter {
 	var filter FilterRequest
-	rq := r.URL.Query()
-	filter.GroupName = rq.Get("group")
-	filter.SongName = rq.Get("song")
-	filter.ReleaseDateLowerBound = rq.Get("releaseDateLower")
-	filter.ReleaseDateUpperBound = rq.Get("releaseDateUpper")
-	filter.Text = rq.Get("text")
 
-	v := newValidator()
-	filter.Page = convertAndValidateStringToInt64(v, rq.Get("page"), "page")
+	// page is replaced by cursor when listing pages by keys
+	if rq.Get("cursor") == "" {
+		filter.Page = convertAndValidateStringToInt64(v, rq.Get("page"), "page")
+	} else {
+		v.check(rq.Get("page") == "", "page", "should not be provided together with cursor")
+	}
 	filter.PageSize = convertAndValidateStringToInt64(v, rq.Get("pageSize"), "pageSize")
+	limit, offset := pageLimitOffset(v, filter.Page, filter.PageSize)
 
-	var dbFilter database.ListFilter
+	dbFilter := hq.songFilterFromQuery(v, rq)
 
-	if filter.GroupName == "" {
-		dbFilter.GroupName.Valid = false
-	} else {
-		dbFilter.GroupName.Valid = true
-		dbFilter.GroupName.String = filter.GroupName
+	if encoded := rq.Get("cursor"); encoded != "" {
+		cursor, err := decodeCursor(encoded)
+		v.check(err == nil, "cursor", "is malformed")
+		dbFilter.Cursor = cursor
 	}
 
-	if filter.SongName == "" {
-		dbFilter.SongName.Valid = false
-	} else {
-		dbFilter.SongName.Valid = true
-		dbFilter.SongName.String = filter.SongName
+	// one extra row tells whether there's a next page, see [HandleQueries.listPage]
+	dbFilter.Limit = limit + 1
+	if dbFilter.Cursor == nil {
+		dbFilter.Offset = offset
 	}
 
+	return dbFilter
+}
+
+// Builds filters, sort and fields of the list from query parameters, leaving pagination to the caller
+func (hq *HandleQueries) songFilterFromQuery(v *validator, rq url.Values) database.ListFilter {
+	var filter FilterRequest
+	filter.ReleaseDateLowerBound = rq.Get("releaseDateLower")
+	filter.ReleaseDateUpperBound = rq.Get("releaseDateUpper")
+
+	var dbFilter database.ListFilter
+
+	for _, groupId := range rq["groupId"] {
+		dbFilter.GroupIds = append(dbFilter.GroupIds, convertAndValidateStringToInt64(v, groupId, "groupId"))
+	}
+	for _, albumId := range rq["albumId"] {
+		dbFilter.AlbumIds = append(dbFilter.AlbumIds, convertAndValidateStringToInt64(v, albumId, "albumId"))
+	}
+	dbFilter.Tags, dbFilter.AllTags = tagsFromQuery(v, rq)
+	dbFilter.GroupName = textFiltersFromQuery(v, rq, "group")
+	dbFilter.SongName = textFiltersFromQuery(v, rq, "song")
+
 	if filter.ReleaseDateLowerBound == "" {
 		dbFilter.ReleaseDateLowerBound.Valid = false
 	} else {
@@ -385,65 +698,49 @@ func (hq *HandleQueries) GetFilteredList(w http.ResponseWriter, r *http.Request)
 		dbFilter.ReleaseDateUpperBound.Valid = true
 	}
 
-	if filter.Text == "" {
-		dbFilter.Lyrics.Valid = false
-	} else {
-		dbFilter.Lyrics.Valid = true
-		dbFilter.Lyrics.String = filter.Text
-	}
-
-	dbFilter.Limit = int32(filter.PageSize)
-	dbFilter.Offset = int32((filter.Page - 1) * filter.PageSize)
+	dbFilter.Lyrics = textFiltersFromQuery(v, rq, "text")
 
-	if !v.valid() {
-		badresponses.FailedValidationResponse(w, r, v.Errors)
-		return
+	if hasLyrics := rq.Get("hasLyrics"); hasLyrics != "" {
+		dbFilter.HasLyrics = sql.NullBool{Bool: convertAndValidateStringToBool(v, hasLyrics, "hasLyrics"), Valid: true}
 	}
-
-	resultNullable, err := hq.q.GetFilteredList(&dbFilter)
-	if err != nil {
-		badresponses.InternalServerErrorResponse(
-			w, r, fmt.Errorf("failed to get filtered list: %w", err))
-		return
+	if hasLink := rq.Get("hasLink"); hasLink != "" {
+		dbFilter.HasLink = sql.NullBool{Bool: convertAndValidateStringToBool(v, hasLink, "hasLink"), Valid: true}
 	}
 
-	var result []ListRowResult
-	for _, row := range resultNullable {
-		var res ListRowResult
-		res.Id = int32(row.Id)
-		res.GroupName = row.GroupName
-		res.SongName = row.SongName
-		if row.ReleaseDate.Valid {
-			res.ReleaseDate = time.Time.Format(row.ReleaseDate.Time, "02.01.2006")
-		}
-		if row.SongLyrics.Valid {
-			res.Text = row.SongLyrics.String
-		}
-		if row.Link.Valid {
-			res.Link = row.Link.String
-		}
-
-		result = append(result, res)
+	if search := rq.Get("search"); search != "" {
+		dbFilter.Search = sql.NullString{String: search, Valid: true}
+	}
+	dbFilter.SearchLanguage = hq.cfg.SearchDefaultLanguage
+	if lang := rq.Get("lang"); lang != "" {
+		v.check(slices.Contains(searchLanguages, lang), "lang",
+			fmt.Sprintf("should be one of %s", strings.Join(searchLanguages, ", ")))
+		dbFilter.SearchLanguage = lang
 	}
 
-	resultMap := map[string]any{"filteredRows": result}
-	err = jsonutil.WriteJSON(w, http.StatusOK, resultMap, nil)
-	if err != nil {
-		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
-		return
+	if sort := rq.Get("sort"); sort != "" {
+		dbFilter.Sort = sortFromQuery(v, sort, dbFilter.Search.Valid)
 	}
+
+	dbFilter.Fields = fieldsFromQuery(v, rq.Get("fields"))
+
+	return dbFilter
 }
 
 // @Summary		Updates song info
 // @Tags			music-library
-// @Description	You need to provide id and 3 other fields, on success returns provided id
+// @Description	You need to provide id and 3 other fields, on success returns provided id,
+// @Description	with If-Match the song is updated only if its ETag still matches, 412 is returned otherwise
 // @Accept			json
 // @Produce		json
 // @Param			UpdateRequestJSON	body		UpdateRequestJSON	true	"id and additional info"
+// @Param			If-Match			header		string				false	"ETag of the song"
 // @Success		200					{object}	models.IdResponse
 // @Failure		400					{object}	models.ErrorResponse
+// @Failure		404					{object}	models.ErrorResponse
+// @Failure		412					{object}	models.ErrorResponse
 // @Failure		422					{object}	models.ErrorResponse
 // @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
 // @Router			/music-library/song [put]
 func (hq *HandleQueries) UpdateSongInfo(w http.ResponseWriter, r *http.Request) {
 	var requestJSON UpdateRequestJSON
@@ -466,13 +763,16 @@ func (hq *HandleQueries) UpdateSongInfo(w http.ResponseWriter, r *http.Request)
 		SongLyrics:  requestJSON.Text,
 		Link:        requestJSON.Link}
 
-	err = hq.q.UpdateSongInfo(requestJSON.Id, &asi)
+	ifVersion, err := hq.ifMatchVersion(r.Context(), r, requestJSON.Id)
+	if err == nil {
+		err = hq.q.UpdateSongInfo(r.Context(), requestJSON.Id, &asi, ifVersion)
+	}
 	if err == database.ErrSongNotFound {
-		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to update song info: %s", err.Error()))
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to update song info: %s", err.Error()))
 		return
 	}
 	if err != nil {
-		badresponses.InternalServerErrorResponse(w, r, fmt.Sprintf("failed to update song info: %s", err.Error()))
+		badresponses.DatabaseErrorResponse(w, r, "failed to update song info", err)
 		return
 	}
 
@@ -483,3 +783,138 @@ func (hq *HandleQueries) UpdateSongInfo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 }
+
+// @Summary		Partially updates song
+// @Tags			music-library
+// @Description	Accepts either JSON Merge Patch (RFC 7396, Content-Type application/merge-patch+json)
+// @Description	or JSON Patch (RFC 6902, Content-Type application/json-patch+json) of the song document
+// @Description	{"group", "song", "releaseDate", "text", "link"}, only the supplied fields are changed,
+// @Description	details (releaseDate, text, link) can be cleared with null. On success returns the updated song and its ETag,
+// @Description	with If-Match the song is patched only if its ETag still matches, 412 is returned otherwise
+// @Accept			application/merge-patch+json
+// @Accept			application/json-patch+json
+// @Produce		json
+// @Param			id			query		int		true	"song id"
+// @Param			If-Match	header		string	false	"ETag of the song"
+// @Param			patch		body		object	true	"merge patch object or array of json patch operations"
+// @Success		200			{object}	ListRowResult
+// @Failure		400			{object}	models.ErrorResponse
+// @Failure		404			{object}	models.ErrorResponse
+// @Failure		409			{object}	models.ErrorResponse
+// @Failure		412			{object}	models.ErrorResponse
+// @Failure		415			{object}	models.ErrorResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
+// @Router			/music-library/song [patch]
+func (hq *HandleQueries) PatchSong(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, r.URL.Query().Get("id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ifVersion, err := hq.ifMatchVersion(r.Context(), r, songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to patch song", err)
+		return
+	}
+
+	var fields map[string]any
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchMediaType:
+		err := jsonutil.ReadJSON(w, r, &fields)
+		if err != nil {
+			badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+			return
+		}
+	case jsonPatchMediaType:
+		var ops []JSONPatchOperation
+		err := jsonutil.ReadJSON(w, r, &ops)
+		if err != nil {
+			badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+			return
+		}
+
+		song, err := hq.q.GetSong(r.Context(), songId)
+		if err == database.ErrSongNotFound {
+			badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+			return
+		}
+		if err != nil {
+			badresponses.DatabaseErrorResponse(w, r, "failed to patch song", err)
+			return
+		}
+		if ifVersion != 0 && ifVersion != song.Version {
+			badresponses.DatabaseErrorResponse(w, r, "failed to patch song", database.ErrVersionMismatch)
+			return
+		}
+		// Operations were applied to this version, so it must not change before they are saved
+		ifVersion = song.Version
+
+		before, after := songDocument(song), songDocument(song)
+		err = applyJSONPatch(after, ops)
+		if errors.Is(err, errPatchTestFailed) {
+			badresponses.ConflictResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+			return
+		}
+		if err != nil {
+			v.addError("patch", err.Error())
+			badresponses.FailedValidationResponse(w, r, v.Errors)
+			return
+		}
+		fields = changedFields(before, after)
+	default:
+		badresponses.UnsupportedMediaTypeResponse(w, r,
+			fmt.Sprintf("expected Content-Type %s or %s", mergePatchMediaType, jsonPatchMediaType))
+		return
+	}
+
+	patch := validateSongPatch(v, fields, &hq.cfg)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if len(fields) > 0 {
+		err := hq.q.PatchSong(r.Context(), songId, patch, ifVersion)
+		if err == database.ErrSongNotFound {
+			badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+			return
+		}
+		if err != nil {
+			badresponses.DatabaseErrorResponse(w, r, "failed to patch song", err)
+			return
+		}
+	}
+
+	song, err := hq.q.GetSong(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to patch song: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get patched song", err)
+		return
+	}
+	// Empty patch doesn't touch the song, its precondition is checked against the current version
+	if len(fields) == 0 && ifVersion != 0 && ifVersion != song.Version {
+		badresponses.DatabaseErrorResponse(w, r, "failed to patch song", database.ErrVersionMismatch)
+		return
+	}
+
+	headers := songHeaders(song)
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, newListRowResult(song), headers)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
@@ -0,0 +1,286 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/router"
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/metadata"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+func testConfig() config.Config {
+	return config.Config{
+		IdempotencyKeyTTL: time.Hour,
+		MaxGroupNameLen:   450,
+		MaxSongNameLen:    450,
+		MaxSongLyricsLen:  10000,
+		MaxSongLinkLen:    450,
+	}
+}
+
+var errUnavailable = errors.New("external api is unavailable")
+
+// Provider answering with the same details for every song, or with err if it's set.
+// If release is set, calls wait for it to be closed
+type fakeDetails struct {
+	mu      sync.Mutex
+	calls   int
+	err     error
+	release chan struct{}
+}
+
+func (p *fakeDetails) SongDetails(ctx context.Context, group, song string) (*metadata.SongDetails, error) {
+	p.mu.Lock()
+	p.calls++
+	err, release := p.err, p.release
+	p.mu.Unlock()
+
+	if release != nil {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.SongDetails{
+		ReleaseDate: "16.07.2006",
+		Text:        "Ooh baby, don't you know I suffer?\n\nOoh baby, can you hear me moan?",
+		Link:        "https://example.com",
+	}, nil
+}
+
+func (p *fakeDetails) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *fakeDetails) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// Router of handlers running on a store, details of songs come from fakeDetails
+type testAPI struct {
+	t       *testing.T
+	handler http.Handler
+	hq      *handlers.HandleQueries
+	store   database.SongStore
+	details *fakeDetails
+}
+
+// Runs handlers on an empty [database.MemoryStore]
+func newTestAPI(t *testing.T) *testAPI {
+	return newTestAPIWithStore(t, database.NewMemoryStore())
+}
+
+func newTestAPIWithStore(t *testing.T, store database.SongStore) *testAPI {
+	details := &fakeDetails{}
+	hq := handlers.NewHandlerQueries(store, details, testConfig())
+	return &testAPI{t: t, handler: router.New(hq), hq: hq, store: store, details: details}
+}
+
+// Serves the request, header holds pairs of header names and values
+func (api *testAPI) do(method, target, body string, header ...string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	if len(header)%2 != 0 {
+		api.t.Fatalf("header should hold pairs of names and values, got %v", header)
+	}
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, r)
+	return w
+}
+
+// Same as do, failing the test unless the response has the status
+func (api *testAPI) expect(status int, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	w := api.do(method, target, body, header...)
+	if w.Code != status {
+		api.t.Fatalf("%s %s: got status %d, want %d, body: %s", method, target, w.Code, status, w.Body.String())
+	}
+	return w
+}
+
+// Adds songs of the group, returns their ids in the same order
+func (api *testAPI) addSongs(group string, songs ...string) []int64 {
+	api.t.Helper()
+	var ids []int64
+	for _, song := range songs {
+		w := api.expect(http.StatusCreated, http.MethodPost, "/music-library/song",
+			fmt.Sprintf(`{"group":%q,"song":%q}`, group, song))
+
+		var result handlers.AddSongResponse
+		decode(api.t, w, &result)
+		if result.EnrichmentStatus != models.EnrichmentPending {
+			api.t.Fatalf("got enrichment status %q, want details to be acquired in background", result.EnrichmentStatus)
+		}
+		ids = append(ids, int64(result.Id))
+	}
+	return ids
+}
+
+// Acquires details of every queued song once, the way enrichment workers do with their last attempt
+func (api *testAPI) enrich() {
+	api.t.Helper()
+	ctx := context.Background()
+	jobs, err := api.store.ClaimEnrichmentJobs(ctx, 100, time.Minute)
+	if err != nil {
+		api.t.Fatalf("failed to claim enrichment jobs: %v", err)
+	}
+	for _, job := range jobs {
+		info, err := api.hq.EnrichSong(ctx, models.BasicSongInfo{Id: job.SongId, GroupName: job.GroupName, SongName: job.SongName})
+		if err == nil {
+			err = api.store.UpdateSongInfo(ctx, job.SongId, info, 0)
+		} else {
+			err = api.store.FailEnrichmentJob(ctx, job.Id)
+		}
+		if err != nil {
+			api.t.Fatalf("failed to finish enrichment job %d: %v", job.Id, err)
+		}
+	}
+}
+
+func (api *testAPI) getSong(id int64) handlers.ListRowResult {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/song/%d", id), "")
+
+	var song handlers.ListRowResult
+	decode(api.t, w, &song)
+	return song
+}
+
+func (api *testAPI) list(query string) []handlers.ListRowResult {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/list?"+query, "")
+
+	var response struct {
+		FilteredRows []handlers.ListRowResult `json:"filteredRows"`
+	}
+	decode(api.t, w, &response)
+	return response.FilteredRows
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, dst any) {
+	t.Helper()
+	err := json.Unmarshal(w.Body.Bytes(), dst)
+	if err != nil {
+		t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestSongLifecycle(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Hysteria")[0]
+
+	rows := api.list("group=muse&page=1&pageSize=10")
+	if len(rows) != 1 || rows[0].SongName != "Hysteria" || rows[0].EnrichmentStatus != models.EnrichmentPending {
+		t.Fatalf("got rows %+v, want Hysteria waiting for details", rows)
+	}
+	api.enrich()
+	rows = api.list("group=muse&page=1&pageSize=10")
+	if len(rows) != 1 || rows[0].ReleaseDate != "16.07.2006" || rows[0].EnrichmentStatus != models.EnrichmentEnriched {
+		t.Fatalf("got rows %+v, want Hysteria with details from external api", rows)
+	}
+
+	w := api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/lyrics?id=%d&page=2&pageSize=1", id), "")
+	if body := w.Body.String(); !strings.Contains(body, "can you hear me moan") || strings.Contains(body, "I suffer") {
+		t.Errorf("got body %s, want the second verse only", body)
+	}
+
+	api.expect(http.StatusOK, http.MethodPut, "/music-library/song",
+		fmt.Sprintf(`{"id":%d,"releaseDate":"01.12.2003","text":"It's bugging me","link":"https://example.com/hysteria"}`, id))
+	if rows := api.list("text=BUGGING&page=1&pageSize=10"); len(rows) != 1 || rows[0].ReleaseDate != "01.12.2003" {
+		t.Errorf("got rows %+v, want the updated song", rows)
+	}
+
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", id), "")
+	if rows := api.list("page=1&pageSize=10"); len(rows) != 0 {
+		t.Errorf("got rows %+v after deletion", rows)
+	}
+	api.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/music-library/lyrics?id=%d&page=1&pageSize=1", id), "")
+	api.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/music-library/song/%d", id), "")
+	api.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", id), "")
+	api.expect(http.StatusNotFound, http.MethodPut, "/music-library/song",
+		fmt.Sprintf(`{"id":%d,"releaseDate":"01.12.2003","text":"It's bugging me","link":"https://example.com/hysteria"}`, id))
+}
+
+func TestAddSongWithoutDetails(t *testing.T) {
+	api := newTestAPI(t)
+	api.details.setErr(errUnavailable)
+	api.addSongs("Muse", "Hysteria")
+
+	api.enrich()
+	rows := api.list("page=1&pageSize=10")
+	if len(rows) != 1 || rows[0].Text != "" || rows[0].EnrichmentStatus != models.EnrichmentFailed {
+		t.Errorf("got rows %+v, want the song without details", rows)
+	}
+}
+
+func TestAddSongTwice(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Hysteria")
+
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Hysteria"}`)
+	api.addSongs("Muse", "Starlight")
+}
+
+func TestAddSongRequiringDetails(t *testing.T) {
+	api := newTestAPI(t)
+	api.details.setErr(errUnavailable)
+
+	api.expect(http.StatusBadGateway, http.MethodPost, "/music-library/song?requireDetails=true", `{"group":"Muse","song":"Hysteria"}`)
+	if rows := api.list("page=1&pageSize=10"); len(rows) != 0 {
+		t.Fatalf("got rows %+v, want nothing saved without details", rows)
+	}
+
+	api.details.setErr(nil)
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/song?requireDetails=true", `{"group":"Muse","song":"Hysteria"}`)
+	if rows := api.list("page=1&pageSize=10"); len(rows) != 1 || rows[0].Text == "" {
+		t.Errorf("got rows %+v, want the song with details", rows)
+	}
+
+	// existing songs are conflicts whether details can be acquired or not
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/song?requireDetails=true", `{"group":"Muse","song":"Hysteria"}`)
+	api.details.setErr(errUnavailable)
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/song?requireDetails=true", `{"group":"Muse","song":"Hysteria"}`)
+}
+
+func TestCanceledRequest(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Hysteria")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "/music-library/list?page=1&pageSize=10", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	api.handler.ServeHTTP(w, r)
+	if w.Code != badresponses.StatusClientClosedRequest {
+		t.Errorf("got status %d, want %d", w.Code, badresponses.StatusClientClosedRequest)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func newRevisionResult(revision *models.SongRevision) RevisionResult {
+	res := RevisionResult{
+		Revision:  revision.Id,
+		Version:   revision.Version,
+		Operation: revision.Operation,
+		Actor:     revision.Actor,
+		ChangedAt: revision.ChangedAt,
+	}
+	if revision.Old != nil {
+		res.Old = valuesDocument(revision.Old)
+	}
+	if revision.New != nil {
+		res.New = valuesDocument(revision.New)
+	}
+
+	res.ChangedFields = []string{}
+	for field := range changedFields(res.Old, res.New) {
+		res.ChangedFields = append(res.ChangedFields, field)
+	}
+	sort.Strings(res.ChangedFields)
+	return res
+}
+
+// @Summary		Fetches history of song changes
+// @Tags			history
+// @Description	Revisions are ordered from the oldest, each one holds values of the song before and after the change,
+// @Description	who made it (X-Actor header of the request) and when. History is kept after the song is purged
+// @Accept			plain
+// @Produce		json
+// @Param			id			path		int	true	"song id"
+// @Param			page		query		int	true	"page number"
+// @Param			pageSize	query		int	true	"number of revisions per page"	maximum(1000)
+// @Success		200			{object}	SongHistoryResponse
+// @Failure		404			{object}	models.ErrorResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
+// @Router			/music-library/song/{id}/history [get]
+func (hq *HandleQueries) GetSongHistory(w http.ResponseWriter, r *http.Request) {
+	rq := r.URL.Query()
+
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	page := convertAndValidateStringToInt64(v, rq.Get("page"), "page")
+	pageSize := convertAndValidateStringToInt64(v, rq.Get("pageSize"), "pageSize")
+	limit, offset := pageLimitOffset(v, page, pageSize)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, err := hq.q.GetSongHistory(r.Context(), songId, limit, offset)
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get song history", err)
+		return
+	}
+	if len(revisions) == 0 && offset == 0 {
+		badresponses.ResourceNotFoundResponse(w, r, "failed to get song history: no matching record in database")
+		return
+	}
+
+	result := SongHistoryResponse{Revisions: []RevisionResult{}}
+	for _, revision := range revisions {
+		result.Revisions = append(result.Revisions, newRevisionResult(&revision))
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Reverts song to a revision
+// @Tags			history
+// @Description	Sets group, song and details back to the values the song had after the chosen revision,
+// @Description	the revert itself is recorded as a new revision. Trashed songs have to be restored first.
+// @Description	With If-Match the song is reverted only if its ETag still matches, 412 is returned otherwise
+// @Accept			plain
+// @Produce		json
+// @Param			id			path		int		true	"song id"
+// @Param			revision	path		int		true	"revision to revert to"
+// @Param			If-Match	header		string	false	"ETag of the song"
+// @Success		200			{object}	ListRowResult
+// @Failure		404			{object}	models.ErrorResponse
+// @Failure		409			{object}	models.ErrorResponse
+// @Failure		412			{object}	models.ErrorResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
+// @Router			/music-library/song/{id}/history/{revision}/revert [post]
+func (hq *HandleQueries) RevertSong(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	revisionId := convertAndValidateStringToInt64(v, chi.URLParam(r, "revision"), "revision")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revision, err := hq.q.GetRevision(r.Context(), revisionId)
+	if err == database.ErrRevisionNotFound || err == nil && revision.SongId != songId {
+		badresponses.ResourceNotFoundResponse(w, r, "failed to revert song: no matching revision in database")
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to revert song", err)
+		return
+	}
+	if revision.New == nil {
+		badresponses.ConflictResponse(w, r, "failed to revert song: revision purged the song, there's nothing to revert to")
+		return
+	}
+
+	ifVersion, err := hq.ifMatchVersion(r.Context(), r, songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to revert song: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to revert song", err)
+		return
+	}
+
+	song, err := hq.q.GetSong(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to revert song: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to revert song", err)
+		return
+	}
+	if ifVersion != 0 && ifVersion != song.Version {
+		badresponses.DatabaseErrorResponse(w, r, "failed to revert song", database.ErrVersionMismatch)
+		return
+	}
+
+	fields := changedFields(songDocument(song), valuesDocument(revision.New))
+	patch := validateSongPatch(v, fields, &hq.cfg)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if len(fields) > 0 {
+		// Values were compared against this version, so it must not change before they are saved
+		err = hq.q.PatchSong(r.Context(), songId, patch, song.Version)
+		if err == database.ErrSongNotFound {
+			badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to revert song: %s", err.Error()))
+			return
+		}
+		if err != nil {
+			badresponses.DatabaseErrorResponse(w, r, "failed to revert song", err)
+			return
+		}
+
+		song, err = hq.q.GetSong(r.Context(), songId)
+		if err == database.ErrSongNotFound {
+			badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get reverted song: %s", err.Error()))
+			return
+		}
+		if err != nil {
+			badresponses.DatabaseErrorResponse(w, r, "failed to get reverted song", err)
+			return
+		}
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, newListRowResult(song), songHeaders(song))
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
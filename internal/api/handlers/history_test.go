@@ -0,0 +1,144 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+func (api *testAPI) history(id int64, query string) []handlers.RevisionResult {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/song/%d/history?%s", id, query), "")
+
+	var response handlers.SongHistoryResponse
+	decode(api.t, w, &response)
+	return response.Revisions
+}
+
+func TestHistoryRecordsActors(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Hysteria")[0]
+	// details come from enrichment workers, not from a request
+	api.enrich()
+	api.expect(http.StatusOK, http.MethodPatch, fmt.Sprintf("/music-library/song?id=%d", id),
+		`{"text":"It's bugging me"}`, "Content-Type", "application/merge-patch+json", "X-Actor", " matt ")
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", id), "", "X-Actor", "dom")
+	api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/trash/%d/restore", id), "", "X-Actor", "chris")
+
+	revisions := api.history(id, "page=1&pageSize=10")
+	want := []struct {
+		operation, actor string
+		version          int64
+	}{
+		{models.HistoryCreate, "anonymous", 1},
+		{models.HistoryUpdate, "system", 2},
+		{models.HistoryUpdate, "matt", 3},
+		{models.HistoryDelete, "dom", 4},
+		{models.HistoryRestore, "chris", 5},
+	}
+	if len(revisions) != len(want) {
+		t.Fatalf("got revisions %+v, want %d of them", revisions, len(want))
+	}
+	for i, w := range want {
+		got := revisions[i]
+		if got.Operation != w.operation || got.Actor != w.actor || got.Version != w.version {
+			t.Errorf("revision %d: got %s by %q at version %d, want %s by %q at version %d",
+				i+1, got.Operation, got.Actor, got.Version, w.operation, w.actor, w.version)
+		}
+	}
+
+	if created := revisions[0]; created.Old != nil || created.New["song"] != "Hysteria" {
+		t.Errorf("got created revision %+v, want values of the new song only", created)
+	}
+	if edited := revisions[2]; !slices.Equal(edited.ChangedFields, []string{"text"}) ||
+		edited.Old["text"] == edited.New["text"] || edited.New["text"] != "It's bugging me" {
+		t.Errorf("got edit %+v, want the text changed", edited)
+	}
+
+	long := make([]byte, 256)
+	for i := range long {
+		long[i] = 'a'
+	}
+	api.expect(http.StatusUnprocessableEntity, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", id), "", "X-Actor", string(long))
+}
+
+func TestHistoryPaginates(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Hysteria")[0]
+	for i := 1; i <= 4; i++ {
+		api.expect(http.StatusOK, http.MethodPatch, fmt.Sprintf("/music-library/song?id=%d", id),
+			fmt.Sprintf(`{"text":"verse %d"}`, i), "Content-Type", "application/merge-patch+json")
+	}
+
+	revisions := api.history(id, "page=2&pageSize=2")
+	if len(revisions) != 2 || revisions[0].Version != 3 || revisions[1].Version != 4 {
+		t.Errorf("got revisions %+v, want versions 3 and 4", revisions)
+	}
+	if revisions := api.history(id, "page=4&pageSize=2"); len(revisions) != 0 {
+		t.Errorf("got revisions %+v past the end", revisions)
+	}
+
+	api.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/music-library/song/%d/history?page=1&pageSize=2", id+1), "")
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, fmt.Sprintf("/music-library/song/%d/history?page=1&pageSize=1001", id), "")
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, fmt.Sprintf("/music-library/song/%d/history?page=4000000&pageSize=1000", id), "")
+}
+
+func TestRevertSong(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Hysteria")[0]
+	api.enrich()
+	api.expect(http.StatusOK, http.MethodPatch, fmt.Sprintf("/music-library/song?id=%d", id),
+		`{"song":"Hysteria (Live)","text":"It's bugging me","link":null}`, "Content-Type", "application/merge-patch+json")
+
+	enriched := api.history(id, "page=1&pageSize=10")[1]
+	w := api.expect(http.StatusOK, http.MethodPost,
+		fmt.Sprintf("/music-library/song/%d/history/%d/revert", id, enriched.Revision), "", "X-Actor", "matt")
+	var song handlers.ListRowResult
+	decode(t, w, &song)
+	if song.SongName != "Hysteria" || song.Text != enriched.New["text"] || song.Link != enriched.New["link"] || song.Version != 4 {
+		t.Errorf("got song %+v, want values of revision %+v", song, enriched)
+	}
+	if w.Header().Get("ETag") != `"4"` {
+		t.Errorf("got ETag %q, want the one of reverted song", w.Header().Get("ETag"))
+	}
+
+	revisions := api.history(id, "page=1&pageSize=10")
+	revert := revisions[len(revisions)-1]
+	if revert.Operation != models.HistoryUpdate || revert.Actor != "matt" ||
+		!slices.Equal(revert.ChangedFields, []string{"link", "song", "text"}) {
+		t.Errorf("got revision %+v, want revert recorded as an update", revert)
+	}
+
+	// reverting to the current values changes nothing
+	api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/song/%d/history/%d/revert", id, enriched.Revision), "")
+	if got := api.history(id, "page=1&pageSize=10"); len(got) != len(revisions) {
+		t.Errorf("got revisions %+v, want no new revision", got)
+	}
+
+	api.expect(http.StatusPreconditionFailed, http.MethodPost,
+		fmt.Sprintf("/music-library/song/%d/history/%d/revert", id, enriched.Revision), "", "If-Match", `"3"`)
+}
+
+func TestRevertToMissingRevision(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Hysteria", "Starlight")
+	starlight := api.history(ids[1], "page=1&pageSize=10")[0]
+
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/song/%d/history/%d/revert", ids[0], starlight.Revision+1), "")
+	// revisions of other songs aren't found either
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/song/%d/history/%d/revert", ids[0], starlight.Revision), "")
+
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", ids[1]), "")
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/trash/%d", ids[1]), "")
+	revisions := api.history(ids[1], "page=1&pageSize=10")
+	purge := revisions[len(revisions)-1]
+	if purge.Operation != models.HistoryPurge || purge.New != nil {
+		t.Fatalf("got revision %+v, want history kept after purge", purge)
+	}
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/song/%d/history/%d/revert", ids[1], starlight.Revision), "")
+	api.expect(http.StatusConflict, http.MethodPost, fmt.Sprintf("/music-library/song/%d/history/%d/revert", ids[1], purge.Revision), "")
+}
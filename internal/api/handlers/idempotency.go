@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+)
+
+const maxIdempotencyKeyLen = 255
+
+// Rolls back transactions of requests whose response isn't saved
+var errResponseNotSaved = errors.New("failed response is not saved")
+
+// Returns Idempotency-Key header, empty if the request doesn't have one
+func validateIdempotencyKey(v *validator, r *http.Request) string {
+	key := r.Header.Get("Idempotency-Key")
+	v.check(len(key) <= maxIdempotencyKeyLen, "Idempotency-Key",
+		fmt.Sprintf("should be no longer than %d characters, length provided: %d", maxIdempotencyKeyLen, len(key)))
+	return key
+}
+
+// Identifies the request an idempotency key is used with by its method, path, parameters and decoded body,
+// so requests differing only in formatting of the body are the same
+func requestFingerprint(r *http.Request, requestJSON any) string {
+	body, _ := json.Marshal(requestJSON)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s?%s\n", r.Method, r.URL.Path, r.URL.Query().Encode())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Buffers response of a request made with an idempotency key until it's known whether it's saved
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(data)
+}
+
+func (rec *responseRecorder) successful() bool {
+	return rec.status >= 200 && rec.status < 300
+}
+
+func (rec *responseRecorder) writeTo(w http.ResponseWriter) {
+	for key, value := range rec.header {
+		w.Header()[key] = value
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
+
+// Responds with the response saved for the key, if the key was used with a different request responds with 422.
+// Reports false if there's no saved response
+func (hq *HandleQueries) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, key, fingerprint string) bool {
+	saved, err := hq.q.GetIdempotentResponse(r.Context(), key)
+	if errors.Is(err, database.ErrIdempotencyKeyNotFound) {
+		return false
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to look up idempotency key", err)
+		return true
+	}
+
+	if saved.Fingerprint != fingerprint {
+		badresponses.FailedValidationResponse(w, r,
+			map[string]string{"Idempotency-Key": "was already used with a different request"})
+		return true
+	}
+
+	logger.Zap.Debug(fmt.Sprintf("replaying response saved for idempotency key %q", key))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(saved.StatusCode)
+	w.Write(saved.Body)
+	return true
+}
+
+// Runs handle once per key in a transaction that claims the key before handle runs and saves the response
+// if it succeeded. Failed responses aren't saved and their changes are rolled back, so the key can be used again.
+// Concurrent requests with the key wait on the claim for the first one to finish and get its response.
+// Callers try [HandleQueries.replayIdempotentResponse] first and do slow work, like external api calls, before this
+func (hq *HandleQueries) withIdempotencyKey(w http.ResponseWriter, r *http.Request, key, fingerprint string,
+	handle func(w http.ResponseWriter, store database.SongStore)) {
+	rec := &responseRecorder{header: http.Header{}}
+	err := hq.q.WithTx(r.Context(), func(tx database.SongStore) error {
+		err := tx.ClaimIdempotencyKey(r.Context(), key, fingerprint, time.Now().Add(hq.cfg.IdempotencyKeyTTL))
+		if err != nil {
+			return err
+		}
+
+		handle(rec, tx)
+		if !rec.successful() {
+			return errResponseNotSaved
+		}
+		return tx.CompleteIdempotencyKey(r.Context(), key, rec.status, rec.body.Bytes())
+	})
+	switch {
+	case errors.Is(err, database.ErrIdempotencyKeyExists):
+		// request with the key finished after the caller looked for its response
+		if !hq.replayIdempotentResponse(w, r, key, fingerprint) {
+			badresponses.DatabaseErrorResponse(w, r, "failed to claim idempotency key", err)
+		}
+	case err != nil && !errors.Is(err, errResponseNotSaved):
+		badresponses.DatabaseErrorResponse(w, r, "failed to save response", err)
+	default:
+		rec.writeTo(w)
+	}
+}
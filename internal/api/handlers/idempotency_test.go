@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+func TestIdempotentRequestIsReplayed(t *testing.T) {
+	api := newTestAPI(t)
+
+	first := api.expect(http.StatusCreated, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`,
+		"Idempotency-Key", "a")
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first response is marked as replayed")
+	}
+
+	// formatting of the body doesn't matter, only what it holds
+	retry := api.expect(http.StatusCreated, http.MethodPost, "/music-library/song", `{ "song": "Uprising", "group": "Muse" }`,
+		"Idempotency-Key", "a")
+	if retry.Header().Get("Idempotent-Replayed") != "true" || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry got body %q and Idempotent-Replayed %q, want the first response replayed",
+			retry.Body.String(), retry.Header().Get("Idempotent-Replayed"))
+	}
+
+	if total := api.page("page=1&pageSize=10").TotalCount; total != 1 {
+		t.Errorf("got %d songs, want 1", total)
+	}
+}
+
+func TestIdempotencyKeyOfAnotherRequest(t *testing.T) {
+	api := newTestAPI(t)
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`,
+		"Idempotency-Key", "a")
+
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Starlight"}`,
+		"Idempotency-Key", "a")
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/song?requireDetails=true",
+		`{"group":"Muse","song":"Uprising"}`, "Idempotency-Key", "a")
+
+	// other keys and requests without one aren't affected
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Starlight"}`,
+		"Idempotency-Key", "b")
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Hysteria"}`)
+}
+
+func TestFailedIdempotentRequestIsNotSaved(t *testing.T) {
+	api := newTestAPI(t)
+	api.details.setErr(errors.New("api is down"))
+
+	api.expect(http.StatusBadGateway, http.MethodPost, "/music-library/song?requireDetails=true",
+		`{"group":"Muse","song":"Uprising"}`, "Idempotency-Key", "a")
+
+	api.details.setErr(nil)
+	w := api.expect(http.StatusCreated, http.MethodPost, "/music-library/song?requireDetails=true",
+		`{"group":"Muse","song":"Uprising"}`, "Idempotency-Key", "a")
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("retry of a failed request got a replayed response")
+	}
+
+	// conflicts aren't saved either, so retries run the request again
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`,
+		"Idempotency-Key", "b")
+	w = api.expect(http.StatusConflict, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`,
+		"Idempotency-Key", "b")
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("retry of a conflicting request got a replayed response")
+	}
+}
+
+func TestConcurrentIdempotentRequests(t *testing.T) {
+	api := newTestAPI(t)
+	api.details.release = make(chan struct{})
+
+	const requests = 5
+	responses := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = api.do(http.MethodPost, "/music-library/song?requireDetails=true",
+				`{"group":"Muse","song":"Uprising"}`, "Idempotency-Key", "a")
+		}()
+	}
+
+	// every request waits for details, none of them has claimed the key yet
+	for api.details.callCount() < requests {
+		time.Sleep(time.Millisecond)
+	}
+	close(api.details.release)
+	wg.Wait()
+
+	replayed := 0
+	for _, w := range responses {
+		if w.Code != http.StatusCreated || w.Body.String() != responses[0].Body.String() {
+			t.Errorf("got status %d and body %q, want every request to get the same 201", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Idempotent-Replayed") == "true" {
+			replayed++
+		}
+	}
+	if replayed != requests-1 {
+		t.Errorf("got %d replayed responses, want %d", replayed, requests-1)
+	}
+
+	var result handlers.AddSongResponse
+	decode(t, responses[0], &result)
+	if result.EnrichmentStatus != models.EnrichmentEnriched {
+		t.Errorf("got enrichment status %q, want %q", result.EnrichmentStatus, models.EnrichmentEnriched)
+	}
+	if total := api.page("page=1&pageSize=10").TotalCount; total != 1 {
+		t.Errorf("got %d songs, want 1", total)
+	}
+}
+
+func TestIdempotencyKeyLength(t *testing.T) {
+	api := newTestAPI(t)
+
+	key := strings.Repeat("k", 256)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`,
+		"Idempotency-Key", key)
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`,
+		"Idempotency-Key", key[:255])
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Formats of bulk import and export, JSON is only exported
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+const (
+	csvMediaType    = "text/csv"
+	ndjsonMediaType = "application/x-ndjson"
+	jsonMediaType   = "application/json"
+)
+
+// What happens to songs that already exist, see [ImportOptions]
+const (
+	onConflictSkip   = "skip"
+	onConflictUpdate = "update"
+)
+
+// Songs saved by a single call of the store, every batch is committed on its own
+const importBatchSize = 500
+
+// Longest line of NDJSON source, same as the body limit of JSON requests
+const maxImportLineLen = 1 << 20
+
+const (
+	// Read deadline of import requests is pushed forward by this much on every read of the body,
+	// so long imports aren't cut by the server's ReadTimeout while the client keeps sending
+	importIdleTimeout = 10 * time.Second
+	// Time given to write the report once the import is done
+	importWriteTimeout = 20 * time.Second
+)
+
+// Columns of CSV sources, group and song are required
+var importColumns = []string{"group", "song", "releaseDate", "text", "link"}
+
+// Returned by [Import] for sources that can't be read at all, e.g. CSV without a header row
+var ErrInvalidImportSource = errors.New("invalid import source")
+
+type ImportOptions struct {
+	Format string // [FormatCSV] or [FormatNDJSON]
+	Update bool   // Replace details of existing songs instead of skipping them
+}
+
+// Reads songs from src, validates them like AddSong and UpdateSongInfo requests and saves them into store in batches.
+// Details of a song are optional, rows without them are queued for enrichment.
+// Invalid rows are reported as failed and the rest are still imported, repeated songs are skipped.
+// Malformed source stops the import at the row it can't be read at.
+// If the store fails, batches saved before stay saved and the report of the rows read so far is returned with the error,
+// rows of the failed batch are reported as failed
+func Import(ctx context.Context, store database.SongStore, cfg *config.Config, src io.Reader, opts ImportOptions) (*ImportReport, error) {
+	var source importSource
+	switch opts.Format {
+	case FormatCSV:
+		csvSource, err := newCSVSource(src)
+		if err != nil {
+			return nil, err
+		}
+		source = csvSource
+	case FormatNDJSON:
+		source = newNDJSONSource(src)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidImportSource, opts.Format)
+	}
+
+	report := &ImportReport{Rows: []ImportRowResult{}}
+	seen := make(map[BasicSongInfoJSON]int) // Lines songs were first read at
+
+	var batch []models.ImportSong
+	var batchRows []int // Indices of report rows of the batch
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := store.ImportSongs(ctx, batch, opts.Update)
+		if err != nil {
+			for _, i := range batchRows {
+				report.Rows[i].Status, report.Rows[i].Reason = models.ImportFailed, fmt.Sprintf("not imported: %s", err.Error())
+			}
+			report.Error = fmt.Sprintf("import stopped at line %d, rows after it were not read: %s",
+				report.Rows[len(report.Rows)-1].Line, err.Error())
+			return err
+		}
+		for i, result := range results {
+			row := &report.Rows[batchRows[i]]
+			row.Status, row.Id, row.Reason = result.Status, result.SongId, result.Reason
+		}
+		batch, batchRows = batch[:0], batchRows[:0]
+		return nil
+	}
+
+	for {
+		row, line, err := source.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *importRowError
+		if errors.As(err, &rowErr) {
+			report.Rows = append(report.Rows, ImportRowResult{Line: line, Status: models.ImportFailed, Reason: err.Error()})
+			continue
+		}
+		if err != nil {
+			// where the next row starts is unknown, so the import can't go on
+			report.Rows = append(report.Rows, ImportRowResult{Line: line, Status: models.ImportFailed,
+				Reason: fmt.Sprintf("%s, rows after it were not read", err.Error())})
+			break
+		}
+
+		v := newValidator()
+		validateBasicSongInfoJSON(v, &row.BasicSongInfoJSON, cfg)
+		hasDetails := row.additionalSongInfoJSON != additionalSongInfoJSON{}
+		if hasDetails {
+			validateAdditionalSongInfoJSON(v, &row.additionalSongInfoJSON, cfg)
+		}
+		if !v.valid() {
+			report.Rows = append(report.Rows, ImportRowResult{Line: line, Status: models.ImportFailed,
+				Reason: validationReason(v.Errors)})
+			continue
+		}
+
+		if first, exists := seen[row.BasicSongInfoJSON]; exists {
+			report.Rows = append(report.Rows, ImportRowResult{Line: line, Status: models.ImportSkipped,
+				Reason: fmt.Sprintf("duplicate of line %d", first)})
+			continue
+		}
+		seen[row.BasicSongInfoJSON] = line
+
+		song := models.ImportSong{BasicSongInfo: models.BasicSongInfo{GroupName: row.Group, SongName: row.Song}}
+		if hasDetails {
+			rd, _ := time.Parse("02.01.2006", row.ReleaseDate)
+			song.Details = &models.AdditionalSongInfo{ReleaseDate: rd, SongLyrics: row.Text, Link: row.Link}
+		}
+
+		batchRows = append(batchRows, len(report.Rows))
+		report.Rows = append(report.Rows, ImportRowResult{Line: line})
+		batch = append(batch, song)
+		if len(batch) == importBatchSize {
+			err := flush()
+			if err != nil {
+				report.count()
+				return report, err
+			}
+		}
+	}
+
+	err := flush()
+	report.count()
+	return report, err
+}
+
+// Tallies outcomes of the rows
+func (report *ImportReport) count() {
+	for _, row := range report.Rows {
+		switch row.Status {
+		case models.ImportCreated:
+			report.Created++
+		case models.ImportUpdated:
+			report.Updated++
+		case models.ImportSkipped:
+			report.Skipped++
+		case models.ImportFailed:
+			report.Failed++
+		}
+	}
+}
+
+// Joins validation errors into a single line ordered by key
+func validationReason(fieldErrors map[string]string) string {
+	keys := slices.Sorted(maps.Keys(fieldErrors))
+	messages := make([]string, len(keys))
+	for i, key := range keys {
+		messages[i] = fmt.Sprintf("%s: %s", key, fieldErrors[key])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Rows of an import source read one at a time
+type importSource interface {
+	// Returns the next row with the line it starts at, io.EOF after the last row.
+	// [importRowError] concerns only the returned row, other errors stop the import
+	next() (importSongJSON, int, error)
+}
+
+// Row that can't be read, rows after it still can
+type importRowError struct {
+	err error
+}
+
+func (e *importRowError) Error() string {
+	return e.err.Error()
+}
+
+type csvSource struct {
+	r       *csv.Reader
+	columns []string // In the order of the header row
+}
+
+func newCSVSource(src io.Reader) (*csvSource, error) {
+	r := csv.NewReader(src)
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: csv should start with a header row", ErrInvalidImportSource)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed csv header: %s", ErrInvalidImportSource, err.Error())
+	}
+
+	// header of files saved by spreadsheet editors may start with byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	for i, column := range header {
+		if !slices.Contains(importColumns, column) {
+			return nil, fmt.Errorf("%w: unknown csv column %q, expected any of %s",
+				ErrInvalidImportSource, column, strings.Join(importColumns, ", "))
+		}
+		if slices.Contains(header[:i], column) {
+			return nil, fmt.Errorf("%w: csv column %q is repeated", ErrInvalidImportSource, column)
+		}
+	}
+	if !slices.Contains(header, "group") || !slices.Contains(header, "song") {
+		return nil, fmt.Errorf("%w: csv should have group and song columns", ErrInvalidImportSource)
+	}
+
+	return &csvSource{r: r, columns: header}, nil
+}
+
+func (s *csvSource) next() (importSongJSON, int, error) {
+	record, err := s.r.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+		return importSongJSON{}, parseErr.StartLine, &importRowError{
+			fmt.Errorf("expected %d fields, got %d", len(s.columns), len(record))}
+	}
+	if errors.As(err, &parseErr) {
+		return importSongJSON{}, parseErr.StartLine, fmt.Errorf("malformed csv: %s", parseErr.Err.Error())
+	}
+	if err != nil {
+		return importSongJSON{}, 0, err
+	}
+
+	var row importSongJSON
+	for i, column := range s.columns {
+		switch column {
+		case "group":
+			row.Group = record[i]
+		case "song":
+			row.Song = record[i]
+		case "releaseDate":
+			row.ReleaseDate = record[i]
+		case "text":
+			row.Text = record[i]
+		case "link":
+			row.Link = record[i]
+		}
+	}
+
+	line, _ := s.r.FieldPos(0)
+	return row, line, nil
+}
+
+type ndjsonSource struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func newNDJSONSource(src io.Reader) *ndjsonSource {
+	s := bufio.NewScanner(src)
+	s.Buffer(nil, maxImportLineLen)
+	return &ndjsonSource{s: s}
+}
+
+// Blank lines are skipped
+func (s *ndjsonSource) next() (importSongJSON, int, error) {
+	for s.s.Scan() {
+		s.line++
+		line := bytes.TrimSpace(s.s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var row importSongJSON
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&row)
+		if err == nil && dec.More() {
+			err = errors.New("line should hold a single object")
+		}
+		if err != nil {
+			return importSongJSON{}, s.line, &importRowError{fmt.Errorf("malformed json: %w", err)}
+		}
+		return row, s.line, nil
+	}
+
+	err := s.s.Err()
+	if err == bufio.ErrTooLong {
+		return importSongJSON{}, s.line + 1, fmt.Errorf("line is longer than %d bytes", maxImportLineLen)
+	}
+	if err != nil {
+		return importSongJSON{}, s.line + 1, err
+	}
+	return importSongJSON{}, 0, io.EOF
+}
+
+// Pushes read deadline of the request forward on every read of its body, see [importIdleTimeout]
+type idleTimeoutReader struct {
+	r  io.Reader
+	rc *http.ResponseController
+}
+
+func (t *idleTimeoutReader) Read(p []byte) (int, error) {
+	// not every ResponseWriter supports deadlines, the server's timeouts apply then
+	_ = t.rc.SetReadDeadline(time.Now().Add(importIdleTimeout))
+	return t.r.Read(p)
+}
+
+// @Summary		Imports songs in bulk
+// @Tags			music-library
+// @Description	Streams songs from CSV (Content-Type text/csv) with a header row naming the columns group, song, releaseDate, text and link,
+// @Description	or NDJSON (Content-Type application/x-ndjson) with an object of the same fields per line.
+// @Description	Songs are validated like in POST and PUT /music-library/song, details are optional but releaseDate, text and link
+// @Description	should be provided together, songs without details are queued for enrichment.
+// @Description	Existing songs are skipped, with onConflict=update their details are replaced by the given ones.
+// @Description	Songs are saved in batches of 500 committed one by one. Returns outcome of every row:
+// @Description	created, updated, skipped or failed, with the reason for the last two.
+// @Description	If saving a batch fails, the import stops and the report is returned with status of the error (500, 503 or 499)
+// @Description	and errors field explaining it, rows of the failed batch are failed and the batches before it stay saved
+// @Accept			text/csv
+// @Accept			application/x-ndjson
+// @Produce		json
+// @Param			onConflict	query		string	false	"what to do with existing songs"	Enums(skip, update)	default(skip)
+// @Success		200			{object}	ImportReport
+// @Failure		400			{object}	models.ErrorResponse
+// @Failure		415			{object}	models.ErrorResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	ImportReport
+// @Failure		503			{object}	ImportReport
+// @Router			/music-library/import [post]
+func (hq *HandleQueries) ImportSongs(w http.ResponseWriter, r *http.Request) {
+	onConflict := r.URL.Query().Get("onConflict")
+	if onConflict == "" {
+		onConflict = onConflictSkip
+	}
+
+	v := newValidator()
+	v.check(onConflict == onConflictSkip || onConflict == onConflictUpdate, "onConflict",
+		fmt.Sprintf("expected %s or %s, value provided: %v", onConflictSkip, onConflictUpdate, onConflict))
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	opts := ImportOptions{Update: onConflict == onConflictUpdate}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case csvMediaType:
+		opts.Format = FormatCSV
+	case ndjsonMediaType:
+		opts.Format = FormatNDJSON
+	default:
+		badresponses.UnsupportedMediaTypeResponse(w, r,
+			fmt.Sprintf("expected Content-Type %s or %s", csvMediaType, ndjsonMediaType))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	body := &idleTimeoutReader{r: r.Body, rc: rc}
+
+	report, err := Import(r.Context(), hq.q, &hq.cfg, body, opts)
+	if errors.Is(err, ErrInvalidImportSource) {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to import songs: %s", err.Error()))
+		return
+	}
+
+	// batches saved before the store failed stay saved, the report tells the client which rows they are
+	status := http.StatusOK
+	if err != nil {
+		status = badresponses.DatabaseErrorStatus(err)
+		logger.Zap.Error(fmt.Errorf("failed to import songs, %d created and %d updated before: %w",
+			report.Created, report.Updated, err))
+	}
+
+	_ = rc.SetWriteDeadline(time.Now().Add(importWriteTimeout))
+	err = jsonutil.WriteJSON(w, status, report, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
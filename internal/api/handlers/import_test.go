@@ -0,0 +1,201 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+const (
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+// Statuses of report rows by line
+func rowStatuses(report *handlers.ImportReport) map[int]string {
+	statuses := make(map[int]string)
+	for _, row := range report.Rows {
+		statuses[row.Line] = row.Status
+	}
+	return statuses
+}
+
+func (api *testAPI) importSongs(query, contentType, body string) handlers.ImportReport {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodPost, "/music-library/import"+query, body, "Content-Type", contentType)
+
+	var report handlers.ImportReport
+	decode(api.t, w, &report)
+	return report
+}
+
+func checkRows(t *testing.T, report *handlers.ImportReport, want map[int]string) {
+	t.Helper()
+	got := rowStatuses(report)
+	if len(got) != len(want) {
+		t.Errorf("got rows %+v, want statuses %v", report.Rows, want)
+	}
+	for line, status := range want {
+		if got[line] != status {
+			t.Errorf("line %d: got status %q, want %q, rows: %+v", line, got[line], status, report.Rows)
+		}
+	}
+}
+
+const importCSV = "\ufeffsong,group,releaseDate,text,link\n" +
+	"Uprising,Muse,,,\n" +
+	"Starlight,Muse,04.09.2006,\"Far away\nThis ship is taking me\",https://example.com\n" +
+	"Hysteria,Muse,2003-12-01,It's bugging me,https://example.com\n" +
+	"Uprising,Muse,,,\n" +
+	"Madness,Muse\n" +
+	",Muse,,,\n" +
+	"Resistance,Muse,14.09.2009,Is our secret safe tonight,https://example.com\n"
+
+func TestImportCSV(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Resistance")
+
+	report := api.importSongs("", csvType, importCSV)
+	checkRows(t, &report, map[int]string{
+		2: models.ImportCreated,
+		3: models.ImportCreated, // quoted text spans lines 3 and 4
+		5: models.ImportFailed,  // invalid release date
+		6: models.ImportSkipped, // duplicate of line 2
+		7: models.ImportFailed,  // missing fields
+		8: models.ImportFailed,  // missing song name
+		9: models.ImportSkipped, // already exists
+	})
+	if report.Created != 2 || report.Updated != 0 || report.Skipped != 2 || report.Failed != 3 || report.Error != "" {
+		t.Errorf("got report %+v, want 2 created, 2 skipped and 3 failed", report)
+	}
+
+	starlight := api.getSong(report.Rows[1].Id)
+	if starlight.Text != "Far away\nThis ship is taking me" || starlight.EnrichmentStatus != models.EnrichmentEnriched {
+		t.Errorf("got song %+v, want enriched song with multiline text", starlight)
+	}
+	if uprising := api.getSong(report.Rows[0].Id); uprising.EnrichmentStatus != models.EnrichmentPending {
+		t.Errorf("song without details has enrichment status %q, want %q", uprising.EnrichmentStatus, models.EnrichmentPending)
+	}
+}
+
+func TestImportCSVUpdatesExistingSongs(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Resistance")
+
+	report := api.importSongs("?onConflict=update", csvType, importCSV)
+	if status := rowStatuses(&report)[9]; status != models.ImportUpdated {
+		t.Fatalf("existing song: got status %q, want %q", status, models.ImportUpdated)
+	}
+	if song := api.getSong(1); song.Text != "Is our secret safe tonight" {
+		t.Errorf("got text %q of updated song, want the imported one", song.Text)
+	}
+
+	// imported again, songs without details have nothing to be updated with
+	report = api.importSongs("?onConflict=update", csvType, "group,song\nMuse,Resistance\n")
+	checkRows(t, &report, map[int]string{2: models.ImportSkipped})
+}
+
+func TestImportNDJSON(t *testing.T) {
+	api := newTestAPI(t)
+
+	report := api.importSongs("", ndjsonType, strings.Join([]string{
+		`{"group":"Muse","song":"Uprising"}`,
+		``,
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`,
+		`{"group":"Muse","song":"Hysteria","album":"Absolution"}`,
+		`{"group":"Muse"`,
+		`{"group":"Muse","song":"Madness"} {"group":"Muse","song":"Resistance"}`,
+		`{"group":"Muse","song":"Madness","text":"I can't get it right"}`,
+		`  {"group":"Muse","song":"Resistance"}  `,
+	}, "\n"))
+	checkRows(t, &report, map[int]string{
+		1: models.ImportCreated,
+		3: models.ImportCreated,
+		4: models.ImportFailed, // unknown field
+		5: models.ImportFailed, // malformed json
+		6: models.ImportFailed, // two objects on a line
+		7: models.ImportFailed, // details should be provided together
+		8: models.ImportCreated,
+	})
+}
+
+func TestImportRejectsSources(t *testing.T) {
+	api := newTestAPI(t)
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"empty csv", "", csvType, "", http.StatusBadRequest},
+		{"unknown column", "", csvType, "group,song,album\n", http.StatusBadRequest},
+		{"repeated column", "", csvType, "group,song,song\n", http.StatusBadRequest},
+		{"missing column", "", csvType, "group,text\n", http.StatusBadRequest},
+		{"json", "", "application/json", `{"group":"Muse","song":"Uprising"}`, http.StatusUnsupportedMediaType},
+		{"unknown onConflict", "?onConflict=replace", csvType, "group,song\n", http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(http.MethodPost, "/music-library/import"+tt.query, tt.body, "Content-Type", tt.contentType)
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d, body: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestImportStopsAtMalformedCSV(t *testing.T) {
+	api := newTestAPI(t)
+
+	report := api.importSongs("", csvType, "group,song\nMuse,Uprising\nMuse,\"Star\"light\nMuse,Hysteria\n")
+	checkRows(t, &report, map[int]string{2: models.ImportCreated, 3: models.ImportFailed})
+}
+
+// Store failing every import after the first one
+type failingImportStore struct {
+	*database.MemoryStore
+	imports int
+}
+
+func (s *failingImportStore) ImportSongs(ctx context.Context, songs []models.ImportSong, update bool) ([]models.ImportResult, error) {
+	s.imports++
+	if s.imports > 1 {
+		return nil, database.ErrQueryTimeout
+	}
+	return s.MemoryStore.ImportSongs(ctx, songs, update)
+}
+
+func TestImportReportsRowsSavedBeforeFailure(t *testing.T) {
+	api := newTestAPIWithStore(t, &failingImportStore{MemoryStore: database.NewMemoryStore()})
+
+	var source strings.Builder
+	for i := 0; i < 600; i++ {
+		fmt.Fprintf(&source, "{\"group\":\"Muse\",\"song\":\"Song %d\"}\n", i)
+	}
+	w := api.expect(http.StatusServiceUnavailable, http.MethodPost, "/music-library/import", source.String(),
+		"Content-Type", ndjsonType)
+
+	var report handlers.ImportReport
+	decode(t, w, &report)
+	// the first batch of 500 songs is saved, the second one is read to its end and fails
+	if report.Created != 500 || report.Failed != 100 || len(report.Rows) != 600 {
+		t.Errorf("got %d created and %d failed of %d rows, want 500 and 100 of 600",
+			report.Created, report.Failed, len(report.Rows))
+	}
+	if !strings.Contains(report.Error, database.ErrQueryTimeout.Error()) {
+		t.Errorf("got error %q, want it to mention %q", report.Error, database.ErrQueryTimeout)
+	}
+
+	list := api.page("page=1&pageSize=1")
+	if list.TotalCount != 500 {
+		t.Errorf("got %d songs in the library, want 500", list.TotalCount)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+)
+
+var errMalformedCursor = errors.New("malformed cursor")
+
+// Wire format of [database.ListCursor], clients get it as an opaque base64url token
+type cursorToken struct {
+	Order    string    `json:"o"`
+	Keys     []*string `json:"k"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+func encodeCursor(cursor *database.ListCursor) string {
+	token := cursorToken{Order: cursor.Order, Backward: cursor.Backward}
+	for _, key := range cursor.Keys {
+		if key.Valid {
+			token.Keys = append(token.Keys, &key.String)
+		} else {
+			token.Keys = append(token.Keys, nil)
+		}
+	}
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(encoded string) (*database.ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errMalformedCursor
+	}
+	var token cursorToken
+	err = json.Unmarshal(data, &token)
+	if err != nil || token.Order == "" || len(token.Keys) == 0 {
+		return nil, errMalformedCursor
+	}
+
+	cursor := &database.ListCursor{Order: token.Order, Backward: token.Backward}
+	for _, key := range token.Keys {
+		if key == nil {
+			cursor.Keys = append(cursor.Keys, sql.NullString{})
+		} else {
+			cursor.Keys = append(cursor.Keys, sql.NullString{String: *key, Valid: true})
+		}
+	}
+	return cursor, nil
+}
+
+// Fetches a page of the list together with pagination metadata, filter's limit should be one more than page size
+// (see [HandleQueries.listFilterFromQuery]), the extra row tells whether there's anything past the page
+func (hq *HandleQueries) listPage(ctx context.Context, filter *database.ListFilter, estimate bool) (*FilteredListResponse, error) {
+	rows, err := hq.q.GetFilteredList(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	count, estimated, err := hq.q.CountFilteredList(ctx, filter, estimate)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := int(filter.Limit) - 1
+	backward := filter.Cursor != nil && filter.Cursor.Backward
+	more := len(rows) > pageSize
+	if more && backward {
+		rows = rows[len(rows)-pageSize:]
+	} else if more {
+		rows = rows[:pageSize]
+	}
+
+	response := FilteredListResponse{
+		PageSize:            int64(pageSize),
+		TotalCount:          count,
+		TotalCountEstimated: estimated,
+		TotalPages:          (count + int64(pageSize) - 1) / int64(pageSize),
+	}
+	if filter.Cursor == nil {
+		response.Page = int64(filter.Offset)/int64(pageSize) + 1
+	}
+
+	var result []any
+	for _, row := range rows {
+		result = append(result, sparseRow(newListRowResult(&row), filter.Fields))
+	}
+	response.FilteredRows = result
+
+	if len(rows) == 0 {
+		return &response, nil
+	}
+	if more || backward {
+		response.NextCursor = encodeCursor(filter.CursorAt(&rows[len(rows)-1], false))
+	}
+	if (more && backward) || (!backward && (filter.Cursor != nil || filter.Offset > 0)) {
+		response.PrevCursor = encodeCursor(filter.CursorAt(&rows[0], true))
+	}
+	response.HasNext = response.NextCursor != ""
+	return &response, nil
+}
+
+// Turns count query parameter into estimate flag of [database.SongStore.CountFilteredList]
+func countEstimateFromQuery(v *validator, rq url.Values) bool {
+	count := rq.Get("count")
+	v.check(count == "" || count == "exact" || count == "estimated", "count", "should be exact or estimated")
+	return count == "estimated"
+}
+
+// Link header (RFC 8288) pointing at the neighbouring pages of the list, links keep the request's filters.
+// Pages listed by cursor link to their neighbours by cursor, first and last pages are linked by number
+func paginationLinks(u *url.URL, response *FilteredListResponse) http.Header {
+	var links []string
+	link := func(rel string, set map[string]string) {
+		query := u.Query()
+		query.Del("page")
+		query.Del("cursor")
+		for key, value := range set {
+			query.Set(key, value)
+		}
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, u.Path, query.Encode(), rel))
+	}
+
+	link("first", map[string]string{"page": "1"})
+	if response.Page == 0 {
+		if response.PrevCursor != "" {
+			link("prev", map[string]string{"cursor": response.PrevCursor})
+		}
+		if response.NextCursor != "" {
+			link("next", map[string]string{"cursor": response.NextCursor})
+		}
+	} else {
+		if response.Page > 1 {
+			link("prev", map[string]string{"page": strconv.FormatInt(response.Page-1, 10)})
+		}
+		if response.HasNext {
+			link("next", map[string]string{"page": strconv.FormatInt(response.Page+1, 10)})
+		}
+	}
+	if response.TotalPages > 0 {
+		link("last", map[string]string{"page": strconv.FormatInt(response.TotalPages, 10)})
+	}
+
+	headers := http.Header{}
+	headers.Set("Link", strings.Join(links, ", "))
+	return headers
+}
@@ -0,0 +1,302 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type listPage struct {
+	FilteredRows []struct {
+		Id int64 `json:"id"`
+	} `json:"filteredRows"`
+	NextCursor string `json:"nextCursor"`
+	PrevCursor string `json:"prevCursor"`
+	Page       int64  `json:"page"`
+	PageSize   int64  `json:"pageSize"`
+	TotalCount int64  `json:"totalCount"`
+	TotalPages int64  `json:"totalPages"`
+	HasNext    bool   `json:"hasNext"`
+}
+
+func (p listPage) ids() []int64 {
+	var ids []int64
+	for _, row := range p.FilteredRows {
+		ids = append(ids, row.Id)
+	}
+	return ids
+}
+
+// Same as list, also returning what the response tells about neighbour pages
+func (api *testAPI) page(query string) listPage {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/list?"+query, "")
+
+	var page listPage
+	decode(api.t, w, &page)
+	return page
+}
+
+func TestListPagesByCursor(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness", "Resistance")
+
+	first := api.page("page=1&pageSize=2")
+	if !slices.Equal(first.ids(), ids[:2]) || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("first page: got ids %v, next %q, prev %q", first.ids(), first.NextCursor, first.PrevCursor)
+	}
+
+	second := api.page("pageSize=2&cursor=" + url.QueryEscape(first.NextCursor))
+	if !slices.Equal(second.ids(), ids[2:4]) || second.Page != 0 || second.PrevCursor == "" {
+		t.Fatalf("second page: got ids %v, page %d, prev %q", second.ids(), second.Page, second.PrevCursor)
+	}
+
+	last := api.page("pageSize=2&cursor=" + url.QueryEscape(second.NextCursor))
+	if !slices.Equal(last.ids(), ids[4:]) || last.NextCursor != "" || last.HasNext {
+		t.Fatalf("last page: got ids %v, next %q", last.ids(), last.NextCursor)
+	}
+
+	back := api.page("pageSize=2&cursor=" + url.QueryEscape(last.PrevCursor))
+	if !slices.Equal(back.ids(), ids[2:4]) {
+		t.Fatalf("page before the last: got ids %v, want %v", back.ids(), ids[2:4])
+	}
+	if back.PrevCursor == "" || back.NextCursor == "" {
+		t.Errorf("page before the last: got next %q and prev %q, want both", back.NextCursor, back.PrevCursor)
+	}
+
+	// offset pages past the first one link back as well
+	if third := api.page("page=3&pageSize=2"); !slices.Equal(third.ids(), ids[4:]) || third.PrevCursor == "" {
+		t.Errorf("third page: got ids %v, prev %q", third.ids(), third.PrevCursor)
+	}
+}
+
+func TestListCursorIsStableWhenSongsAreDeleted(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness", "Resistance")
+
+	first := api.page("page=1&pageSize=2&sort=-song")
+	if want := []int64{ids[0], ids[1]}; !slices.Equal(first.ids(), want) {
+		t.Fatalf("first page: got ids %v, want %v", first.ids(), want)
+	}
+
+	// a song of the first page is gone, the next page still starts right after it
+	api.expect(http.StatusOK, http.MethodDelete, "/music-library/song?id=2", "")
+	second := api.page("pageSize=2&sort=-song&cursor=" + url.QueryEscape(first.NextCursor))
+	want := []int64{ids[4], ids[3]} // Resistance, Madness
+	if !slices.Equal(second.ids(), want) {
+		t.Errorf("second page: got ids %v, want %v", second.ids(), want)
+	}
+}
+
+func TestListRejectsCursors(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising", "Starlight", "Hysteria")
+	next := api.page("page=1&pageSize=2").NextCursor
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"malformed", "pageSize=2&cursor=not-a-cursor"},
+		{"empty token", "pageSize=2&cursor=e30"},
+		{"other order", "pageSize=2&sort=-song&cursor=" + url.QueryEscape(next)},
+		{"other order of search", "pageSize=2&search=bloom&cursor=" + url.QueryEscape(next)},
+		{"with page", "page=2&pageSize=2&cursor=" + url.QueryEscape(next)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(http.MethodGet, "/music-library/list?"+tt.query, "")
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d, body: %s", w.Code, http.StatusUnprocessableEntity, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListValidatesPageBounds(t *testing.T) {
+	api := newTestAPI(t)
+
+	for _, query := range []string{"page=1&pageSize=1001", "page=0&pageSize=10", "page=4294967295&pageSize=1000"} {
+		w := api.do(http.MethodGet, "/music-library/list?"+query, "")
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want %d", query, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestListCountsSongs(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness", "Resistance")
+	api.addSongs("Placebo", "Pure Morning")
+
+	tests := []struct {
+		query                            string
+		page, pageSize, count, pageCount int64
+		hasNext                          bool
+	}{
+		{"page=1&pageSize=2", 1, 2, 6, 3, true},
+		{"page=3&pageSize=2&group=muse", 3, 2, 5, 3, false},
+		{"page=2&pageSize=10&count=estimated", 2, 10, 6, 1, false},
+		{"page=1&pageSize=10&group=queen", 1, 10, 0, 0, false},
+	}
+	for _, tt := range tests {
+		got := api.page(tt.query)
+		if got.Page != tt.page || got.PageSize != tt.pageSize || got.TotalCount != tt.count ||
+			got.TotalPages != tt.pageCount || got.HasNext != tt.hasNext {
+			t.Errorf("%s: got page %d of size %d, %d songs on %d pages, hasNext %v", tt.query,
+				got.Page, got.PageSize, got.TotalCount, got.TotalPages, got.HasNext)
+		}
+	}
+
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/list?page=1&pageSize=2&count=approximate", "")
+}
+
+func TestListLinksNeighbourPages(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness", "Resistance")
+
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/list?page=2&pageSize=2&group=Muse", "")
+	link := w.Header().Get("Link")
+	for _, want := range []string{
+		`</music-library/list?group=Muse&page=1&pageSize=2>; rel="first"`,
+		`</music-library/list?group=Muse&page=1&pageSize=2>; rel="prev"`,
+		`</music-library/list?group=Muse&page=3&pageSize=2>; rel="next"`,
+		`</music-library/list?group=Muse&page=3&pageSize=2>; rel="last"`,
+	} {
+		if !strings.Contains(link, want) {
+			t.Errorf("Link header %q does not contain %q", link, want)
+		}
+	}
+
+	// pages listed by cursor link to their neighbours by cursor
+	next := api.page("page=1&pageSize=2").NextCursor
+	w = api.expect(http.StatusOK, http.MethodGet, "/music-library/list?pageSize=2&cursor="+url.QueryEscape(next), "")
+	link = w.Header().Get("Link")
+	if !strings.Contains(link, `rel="first"`) || !strings.Contains(link, `rel="last"`) ||
+		strings.Count(link, "cursor=") != 2 || strings.Contains(link, "page=2&") {
+		t.Errorf("Link header %q, want first and last pages by number, prev and next by cursor", link)
+	}
+}
+
+func TestListSortsByKeys(t *testing.T) {
+	api := newTestAPI(t)
+	muse := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria")
+	placebo := api.addSongs("placebo", "Pure Morning", "Every You Every Me")
+	queen := api.addSongs("Queen", "Bohemian Rhapsody")
+	for id, date := range map[int64]string{muse[0]: "07.09.2009", muse[1]: "04.09.2006", placebo[0]: "04.09.2006", queen[0]: "31.10.1975"} {
+		api.expect(http.StatusOK, http.MethodPatch, fmt.Sprintf("/music-library/song?id=%d", id),
+			fmt.Sprintf(`{"releaseDate":%q}`, date), "Content-Type", "application/merge-patch+json")
+	}
+
+	tests := []struct {
+		sort string
+		want []int64
+	}{
+		{"", []int64{muse[0], muse[1], muse[2], placebo[0], placebo[1], queen[0]}},
+		{"-id", []int64{queen[0], placebo[1], placebo[0], muse[2], muse[1], muse[0]}},
+		// names are ordered ignoring case
+		{"group,-song", []int64{muse[0], muse[1], muse[2], placebo[0], placebo[1], queen[0]}},
+		{"-group,song", []int64{queen[0], placebo[1], placebo[0], muse[2], muse[1], muse[0]}},
+		// ties of release date are broken by the next key, then by id; missing dates go last
+		{"releaseDate,-group", []int64{queen[0], placebo[0], muse[1], muse[0], placebo[1], muse[2]}},
+		{"-releaseDate", []int64{muse[2], placebo[1], muse[0], muse[1], placebo[0], queen[0]}},
+		{"song", []int64{queen[0], placebo[1], muse[2], placebo[0], muse[1], muse[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			if got := api.page("page=1&pageSize=10&sort=" + url.QueryEscape(tt.sort)).ids(); !slices.Equal(got, tt.want) {
+				t.Errorf("got ids %v, want %v", got, tt.want)
+			}
+
+			// cursor pages follow the same order
+			var got []int64
+			page := api.page("page=1&pageSize=4&sort=" + url.QueryEscape(tt.sort))
+			got = append(got, page.ids()...)
+			page = api.page("pageSize=4&sort=" + url.QueryEscape(tt.sort) + "&cursor=" + url.QueryEscape(page.NextCursor))
+			got = append(got, page.ids()...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got ids %v by cursor, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListRejectsUnknownSort(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising")
+
+	for _, sort := range []string{"name", "group,-", "relevance", "song,-song", "group,"} {
+		w := api.do(http.MethodGet, "/music-library/list?page=1&pageSize=10&sort="+url.QueryEscape(sort), "")
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("sort %q: got status %d, want %d", sort, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+	api.page("page=1&pageSize=10&search=bloom&sort=-relevance,group")
+}
+
+func TestListFiltersByOperators(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Supermassive Black Hole")
+	placebo := api.addSongs("Placebo", "Pure Morning")
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"song[prefix]=s&song[not]=black", []int64{ids[1]}},
+		{"song[exact]=uprising&song[exact]=pure%20morning", []int64{ids[0], placebo[0]}},
+		{"group[notExact]=muse", placebo},
+		{"group=&song[notPrefix]=", []int64{ids[0], ids[1], ids[2], placebo[0]}},
+		{"group[exact]=m%C3%BAse&hasLyrics=false", ids},
+		{"hasLink=true", nil},
+	}
+	for _, tt := range tests {
+		if got := api.page("page=1&pageSize=10&" + tt.query).ids(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got ids %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListValidatesFilters(t *testing.T) {
+	api := newTestAPI(t)
+
+	for _, query := range []string{"group[like]=muse", "text[Exact]=love", "hasLyrics=maybe", "hasLink=1x"} {
+		w := api.do(http.MethodGet, "/music-library/list?page=1&pageSize=10&"+query, "")
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d, want %d", query, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+	// parameters that merely start with a filter name aren't filters
+	api.page("page=1&pageSize=10&groupie=muse")
+}
+
+func TestListRespondsWithFields(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Hysteria")[0]
+	api.enrich()
+
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/list?page=1&pageSize=10&fields=id,%20song,id", "")
+	var response struct {
+		FilteredRows []map[string]any `json:"filteredRows"`
+	}
+	decode(t, w, &response)
+	if len(response.FilteredRows) != 1 {
+		t.Fatalf("got rows %+v, want one", response.FilteredRows)
+	}
+	if row := response.FilteredRows[0]; len(row) != 2 || row["id"] != float64(id) || row["song"] != "Hysteria" {
+		t.Errorf("got row %v, want id and song only", row)
+	}
+
+	w = api.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/music-library/song/%d?fields=group,text", id), "")
+	var song map[string]any
+	decode(t, w, &song)
+	if len(song) != 2 || song["group"] != "Muse" || song["text"] == nil {
+		t.Errorf("got song %v, want group and text only", song)
+	}
+
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/list?page=1&pageSize=10&fields=id,lyrics", "")
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, fmt.Sprintf("/music-library/song/%d?fields=name", id), "")
+}
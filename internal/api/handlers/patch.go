@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// Fields of a song document that can be patched, named like in [ListRowResult]
+var patchableFields = map[string]bool{
+	"group":       true,
+	"song":        true,
+	"releaseDate": true,
+	"text":        true,
+	"link":        true,
+}
+
+var errPatchTestFailed = errors.New("test operation failed")
+
+// Single operation of a JSON Patch (RFC 6902) document
+type JSONPatchOperation struct {
+	Op    string          `json:"op" enums:"add,remove,replace,move,copy,test"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty" swaggertype:"string"`
+}
+
+// Patchable fields of a song as a json document, null values stand for absent details
+func songDocument(song *models.FullSongInfo) map[string]any {
+	return valuesDocument(&models.SongValues{
+		GroupName:   song.GroupName,
+		SongName:    song.SongName,
+		ReleaseDate: song.ReleaseDate,
+		SongLyrics:  song.SongLyrics,
+		Link:        song.Link,
+	})
+}
+
+// Same as [songDocument] for values recorded in song history
+func valuesDocument(values *models.SongValues) map[string]any {
+	doc := map[string]any{
+		"group":       values.GroupName,
+		"song":        values.SongName,
+		"releaseDate": nil,
+		"text":        nil,
+		"link":        nil,
+	}
+	if values.ReleaseDate.Valid {
+		doc["releaseDate"] = values.ReleaseDate.Time.Format("02.01.2006")
+	}
+	if values.SongLyrics.Valid {
+		doc["text"] = values.SongLyrics.String
+	}
+	if values.Link.Valid {
+		doc["link"] = values.Link.String
+	}
+	return doc
+}
+
+// Applies operations to a flat document, paths must point at its members (e.g. "/text").
+// Returns [errPatchTestFailed] if a test operation didn't match
+func applyJSONPatch(doc map[string]any, ops []JSONPatchOperation) error {
+	for i, op := range ops {
+		field, err := patchPointerField(op.Path)
+		if err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		var value any
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if len(op.Value) == 0 {
+				return fmt.Errorf("operation %d: value should be provided", i)
+			}
+			err = json.Unmarshal(op.Value, &value)
+			if err != nil {
+				return fmt.Errorf("operation %d: invalid value: %w", i, err)
+			}
+		}
+
+		_, exists := doc[field]
+		switch op.Op {
+		case "add":
+			doc[field] = value
+		case "replace":
+			if !exists {
+				return fmt.Errorf("operation %d: path %s does not exist", i, op.Path)
+			}
+			doc[field] = value
+		case "remove":
+			if !exists {
+				return fmt.Errorf("operation %d: path %s does not exist", i, op.Path)
+			}
+			delete(doc, field)
+		case "move", "copy":
+			from, err := patchPointerField(op.From)
+			if err != nil {
+				return fmt.Errorf("operation %d: from: %w", i, err)
+			}
+			fromValue, fromExists := doc[from]
+			if !fromExists {
+				return fmt.Errorf("operation %d: path %s does not exist", i, op.From)
+			}
+			if op.Op == "move" {
+				delete(doc, from)
+			}
+			doc[field] = fromValue
+		case "test":
+			if !exists || !reflect.DeepEqual(doc[field], value) {
+				return fmt.Errorf("operation %d (%s): %w", i, op.Path, errPatchTestFailed)
+			}
+		default:
+			return fmt.Errorf("operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+	return nil
+}
+
+// Converts JSON pointer (RFC 6901) into name of a top level member
+func patchPointerField(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return "", fmt.Errorf("path %q should point at a top level field", pointer)
+	}
+	field := strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:])
+	if !patchableFields[field] {
+		return "", fmt.Errorf("path %q does not point at a patchable field", pointer)
+	}
+	return field, nil
+}
+
+// Returns fields of after that differ from before, removed fields are reported as null
+func changedFields(before, after map[string]any) map[string]any {
+	changed := make(map[string]any)
+	for field := range patchableFields {
+		value, exists := after[field]
+		if !exists {
+			value = nil
+		}
+		if !reflect.DeepEqual(before[field], value) {
+			changed[field] = value
+		}
+	}
+	return changed
+}
+
+// Validates patched field values (strings or nulls) and converts them into [models.SongPatch]
+func validateSongPatch(v *validator, fields map[string]any, cfg *config.Config) *models.SongPatch {
+	var patch models.SongPatch
+
+	for field, value := range fields {
+		if !patchableFields[field] {
+			v.addError(field, "is not a patchable field")
+			continue
+		}
+
+		str, isString := value.(string)
+		if value != nil && !isString {
+			v.addError(field, "should be a string or null")
+			continue
+		}
+
+		switch field {
+		case "group":
+			v.check(isString && len(str) > 0, field, "should be provided")
+			v.check(len(str) <= cfg.MaxGroupNameLen, field,
+				fmt.Sprintf("should be no more than %v characters long, current length %v",
+					cfg.MaxGroupNameLen, len(str)))
+			patch.GroupName = &str
+		case "song":
+			v.check(isString && len(str) > 0, field, "should be provided")
+			v.check(len(str) <= cfg.MaxSongNameLen, field,
+				fmt.Sprintf("should be no more than %v characters long, current length %v",
+					cfg.MaxSongNameLen, len(str)))
+			patch.SongName = &str
+		case "releaseDate":
+			releaseDate := sql.NullTime{}
+			if isString {
+				releaseDate.Time = convertAndValidateStringToDate(v, str, field)
+				releaseDate.Valid = true
+			}
+			patch.ReleaseDate = &releaseDate
+		case "text":
+			v.check(len(str) <= cfg.MaxSongLyricsLen, field,
+				fmt.Sprintf("should be no more than %v characters long, current length %v",
+					cfg.MaxSongLyricsLen, len(str)))
+			patch.SongLyrics = &sql.NullString{String: str, Valid: isString}
+		case "link":
+			v.check(len(str) <= cfg.MaxSongLinkLen, field,
+				fmt.Sprintf("should be no more than %v characters long, current length %v",
+					cfg.MaxSongLinkLen, len(str)))
+			patch.Link = &sql.NullString{String: str, Valid: isString}
+		}
+	}
+
+	return &patch
+}
@@ -0,0 +1,97 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+const (
+	mergePatch = "application/merge-patch+json"
+	jsonPatch  = "application/json-patch+json"
+)
+
+// Adds a song with details, returns its id
+func (api *testAPI) addSongWithDetails(group, song string) int64 {
+	api.t.Helper()
+	id := api.addSongs(group, song)[0]
+	api.expect(http.StatusOK, http.MethodPut, "/music-library/song",
+		fmt.Sprintf(`{"id":%d,"releaseDate":"16.07.2006","text":"Ooh baby","link":"https://example.com"}`, id))
+	return id
+}
+
+func TestPatchSongWithMergePatch(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongWithDetails("Muse", "Supermassive Black Hole")
+
+	w := api.expect(http.StatusOK, http.MethodPatch, "/music-library/song?id=1",
+		`{"song":"Starlight","text":null}`, "Content-Type", mergePatch)
+	if etag := w.Header().Get("ETag"); etag != `"3"` {
+		t.Errorf("got ETag %s, want \"3\"", etag)
+	}
+
+	song := api.getSong(id)
+	if song.SongName != "Starlight" || song.Text != "" || song.Link != "https://example.com" || song.ReleaseDate != "16.07.2006" {
+		t.Errorf("got song %+v, want renamed song without text", song)
+	}
+}
+
+func TestPatchSongWithJSONPatch(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongWithDetails("Muse", "Supermassive Black Hole")
+
+	api.expect(http.StatusOK, http.MethodPatch, "/music-library/song?id=1", `[
+		{"op":"test","path":"/song","value":"Supermassive Black Hole"},
+		{"op":"copy","from":"/song","path":"/text"},
+		{"op":"remove","path":"/link"},
+		{"op":"replace","path":"/releaseDate","value":"01.01.2007"}
+	]`, "Content-Type", jsonPatch)
+
+	song := api.getSong(id)
+	if song.Text != "Supermassive Black Hole" || song.Link != "" || song.ReleaseDate != "01.01.2007" {
+		t.Errorf("got song %+v, want text copied from name, no link and new release date", song)
+	}
+}
+
+func TestPatchSongRejectsPatches(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongWithDetails("Muse", "Supermassive Black Hole")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"failed test", jsonPatch, `[{"op":"test","path":"/song","value":"Uprising"}]`, http.StatusConflict},
+		{"unknown path", jsonPatch, `[{"op":"replace","path":"/version","value":"2"}]`, http.StatusUnprocessableEntity},
+		{"nested path", jsonPatch, `[{"op":"add","path":"/text/0","value":"Ooh"}]`, http.StatusUnprocessableEntity},
+		{"missing value", jsonPatch, `[{"op":"replace","path":"/text"}]`, http.StatusUnprocessableEntity},
+		{"unknown op", jsonPatch, `[{"op":"append","path":"/text","value":"Ooh"}]`, http.StatusUnprocessableEntity},
+		{"removed name", jsonPatch, `[{"op":"remove","path":"/group"}]`, http.StatusUnprocessableEntity},
+		{"not a string", mergePatch, `{"text":42}`, http.StatusUnprocessableEntity},
+		{"unknown field", mergePatch, `{"version":2}`, http.StatusUnprocessableEntity},
+		{"invalid date", mergePatch, `{"releaseDate":"2006-07-16"}`, http.StatusUnprocessableEntity},
+		{"plain json", "application/json", `{"text":"Ooh"}`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := api.do(http.MethodPatch, "/music-library/song?id=1", tt.body, "Content-Type", tt.contentType)
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d, body: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+
+	if song := api.getSong(1); song.Version != 2 {
+		t.Errorf("rejected patches changed the song, got version %d, want 2", song.Version)
+	}
+}
+
+func TestPatchMissingSong(t *testing.T) {
+	api := newTestAPI(t)
+
+	api.expect(http.StatusNotFound, http.MethodPatch, "/music-library/song?id=1", `{"text":"Ooh"}`, "Content-Type", mergePatch)
+	api.expect(http.StatusNotFound, http.MethodPatch, "/music-library/song?id=1",
+		`[{"op":"add","path":"/text","value":"Ooh"}]`, "Content-Type", jsonPatch)
+}
@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func newPlaylistResult(playlist *models.Playlist) PlaylistResult {
+	return PlaylistResult{
+		Id:          playlist.Id,
+		Owner:       playlist.Owner,
+		Name:        playlist.Name,
+		Description: playlist.Description,
+		ItemCount:   playlist.ItemCount,
+		CreatedAt:   playlist.CreatedAt,
+		UpdatedAt:   playlist.UpdatedAt,
+	}
+}
+
+func validatePlaylistRequestJSON(v *validator, playlist *PlaylistRequestJSON, maxNameLen, maxDescriptionLen int) {
+	v.check(len(playlist.Name) > 0, "name", "should be provided")
+	v.check(len(playlist.Name) <= maxNameLen, "name",
+		fmt.Sprintf("should be no more than %v characters long, current length %v", maxNameLen, len(playlist.Name)))
+	v.check(len(playlist.Description) <= maxDescriptionLen, "description",
+		fmt.Sprintf("should be no more than %v characters long, current length %v",
+			maxDescriptionLen, len(playlist.Description)))
+}
+
+// Responds with the playlist and its items, status is 200 unless given
+func (hq *HandleQueries) writePlaylist(w http.ResponseWriter, r *http.Request, playlistId int64, status int) {
+	var (
+		playlist *models.Playlist
+		items    []models.PlaylistItem
+	)
+	// playlist and items are read in one transaction so that they match
+	err := hq.q.WithTx(r.Context(), func(tx database.SongStore) error {
+		var err error
+		playlist, err = tx.GetPlaylist(r.Context(), playlistId)
+		if err != nil {
+			return err
+		}
+		items, err = tx.GetPlaylistItems(r.Context(), playlistId)
+		return err
+	})
+	if err == database.ErrPlaylistNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get playlist: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get playlist", err)
+		return
+	}
+
+	result := PlaylistDetailsResult{PlaylistResult: newPlaylistResult(playlist), Items: []PlaylistItemResult{}}
+	for _, item := range items {
+		result.Items = append(result.Items, PlaylistItemResult{
+			Id:       item.Id,
+			Position: item.Position,
+			AddedAt:  item.AddedAt,
+			Song:     newListRowResult(&item.Song),
+		})
+	}
+
+	err = jsonutil.WriteJSON(w, status, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches playlists in pages
+// @Tags			playlists
+// @Description	Playlists are ordered by owner and name, items are not listed
+// @Accept			plain
+// @Produce		json
+// @Param			owner		query		string	false	"playlists of this owner only"
+// @Param			page		query		int		true	"page number"
+// @Param			pageSize	query		int		true	"number of playlists displayed per page"	maximum(1000)
+// @Success		200			{object}	PlaylistListResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
+// @Router			/music-library/playlists [get]
+func (hq *HandleQueries) GetPlaylists(w http.ResponseWriter, r *http.Request) {
+	rq := r.URL.Query()
+
+	v := newValidator()
+	page := convertAndValidateStringToInt64(v, rq.Get("page"), "page")
+	pageSize := convertAndValidateStringToInt64(v, rq.Get("pageSize"), "pageSize")
+	limit, offset := pageLimitOffset(v, page, pageSize)
+	filter := database.PlaylistFilter{Owner: rq.Get("owner")}
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// one extra playlist tells whether there's a next page
+	filter.Limit = limit + 1
+	filter.Offset = offset
+	playlists, err := hq.q.GetPlaylists(r.Context(), &filter)
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get playlists", err)
+		return
+	}
+
+	result := PlaylistListResponse{Playlists: []PlaylistResult{}, Page: page, PageSize: pageSize}
+	if len(playlists) > int(pageSize) {
+		playlists = playlists[:pageSize]
+		result.HasNext = true
+	}
+	for _, playlist := range playlists {
+		result.Playlists = append(result.Playlists, newPlaylistResult(&playlist))
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches playlist with its items
+// @Tags			playlists
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"playlist id"
+// @Success		200	{object}	PlaylistDetailsResult
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/playlists/{id} [get]
+func (hq *HandleQueries) GetPlaylist(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	playlistId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hq.writePlaylist(w, r, playlistId, http.StatusOK)
+}
+
+// @Summary		Adds new playlist
+// @Tags			playlists
+// @Description	Playlist is owned by the actor of the request (X-Actor header), names are unique among playlists of an owner.
+// @Description	Returns the created playlist
+// @Accept			json
+// @Produce		json
+// @Param			PlaylistRequestJSON	body		PlaylistRequestJSON	true	"playlist name and description"
+// @Success		201					{object}	PlaylistDetailsResult
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		409					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/playlists [post]
+func (hq *HandleQueries) AddPlaylist(w http.ResponseWriter, r *http.Request) {
+	var requestJSON PlaylistRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to add playlist: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	validatePlaylistRequestJSON(v, &requestJSON, hq.cfg.MaxSongNameLen, hq.cfg.MaxSongLyricsLen)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	playlist := models.Playlist{
+		Owner:       database.ActorFrom(r.Context()),
+		Name:        requestJSON.Name,
+		Description: requestJSON.Description,
+	}
+	err = hq.q.AddPlaylist(r.Context(), &playlist)
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to add playlist", err)
+		return
+	}
+
+	hq.writePlaylist(w, r, playlist.Id, http.StatusCreated)
+}
+
+// @Summary		Renames playlist
+// @Tags			playlists
+// @Description	Replaces name and description of the playlist
+// @Accept			json
+// @Produce		json
+// @Param			id					path		int					true	"playlist id"
+// @Param			PlaylistRequestJSON	body		PlaylistRequestJSON	true	"new name and description"
+// @Success		200					{object}	PlaylistDetailsResult
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		404					{object}	models.ErrorResponse
+// @Failure		409					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/playlists/{id} [put]
+func (hq *HandleQueries) UpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var requestJSON PlaylistRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to update playlist: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	playlistId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	validatePlaylistRequestJSON(v, &requestJSON, hq.cfg.MaxSongNameLen, hq.cfg.MaxSongLyricsLen)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.UpdatePlaylist(r.Context(), playlistId, requestJSON.Name, requestJSON.Description)
+	if err == database.ErrPlaylistNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to update playlist: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to update playlist", err)
+		return
+	}
+
+	hq.writePlaylist(w, r, playlistId, http.StatusOK)
+}
+
+// @Summary		Deletes playlist
+// @Tags			playlists
+// @Description	Songs of the playlist stay in the library, returns provided id on success
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"playlist id"
+// @Success		200	{object}	models.IdResponse
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/playlists/{id} [delete]
+func (hq *HandleQueries) DeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	playlistId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.DeletePlaylist(r.Context(), playlistId)
+	if err == database.ErrPlaylistNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to delete playlist: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to delete playlist", err)
+		return
+	}
+
+	result := map[string]any{"id": playlistId}
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Adds song to playlist
+// @Tags			playlists
+// @Description	Song is put at the given position and the following items move down, without position it's appended.
+// @Description	The same song can be added several times. Returns the playlist with its items
+// @Accept			json
+// @Produce		json
+// @Param			id						path		int						true	"playlist id"
+// @Param			PlaylistItemRequestJSON	body		PlaylistItemRequestJSON	true	"song and its position"
+// @Success		200						{object}	PlaylistDetailsResult
+// @Failure		400						{object}	models.ErrorResponse
+// @Failure		404						{object}	models.ErrorResponse
+// @Failure		422						{object}	models.ErrorResponse
+// @Failure		500						{object}	models.ErrorResponse
+// @Failure		503						{object}	models.ErrorResponse
+// @Router			/music-library/playlists/{id}/items [post]
+func (hq *HandleQueries) AddPlaylistItem(w http.ResponseWriter, r *http.Request) {
+	var requestJSON PlaylistItemRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to add item: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	playlistId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	v.check(requestJSON.SongId > 0, "songId", "should be a positive id")
+	v.check(requestJSON.Position >= 0, "position", "should be positive")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = hq.q.AddPlaylistItem(r.Context(), playlistId, requestJSON.SongId, requestJSON.Position)
+	if err == database.ErrPlaylistNotFound || err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to add item: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to add item", err)
+		return
+	}
+
+	hq.writePlaylist(w, r, playlistId, http.StatusOK)
+}
+
+// @Summary		Moves playlist item
+// @Tags			playlists
+// @Description	Item is put at the given position, items between its old and new positions shift by one,
+// @Description	position 0 moves it to the end. Returns the playlist with its items
+// @Accept			json
+// @Produce		json
+// @Param			id					path		int					true	"playlist id"
+// @Param			itemId				path		int					true	"item id"
+// @Param			MoveItemRequestJSON	body		MoveItemRequestJSON	true	"new position"
+// @Success		200					{object}	PlaylistDetailsResult
+// @Failure		400					{object}	models.ErrorResponse
+// @Failure		404					{object}	models.ErrorResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/playlists/{id}/items/{itemId} [put]
+func (hq *HandleQueries) MovePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	var requestJSON MoveItemRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to move item: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	playlistId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	itemId := convertAndValidateStringToInt64(v, chi.URLParam(r, "itemId"), "itemId")
+	v.check(requestJSON.Position >= 0, "position", "should be positive")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.MovePlaylistItem(r.Context(), playlistId, itemId, requestJSON.Position)
+	if err == database.ErrPlaylistNotFound || err == database.ErrItemNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to move item: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to move item", err)
+		return
+	}
+
+	hq.writePlaylist(w, r, playlistId, http.StatusOK)
+}
+
+// @Summary		Removes item from playlist
+// @Tags			playlists
+// @Description	The following items move up, the song stays in the library. Returns the playlist with its items
+// @Accept			plain
+// @Produce		json
+// @Param			id		path		int	true	"playlist id"
+// @Param			itemId	path		int	true	"item id"
+// @Success		200		{object}	PlaylistDetailsResult
+// @Failure		404		{object}	models.ErrorResponse
+// @Failure		422		{object}	models.ErrorResponse
+// @Failure		500		{object}	models.ErrorResponse
+// @Failure		503		{object}	models.ErrorResponse
+// @Router			/music-library/playlists/{id}/items/{itemId} [delete]
+func (hq *HandleQueries) RemovePlaylistItem(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	playlistId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	itemId := convertAndValidateStringToInt64(v, chi.URLParam(r, "itemId"), "itemId")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.RemovePlaylistItem(r.Context(), playlistId, itemId)
+	if err == database.ErrPlaylistNotFound || err == database.ErrItemNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to remove item: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to remove item", err)
+		return
+	}
+
+	hq.writePlaylist(w, r, playlistId, http.StatusOK)
+}
@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+)
+
+func itemIds(t *testing.T, playlist *handlers.PlaylistDetailsResult) []int64 {
+	t.Helper()
+	var ids []int64
+	for i, item := range playlist.Items {
+		if item.Position != i+1 {
+			t.Fatalf("item %d is at position %d, want %d", item.Id, item.Position, i+1)
+		}
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func TestPlaylistKeepsOrderWhenRanksRunOut(t *testing.T) {
+	api := newTestAPI(t)
+	songIds := api.addSongs("Muse", "Uprising", "Starlight")
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/playlists", `{"name":"Favourites"}`)
+	api.expect(http.StatusOK, http.MethodPost, "/music-library/playlists/1/items", fmt.Sprintf(`{"songId":%d}`, songIds[0]))
+
+	// every insert halves the gap after the first item, it runs out after about 16 of them
+	want := []int64{1}
+	var playlist handlers.PlaylistDetailsResult
+	for i := 0; i < 40; i++ {
+		w := api.expect(http.StatusOK, http.MethodPost, "/music-library/playlists/1/items",
+			fmt.Sprintf(`{"songId":%d,"position":2}`, songIds[i%2]))
+		playlist = handlers.PlaylistDetailsResult{}
+		decode(t, w, &playlist)
+
+		want = slices.Insert(want, 1, int64(i+2))
+		if got := itemIds(t, &playlist); !slices.Equal(got, want) {
+			t.Fatalf("after insert %d: got items %v, want %v", i+1, got, want)
+		}
+	}
+	if playlist.ItemCount != 41 {
+		t.Errorf("got item count %d, want 41", playlist.ItemCount)
+	}
+}
+
+func TestPlaylistMovesItems(t *testing.T) {
+	api := newTestAPI(t)
+	songIds := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness", "Resistance")
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/playlists", `{"name":"Favourites"}`)
+	for _, songId := range songIds {
+		api.expect(http.StatusOK, http.MethodPost, "/music-library/playlists/1/items", fmt.Sprintf(`{"songId":%d}`, songId))
+	}
+
+	moves := []struct {
+		item     int64
+		position int
+		want     []int64
+	}{
+		{4, 1, []int64{4, 1, 2, 3, 5}},
+		{4, 0, []int64{1, 2, 3, 5, 4}},
+		{1, 3, []int64{2, 3, 1, 5, 4}},
+		{2, 5, []int64{3, 1, 5, 4, 2}},
+	}
+	want := []int64{}
+	for _, move := range moves {
+		w := api.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/music-library/playlists/1/items/%d", move.item),
+			fmt.Sprintf(`{"position":%d}`, move.position))
+		var playlist handlers.PlaylistDetailsResult
+		decode(t, w, &playlist)
+
+		if got := itemIds(t, &playlist); !slices.Equal(got, move.want) {
+			t.Fatalf("item %d moved to %d: got items %v, want %v", move.item, move.position, got, move.want)
+		}
+		want = slices.Clone(move.want)
+	}
+
+	// moving the last item after the first one halves the gap after the first one every time
+	for i := 0; i < 40; i++ {
+		last := want[len(want)-1]
+		w := api.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/music-library/playlists/1/items/%d", last), `{"position":2}`)
+		var playlist handlers.PlaylistDetailsResult
+		decode(t, w, &playlist)
+
+		want = slices.Insert(want[:len(want)-1], 1, last)
+		if got := itemIds(t, &playlist); !slices.Equal(got, want) {
+			t.Fatalf("after move %d: got items %v, want %v", i+1, got, want)
+		}
+	}
+
+	api.expect(http.StatusNotFound, http.MethodPut, "/music-library/playlists/1/items/9", `{"position":1}`)
+}
+
+func TestPlaylistsAreListedByOwner(t *testing.T) {
+	api := newTestAPI(t)
+	for _, playlist := range []struct{ owner, name string }{{"matt", "Live"}, {"dom", "Drums"}, {"matt", "Demos"}} {
+		api.expect(http.StatusCreated, http.MethodPost, "/music-library/playlists", fmt.Sprintf(`{"name":%q}`, playlist.name),
+			"X-Actor", playlist.owner)
+	}
+	// names are unique per owner
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/playlists", `{"name":"Live"}`, "X-Actor", "matt")
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/playlists", `{"name":"Live"}`, "X-Actor", "chris")
+
+	var list handlers.PlaylistListResponse
+	decode(t, api.expect(http.StatusOK, http.MethodGet, "/music-library/playlists?page=1&pageSize=3", ""), &list)
+	if len(list.Playlists) != 3 || list.Playlists[1].Owner != "dom" || list.Playlists[2].Name != "Demos" || !list.HasNext {
+		t.Errorf("got playlists %+v, want the first page ordered by owner and name", list)
+	}
+	decode(t, api.expect(http.StatusOK, http.MethodGet, "/music-library/playlists?page=2&pageSize=3", ""), &list)
+	if len(list.Playlists) != 1 || list.Playlists[0].Owner != "matt" || list.Playlists[0].Name != "Live" || list.HasNext {
+		t.Errorf("got playlists %+v, want the second page ordered by owner and name", list)
+	}
+
+	decode(t, api.expect(http.StatusOK, http.MethodGet, "/music-library/playlists?page=1&pageSize=10&owner=matt", ""), &list)
+	if len(list.Playlists) != 2 || list.Playlists[0].Name != "Demos" || list.Playlists[1].Name != "Live" {
+		t.Errorf("got playlists %+v, want playlists of matt", list)
+	}
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/playlists?page=1&pageSize=1001", "")
+}
+
+func TestDeletedSongsLeavePlaylists(t *testing.T) {
+	store := database.NewMemoryStore()
+	api := newTestAPIWithStore(t, store)
+	songIds := api.addSongs("Muse", "Uprising", "Starlight")
+	api.expect(http.StatusCreated, http.MethodPost, "/music-library/playlists", `{"name":"Favourites"}`)
+	for _, songId := range []int64{songIds[0], songIds[1], songIds[0]} {
+		api.expect(http.StatusOK, http.MethodPost, "/music-library/playlists/1/items", fmt.Sprintf(`{"songId":%d}`, songId))
+	}
+
+	// restrict policy keeps songs of playlists in the library
+	if err := store.SetDeletePolicy("restrict"); err != nil {
+		t.Fatal(err)
+	}
+	api.expect(http.StatusConflict, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", songIds[0]), "")
+
+	if err := store.SetDeletePolicy("cascade"); err != nil {
+		t.Fatal(err)
+	}
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", songIds[0]), "")
+
+	var playlist handlers.PlaylistDetailsResult
+	decode(t, api.expect(http.StatusOK, http.MethodGet, "/music-library/playlists/1", ""), &playlist)
+	if got := itemIds(t, &playlist); !slices.Equal(got, []int64{2}) || playlist.ItemCount != 1 {
+		t.Errorf("got items %v, want every item of the deleted song removed", got)
+	}
+}
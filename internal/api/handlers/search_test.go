@@ -0,0 +1,67 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func (api *testAPI) setLyrics(id int64, lyrics string) {
+	api.t.Helper()
+	api.expect(http.StatusOK, http.MethodPut, "/music-library/song",
+		fmt.Sprintf(`{"id":%d,"releaseDate":"01.12.2003","text":%q,"link":"https://example.com"}`, id, lyrics))
+}
+
+func TestSearchRanksSongsByRelevance(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness")
+	api.setLyrics(ids[0], "Paranoia is in bloom\n\nThey will not force us, they will stop degrading us")
+	api.setLyrics(ids[1], "Far away, this ship is taking me far away\n\nFar away from the memories")
+	api.setLyrics(ids[2], "It's bugging me, grating me\n\nAnd twisting me around")
+	api.setLyrics(ids[3], "I, I can't get these memories out of my mind")
+
+	rows := api.list("search=far%20memories&page=1&pageSize=10")
+	if len(rows) != 1 || rows[0].SongName != "Starlight" || rows[0].Match == nil {
+		t.Fatalf("got rows %+v, want Starlight only", rows)
+	}
+	match := rows[0].Match
+	if match.Verse != 2 || match.Snippet != "<b>Far</b> away from the <b>memories</b>" {
+		t.Errorf("got match %+v, want the second verse highlighted", match)
+	}
+
+	rows = api.list("search=memories%20or%20will&page=1&pageSize=10")
+	want := []string{"Uprising", "Starlight", "Madness"}
+	if len(rows) != len(want) {
+		t.Fatalf("got rows %+v, want %v", rows, want)
+	}
+	for i, name := range want {
+		if rows[i].SongName != name {
+			t.Errorf("row %d: got %s, want %s", i+1, rows[i].SongName, name)
+		}
+	}
+	// ties are kept in the order of ids
+	if rows[0].Match.Rank <= rows[1].Match.Rank || rows[1].Match.Rank != rows[2].Match.Rank {
+		t.Errorf("got ranks %v, %v and %v, want Uprising ranked higher than the rest", rows[0].Match.Rank, rows[1].Match.Rank, rows[2].Match.Rank)
+	}
+
+	rows = api.list("search=memories%20-far&page=1&pageSize=10")
+	if len(rows) != 1 || rows[0].SongName != "Madness" || rows[0].Match.Snippet != "I, I can't get these <b>memories</b> out of my mind" {
+		t.Errorf("got rows %+v, want Madness without excluded word", rows)
+	}
+
+	// search is combined with other filters and leaves songs without lyrics out
+	if rows := api.list("search=me&song=hyst&page=1&pageSize=10"); len(rows) != 1 || rows[0].SongName != "Hysteria" {
+		t.Errorf("got rows %+v, want Hysteria only", rows)
+	}
+	if rows := api.list("page=1&pageSize=10"); len(rows) != 4 || rows[0].Match != nil {
+		t.Errorf("got rows %+v, want every song without matches", rows)
+	}
+}
+
+func TestSearchValidatesLanguage(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Uprising")
+
+	api.list("search=bloom&lang=russian&page=1&pageSize=10")
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/list?search=bloom&lang=klingon&page=1&pageSize=10", "")
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxTagNameLen = 100
+
+// How songs are matched by tag filters of the list
+const (
+	tagMatchAny = "any"
+	tagMatchAll = "all"
+)
+
+func newTagResult(tag *models.Tag) TagResult {
+	return TagResult{Id: tag.Id, Kind: tag.Kind, Name: tag.Name}
+}
+
+// Appends tags of the kind named in names to tags, names are trimmed and lowercased and repeated tags are skipped
+func appendTags(v *validator, tags []models.Tag, kind string, names []string, field string) []models.Tag {
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		v.check(len(name) > 0, field, "should not contain empty names")
+		v.check(len(name) <= maxTagNameLen, field,
+			fmt.Sprintf("should contain names no more than %v characters long, current length %v", maxTagNameLen, len(name)))
+
+		tag := models.Tag{Kind: kind, Name: name}
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Parses genre and tag filters of the list, tagMatch decides whether songs need any or all of them
+func tagsFromQuery(v *validator, rq url.Values) (tags []models.Tag, all bool) {
+	tags = appendTags(v, tags, models.TagKindGenre, rq["genre"], "genre")
+	tags = appendTags(v, tags, models.TagKindTag, rq["tag"], "tag")
+
+	match := rq.Get("tagMatch")
+	v.check(match == "" || match == tagMatchAny || match == tagMatchAll, "tagMatch",
+		fmt.Sprintf("should be %s or %s", tagMatchAny, tagMatchAll))
+	return tags, match == tagMatchAll
+}
+
+// Responds with tags of the song
+func (hq *HandleQueries) writeSongTags(w http.ResponseWriter, r *http.Request, songId int64) {
+	tags, err := hq.q.GetSongTags(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get song tags: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get song tags", err)
+		return
+	}
+
+	result := SongTagsResponse{Tags: []TagResult{}}
+	for _, tag := range tags {
+		result.Tags = append(result.Tags, newTagResult(&tag))
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches tags in pages
+// @Tags			tags
+// @Description	Genres and free-form tags ordered by the number of songs (not in trash) they're attached to, most used first
+// @Accept			plain
+// @Produce		json
+// @Param			kind		query		string	false	"genre or tag, every kind is listed if empty"	Enums(genre, tag)
+// @Param			page		query		int		true	"page number"
+// @Param			pageSize	query		int		true	"number of tags displayed per page"	maximum(1000)
+// @Success		200			{object}	TagListResponse
+// @Failure		422			{object}	models.ErrorResponse
+// @Failure		500			{object}	models.ErrorResponse
+// @Failure		503			{object}	models.ErrorResponse
+// @Router			/music-library/tags [get]
+func (hq *HandleQueries) GetTags(w http.ResponseWriter, r *http.Request) {
+	rq := r.URL.Query()
+
+	v := newValidator()
+	page := convertAndValidateStringToInt64(v, rq.Get("page"), "page")
+	pageSize := convertAndValidateStringToInt64(v, rq.Get("pageSize"), "pageSize")
+	limit, offset := pageLimitOffset(v, page, pageSize)
+	filter := database.TagFilter{Kind: rq.Get("kind")}
+	v.check(filter.Kind == "" || filter.Kind == models.TagKindGenre || filter.Kind == models.TagKindTag, "kind",
+		fmt.Sprintf("should be %s or %s", models.TagKindGenre, models.TagKindTag))
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// one extra tag tells whether there's a next page
+	filter.Limit = limit + 1
+	filter.Offset = offset
+	tags, err := hq.q.GetTags(r.Context(), &filter)
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get tags", err)
+		return
+	}
+
+	result := TagListResponse{Tags: []TagCountResult{}, Page: page, PageSize: pageSize}
+	if len(tags) > int(pageSize) {
+		tags = tags[:pageSize]
+		result.HasNext = true
+	}
+	for _, tag := range tags {
+		result.Tags = append(result.Tags, TagCountResult{TagResult: newTagResult(&tag), SongCount: tag.SongCount})
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Fetches tags of a song
+// @Tags			tags
+// @Description	Genres go first, tags of each kind are ordered by name
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"song id"
+// @Success		200	{object}	SongTagsResponse
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/song/{id}/tags [get]
+func (hq *HandleQueries) GetSongTags(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hq.writeSongTags(w, r, songId)
+}
+
+// @Summary		Attaches genres and tags to a song
+// @Tags			tags
+// @Description	Names are trimmed and lowercased, missing genres and tags are created.
+// @Description	Ones the song already has are skipped. Returns all tags of the song
+// @Accept			json
+// @Produce		json
+// @Param			id				path		int				true	"song id"
+// @Param			TagsRequestJSON	body		TagsRequestJSON	true	"genre and tag names"
+// @Success		200				{object}	SongTagsResponse
+// @Failure		400				{object}	models.ErrorResponse
+// @Failure		404				{object}	models.ErrorResponse
+// @Failure		422				{object}	models.ErrorResponse
+// @Failure		500				{object}	models.ErrorResponse
+// @Failure		503				{object}	models.ErrorResponse
+// @Router			/music-library/song/{id}/tags [post]
+func (hq *HandleQueries) AttachTags(w http.ResponseWriter, r *http.Request) {
+	var requestJSON TagsRequestJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, fmt.Sprintf("failed to attach tags: %s", err.Error()))
+		return
+	}
+
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	tags := appendTags(v, nil, models.TagKindGenre, requestJSON.Genres, "genres")
+	tags = appendTags(v, tags, models.TagKindTag, requestJSON.Tags, "tags")
+	v.check(len(tags) > 0, "tags", "genres or tags should be provided")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = hq.q.AttachTags(r.Context(), songId, tags)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to attach tags: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to attach tags", err)
+		return
+	}
+
+	hq.writeSongTags(w, r, songId)
+}
+
+// @Summary		Detaches genre or tag from a song
+// @Tags			tags
+// @Description	Returns remaining tags of the song
+// @Accept			plain
+// @Produce		json
+// @Param			id		path		int	true	"song id"
+// @Param			tagId	path		int	true	"tag id"
+// @Success		200		{object}	SongTagsResponse
+// @Failure		404		{object}	models.ErrorResponse
+// @Failure		422		{object}	models.ErrorResponse
+// @Failure		500		{object}	models.ErrorResponse
+// @Failure		503		{object}	models.ErrorResponse
+// @Router			/music-library/song/{id}/tags/{tagId} [delete]
+func (hq *HandleQueries) DetachTag(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	tagId := convertAndValidateStringToInt64(v, chi.URLParam(r, "tagId"), "tagId")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.DetachTag(r.Context(), songId, tagId)
+	if err == database.ErrSongNotFound || err == database.ErrTagNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to detach tag: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to detach tag", err)
+		return
+	}
+
+	hq.writeSongTags(w, r, songId)
+}
@@ -0,0 +1,122 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+)
+
+func (api *testAPI) tagSong(id int64, body string) handlers.SongTagsResponse {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/song/%d/tags", id), body)
+
+	var response handlers.SongTagsResponse
+	decode(api.t, w, &response)
+	return response
+}
+
+func (api *testAPI) tags(query string) handlers.TagListResponse {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/tags?"+query, "")
+
+	var response handlers.TagListResponse
+	decode(api.t, w, &response)
+	return response
+}
+
+func TestSongTags(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Uprising")[0]
+
+	tags := api.tagSong(id, `{"genres":[" Rock","alternative rock"],"tags":["Protest","rock"]}`)
+	var got []string
+	for _, tag := range tags.Tags {
+		got = append(got, tag.Kind+":"+tag.Name)
+	}
+	// genres go first, names are trimmed and lowercased
+	if want := []string{"genre:alternative rock", "genre:rock", "tag:protest", "tag:rock"}; !slices.Equal(got, want) {
+		t.Errorf("got tags %v, want %v", got, want)
+	}
+
+	// tags the song already has are skipped
+	if tags := api.tagSong(id, `{"genres":["ROCK"]}`); len(tags.Tags) != 4 {
+		t.Errorf("got tags %+v, want them unchanged", tags.Tags)
+	}
+
+	w := api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song/%d/tags/%d", id, tags.Tags[2].Id), "")
+	var remaining handlers.SongTagsResponse
+	decode(t, w, &remaining)
+	if len(remaining.Tags) != 3 || slices.ContainsFunc(remaining.Tags, func(tag handlers.TagResult) bool { return tag.Name == "protest" }) {
+		t.Errorf("got tags %+v, want protest removed", remaining.Tags)
+	}
+	api.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/music-library/song/%d/tags/%d", id, tags.Tags[2].Id), "")
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/song/%d/tags", id+1), `{"tags":["rock"]}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, fmt.Sprintf("/music-library/song/%d/tags", id), `{"tags":[" "]}`)
+}
+
+func TestListFiltersByTags(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria", "Madness")
+	api.tagSong(ids[0], `{"genres":["rock"],"tags":["protest"]}`)
+	api.tagSong(ids[1], `{"genres":["rock"],"tags":["love"]}`)
+	api.tagSong(ids[2], `{"genres":["rock"]}`)
+	api.tagSong(ids[3], `{"genres":["electronic"],"tags":["love"]}`)
+
+	tests := []struct {
+		query string
+		want  []int64
+	}{
+		{"genre=rock", ids[:3]},
+		{"genre=rock&genre=electronic", ids[:4]},
+		{"tag=love&tag=protest", []int64{ids[0], ids[1], ids[3]}},
+		{"tag=love&tag=protest&tagMatch=all", nil},
+		{"genre=rock&tag=love&tagMatch=all", []int64{ids[1]}},
+		{"genre=rock&tag=love", ids[:4]},
+		// genres and tags of the same name are different
+		{"tag=rock", nil},
+		{"genre=ROCK&tagMatch=all", ids[:3]},
+	}
+	for _, tt := range tests {
+		if got := api.page("page=1&pageSize=10&" + tt.query).ids(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got ids %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/list?page=1&pageSize=10&tag=love&tagMatch=most", "")
+}
+
+func TestTagsAreListedByKind(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria")
+	api.tagSong(ids[0], `{"genres":["rock"],"tags":["protest"]}`)
+	api.tagSong(ids[1], `{"genres":["rock","space rock"],"tags":["love"]}`)
+	api.tagSong(ids[2], `{"genres":["rock"],"tags":["love"]}`)
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", ids[2]), "")
+
+	counts := func(tags handlers.TagListResponse) []string {
+		var got []string
+		for _, tag := range tags.Tags {
+			got = append(got, fmt.Sprintf("%s:%s:%d", tag.Kind, tag.Name, tag.SongCount))
+		}
+		return got
+	}
+
+	// most used first, trashed songs aren't counted
+	genres := api.tags("kind=genre&page=1&pageSize=10")
+	if got, want := counts(genres), []string{"genre:rock:2", "genre:space rock:1"}; !slices.Equal(got, want) {
+		t.Errorf("got genres %v, want %v", got, want)
+	}
+	if got, want := counts(api.tags("kind=tag&page=1&pageSize=10")), []string{"tag:love:1", "tag:protest:1"}; !slices.Equal(got, want) {
+		t.Errorf("got tags %v, want %v", got, want)
+	}
+
+	all := api.tags("page=1&pageSize=3")
+	if len(all.Tags) != 3 || all.Tags[0].Name != "rock" || !all.HasNext {
+		t.Errorf("got tags %+v, want rock first followed by more tags", all)
+	}
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/tags?kind=mood&page=1&pageSize=10", "")
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/tags?page=1&pageSize=1001", "")
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// @Summary		Fetches trashed songs in pages
+// @Tags			trash
+// @Description	Accepts the same filters as /music-library/list, rows carry the time they were deleted at.
+// @Description	Trashed songs are purged automatically once retention period passes
+// @Accept			plain
+// @Produce		json
+// @Param			groupId				query		[]int		false	"group id"		collectionFormat(multi)
+// @Param			albumId				query		[]int		false	"album id"		collectionFormat(multi)
+// @Param			genre				query		[]string	false	"genre name"	collectionFormat(multi)
+// @Param			tag					query		[]string	false	"tag name"		collectionFormat(multi)
+// @Param			tagMatch			query		string		false	"songs need any (default) or all of the genres and tags"	Enums(any, all)
+// @Param			group				query		[]string	false	"group name"	collectionFormat(multi)
+// @Param			song				query		[]string	false	"song name"		collectionFormat(multi)
+// @Param			releaseDateLower	query		string	false	"dates before this will not show up"
+// @Param			releaseDateUpper	query		string	false	"dates after this will not show up"
+// @Param			text				query		[]string	false	"lyrics"		collectionFormat(multi)
+// @Param			hasLyrics			query		bool		false	"whether song has lyrics"
+// @Param			hasLink				query		bool		false	"whether song has link"
+// @Param			search				query		string	false	"full-text search of lyrics"
+// @Param			lang				query		string	false	"language of search"	Enums(english, russian, simple)
+// @Param			sort				query		string	false	"fields of id, group, song, releaseDate and relevance"
+// @Param			fields				query		string	false	"comma separated fields of rows, e.g. id,group,song, lyrics aren't read unless listed"
+// @Param			page				query		int		false	"page number, required without cursor"
+// @Param			cursor				query		string	false	"nextCursor or prevCursor of the previous response"
+// @Param			pageSize			query		int		true	"number of songs displayed per page"	maximum(1000)
+// @Param			count				query		string	false	"estimated takes number of songs from table statistics when the list isn't filtered"	Enums(exact, estimated)
+// @Header			200					{string}	Link	"first, prev, next and last pages (RFC 8288)"
+// @Success		200					{object}	FilteredListResponse
+// @Failure		422					{object}	models.ErrorResponse
+// @Failure		500					{object}	models.ErrorResponse
+// @Failure		503					{object}	models.ErrorResponse
+// @Router			/music-library/trash [get]
+func (hq *HandleQueries) GetTrash(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	dbFilter := hq.listFilterFromQuery(v, r.URL.Query())
+	estimate := countEstimateFromQuery(v, r.URL.Query())
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+	dbFilter.Trashed = true
+
+	response, err := hq.listPage(r.Context(), &dbFilter, estimate)
+	if err == database.ErrInvalidCursor {
+		badresponses.FailedValidationResponse(w, r, map[string]string{"cursor": err.Error()})
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get trash", err)
+		return
+	}
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, response, paginationLinks(r.URL, response))
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Restores song from trash
+// @Tags			trash
+// @Description	Returns the restored song and its ETag
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"song id"
+// @Success		200	{object}	ListRowResult
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/trash/{id}/restore [post]
+func (hq *HandleQueries) RestoreSong(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.RestoreSong(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, "failed to restore song: no matching record in trash")
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to restore song", err)
+		return
+	}
+
+	song, err := hq.q.GetSong(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, fmt.Sprintf("failed to get restored song: %s", err.Error()))
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to get restored song", err)
+		return
+	}
+
+	headers := songHeaders(song)
+
+	err = jsonutil.WriteJSON(w, http.StatusOK, newListRowResult(song), headers)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
+
+// @Summary		Permanently deletes song from trash
+// @Tags			trash
+// @Description	Only trashed songs can be purged, returns provided id if purge succeeded
+// @Accept			plain
+// @Produce		json
+// @Param			id	path		int	true	"song id"
+// @Success		200	{object}	models.IdResponse
+// @Failure		404	{object}	models.ErrorResponse
+// @Failure		422	{object}	models.ErrorResponse
+// @Failure		500	{object}	models.ErrorResponse
+// @Failure		503	{object}	models.ErrorResponse
+// @Router			/music-library/trash/{id} [delete]
+func (hq *HandleQueries) PurgeSong(w http.ResponseWriter, r *http.Request) {
+	v := newValidator()
+	songId := convertAndValidateStringToInt64(v, chi.URLParam(r, "id"), "id")
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := hq.q.PurgeSong(r.Context(), songId)
+	if err == database.ErrSongNotFound {
+		badresponses.ResourceNotFoundResponse(w, r, "failed to purge song: no matching record in trash")
+		return
+	}
+	if err != nil {
+		badresponses.DatabaseErrorResponse(w, r, "failed to purge song", err)
+		return
+	}
+
+	result := map[string]any{"id": songId}
+	err = jsonutil.WriteJSON(w, http.StatusOK, result, nil)
+	if err != nil {
+		badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+		return
+	}
+}
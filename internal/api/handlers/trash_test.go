@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+)
+
+func (api *testAPI) trash(query string) []handlers.ListRowResult {
+	api.t.Helper()
+	w := api.expect(http.StatusOK, http.MethodGet, "/music-library/trash?"+query, "")
+
+	var response struct {
+		FilteredRows []handlers.ListRowResult `json:"filteredRows"`
+	}
+	decode(api.t, w, &response)
+	return response.FilteredRows
+}
+
+func TestTrashListsDeletedSongs(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight", "Hysteria")
+	for _, id := range ids[:2] {
+		api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", id), "")
+	}
+
+	if rows := api.list("page=1&pageSize=10"); len(rows) != 1 || rows[0].SongName != "Hysteria" {
+		t.Errorf("got rows %+v, want deleted songs hidden from the library", rows)
+	}
+
+	rows := api.trash("page=1&pageSize=10")
+	if len(rows) != 2 || rows[0].SongName != "Uprising" || rows[1].SongName != "Starlight" {
+		t.Fatalf("got trash %+v, want Uprising and Starlight", rows)
+	}
+	for _, row := range rows {
+		if row.DeletedAt == nil {
+			t.Errorf("got trashed song %+v without deletion time", row)
+		}
+	}
+
+	if rows := api.trash("page=2&pageSize=1"); len(rows) != 1 || rows[0].SongName != "Starlight" {
+		t.Errorf("got trash %+v, want its second page", rows)
+	}
+	if rows := api.trash("page=1&pageSize=10&song=RISING"); len(rows) != 1 || rows[0].SongName != "Uprising" {
+		t.Errorf("got trash %+v, want songs matching the filter", rows)
+	}
+	api.expect(http.StatusUnprocessableEntity, http.MethodGet, "/music-library/trash?page=1&pageSize=1001", "")
+}
+
+func TestRestoreSong(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Uprising")[0]
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", id), "")
+
+	// deleted song keeps its names, so it can't be added again
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/song", `{"group":"Muse","song":"Uprising"}`)
+	api.expect(http.StatusConflict, http.MethodPost, "/music-library/song?requireDetails=true", `{"group":"Muse","song":"Uprising"}`)
+
+	w := api.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/music-library/trash/%d/restore", id), "")
+	var restored handlers.ListRowResult
+	decode(t, w, &restored)
+	if restored.SongName != "Uprising" || restored.DeletedAt != nil || w.Header().Get("ETag") == "" {
+		t.Errorf("got song %+v with ETag %q, want restored Uprising with its ETag", restored, w.Header().Get("ETag"))
+	}
+
+	if song := api.getSong(id); song.SongName != "Uprising" {
+		t.Errorf("got song %+v, want Uprising back in the library", song)
+	}
+	if rows := api.trash("page=1&pageSize=10"); len(rows) != 0 {
+		t.Errorf("got trash %+v after restore", rows)
+	}
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/trash/%d/restore", id), "")
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/trash/%d/restore", id+1), "")
+}
+
+func TestPurgeSong(t *testing.T) {
+	api := newTestAPI(t)
+	ids := api.addSongs("Muse", "Uprising", "Starlight")
+
+	// only trashed songs can be purged
+	api.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/music-library/trash/%d", ids[0]), "")
+
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/song?id=%d", ids[0]), "")
+	api.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/music-library/trash/%d", ids[0]), "")
+	if rows := api.trash("page=1&pageSize=10"); len(rows) != 0 {
+		t.Errorf("got trash %+v after purge", rows)
+	}
+	api.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/music-library/trash/%d/restore", ids[0]), "")
+
+	// names of purged songs are free again
+	api.addSongs("Muse", "Uprising")
+}
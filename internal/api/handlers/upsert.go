@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/badresponses"
+	"github.com/Scorzoner/effective-mobile-test/internal/api/jsonutil"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Handles POST /music-library/song?onConflict=update, see [HandleQueries.AddSong]
+func (hq *HandleQueries) upsertSong(w http.ResponseWriter, r *http.Request) {
+	var requestJSON UpsertSongJSON
+	err := jsonutil.ReadJSON(w, r, &requestJSON)
+	if err != nil {
+		badresponses.BadRequestResponse(w, r, err)
+		return
+	}
+	logger.Zap.Debug(fmt.Sprintf("upsertSong request json: %v", requestJSON))
+
+	v := newValidator()
+	validateBasicSongInfoJSON(v, &requestJSON.BasicSongInfoJSON, &hq.cfg)
+	validateAdditionalSongInfoJSON(v, &requestJSON.additionalSongInfoJSON, &hq.cfg)
+	v.check(r.URL.Query().Get("requireDetails") == "", "requireDetails",
+		fmt.Sprintf("can't be used with onConflict=%s, details are given", onConflictUpdate))
+	key := validateIdempotencyKey(v, r)
+	if !v.valid() {
+		badresponses.FailedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	upsert := func(w http.ResponseWriter, store database.SongStore) {
+		bsi := models.BasicSongInfo{GroupName: requestJSON.Group, SongName: requestJSON.Song}
+		rd, _ := time.Parse("02.01.2006", requestJSON.ReleaseDate)
+		asi := models.AdditionalSongInfo{ReleaseDate: rd, SongLyrics: requestJSON.Text, Link: requestJSON.Link}
+
+		created, err := store.UpsertSong(r.Context(), &bsi, &asi)
+		if err != nil {
+			badresponses.DatabaseErrorResponse(w, r, "failed to upsert song", err)
+			return
+		}
+
+		status, result := http.StatusOK, UpsertSongResponse{Id: bsi.Id, Status: models.ImportUpdated}
+		if created {
+			status, result.Status = http.StatusCreated, models.ImportCreated
+		}
+		err = jsonutil.WriteJSON(w, status, result, nil)
+		if err != nil {
+			badresponses.InternalServerErrorResponse(w, r, fmt.Errorf("failed writing response: %w", err))
+			return
+		}
+	}
+
+	if key == "" || hq.cfg.IdempotencyKeyTTL <= 0 {
+		upsert(w, hq.q)
+		return
+	}
+	fingerprint := requestFingerprint(r, requestJSON)
+	if hq.replayIdempotentResponse(w, r, key, fingerprint) {
+		return
+	}
+	hq.withIdempotencyKey(w, r, key, fingerprint, upsert)
+}
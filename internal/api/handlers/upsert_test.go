@@ -0,0 +1,100 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+const upsertTarget = "/music-library/song?onConflict=update"
+
+func (api *testAPI) upsert(status int, body string, header ...string) handlers.UpsertSongResponse {
+	api.t.Helper()
+	w := api.expect(status, http.MethodPost, upsertTarget, body, header...)
+
+	var result handlers.UpsertSongResponse
+	decode(api.t, w, &result)
+	return result
+}
+
+func TestUpsertCreatesThenUpdates(t *testing.T) {
+	api := newTestAPI(t)
+
+	created := api.upsert(http.StatusCreated,
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`)
+	if created.Status != models.ImportCreated {
+		t.Errorf("got status %q, want %q", created.Status, models.ImportCreated)
+	}
+
+	updated := api.upsert(http.StatusOK,
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"This ship is taking me","link":"https://example.com"}`)
+	if updated.Status != models.ImportUpdated || updated.Id != created.Id {
+		t.Errorf("got %+v, want song %d updated", updated, created.Id)
+	}
+
+	song := api.getSong(created.Id)
+	if song.Text != "This ship is taking me" || song.Version != 2 || song.EnrichmentStatus != models.EnrichmentEnriched {
+		t.Errorf("got song %+v, want enriched song with replaced text", song)
+	}
+}
+
+func TestUpsertEnrichesPendingSong(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.addSongs("Muse", "Starlight")[0]
+
+	updated := api.upsert(http.StatusOK,
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`)
+	if updated.Id != id {
+		t.Errorf("got id %d, want %d", updated.Id, id)
+	}
+	if song := api.getSong(id); song.EnrichmentStatus != models.EnrichmentEnriched || song.Text != "Far away" {
+		t.Errorf("got song %+v, want it enriched with the given details", song)
+	}
+}
+
+func TestUpsertTrashedSong(t *testing.T) {
+	api := newTestAPI(t)
+	api.addSongs("Muse", "Starlight")
+	api.expect(http.StatusOK, http.MethodDelete, "/music-library/song?id=1", "")
+
+	w := api.expect(http.StatusConflict, http.MethodPost, upsertTarget,
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`)
+	if !strings.Contains(w.Body.String(), "restore it") {
+		t.Errorf("got body %q, want it to ask for the song to be restored", w.Body.String())
+	}
+
+	api.expect(http.StatusOK, http.MethodPost, "/music-library/trash/1/restore", "")
+	api.upsert(http.StatusOK,
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`)
+}
+
+func TestUpsertValidates(t *testing.T) {
+	api := newTestAPI(t)
+
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, upsertTarget, `{"group":"Muse","song":"Starlight"}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, upsertTarget+"&requireDetails=true",
+		`{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`)
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/song?onConflict=replace",
+		`{"group":"Muse","song":"Starlight"}`)
+}
+
+func TestUpsertWithIdempotencyKey(t *testing.T) {
+	api := newTestAPI(t)
+	body := `{"group":"Muse","song":"Starlight","releaseDate":"04.09.2006","text":"Far away","link":"https://example.com"}`
+
+	first := api.upsert(http.StatusCreated, body, "Idempotency-Key", "a")
+	w := api.expect(http.StatusCreated, http.MethodPost, upsertTarget, body, "Idempotency-Key", "a")
+	if w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry was not replayed")
+	}
+	if song := api.getSong(first.Id); song.Version != 1 {
+		t.Errorf("got version %d, want the song written once", song.Version)
+	}
+
+	// the same body without onConflict is another request
+	api.expect(http.StatusUnprocessableEntity, http.MethodPost, "/music-library/song",
+		`{"group":"Muse","song":"Starlight"}`, "Idempotency-Key", "a")
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"time"
 
@@ -42,6 +43,41 @@ func convertAndValidateStringToInt64(v *validator, numberAsStr string, name stri
 	return numberAsInt
 }
 
+// Largest pageSize of listings
+const maxPageSize = 1000
+
+// Checks pageSize against maxPageSize and that the page starts within reach of query offsets,
+// returns them as limit and offset of the query. Page 0 (pages listed by cursor) starts at offset 0
+func pageLimitOffset(v *validator, page, pageSize int64) (int32, int32) {
+	v.check(pageSize <= maxPageSize, "pageSize", fmt.Sprintf("should be no more than %d", maxPageSize))
+	if pageSize < 1 || pageSize > maxPageSize {
+		return 0, 0
+	}
+
+	var offset int64
+	if page > 1 {
+		// page is below 2^32 and pageSize is small, so this doesn't overflow
+		offset = (page - 1) * pageSize
+		v.check(offset <= math.MaxInt32, "page",
+			fmt.Sprintf("should be no more than %d for pageSize %d", math.MaxInt32/pageSize+1, pageSize))
+		if offset > math.MaxInt32 {
+			offset = 0
+		}
+	}
+	return int32(pageSize), int32(offset)
+}
+
+// Empty string is treated as false
+func convertAndValidateStringToBool(v *validator, boolAsStr string, name string) bool {
+	if boolAsStr == "" {
+		return false
+	}
+
+	value, err := strconv.ParseBool(boolAsStr)
+	v.check(err == nil, name, fmt.Sprintf("expected true or false, value provided: %v", boolAsStr))
+	return value
+}
+
 func convertAndValidateStringToDate(v *validator, dateAsStr string, name string) time.Time {
 	date, err := time.Parse("02.01.2006", dateAsStr)
 	v.check(err == nil, name,
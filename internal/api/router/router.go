@@ -13,19 +13,55 @@ func New(hq *handlers.HandleQueries) *chi.Mux {
 	router.MethodNotAllowed(badresponses.MethodNotAllowedResponse)
 	router.NotFound(badresponses.NotFoundResponse)
 
+	router.Use(hq.Actor)
+
 	router.Group(func(r chi.Router) {
 		r.Use(hq.RequestLogging)
 
 		r.Post("/music-library/song", hq.AddSong)
 		r.Put("/music-library/song", hq.UpdateSongInfo)
+		r.Patch("/music-library/song", hq.PatchSong)
 		r.Delete("/music-library/song", hq.DeleteSong)
+		r.Post("/music-library/trash/{id}/restore", hq.RestoreSong)
+		r.Delete("/music-library/trash/{id}", hq.PurgeSong)
+		r.Post("/music-library/song/{id}/history/{revision}/revert", hq.RevertSong)
+		r.Post("/music-library/groups", hq.AddGroup)
+		r.Put("/music-library/groups/{id}", hq.RenameGroup)
+		r.Delete("/music-library/groups/{id}", hq.DeleteGroup)
+		r.Post("/music-library/groups/{id}/merge", hq.MergeGroups)
+		r.Post("/music-library/albums", hq.AddAlbum)
+		r.Delete("/music-library/albums/{id}", hq.DeleteAlbum)
+		r.Post("/music-library/albums/{id}/tracks", hq.AddAlbumTrack)
+		r.Put("/music-library/albums/{id}/tracks", hq.ReorderAlbumTracks)
+		r.Delete("/music-library/albums/{id}/tracks/{songId}", hq.RemoveAlbumTrack)
+		r.Post("/music-library/playlists", hq.AddPlaylist)
+		r.Put("/music-library/playlists/{id}", hq.UpdatePlaylist)
+		r.Delete("/music-library/playlists/{id}", hq.DeletePlaylist)
+		r.Post("/music-library/playlists/{id}/items", hq.AddPlaylistItem)
+		r.Put("/music-library/playlists/{id}/items/{itemId}", hq.MovePlaylistItem)
+		r.Delete("/music-library/playlists/{id}/items/{itemId}", hq.RemovePlaylistItem)
+		r.Post("/music-library/song/{id}/tags", hq.AttachTags)
+		r.Delete("/music-library/song/{id}/tags/{tagId}", hq.DetachTag)
+		r.Post("/music-library/import", hq.ImportSongs)
+		r.Post("/music-library/batch", hq.BatchSongs)
 	})
 
 	router.Group(func(r chi.Router) {
 		r.Use(hq.ResponseLogging)
 
+		r.Get("/music-library/song/{id}", hq.GetSong)
 		r.Get("/music-library/lyrics", hq.GetSongLyrics)
 		r.Get("/music-library/list", hq.GetFilteredList)
+		r.Get("/music-library/trash", hq.GetTrash)
+		r.Get("/music-library/song/{id}/history", hq.GetSongHistory)
+		r.Get("/music-library/groups", hq.GetGroups)
+		r.Get("/music-library/groups/{id}", hq.GetGroup)
+		r.Get("/music-library/albums/{id}", hq.GetAlbum)
+		r.Get("/music-library/playlists", hq.GetPlaylists)
+		r.Get("/music-library/playlists/{id}", hq.GetPlaylist)
+		r.Get("/music-library/song/{id}/tags", hq.GetSongTags)
+		r.Get("/music-library/tags", hq.GetTags)
+		r.Get("/music-library/export", hq.ExportSongs)
 	})
 
 	router.Get("/swagger/*", httpSwagger.Handler(
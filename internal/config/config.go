@@ -1,19 +1,49 @@
 package config
 
-import "github.com/spf13/viper"
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
 
 type Config struct {
-	Port             uint16 `mapstructure:"PORT"`
-	DBUser           string `mapstructure:"DB_USER"`
-	DBPassword       string `mapstructure:"DB_PASSWORD"`
-	DBHost           string `mapstructure:"DB_HOST"`
-	DBPort           uint16 `mapstructure:"DB_PORT"`
-	DBName           string `mapstructure:"DB_NAME"`
-	ExternalAPIURL   string `mapstructure:"EXTERNAL_API_URL"`
-	MaxGroupNameLen  int    `mapstructure:"MAX_GROUP_NAME_LEN"`
-	MaxSongNameLen   int    `mapstructure:"MAX_SONG_NAME_LEN"`
-	MaxSongLyricsLen int    `mapstructure:"MAX_SONG_LYRICS_LEN"`
-	MaxSongLinkLen   int    `mapstructure:"MAX_SONG_LINK_LEN"`
+	Port           uint16        `mapstructure:"PORT"`
+	DBUser         string        `mapstructure:"DB_USER"`
+	DBPassword     string        `mapstructure:"DB_PASSWORD"`
+	DBHost         string        `mapstructure:"DB_HOST"`
+	DBPort         uint16        `mapstructure:"DB_PORT"`
+	DBName         string        `mapstructure:"DB_NAME"`
+	DBQueryTimeout time.Duration `mapstructure:"DB_QUERY_TIMEOUT"`
+
+	ExternalAPIURL              string        `mapstructure:"EXTERNAL_API_URL"`
+	ExternalAPIFallbackURLs     []string      `mapstructure:"EXTERNAL_API_FALLBACK_URLS"`
+	ExternalAPITimeout          time.Duration `mapstructure:"EXTERNAL_API_TIMEOUT"`
+	ExternalAPICacheSize        int           `mapstructure:"EXTERNAL_API_CACHE_SIZE"`
+	ExternalAPICacheTTL         time.Duration `mapstructure:"EXTERNAL_API_CACHE_TTL"`
+	ExternalAPIBreakerThreshold int           `mapstructure:"EXTERNAL_API_BREAKER_THRESHOLD"`
+	ExternalAPIBreakerCooldown  time.Duration `mapstructure:"EXTERNAL_API_BREAKER_COOLDOWN"`
+
+	EnrichmentWorkers      int           `mapstructure:"ENRICHMENT_WORKERS"`
+	EnrichmentPollInterval time.Duration `mapstructure:"ENRICHMENT_POLL_INTERVAL"`
+	EnrichmentLease        time.Duration `mapstructure:"ENRICHMENT_LEASE"`
+	EnrichmentMaxAttempts  int           `mapstructure:"ENRICHMENT_MAX_ATTEMPTS"`
+	EnrichmentBackoffBase  time.Duration `mapstructure:"ENRICHMENT_BACKOFF_BASE"`
+	EnrichmentBackoffMax   time.Duration `mapstructure:"ENRICHMENT_BACKOFF_MAX"`
+
+	SearchDefaultLanguage string `mapstructure:"SEARCH_DEFAULT_LANGUAGE"`
+
+	TrashRetention     time.Duration `mapstructure:"TRASH_RETENTION"`
+	TrashPurgeInterval time.Duration `mapstructure:"TRASH_PURGE_INTERVAL"`
+
+	PlaylistSongDeletePolicy string `mapstructure:"PLAYLIST_SONG_DELETE_POLICY"`
+
+	IdempotencyKeyTTL           time.Duration `mapstructure:"IDEMPOTENCY_KEY_TTL"`
+	IdempotencyKeyPurgeInterval time.Duration `mapstructure:"IDEMPOTENCY_KEY_PURGE_INTERVAL"`
+
+	MaxGroupNameLen  int `mapstructure:"MAX_GROUP_NAME_LEN"`
+	MaxSongNameLen   int `mapstructure:"MAX_SONG_NAME_LEN"`
+	MaxSongLyricsLen int `mapstructure:"MAX_SONG_LYRICS_LEN"`
+	MaxSongLinkLen   int `mapstructure:"MAX_SONG_LINK_LEN"`
 }
 
 func Load() (config Config, err error) {
@@ -21,6 +51,25 @@ func Load() (config Config, err error) {
 
 	viper.AutomaticEnv()
 
+	viper.SetDefault("DB_QUERY_TIMEOUT", "3s")
+	viper.SetDefault("EXTERNAL_API_TIMEOUT", "5s")
+	viper.SetDefault("EXTERNAL_API_CACHE_SIZE", 1000)
+	viper.SetDefault("EXTERNAL_API_CACHE_TTL", "1h")
+	viper.SetDefault("EXTERNAL_API_BREAKER_THRESHOLD", 5)
+	viper.SetDefault("EXTERNAL_API_BREAKER_COOLDOWN", "30s")
+	viper.SetDefault("ENRICHMENT_WORKERS", 4)
+	viper.SetDefault("ENRICHMENT_POLL_INTERVAL", "2s")
+	viper.SetDefault("ENRICHMENT_LEASE", "1m")
+	viper.SetDefault("ENRICHMENT_MAX_ATTEMPTS", 5)
+	viper.SetDefault("ENRICHMENT_BACKOFF_BASE", "5s")
+	viper.SetDefault("ENRICHMENT_BACKOFF_MAX", "10m")
+	viper.SetDefault("SEARCH_DEFAULT_LANGUAGE", "english")
+	viper.SetDefault("TRASH_RETENTION", "720h")
+	viper.SetDefault("TRASH_PURGE_INTERVAL", "1h")
+	viper.SetDefault("PLAYLIST_SONG_DELETE_POLICY", "cascade")
+	viper.SetDefault("IDEMPOTENCY_KEY_TTL", "24h")
+	viper.SetDefault("IDEMPOTENCY_KEY_PURGE_INTERVAL", "1h")
+
 	err = viper.ReadInConfig()
 	if err != nil {
 		return
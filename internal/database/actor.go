@@ -0,0 +1,22 @@
+package database
+
+import "context"
+
+// Actor recorded in song history for changes made without one, e.g. by migrations
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// Returns ctx whose changes are recorded in song history as made by actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Returns actor set by [WithActor], [SystemActor] if there's none
+func ActorFrom(ctx context.Context) string {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	if !ok || actor == "" {
+		return SystemActor
+	}
+	return actor
+}
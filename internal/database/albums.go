@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
+)
+
+// Reports whether err is a violation of the unique album titles of a group constraint
+func isAlbumConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) &&
+		pqErr.Code == "23505" && pqErr.Constraint == "unique_group_album_title"
+}
+
+// Writes assigned id into album.Id.
+// Returns [ErrGroupNotFound] if there's no such group, [ErrAlbumAlreadyExists] if the title is taken
+func (q *Queries) AddAlbum(ctx context.Context, album *models.Album) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{album.GroupId, album.Title, album.ReleaseDate, album.CoverLink}
+
+	err = q.stmt(ctx, "AddAlbum").QueryRowContext(ctx, args...).Scan(&album.Id)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	// nothing is inserted both for missing groups and taken titles
+	_, err = q.GetGroup(ctx, album.GroupId)
+	if err != nil {
+		return err
+	}
+	return ErrAlbumAlreadyExists
+}
+
+// Returns [ErrAlbumNotFound] if there's no such album
+func (q *Queries) GetAlbum(ctx context.Context, albumId int64) (_ *models.Album, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{albumId}
+
+	var album models.Album
+	err = q.stmt(ctx, "GetAlbum").QueryRowContext(ctx, args...).Scan(
+		&album.Id,
+		&album.GroupId,
+		&album.GroupName,
+		&album.Title,
+		&album.ReleaseDate,
+		&album.CoverLink,
+		&album.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlbumNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &album, nil
+}
+
+// Deletes album with its tracks, songs stay in the library.
+// Returns [ErrAlbumNotFound] if there's no such album
+func (q *Queries) DeleteAlbum(ctx context.Context, albumId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{albumId}
+
+	result, err := q.stmt(ctx, "DeleteAlbum").ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrAlbumNotFound
+	}
+	return nil
+}
+
+// Returns tracks ordered by position, [ErrAlbumNotFound] if there's no such album
+func (q *Queries) GetAlbumTracks(ctx context.Context, albumId int64) (_ []models.AlbumTrack, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	_, err = q.GetAlbum(ctx, albumId)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{albumId}
+
+	rows, err := q.stmt(ctx, "GetAlbumTracks").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []models.AlbumTrack
+	for rows.Next() {
+		var track models.AlbumTrack
+		err := rows.Scan(
+			&track.Position,
+			&track.Song.Id,
+			&track.Song.GroupId,
+			&track.Song.GroupName,
+			&track.Song.SongName,
+			&track.Song.ReleaseDate,
+			&track.Song.SongLyrics,
+			&track.Song.Link,
+			&track.Song.EnrichmentStatus,
+			&track.Song.UpdatedAt,
+			&track.Song.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// Inserts song at the position shifting the following tracks, 0 or positions past the end append it.
+// Returns [ErrAlbumNotFound], [ErrSongNotFound] if the album or the song is missing,
+// [ErrTrackAlreadyExists] if the song is already on the album
+func (q *Queries) AddAlbumTrack(ctx context.Context, albumId int64, songId int64, position int) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		order, _, err := tx.lockTrackOrder(ctx, albumId)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(order, songId) {
+			return ErrTrackAlreadyExists
+		}
+
+		exists, err := tx.isSongIdPresent(ctx, songId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSongNotFound
+		}
+
+		return tx.writeTrackOrder(ctx, albumId, insertTrack(order, songId, position))
+	})
+}
+
+// Puts tracks in the order of songIds, which should list every listed track once, tracks of trashed songs go last.
+// Returns [ErrAlbumNotFound] if there's no such album, [ErrInvalidTrackOrder] if songIds don't match the tracks
+func (q *Queries) ReorderAlbumTracks(ctx context.Context, albumId int64, songIds []int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		order, trashed, err := tx.lockTrackOrder(ctx, albumId)
+		if err != nil {
+			return err
+		}
+
+		newOrder, err := reorderTracks(order, trashed, songIds)
+		if err != nil {
+			return err
+		}
+		return tx.writeTrackOrder(ctx, albumId, newOrder)
+	})
+}
+
+// Removes the track, following tracks move up.
+// Returns [ErrAlbumNotFound] if there's no such album, [ErrTrackNotFound] if the song isn't on the album
+func (q *Queries) RemoveAlbumTrack(ctx context.Context, albumId int64, songId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		order, _, err := tx.lockTrackOrder(ctx, albumId)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(order, songId) {
+			return ErrTrackNotFound
+		}
+
+		return tx.writeTrackOrder(ctx, albumId, slices.DeleteFunc(order, func(id int64) bool { return id == songId }))
+	})
+}
+
+// Locks the album against concurrent changes of its tracks and returns song ids of the tracks by position
+// together with those of them that are in trash
+func (q *Queries) lockTrackOrder(ctx context.Context, albumId int64) (order, trashed []int64, err error) {
+	var locked int64
+	err = q.stmt(ctx, "LockAlbum").QueryRowContext(ctx, albumId).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrAlbumNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := q.stmt(ctx, "GetTrackOrder").QueryContext(ctx, albumId)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			songId    int64
+			isTrashed bool
+		)
+		err := rows.Scan(&songId, &isTrashed)
+		if err != nil {
+			return nil, nil, err
+		}
+		order = append(order, songId)
+		if isTrashed {
+			trashed = append(trashed, songId)
+		}
+	}
+
+	return order, trashed, rows.Err()
+}
+
+// Replaces tracks of the album with songIds numbered from 1
+func (q *Queries) writeTrackOrder(ctx context.Context, albumId int64, songIds []int64) error {
+	_, err := q.stmt(ctx, "WriteTrackOrder").ExecContext(ctx, albumId, pq.Array(songIds))
+	return err
+}
+
+// Returns order with songId inserted at the position, positions count from 1
+func insertTrack(order []int64, songId int64, position int) []int64 {
+	if position <= 0 || position > len(order) {
+		return append(order, songId)
+	}
+	return slices.Insert(order, position-1, songId)
+}
+
+// Returns order of songIds followed by trashed tracks, songIds should list every other track once
+func reorderTracks(order, trashed, songIds []int64) ([]int64, error) {
+	listed := slices.DeleteFunc(slices.Clone(order), func(id int64) bool { return slices.Contains(trashed, id) })
+	given := slices.Clone(songIds)
+	slices.Sort(listed)
+	slices.Sort(given)
+	if !slices.Equal(listed, given) {
+		return nil, ErrInvalidTrackOrder
+	}
+
+	newOrder := slices.Clone(songIds)
+	for _, songId := range order {
+		if slices.Contains(trashed, songId) {
+			newOrder = append(newOrder, songId)
+		}
+	}
+	return newOrder, nil
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Locks up to limit due jobs for lease duration, increasing their attempt counters.
+// Jobs whose lease ran out (e.g. worker died) can be claimed again
+func (q *Queries) ClaimEnrichmentJobs(ctx context.Context, limit int, lease time.Duration) (_ []models.EnrichmentJob, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{limit, lease.Milliseconds()}
+
+	rows, err := q.stmt(ctx, "ClaimEnrichmentJobs").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.EnrichmentJob
+	for rows.Next() {
+		var job models.EnrichmentJob
+		err := rows.Scan(&job.Id, &job.SongId, &job.GroupName, &job.SongName, &job.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// Releases the job so it can be claimed again after runAfter
+func (q *Queries) RetryEnrichmentJob(ctx context.Context, jobId int64, runAfter time.Time, reason string) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{jobId, runAfter, reason}
+
+	_, err = q.stmt(ctx, "RetryEnrichmentJob").ExecContext(ctx, args...)
+	return err
+}
+
+// Drops the job and marks its song as failed to enrich
+func (q *Queries) FailEnrichmentJob(ctx context.Context, jobId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{jobId}
+
+	_, err = q.stmt(ctx, "FailEnrichmentJob").ExecContext(ctx, args...)
+	return err
+}
+
+// Reports whether job is still queued and its song still has the names job was claimed with,
+// inside a transaction the job and the song stay locked until it ends
+func (q *Queries) IsEnrichmentJobCurrent(ctx context.Context, job models.EnrichmentJob) (_ bool, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{job.Id, job.GroupName, job.SongName}
+
+	var jobId int64
+	err = q.stmt(ctx, "IsEnrichmentJobCurrent").QueryRowContext(ctx, args...).Scan(&jobId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -1,11 +1,50 @@
 package database
 
 import (
+	"context"
 	"errors"
 )
 
 var (
-	ErrSongNotFound      = errors.New("no matching record in database")
-	ErrSongAlreadyExists = errors.New("given song already exists in database")
-	ErrSongHasNoLyrics   = errors.New("given song does not have any lyrics assigned")
+	ErrSongNotFound           = errors.New("no matching record in database")
+	ErrSongAlreadyExists      = errors.New("given song already exists in database")
+	ErrSongTrashed            = errors.New("given song is in trash, restore it first")
+	ErrSongHasNoLyrics        = errors.New("given song does not have any lyrics assigned")
+	ErrVersionMismatch        = errors.New("song was modified since the given version")
+	ErrRevisionNotFound       = errors.New("no matching revision in database")
+	ErrInvalidCursor          = errors.New("cursor does not belong to this list")
+	ErrGroupNotFound          = errors.New("no matching group in database")
+	ErrGroupAlreadyExists     = errors.New("group with given name already exists in database")
+	ErrGroupNotEmpty          = errors.New("group still has albums or songs, including trashed ones")
+	ErrAlbumNotFound          = errors.New("no matching album in database")
+	ErrAlbumAlreadyExists     = errors.New("group already has an album with given title")
+	ErrTrackNotFound          = errors.New("song is not on the album")
+	ErrTrackAlreadyExists     = errors.New("song is already on the album")
+	ErrInvalidTrackOrder      = errors.New("track order must list every track of the album once")
+	ErrPlaylistNotFound       = errors.New("no matching playlist in database")
+	ErrPlaylistAlreadyExists  = errors.New("owner already has a playlist with given name")
+	ErrItemNotFound           = errors.New("no matching item in the playlist")
+	ErrSongInPlaylist         = errors.New("song is in playlists, remove it from them first")
+	ErrTagNotFound            = errors.New("song does not have given tag")
+	ErrIdempotencyKeyNotFound = errors.New("no response saved for given idempotency key")
+	ErrIdempotencyKeyExists   = errors.New("response is already saved for given idempotency key")
+	ErrQueryTimeout           = errors.New("database query timed out")
+	ErrQueryCanceled          = errors.New("database query was canceled")
 )
+
+// Replaces errors caused by a finished ctx with [ErrQueryTimeout] or [ErrQueryCanceled],
+// other errors are returned as is
+func contextErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrQueryTimeout
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return ErrQueryCanceled
+	default:
+		return err
+	}
+}
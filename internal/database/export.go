@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Songs fetched from the export cursor at a time
+const exportFetchSize = 1000
+
+// Calls fn for every song of the list in the list order, cursor, limit and offset of the filter are ignored.
+// Songs are read through a cursor of a single transaction, so the export sees the library as it was
+// when it started however long fn takes, and only [exportFetchSize] songs are held at a time.
+// Query timeout applies to every fetch rather than to the whole export.
+// Errors returned by fn stop the export and are returned as is
+func (q *Queries) ExportSongs(ctx context.Context, filter *ListFilter, fn func(song *models.FullSongInfo) error) error {
+	query, args, err := buildListQuery(filter, false)
+	if err != nil {
+		return err
+	}
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		err := tx.declareExport(ctx, query, args)
+		if err != nil {
+			return err
+		}
+
+		for {
+			songs, err := tx.fetchExport(ctx)
+			if err != nil {
+				return err
+			}
+			if len(songs) == 0 {
+				return nil
+			}
+
+			for i := range songs {
+				err := fn(&songs[i])
+				if err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// Cursor is closed when the transaction ends
+func (q *Queries) declareExport(ctx context.Context, query string, args []any) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	_, err = q.tx.ExecContext(ctx, "DECLARE song_export NO SCROLL CURSOR FOR "+query, args...)
+	return err
+}
+
+func (q *Queries) fetchExport(ctx context.Context) (_ []models.FullSongInfo, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	rows, err := q.query(ctx, fmt.Sprintf("FETCH %d FROM song_export", exportFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	return scanListRows(rows)
+}
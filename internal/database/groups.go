@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
+)
+
+type GroupFilter struct {
+	Name   []TextFilter
+	Limit  int32
+	Offset int32
+}
+
+// Reports whether err is a violation of the unique group names constraint
+func isGroupConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) &&
+		pqErr.Code == "23505" && pqErr.Constraint == "unique_group_name"
+}
+
+// Returns [ErrGroupAlreadyExists] if the name is taken
+func (q *Queries) AddGroup(ctx context.Context, name string) (_ int64, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{name}
+
+	var groupId int64
+	err = q.stmt(ctx, "AddGroup").QueryRowContext(ctx, args...).Scan(&groupId)
+	if err == sql.ErrNoRows {
+		return 0, ErrGroupAlreadyExists
+	}
+	return groupId, err
+}
+
+// Returns [ErrGroupNotFound] if there's no such group
+func (q *Queries) GetGroup(ctx context.Context, groupId int64) (_ *models.Group, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{groupId}
+
+	var group models.Group
+	err = q.stmt(ctx, "GetGroup").QueryRowContext(ctx, args...).Scan(
+		&group.Id,
+		&group.Name,
+		&group.CreatedAt,
+		&group.SongCount,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// Returns groups ordered by name, name filters work like those of [ListFilter]
+func (q *Queries) GetGroups(ctx context.Context, filter *GroupFilter) (_ []models.Group, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	var args queryArgs
+	where := append([]string{"true"}, textConditions("group_name", filter.Name, &args)...)
+	query := fmt.Sprintf(`
+		SELECT group_id,
+			group_name,
+			created_at,
+			(SELECT count(*) FROM music_library AS m WHERE m.group_id=g.group_id AND m.deleted_at IS NULL)
+		FROM groups AS g
+		WHERE %s
+		ORDER BY group_name, group_id
+		LIMIT %s OFFSET %s`,
+		strings.Join(where, " AND "), args.add(filter.Limit), args.add(filter.Offset))
+
+	rows, err := q.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		err := rows.Scan(&group.Id, &group.Name, &group.CreatedAt, &group.SongCount)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+// Renames the group in every of its songs as well (see migration 010_add_groups), so the songs' history records it.
+// Returns [ErrGroupNotFound] if there's no such group, [ErrGroupAlreadyExists] if the name is taken
+func (q *Queries) RenameGroup(ctx context.Context, groupId int64, name string) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{groupId, name}
+
+	return q.asActor(ctx, func(tx *Queries) error {
+		result, err := tx.stmt(ctx, "RenameGroup").ExecContext(ctx, args...)
+		if isGroupConflict(err) {
+			return ErrGroupAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		renamed, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if renamed == 0 {
+			return ErrGroupNotFound
+		}
+		return nil
+	})
+}
+
+// Returns [ErrGroupNotFound] if there's no such group, [ErrGroupNotEmpty] if any album or song belongs to it
+func (q *Queries) DeleteGroup(ctx context.Context, groupId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{groupId}
+
+	result, err := q.stmt(ctx, "DeleteGroup").ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		return nil
+	}
+
+	_, err = q.GetGroup(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	return ErrGroupNotEmpty
+}
+
+// Moves albums and songs of sources (trashed ones too) into the target group and deletes sources.
+// Groups are locked first so that nothing joins a source while it's merged.
+// Returns [ErrGroupNotFound] if any of the groups is missing,
+// [ErrSongAlreadyExists] or [ErrAlbumAlreadyExists] if the target group already has a song
+// or an album named like one being moved
+func (q *Queries) MergeGroups(ctx context.Context, targetId int64, sourceIds []int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	sources := slices.DeleteFunc(slices.Clone(sourceIds), func(id int64) bool { return id == targetId })
+	slices.Sort(sources)
+	sources = slices.Compact(sources)
+	groups := append([]int64{targetId}, sources...)
+
+	return q.asActor(ctx, func(tx *Queries) error {
+		var locked int
+		err := tx.stmt(ctx, "LockGroups").QueryRowContext(ctx, pq.Array(groups)).Scan(&locked)
+		if err != nil {
+			return err
+		}
+		if locked != len(groups) {
+			return ErrGroupNotFound
+		}
+
+		_, err = tx.stmt(ctx, "MoveGroupAlbums").ExecContext(ctx, targetId, pq.Array(sources))
+		if isAlbumConflict(err) {
+			return ErrAlbumAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.stmt(ctx, "MoveGroupSongs").ExecContext(ctx, targetId, pq.Array(sources))
+		if isSongConflict(err) {
+			return ErrSongAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.stmt(ctx, "DeleteGroups").ExecContext(ctx, pq.Array(sources))
+		return err
+	})
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Song fields as stored in song_history by song_history_values()
+type historyValues struct {
+	Group       string  `json:"group"`
+	Song        string  `json:"song"`
+	ReleaseDate *string `json:"releaseDate"`
+	Text        *string `json:"text"`
+	Link        *string `json:"link"`
+}
+
+// Returns revisions of the song oldest first
+func (q *Queries) GetSongHistory(ctx context.Context, songId int64, limit, offset int32) (_ []models.SongRevision, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{songId, limit, offset}
+
+	rows, err := q.stmt(ctx, "GetSongHistory").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.SongRevision
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, *revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Returns [ErrRevisionNotFound] if there's no such revision
+func (q *Queries) GetRevision(ctx context.Context, revisionId int64) (_ *models.SongRevision, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{revisionId}
+
+	revision, err := scanRevision(q.stmt(ctx, "GetRevision").QueryRowContext(ctx, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrRevisionNotFound
+	}
+	return revision, err
+}
+
+func scanRevision(row interface{ Scan(dest ...any) error }) (*models.SongRevision, error) {
+	var (
+		revision             models.SongRevision
+		oldValues, newValues []byte
+	)
+	err := row.Scan(
+		&revision.Id,
+		&revision.SongId,
+		&revision.Version,
+		&revision.Operation,
+		&revision.Actor,
+		&revision.ChangedAt,
+		&oldValues,
+		&newValues,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.Old, err = decodeHistoryValues(oldValues)
+	if err != nil {
+		return nil, fmt.Errorf("revision %d: old values: %w", revision.Id, err)
+	}
+	revision.New, err = decodeHistoryValues(newValues)
+	if err != nil {
+		return nil, fmt.Errorf("revision %d: new values: %w", revision.Id, err)
+	}
+	return &revision, nil
+}
+
+func decodeHistoryValues(js []byte) (*models.SongValues, error) {
+	if js == nil {
+		return nil, nil
+	}
+
+	var hv historyValues
+	err := json.Unmarshal(js, &hv)
+	if err != nil {
+		return nil, err
+	}
+
+	values := models.SongValues{GroupName: hv.Group, SongName: hv.Song}
+	if hv.ReleaseDate != nil {
+		releaseDate, err := time.Parse(time.DateOnly, *hv.ReleaseDate)
+		if err != nil {
+			return nil, err
+		}
+		values.ReleaseDate = sql.NullTime{Time: releaseDate, Valid: true}
+	}
+	if hv.Text != nil {
+		values.SongLyrics = sql.NullString{String: *hv.Text, Valid: true}
+	}
+	if hv.Link != nil {
+		values.Link = sql.NullString{String: *hv.Link, Valid: true}
+	}
+	return &values, nil
+}
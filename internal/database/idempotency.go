@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Returns [ErrIdempotencyKeyNotFound] if no response is saved for the key or it expired
+func (q *Queries) GetIdempotentResponse(ctx context.Context, key string) (_ *models.IdempotentResponse, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{key}
+
+	var response models.IdempotentResponse
+	err = q.stmt(ctx, "GetIdempotentResponse").QueryRowContext(ctx, args...).Scan(
+		&response.Key, &response.Fingerprint, &response.StatusCode, &response.Body, &response.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// Inserts the key without a response or takes it over if it expired. When a concurrent transaction
+// claims the key this waits for it to finish, the key is taken if it committed.
+// Returns [ErrIdempotencyKeyExists] if the key is taken and hasn't expired
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, expiresAt time.Time) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{key, fingerprint, expiresAt}
+
+	result, err := q.stmt(ctx, "ClaimIdempotencyKey").ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if claimed == 0 {
+		return ErrIdempotencyKeyExists
+	}
+	return nil
+}
+
+// Returns [ErrIdempotencyKeyNotFound] if the key isn't claimed
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, body []byte) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{key, statusCode, body}
+
+	result, err := q.stmt(ctx, "CompleteIdempotencyKey").ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	completed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if completed == 0 {
+		return ErrIdempotencyKeyNotFound
+	}
+	return nil
+}
+
+// Deletes up to limit keys expired before expiredBefore, oldest first
+func (q *Queries) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time, limit int) (_ int64, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{expiredBefore, limit}
+
+	result, err := q.stmt(ctx, "PurgeIdempotencyKeys").ExecContext(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
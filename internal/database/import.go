@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
+)
+
+// Reasons of songs skipped by ImportSongs
+const (
+	importExists    = "song already exists"
+	importTrashed   = "song is in trash, restore it to update"
+	importNoDetails = "song already exists and there are no details to update it with"
+)
+
+// Explains why an existing song wasn't updated by ImportSongs
+func importSkipped(songId int64, trashed, update, hasDetails bool) models.ImportResult {
+	reason := importExists
+	switch {
+	case trashed:
+		reason = importTrashed
+	case update && !hasDetails:
+		reason = importNoDetails
+	}
+	return models.ImportResult{Status: models.ImportSkipped, SongId: songId, Reason: reason}
+}
+
+// Adds songs in a single batched statement, songs with details are saved as enriched
+// and enrichment jobs are queued for the rest. Songs that already exist are skipped,
+// unless update is set, then their details are replaced by the given ones.
+// Returns results in the order of songs, which should name distinct songs
+func (q *Queries) ImportSongs(ctx context.Context, songs []models.ImportSong, update bool) (_ []models.ImportResult, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	groupNames := make([]string, len(songs))
+	songNames := make([]string, len(songs))
+	releaseDates := make([]sql.NullString, len(songs))
+	lyrics := make([]sql.NullString, len(songs))
+	links := make([]sql.NullString, len(songs))
+	for i, song := range songs {
+		groupNames[i] = song.GroupName
+		songNames[i] = song.SongName
+		if song.Details != nil {
+			releaseDates[i] = sql.NullString{String: song.Details.ReleaseDate.Format("2006-01-02"), Valid: true}
+			lyrics[i] = sql.NullString{String: song.Details.SongLyrics, Valid: true}
+			links[i] = sql.NullString{String: song.Details.Link, Valid: true}
+		}
+	}
+	args := []any{pq.Array(groupNames), pq.Array(songNames), pq.Array(releaseDates),
+		pq.Array(lyrics), pq.Array(links), update}
+
+	results := make([]models.ImportResult, 0, len(songs))
+	err = q.asActor(ctx, func(tx *Queries) error {
+		rows, err := tx.stmt(ctx, "ImportSongs").QueryContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var songId int64
+			var created, updated, trashed bool
+			err := rows.Scan(&songId, &created, &updated, &trashed)
+			if err != nil {
+				return err
+			}
+
+			hasDetails := songs[len(results)].Details != nil
+			switch {
+			case created:
+				results = append(results, models.ImportResult{Status: models.ImportCreated, SongId: songId})
+			case updated:
+				results = append(results, models.ImportResult{Status: models.ImportUpdated, SongId: songId})
+			default:
+				// songs inserted concurrently have no id yet, they're skipped as existing ones
+				results = append(results, importSkipped(songId, trashed, update, hasDetails))
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
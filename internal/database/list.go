@@ -0,0 +1,570 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
+)
+
+// Modes of [TextFilter]
+const (
+	MatchContains = "contains"
+	MatchExact    = "exact"
+	MatchPrefix   = "prefix"
+)
+
+// Filter of a text field, values are compared ignoring case and accents (see migration 009_add_unaccent_filters)
+// and keep ILIKE wildcards in contains and prefix modes.
+// Field matches if it matches any of Values, or none of them when Negate is set
+type TextFilter struct {
+	Mode   string
+	Values []string
+	Negate bool
+}
+
+// Songs match the filter if they match every present part of it
+type ListFilter struct {
+	GroupIds              []int64      // Songs of any of the groups
+	AlbumIds              []int64      // Songs on any of the albums
+	Tags                  []models.Tag // Songs with any of the tags given by kind and name, or all of them with AllTags
+	AllTags               bool
+	GroupName             []TextFilter
+	SongName              []TextFilter
+	ReleaseDateLowerBound sql.NullTime
+	ReleaseDateUpperBound sql.NullTime
+	Lyrics                []TextFilter
+	HasLyrics             sql.NullBool // Songs without lyrics or with empty ones have none
+	HasLink               sql.NullBool
+	Search                sql.NullString // Full-text query (websearch_to_tsquery syntax), orders songs by relevance
+	SearchLanguage        string         // Text search configuration of Search, e.g. english or russian
+	Trashed               bool           // Lists songs from trash instead of the library
+	Sort                  []SortKey      // Order of the list, see [listOrder]
+	Fields                []string       // Fields to read (see [ListFields]), others may be left empty. Every field is read if it's empty
+	Cursor                *ListCursor    // Lists songs next to the cursor instead of skipping Offset songs
+	Limit                 int32
+	Offset                int32
+}
+
+// Position in the list next to a row, see [ListFilter.CursorAt].
+// Songs after the row are listed unless Backward is set, cursor is only valid for the order it was made for
+type ListCursor struct {
+	Order    string
+	Keys     []sql.NullString // Values of the row's sort keys
+	Backward bool
+}
+
+// Fields of listed songs, see [ListFilter.Fields]
+const (
+	FieldId               = "id"
+	FieldGroupId          = "groupId"
+	FieldGroup            = "group"
+	FieldSong             = "song"
+	FieldReleaseDate      = "releaseDate"
+	FieldText             = "text"
+	FieldLink             = "link"
+	FieldEnrichmentStatus = "enrichmentStatus"
+	FieldVersion          = "version"
+	FieldDeletedAt        = "deletedAt"
+	FieldMatch            = "match" // Snippet and verse of search match, rank is read whenever there's a search
+)
+
+var ListFields = []string{FieldId, FieldGroupId, FieldGroup, FieldSong, FieldReleaseDate, FieldText, FieldLink,
+	FieldEnrichmentStatus, FieldVersion, FieldDeletedAt, FieldMatch}
+
+// Column of a listed field and the value it's replaced with when the field isn't read
+type listColumn struct {
+	field    string
+	column   string
+	omission string
+}
+
+// Columns in the order they're scanned by [Queries.GetFilteredList]
+var listColumns = []listColumn{
+	{FieldId, "song_id", "song_id"},
+	{FieldGroupId, "group_id", "0::bigint"},
+	{FieldGroup, "group_name", "''::text"},
+	{FieldSong, "song_name", "''::text"},
+	{FieldReleaseDate, "release_date", "NULL::date"},
+	{FieldText, "song_lyrics", "NULL::text"},
+	{FieldLink, "link", "NULL::text"},
+	{FieldEnrichmentStatus, "enrichment_status", "''::text"},
+	{FieldVersion, "version", "0::bigint"},
+	{FieldDeletedAt, "deleted_at", "NULL::timestamptz"},
+}
+
+// Fields the list can be sorted by
+const (
+	SortId          = "id"
+	SortGroup       = "group"
+	SortSong        = "song"
+	SortReleaseDate = "releaseDate"
+	SortRelevance   = "relevance" // Rank of search match, only available with search
+)
+
+var SortFields = []string{SortId, SortGroup, SortSong, SortReleaseDate, SortRelevance}
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Column the list is ordered by, song_id is always the last one so the order is total
+type orderKey struct {
+	name    string
+	column  string // Sql expression of the key
+	sqlType string // Type keys are cast to when compared with the column
+	desc    bool
+	value   func(row *models.FullSongInfo) sql.NullString
+}
+
+// Returns order of the list, tsquery is the sql expression of the search query if there's one.
+// Lists are ordered by Sort, found songs are ordered by relevance by default.
+// Ties are broken by ascending id unless Sort already has it
+func listOrder(filter *ListFilter, tsquery string) ([]orderKey, error) {
+	sort := filter.Sort
+	if len(sort) == 0 && filter.Search.Valid {
+		sort = []SortKey{{Field: SortRelevance, Desc: true}}
+	}
+
+	var order []orderKey
+	for _, sortKey := range append(sort, SortKey{Field: SortId}) {
+		if slices.ContainsFunc(order, func(key orderKey) bool { return key.name == sortKey.Field }) {
+			continue
+		}
+		key, err := sortColumn(sortKey.Field, filter, tsquery)
+		if err != nil {
+			return nil, err
+		}
+		key.desc = sortKey.Desc
+		order = append(order, key)
+	}
+	return order, nil
+}
+
+func sortColumn(field string, filter *ListFilter, tsquery string) (orderKey, error) {
+	switch field {
+	case SortId:
+		return orderKey{
+			name:    field,
+			column:  "song_id",
+			sqlType: "bigint",
+			value: func(row *models.FullSongInfo) sql.NullString {
+				return sql.NullString{String: strconv.FormatInt(row.Id, 10), Valid: true}
+			},
+		}, nil
+	case SortGroup:
+		return orderKey{
+			name:    field,
+			column:  "group_name",
+			sqlType: "text",
+			value: func(row *models.FullSongInfo) sql.NullString {
+				return sql.NullString{String: row.GroupName, Valid: true}
+			},
+		}, nil
+	case SortSong:
+		return orderKey{
+			name:    field,
+			column:  "song_name",
+			sqlType: "text",
+			value: func(row *models.FullSongInfo) sql.NullString {
+				return sql.NullString{String: row.SongName, Valid: true}
+			},
+		}, nil
+	case SortReleaseDate:
+		// missing release date counts as later than any date, like nulls do in postgres
+		return orderKey{
+			name:    field,
+			column:  "coalesce(release_date, 'infinity'::date)",
+			sqlType: "date",
+			value: func(row *models.FullSongInfo) sql.NullString {
+				if !row.ReleaseDate.Valid {
+					return sql.NullString{String: "infinity", Valid: true}
+				}
+				return sql.NullString{String: row.ReleaseDate.Time.Format(time.DateOnly), Valid: true}
+			},
+		}, nil
+	case SortRelevance:
+		if !filter.Search.Valid {
+			return orderKey{}, fmt.Errorf("sorting by %s requires search", field)
+		}
+		return orderKey{
+			name:    field,
+			column:  fmt.Sprintf("ts_rank(lyrics_search, %s)", tsquery),
+			sqlType: "real",
+			value: func(row *models.FullSongInfo) sql.NullString {
+				if row.Match == nil {
+					return sql.NullString{}
+				}
+				// rank is a real, formatting it as float32 keeps the value exact
+				return sql.NullString{String: strconv.FormatFloat(row.Match.Rank, 'g', -1, 32), Valid: true}
+			},
+		}, nil
+	}
+	return orderKey{}, fmt.Errorf("unknown sort field %q", field)
+}
+
+func orderSignature(order []orderKey) string {
+	names := make([]string, len(order))
+	for i, key := range order {
+		names[i] = key.name
+		if key.desc {
+			names[i] = "-" + key.name
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Returns cursor pointing past row in the list, backward cursors point before it
+func (f *ListFilter) CursorAt(row *models.FullSongInfo, backward bool) *ListCursor {
+	// the order was already checked when the row was listed
+	order, _ := listOrder(f, "")
+	cursor := &ListCursor{Order: orderSignature(order), Backward: backward}
+	for _, key := range order {
+		cursor.Keys = append(cursor.Keys, key.value(row))
+	}
+	return cursor
+}
+
+// Checks that cursor was made for the list's order and its keys can be compared with the columns
+func validateCursor(cursor *ListCursor, order []orderKey) error {
+	if cursor.Order != orderSignature(order) || len(cursor.Keys) != len(order) {
+		return ErrInvalidCursor
+	}
+	for i, key := range order {
+		if !cursor.Keys[i].Valid {
+			return ErrInvalidCursor
+		}
+		var err error
+		switch key.sqlType {
+		case "bigint":
+			_, err = strconv.ParseInt(cursor.Keys[i].String, 10, 64)
+		case "real":
+			_, err = strconv.ParseFloat(cursor.Keys[i].String, 32)
+		case "date":
+			if cursor.Keys[i].String != "infinity" {
+				_, err = time.Parse(time.DateOnly, cursor.Keys[i].String)
+			}
+		}
+		if err != nil {
+			return ErrInvalidCursor
+		}
+	}
+	return nil
+}
+
+// Accumulates arguments of a query built at runtime
+type queryArgs []any
+
+// Adds value to the arguments, returns its placeholder
+func (a *queryArgs) add(value any) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// Returns conditions of the filter, only present filters make it into the query
+// and every value is passed as an argument. tsquery is the sql expression of the search query if there's one
+func listConditions(filter *ListFilter, args *queryArgs) (where []string, language, tsquery string) {
+	where = append(where, "(deleted_at IS NOT NULL)="+args.add(filter.Trashed))
+	if len(filter.GroupIds) > 0 {
+		where = append(where, "group_id=ANY("+args.add(pq.Array(filter.GroupIds))+")")
+	}
+	if len(filter.AlbumIds) > 0 {
+		where = append(where, "song_id IN (SELECT song_id FROM album_tracks WHERE album_id=ANY("+
+			args.add(pq.Array(filter.AlbumIds))+"))")
+	}
+	if len(filter.Tags) > 0 {
+		where = append(where, tagCondition(filter.Tags, filter.AllTags, args))
+	}
+	where = append(where, textConditions("group_name", filter.GroupName, args)...)
+	where = append(where, textConditions("song_name", filter.SongName, args)...)
+	if filter.ReleaseDateLowerBound.Valid {
+		where = append(where, "release_date>="+args.add(filter.ReleaseDateLowerBound.Time))
+	}
+	if filter.ReleaseDateUpperBound.Valid {
+		where = append(where, "release_date<="+args.add(filter.ReleaseDateUpperBound.Time))
+	}
+	where = append(where, textConditions("coalesce(song_lyrics, '')", filter.Lyrics, args)...)
+	if filter.HasLyrics.Valid {
+		where = append(where, "(coalesce(song_lyrics, '')<>'')="+args.add(filter.HasLyrics.Bool))
+	}
+	if filter.HasLink.Valid {
+		where = append(where, "(coalesce(link, '')<>'')="+args.add(filter.HasLink.Bool))
+	}
+	if filter.Search.Valid {
+		language = args.add(filter.SearchLanguage)
+		tsquery = fmt.Sprintf("websearch_to_tsquery(%s::regconfig, %s)", language, args.add(filter.Search.String))
+		where = append(where, "lyrics_search @@ "+tsquery)
+	}
+	return where, language, tsquery
+}
+
+// Returns condition matching songs with any or all of the tags, tags are expected to be distinct
+func tagCondition(tags []models.Tag, all bool, args *queryArgs) string {
+	kinds, names := make([]string, len(tags)), make([]string, len(tags))
+	for i, tag := range tags {
+		kinds[i], names[i] = tag.Kind, tag.Name
+	}
+
+	songs := fmt.Sprintf(`SELECT st.song_id FROM song_tags AS st
+		JOIN tags AS t ON t.tag_id=st.tag_id
+		JOIN unnest(%s::text[], %s::text[]) AS f(kind, name) ON t.kind=f.kind AND t.name=f.name`,
+		args.add(pq.Array(kinds)), args.add(pq.Array(names)))
+	if all {
+		songs += " GROUP BY st.song_id HAVING count(*)=" + args.add(len(tags))
+	}
+	return "song_id IN (" + songs + ")"
+}
+
+// Returns condition for each filter of the column, values are folded the same way
+// as by the indexes of migration 009_add_unaccent_filters
+func textConditions(column string, filters []TextFilter, args *queryArgs) []string {
+	var where []string
+	folded := fmt.Sprintf("lower(f_unaccent(%s))", column)
+	for _, filter := range filters {
+		if len(filter.Values) == 0 {
+			continue
+		}
+
+		alternatives := make([]string, len(filter.Values))
+		for i, value := range filter.Values {
+			pattern := fmt.Sprintf("lower(f_unaccent(%s))", args.add(value))
+			switch filter.Mode {
+			case MatchExact:
+				alternatives[i] = folded + "=" + pattern
+			case MatchPrefix:
+				alternatives[i] = folded + " LIKE " + pattern + " || '%'"
+			default:
+				alternatives[i] = folded + " LIKE '%' || " + pattern + " || '%'"
+			}
+		}
+
+		condition := "(" + strings.Join(alternatives, " OR ") + ")"
+		if filter.Negate {
+			condition = "NOT " + condition
+		}
+		where = append(where, condition)
+	}
+	return where
+}
+
+// Reports whether filter lists whole library (or trash)
+func (f *ListFilter) unfiltered() bool {
+	return len(f.GroupIds) == 0 && len(f.AlbumIds) == 0 && len(f.Tags) == 0 && len(f.GroupName) == 0 &&
+		len(f.SongName) == 0 && !f.ReleaseDateLowerBound.Valid && !f.ReleaseDateUpperBound.Valid &&
+		len(f.Lyrics) == 0 && !f.HasLyrics.Valid && !f.HasLink.Valid && !f.Search.Valid
+}
+
+// Reports whether field should be read, fields the list is ordered by are always read since cursors are made of them
+func (f *ListFilter) reads(field string, order []orderKey) bool {
+	return len(f.Fields) == 0 || slices.Contains(f.Fields, field) ||
+		slices.ContainsFunc(order, func(key orderKey) bool { return key.name == field })
+}
+
+// Builds query of [Queries.GetFilteredList], fields that aren't read are replaced with placeholders
+// so that lyrics aren't read from the table unless they're needed.
+// Unless paged is set the query lists every song, ignoring cursor, limit and offset of the filter
+func buildListQuery(filter *ListFilter, paged bool) (string, []any, error) {
+	var args queryArgs
+	where, language, tsquery := listConditions(filter, &args)
+
+	order, err := listOrder(filter, tsquery)
+	if err != nil {
+		return "", nil, err
+	}
+
+	columns := make([]string, len(listColumns))
+	for i, column := range listColumns {
+		columns[i] = column.omission
+		if filter.reads(column.field, order) {
+			columns[i] = column.column
+		}
+	}
+
+	matchColumns := "NULL::real, NULL::text, NULL::bigint"
+	if filter.Search.Valid {
+		matchColumns = fmt.Sprintf("ts_rank(lyrics_search, %s), NULL::text, NULL::bigint", tsquery)
+	}
+	if filter.Search.Valid && filter.reads(FieldMatch, order) {
+		matchColumns = fmt.Sprintf(`ts_rank(lyrics_search, %[2]s),
+			ts_headline(%[1]s::regconfig, song_lyrics, %[2]s, 'MaxFragments=2, MaxWords=20, MinWords=5'),
+			(SELECT min(verse.number)
+				FROM unnest(string_to_array(song_lyrics, E'\n\n')) WITH ORDINALITY AS verse(text, number)
+				WHERE to_tsvector(%[1]s::regconfig, verse.text) @@ %[2]s)`, language, tsquery)
+	}
+	backward := false
+	if paged && filter.Cursor != nil {
+		err := validateCursor(filter.Cursor, order)
+		if err != nil {
+			return "", nil, err
+		}
+		backward = filter.Cursor.Backward
+		where = append(where, keysetCondition(order, filter.Cursor, &args))
+	}
+
+	orderBy := make([]string, len(order))
+	for i, key := range order {
+		// backward pages are read in reverse and flipped back afterwards
+		if key.desc != backward {
+			orderBy[i] = key.column + " DESC"
+		} else {
+			orderBy[i] = key.column + " ASC"
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s,
+			%s
+		FROM music_library
+		WHERE %s
+		ORDER BY %s`,
+		strings.Join(columns, ", "),
+		matchColumns,
+		strings.Join(where, "\n\t\tAND "),
+		strings.Join(orderBy, ", "))
+	if paged {
+		query += fmt.Sprintf(`
+		LIMIT %s OFFSET %s`, args.add(filter.Limit), args.add(filter.Offset))
+	}
+
+	return query, args, nil
+}
+
+// Matches rows that come after the cursor in the list order, or before it for backward cursors:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with comparisons flipped for descending keys
+func keysetCondition(order []orderKey, cursor *ListCursor, args *queryArgs) string {
+	alternatives := make([]string, len(order))
+	for i, key := range order {
+		var parts []string
+		for _, previous := range order[:i] {
+			parts = append(parts, fmt.Sprintf("%s=%s::%s",
+				previous.column, args.add(cursor.Keys[len(parts)].String), previous.sqlType))
+		}
+
+		op := ">"
+		if key.desc != cursor.Backward {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s%s%s::%s", key.column, op, args.add(cursor.Keys[i].String), key.sqlType))
+		alternatives[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// Returns songs in the list order, cursor pages are returned in the same order as offset ones.
+// Returns [ErrInvalidCursor] if filter's cursor doesn't fit the list
+func (q *Queries) GetFilteredList(ctx context.Context, filter *ListFilter) (_ []models.FullSongInfo, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	query, args, err := buildListQuery(filter, true)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := scanListRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Cursor != nil && filter.Cursor.Backward {
+		slices.Reverse(result)
+	}
+	return result, nil
+}
+
+// Reads rows of a query built by [buildListQuery] and closes them
+func scanListRows(rows *sql.Rows) ([]models.FullSongInfo, error) {
+	defer rows.Close()
+
+	var result []models.FullSongInfo
+
+	for rows.Next() {
+		var (
+			row     models.FullSongInfo
+			rank    sql.NullFloat64
+			snippet sql.NullString
+			verse   sql.NullInt64
+		)
+
+		err := rows.Scan(
+			&row.Id,
+			&row.GroupId,
+			&row.GroupName,
+			&row.SongName,
+			&row.ReleaseDate,
+			&row.SongLyrics,
+			&row.Link,
+			&row.EnrichmentStatus,
+			&row.Version,
+			&row.DeletedAt,
+			&rank,
+			&snippet,
+			&verse,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rank.Valid {
+			row.Match = &models.SearchMatch{Rank: rank.Float64, Snippet: snippet.String, Verse: int(verse.Int64)}
+		}
+
+		result = append(result, row)
+	}
+
+	err := rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, rows.Err()
+}
+
+// Returns number of songs matching the filter, its cursor, limit and offset are ignored.
+// With estimate the number of the whole library or trash is taken from table statistics in pg_class
+// (trashed songs are counted by the partial index music_library_deleted_at_idx),
+// filtered lists and tables that were never analyzed are counted exactly.
+// Reports whether the number is an estimate
+func (q *Queries) CountFilteredList(ctx context.Context, filter *ListFilter, estimate bool) (_ int64, estimated bool, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	if estimate && filter.unfiltered() {
+		var songs, trashed float64
+		err := q.stmt(ctx, "EstimateSongCount").QueryRowContext(ctx).Scan(&songs, &trashed)
+		if err != nil {
+			return 0, false, err
+		}
+		// reltuples is negative until the table is vacuumed or analyzed
+		if songs >= 0 && trashed >= 0 {
+			if filter.Trashed {
+				return int64(trashed), true, nil
+			}
+			return max(int64(songs-trashed), 0), true, nil
+		}
+	}
+
+	var args queryArgs
+	where, _, _ := listConditions(filter, &args)
+	query := "SELECT count(*) FROM music_library WHERE " + strings.Join(where, " AND ")
+
+	var count int64
+	err = q.queryRow(ctx, query, args...).Scan(&count)
+	if err != nil {
+		return 0, false, err
+	}
+	return count, false, nil
+}
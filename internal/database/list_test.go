@@ -0,0 +1,51 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// Columns selected by the list query
+func selectedColumns(t *testing.T, filter ListFilter) string {
+	t.Helper()
+	query, _, err := buildListQuery(&filter, true)
+	if err != nil {
+		t.Fatalf("failed to build list query: %v", err)
+	}
+	selected, _, _ := strings.Cut(query, "FROM music_library")
+	return selected
+}
+
+func TestListQueryReadsLyricsOnlyWhenListed(t *testing.T) {
+	search := sql.NullString{String: "bloom", Valid: true}
+
+	tests := []struct {
+		name   string
+		filter ListFilter
+		want   bool
+	}{
+		{"every field", ListFilter{}, true},
+		{"text listed", ListFilter{Fields: []string{FieldId, FieldText}}, true},
+		{"text not listed", ListFilter{Fields: []string{FieldId, FieldGroup, FieldSong}}, false},
+		// lyrics are still filtered, just not read
+		{"text filtered", ListFilter{Fields: []string{FieldId}, Lyrics: []TextFilter{{Values: []string{"love"}}}}, false},
+		{"search without match", ListFilter{Fields: []string{FieldId}, Search: search}, false},
+		{"search with match", ListFilter{Fields: []string{FieldId, FieldMatch}, Search: search}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := selectedColumns(t, tt.filter)
+			if got := strings.Contains(selected, "song_lyrics"); got != tt.want {
+				t.Errorf("got lyrics read %v, want %v, columns: %s", got, tt.want, selected)
+			}
+		})
+	}
+}
+
+func TestListQueryReadsSortKeys(t *testing.T) {
+	selected := selectedColumns(t, ListFilter{Fields: []string{FieldId}, Sort: []SortKey{{Field: SortSong}}})
+	if !strings.Contains(selected, "song_name") || strings.Contains(selected, "group_name") {
+		t.Errorf("got columns %s, want song name read for cursors", selected)
+	}
+}
@@ -0,0 +1,717 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MemoryStore is an in-memory [SongStore] that mirrors the behaviour of [Queries],
+// useful for running handlers without a database
+type MemoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+	inTx bool // Set for stores passed into [MemoryStore.WithTx] callbacks, they already hold mu
+
+	deletePolicy string // What DeleteSong does to playlists of the song, see [DeletePolicyCascade]
+}
+
+type memoryData struct {
+	songs          map[int64]*models.FullSongInfo
+	lastId         int64
+	jobs           map[int64]*memoryJob
+	lastJobId      int64
+	history        []models.SongRevision
+	lastRevisionId int64
+	groups         map[int64]*models.Group
+	lastGroupId    int64
+	albums         map[int64]*memoryAlbum
+	lastAlbumId    int64
+	playlists      map[int64]*memoryPlaylist
+	lastPlaylistId int64
+	lastItemId     int64
+	tags           map[int64]*models.Tag
+	lastTagId      int64
+	songTags       map[int64][]int64 // Tag ids of songs
+
+	idempotencyKeys map[string]*models.IdempotentResponse
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu: &sync.Mutex{},
+		data: &memoryData{
+			songs:     make(map[int64]*models.FullSongInfo),
+			jobs:      make(map[int64]*memoryJob),
+			groups:    make(map[int64]*models.Group),
+			albums:    make(map[int64]*memoryAlbum),
+			playlists: make(map[int64]*memoryPlaylist),
+			tags:      make(map[int64]*models.Tag),
+			songTags:  make(map[int64][]int64),
+
+			idempotencyKeys: make(map[string]*models.IdempotentResponse),
+		},
+		deletePolicy: DeletePolicyCascade,
+	}
+}
+
+// Sets what DeleteSong does to playlists of the song, [DeletePolicyCascade] by default
+func (m *MemoryStore) SetDeletePolicy(policy string) error {
+	err := validateDeletePolicy(policy)
+	if err != nil {
+		return err
+	}
+	m.deletePolicy = policy
+	return nil
+}
+
+// Locks the store unless it's bound to a transaction, returns the unlock func
+func (m *MemoryStore) lock() func() {
+	if m.inTx {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+// Runs fn while holding the store lock, changes made by fn are reverted if it returns an error.
+// Nested calls act as savepoints, reverting only the changes of their own fn.
+// Every call copies the whole data of the store to revert to, so it costs as much as the store is large.
+// Like postgres sequences, song ids handed out inside a reverted transaction are not reused
+func (m *MemoryStore) WithTx(ctx context.Context, fn func(tx SongStore) error) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	if m.inTx {
+		return m.revertOnError(fn)
+	}
+
+	defer m.lock()()
+
+	tx := &MemoryStore{mu: m.mu, data: m.data, inTx: true, deletePolicy: m.deletePolicy}
+	return tx.revertOnError(fn)
+}
+
+func (m *MemoryStore) revertOnError(fn func(tx SongStore) error) error {
+	snapshot := m.data.clone()
+	err := fn(m)
+	if err != nil {
+		snapshot.lastId = m.data.lastId
+		snapshot.lastJobId = m.data.lastJobId
+		snapshot.lastRevisionId = m.data.lastRevisionId
+		snapshot.lastGroupId = m.data.lastGroupId
+		snapshot.lastAlbumId = m.data.lastAlbumId
+		snapshot.lastPlaylistId = m.data.lastPlaylistId
+		snapshot.lastItemId = m.data.lastItemId
+		snapshot.lastTagId = m.data.lastTagId
+		*m.data = *snapshot
+	}
+	return err
+}
+
+func (d *memoryData) clone() *memoryData {
+	songs := make(map[int64]*models.FullSongInfo, len(d.songs))
+	for id, song := range d.songs {
+		songCopy := *song
+		songs[id] = &songCopy
+	}
+	jobs := make(map[int64]*memoryJob, len(d.jobs))
+	for id, job := range d.jobs {
+		jobCopy := *job
+		jobs[id] = &jobCopy
+	}
+	groups := make(map[int64]*models.Group, len(d.groups))
+	for id, group := range d.groups {
+		groupCopy := *group
+		groups[id] = &groupCopy
+	}
+	albums := make(map[int64]*memoryAlbum, len(d.albums))
+	for id, album := range d.albums {
+		albumCopy := *album
+		albumCopy.tracks = slices.Clone(album.tracks)
+		albums[id] = &albumCopy
+	}
+	playlists := make(map[int64]*memoryPlaylist, len(d.playlists))
+	for id, playlist := range d.playlists {
+		playlistCopy := *playlist
+		playlistCopy.items = slices.Clone(playlist.items)
+		playlists[id] = &playlistCopy
+	}
+	tags := make(map[int64]*models.Tag, len(d.tags))
+	for id, tag := range d.tags {
+		tagCopy := *tag
+		tags[id] = &tagCopy
+	}
+	songTags := make(map[int64][]int64, len(d.songTags))
+	for id, tagIds := range d.songTags {
+		songTags[id] = slices.Clone(tagIds)
+	}
+	// saved responses aren't modified, only replaced
+	idempotencyKeys := maps.Clone(d.idempotencyKeys)
+	return &memoryData{
+		songs:          songs,
+		lastId:         d.lastId,
+		jobs:           jobs,
+		lastJobId:      d.lastJobId,
+		history:        append([]models.SongRevision(nil), d.history...),
+		lastRevisionId: d.lastRevisionId,
+		groups:         groups,
+		lastGroupId:    d.lastGroupId,
+		albums:         albums,
+		lastAlbumId:    d.lastAlbumId,
+		playlists:      playlists,
+		lastPlaylistId: d.lastPlaylistId,
+		lastItemId:     d.lastItemId,
+		tags:           tags,
+		lastTagId:      d.lastTagId,
+		songTags:       songTags,
+
+		idempotencyKeys: idempotencyKeys,
+	}
+}
+
+// Writes assigned id into song.Id and queues enrichment job for the song.
+// Returns [ErrSongAlreadyExists] if song already in the store, [ErrSongTrashed] if it's in trash
+func (m *MemoryStore) AddSong(ctx context.Context, song *models.BasicSongInfo) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if existing := m.findByNames(song.GroupName, song.SongName); existing != nil {
+		if existing.DeletedAt.Valid {
+			return ErrSongTrashed
+		}
+		return ErrSongAlreadyExists
+	}
+
+	m.data.lastId++
+	song.Id = m.data.lastId
+	m.data.songs[song.Id] = &models.FullSongInfo{
+		Id:               song.Id,
+		GroupId:          m.joinGroup(song.GroupName),
+		GroupName:        song.GroupName,
+		SongName:         song.SongName,
+		EnrichmentStatus: models.EnrichmentPending,
+		UpdatedAt:        time.Now(),
+		Version:          1,
+	}
+	m.enqueueJob(song.Id)
+	m.recordChange(ctx, nil, m.data.songs[song.Id])
+	return nil
+}
+
+// Returns [ErrSongNotFound] if there's no song with given names in the store,
+// [ErrSongTrashed] along with its id if the song is in trash
+func (m *MemoryStore) FindSong(ctx context.Context, groupName, songName string) (int64, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	defer m.lock()()
+
+	song := m.findByNames(groupName, songName)
+	if song == nil {
+		return 0, ErrSongNotFound
+	}
+	if song.DeletedAt.Valid {
+		return song.Id, ErrSongTrashed
+	}
+	return song.Id, nil
+}
+
+// Adds song with its details or replaces details of the existing one, dropping its pending enrichment job.
+// Writes assigned id into song.Id and reports whether the song was created.
+// Returns [ErrSongTrashed] if the song is in trash
+func (m *MemoryStore) UpsertSong(ctx context.Context, song *models.BasicSongInfo, info *models.AdditionalSongInfo) (bool, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return false, err
+	}
+
+	defer m.lock()()
+
+	existing := m.findByNames(song.GroupName, song.SongName)
+	if existing == nil {
+		song.Id = m.importSong(ctx, &models.ImportSong{BasicSongInfo: *song, Details: info})
+		return true, nil
+	}
+	if existing.DeletedAt.Valid {
+		return false, ErrSongTrashed
+	}
+	before := *existing
+
+	setSongDetails(existing, info)
+	m.dequeueJobs(existing.Id)
+	touchSong(existing)
+	m.recordChange(ctx, &before, existing)
+	song.Id = existing.Id
+	return false, nil
+}
+
+// Marks song as enriched, dropping its pending enrichment job.
+// If ifVersion isn't 0, song is only updated if its version still matches.
+// Returns [ErrSongNotFound] if there's no matching song in the store,
+// [ErrVersionMismatch] if song's version differs from ifVersion
+func (m *MemoryStore) UpdateSongInfo(ctx context.Context, songId int64, info *models.AdditionalSongInfo, ifVersion int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	song, err := m.songForWrite(songId, ifVersion)
+	if err != nil {
+		return err
+	}
+	before := *song
+
+	setSongDetails(song, info)
+	m.dequeueJobs(songId)
+	touchSong(song)
+	m.recordChange(ctx, &before, song)
+	return nil
+}
+
+// Changes only the fields set in patch, if patch contains details
+// song is marked as enriched and its pending enrichment job is dropped.
+// If ifVersion isn't 0, song is only updated if its version still matches.
+// Returns [ErrSongNotFound] if there's no matching song in the store,
+// [ErrSongAlreadyExists] if new group/song names are taken by another song,
+// [ErrVersionMismatch] if song's version differs from ifVersion
+func (m *MemoryStore) PatchSong(ctx context.Context, songId int64, patch *models.SongPatch, ifVersion int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	song, err := m.songForWrite(songId, ifVersion)
+	if err != nil {
+		return err
+	}
+
+	groupName, songName := song.GroupName, song.SongName
+	if patch.GroupName != nil {
+		groupName = *patch.GroupName
+	}
+	if patch.SongName != nil {
+		songName = *patch.SongName
+	}
+	if other := m.findByNames(groupName, songName); other != nil && other.Id != songId {
+		return ErrSongAlreadyExists
+	}
+
+	before := *song
+
+	if groupName != song.GroupName {
+		song.GroupId = m.joinGroup(groupName)
+	}
+	song.GroupName, song.SongName = groupName, songName
+	if patch.ReleaseDate != nil {
+		song.ReleaseDate = *patch.ReleaseDate
+		song.ReleaseDate.Time = truncateToDate(song.ReleaseDate.Time)
+	}
+	if patch.SongLyrics != nil {
+		song.SongLyrics = *patch.SongLyrics
+	}
+	if patch.Link != nil {
+		song.Link = *patch.Link
+	}
+	if patch.HasDetails() {
+		song.EnrichmentStatus = models.EnrichmentEnriched
+		m.dequeueJobs(songId)
+	}
+	touchSong(song)
+	m.recordChange(ctx, &before, song)
+	return nil
+}
+
+// Moves song to trash, see [Trash], and applies the delete policy to its playlists.
+// If ifVersion isn't 0, song is only deleted if its version still matches.
+// Returns [ErrSongNotFound] if there's no song in the store,
+// [ErrVersionMismatch] if song's version differs from ifVersion,
+// [ErrSongInPlaylist] if the song is in a playlist and the policy is [DeletePolicyRestrict]
+func (m *MemoryStore) DeleteSong(ctx context.Context, songId int64, ifVersion int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	song, err := m.songForWrite(songId, ifVersion)
+	if err != nil {
+		return err
+	}
+
+	err = m.detachFromPlaylists(songId)
+	if err != nil {
+		return err
+	}
+
+	before := *song
+
+	song.DeletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	touchSong(song)
+	m.recordChange(ctx, &before, song)
+	return nil
+}
+
+// Returns [ErrSongNotFound] if there's no song in the store
+func (m *MemoryStore) GetSong(ctx context.Context, songId int64) (*models.FullSongInfo, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	song, exists := m.activeSong(songId)
+	if !exists {
+		return nil, ErrSongNotFound
+	}
+
+	songCopy := *song
+	return &songCopy, nil
+}
+
+// Writes lyrics into [info.SongLyrics].
+// Returns [ErrSongNotFound] if there's no song in the store.
+// Returns [ErrSongHasNoLyrics] if no lyrics were provided.
+func (m *MemoryStore) GetLyrics(ctx context.Context, songId int64, lyrics *string) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	song, exists := m.activeSong(songId)
+	if !exists {
+		return ErrSongNotFound
+	}
+	if !song.SongLyrics.Valid {
+		return ErrSongHasNoLyrics
+	}
+
+	*lyrics = song.SongLyrics.String
+	return nil
+}
+
+func (m *MemoryStore) GetFilteredList(ctx context.Context, filter *ListFilter) ([]models.FullSongInfo, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	var search textSearch
+	if filter.Search.Valid {
+		search = parseTextSearch(filter.Search.String)
+	}
+
+	var matched []models.FullSongInfo
+	for _, song := range m.data.songs {
+		if !m.matchesFilter(song, filter) {
+			continue
+		}
+		row := *song
+		if filter.Search.Valid {
+			row.Match = search.match(song.SongLyrics.String)
+			if row.Match == nil {
+				continue
+			}
+		}
+		matched = append(matched, row)
+	}
+
+	order, err := listOrder(filter, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return compareToKeys(order, &matched[i], rowKeys(order, &matched[j])) < 0
+	})
+
+	if filter.Cursor == nil {
+		return paginate(matched, filter.Limit, filter.Offset), nil
+	}
+
+	if err := validateCursor(filter.Cursor, order); err != nil {
+		return nil, err
+	}
+	var page []models.FullSongInfo
+	for i := range matched {
+		diff := compareToKeys(order, &matched[i], filter.Cursor.Keys)
+		if (diff > 0 && !filter.Cursor.Backward) || (diff < 0 && filter.Cursor.Backward) {
+			page = append(page, matched[i])
+		}
+	}
+	if !filter.Cursor.Backward {
+		return paginate(page, filter.Limit, filter.Offset), nil
+	}
+	slices.Reverse(page)
+	page = paginate(page, filter.Limit, filter.Offset)
+	slices.Reverse(page)
+	return page, nil
+}
+
+// Counts exactly since there are no statistics to estimate from
+func (m *MemoryStore) CountFilteredList(ctx context.Context, filter *ListFilter, estimate bool) (int64, bool, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return 0, false, err
+	}
+
+	defer m.lock()()
+
+	var search textSearch
+	if filter.Search.Valid {
+		search = parseTextSearch(filter.Search.String)
+	}
+
+	var count int64
+	for _, song := range m.data.songs {
+		if m.matchesFilter(song, filter) && (!filter.Search.Valid || search.match(song.SongLyrics.String) != nil) {
+			count++
+		}
+	}
+	return count, false, nil
+}
+
+func rowKeys(order []orderKey, row *models.FullSongInfo) []sql.NullString {
+	keys := make([]sql.NullString, len(order))
+	for i, key := range order {
+		keys[i] = key.value(row)
+	}
+	return keys
+}
+
+// Compares row with sort keys of another row or cursor in the list order,
+// keys are compared as values of their sql types
+func compareToKeys(order []orderKey, row *models.FullSongInfo, keys []sql.NullString) int {
+	for i, key := range order {
+		diff := compareKeyValues(key.sqlType, key.value(row), keys[i])
+		if key.desc {
+			diff = -diff
+		}
+		if diff != 0 {
+			return diff
+		}
+	}
+	return 0
+}
+
+// Nulls go last like in ascending postgres order, dates are compared as formatted by [time.DateOnly].
+// Text is compared ignoring case and accents first, the way the database collation orders names
+func compareKeyValues(sqlType string, a, b sql.NullString) int {
+	switch {
+	case !a.Valid || !b.Valid:
+		return cmp.Compare(boolRank(!a.Valid), boolRank(!b.Valid))
+	case sqlType == "bigint":
+		x, _ := strconv.ParseInt(a.String, 10, 64)
+		y, _ := strconv.ParseInt(b.String, 10, 64)
+		return cmp.Compare(x, y)
+	case sqlType == "real":
+		x, _ := strconv.ParseFloat(a.String, 32)
+		y, _ := strconv.ParseFloat(b.String, 32)
+		return cmp.Compare(float32(x), float32(y))
+	case sqlType == "date" && (a.String == "infinity" || b.String == "infinity"):
+		return cmp.Compare(boolRank(a.String == "infinity"), boolRank(b.String == "infinity"))
+	case sqlType == "text":
+		if diff := strings.Compare(foldText(a.String), foldText(b.String)); diff != 0 {
+			return diff
+		}
+		return strings.Compare(a.String, b.String)
+	default:
+		return strings.Compare(a.String, b.String)
+	}
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Returns song that's about to be changed, checking its version like postgres queries do
+func (m *MemoryStore) songForWrite(songId int64, ifVersion int64) (*models.FullSongInfo, error) {
+	song, exists := m.activeSong(songId)
+	if !exists {
+		return nil, ErrSongNotFound
+	}
+	if ifVersion != 0 && song.Version != ifVersion {
+		return nil, ErrVersionMismatch
+	}
+	return song, nil
+}
+
+// Returns song unless it's missing or trashed
+func (m *MemoryStore) activeSong(songId int64) (*models.FullSongInfo, bool) {
+	song, exists := m.data.songs[songId]
+	if !exists || song.DeletedAt.Valid {
+		return nil, false
+	}
+	return song, true
+}
+
+// Fills in details of the song and marks it as enriched
+func setSongDetails(song *models.FullSongInfo, info *models.AdditionalSongInfo) {
+	song.ReleaseDate = sql.NullTime{Time: truncateToDate(info.ReleaseDate), Valid: true}
+	song.SongLyrics = sql.NullString{String: info.SongLyrics, Valid: true}
+	song.Link = sql.NullString{String: info.Link, Valid: true}
+	song.EnrichmentStatus = models.EnrichmentEnriched
+}
+
+// Mirrors touch_updated_at and bump_version triggers
+func touchSong(song *models.FullSongInfo) {
+	song.UpdatedAt = time.Now()
+	song.Version++
+}
+
+func (m *MemoryStore) findByNames(groupName, songName string) *models.FullSongInfo {
+	for _, song := range m.data.songs {
+		if song.GroupName == groupName && song.SongName == songName {
+			return song
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) matchesFilter(song *models.FullSongInfo, filter *ListFilter) bool {
+	if song.DeletedAt.Valid != filter.Trashed {
+		return false
+	}
+	if len(filter.GroupIds) > 0 && !slices.Contains(filter.GroupIds, song.GroupId) {
+		return false
+	}
+	if len(filter.AlbumIds) > 0 && !m.onAnyAlbum(song.Id, filter.AlbumIds) {
+		return false
+	}
+	if len(filter.Tags) > 0 && !m.hasTags(song.Id, filter.Tags, filter.AllTags) {
+		return false
+	}
+	if !matchesText(song.GroupName, filter.GroupName) || !matchesText(song.SongName, filter.SongName) {
+		return false
+	}
+	if filter.ReleaseDateLowerBound.Valid {
+		lower := truncateToDate(filter.ReleaseDateLowerBound.Time)
+		if !song.ReleaseDate.Valid || song.ReleaseDate.Time.Before(lower) {
+			return false
+		}
+	}
+	if filter.ReleaseDateUpperBound.Valid {
+		upper := truncateToDate(filter.ReleaseDateUpperBound.Time)
+		if !song.ReleaseDate.Valid || song.ReleaseDate.Time.After(upper) {
+			return false
+		}
+	}
+	if !matchesText(song.SongLyrics.String, filter.Lyrics) {
+		return false
+	}
+	if filter.HasLyrics.Valid && (song.SongLyrics.String != "") != filter.HasLyrics.Bool {
+		return false
+	}
+	if filter.HasLink.Valid && (song.Link.String != "") != filter.HasLink.Bool {
+		return false
+	}
+	return true
+}
+
+// Mirrors conditions built by textConditions, missing values are matched as empty strings
+func matchesText(value string, filters []TextFilter) bool {
+	value = foldText(value)
+	for _, filter := range filters {
+		if len(filter.Values) == 0 {
+			continue
+		}
+
+		matched := false
+		for _, pattern := range filter.Values {
+			pattern = foldText(pattern)
+			switch filter.Mode {
+			case MatchExact:
+				matched = value == pattern
+			case MatchPrefix:
+				matched = iLike(value, pattern+"%")
+			default:
+				matched = iLike(value, "%"+pattern+"%")
+			}
+			if matched {
+				break
+			}
+		}
+		if matched == filter.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Lowercases text and strips accents like lower(f_unaccent(text)) does
+func foldText(text string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		folded = text
+	}
+	return strings.ToLower(folded)
+}
+
+func paginate[T any](rows []T, limit, offset int32) []T {
+	if offset < 0 {
+		offset = 0
+	}
+	if int(offset) >= len(rows) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit >= 0 && int(limit) < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// Reports whether value matches ILIKE pattern,
+// pattern wildcards (% and _) and backslash escapes are honoured like in postgres
+func iLike(value, pattern string) bool {
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expr.WriteString(".*")
+		case r == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), value)
+	return err == nil && matched
+}
+
+// Drops time of day the same way a postgres DATE column does
+func truncateToDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+type memoryAlbum struct {
+	models.Album
+	tracks []int64 // Song ids by position
+}
+
+func (m *MemoryStore) onAnyAlbum(songId int64, albumIds []int64) bool {
+	for _, id := range albumIds {
+		if album, exists := m.data.albums[id]; exists && slices.Contains(album.tracks, songId) {
+			return true
+		}
+	}
+	return false
+}
+
+// Song ids of the album's tracks that are in trash
+func (m *MemoryStore) trashedTracks(album *memoryAlbum) []int64 {
+	var trashed []int64
+	for _, songId := range album.tracks {
+		if m.data.songs[songId].DeletedAt.Valid {
+			trashed = append(trashed, songId)
+		}
+	}
+	return trashed
+}
+
+// Writes assigned id into album.Id.
+// Returns [ErrGroupNotFound] if there's no such group, [ErrAlbumAlreadyExists] if the title is taken
+func (m *MemoryStore) AddAlbum(ctx context.Context, album *models.Album) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if _, exists := m.data.groups[album.GroupId]; !exists {
+		return ErrGroupNotFound
+	}
+	for _, other := range m.data.albums {
+		if other.GroupId == album.GroupId && other.Title == album.Title {
+			return ErrAlbumAlreadyExists
+		}
+	}
+
+	m.data.lastAlbumId++
+	album.Id = m.data.lastAlbumId
+	stored := &memoryAlbum{Album: *album}
+	stored.ReleaseDate.Time = truncateToDate(stored.ReleaseDate.Time)
+	stored.CreatedAt = time.Now()
+	m.data.albums[album.Id] = stored
+	return nil
+}
+
+// Returns [ErrAlbumNotFound] if there's no such album
+func (m *MemoryStore) GetAlbum(ctx context.Context, albumId int64) (*models.Album, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	album, exists := m.data.albums[albumId]
+	if !exists {
+		return nil, ErrAlbumNotFound
+	}
+	info := album.Album
+	info.GroupName = m.data.groups[album.GroupId].Name
+	return &info, nil
+}
+
+// Deletes album with its tracks, songs stay in the store.
+// Returns [ErrAlbumNotFound] if there's no such album
+func (m *MemoryStore) DeleteAlbum(ctx context.Context, albumId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if _, exists := m.data.albums[albumId]; !exists {
+		return ErrAlbumNotFound
+	}
+	delete(m.data.albums, albumId)
+	return nil
+}
+
+// Returns tracks ordered by position, [ErrAlbumNotFound] if there's no such album
+func (m *MemoryStore) GetAlbumTracks(ctx context.Context, albumId int64) ([]models.AlbumTrack, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	album, exists := m.data.albums[albumId]
+	if !exists {
+		return nil, ErrAlbumNotFound
+	}
+
+	var tracks []models.AlbumTrack
+	for i, songId := range album.tracks {
+		if song, active := m.activeSong(songId); active {
+			tracks = append(tracks, models.AlbumTrack{Position: i + 1, Song: *song})
+		}
+	}
+	return tracks, nil
+}
+
+// Inserts song at the position shifting the following tracks, 0 or positions past the end append it.
+// Returns [ErrAlbumNotFound], [ErrSongNotFound] if the album or the song is missing,
+// [ErrTrackAlreadyExists] if the song is already on the album
+func (m *MemoryStore) AddAlbumTrack(ctx context.Context, albumId int64, songId int64, position int) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	album, exists := m.data.albums[albumId]
+	if !exists {
+		return ErrAlbumNotFound
+	}
+	if slices.Contains(album.tracks, songId) {
+		return ErrTrackAlreadyExists
+	}
+	if _, active := m.activeSong(songId); !active {
+		return ErrSongNotFound
+	}
+
+	album.tracks = insertTrack(album.tracks, songId, position)
+	return nil
+}
+
+// Puts tracks in the order of songIds, which should list every listed track once, tracks of trashed songs go last.
+// Returns [ErrAlbumNotFound] if there's no such album, [ErrInvalidTrackOrder] if songIds don't match the tracks
+func (m *MemoryStore) ReorderAlbumTracks(ctx context.Context, albumId int64, songIds []int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	album, exists := m.data.albums[albumId]
+	if !exists {
+		return ErrAlbumNotFound
+	}
+
+	order, err := reorderTracks(album.tracks, m.trashedTracks(album), songIds)
+	if err != nil {
+		return err
+	}
+	album.tracks = order
+	return nil
+}
+
+// Removes the track, following tracks move up.
+// Returns [ErrAlbumNotFound] if there's no such album, [ErrTrackNotFound] if the song isn't on the album
+func (m *MemoryStore) RemoveAlbumTrack(ctx context.Context, albumId int64, songId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	album, exists := m.data.albums[albumId]
+	if !exists {
+		return ErrAlbumNotFound
+	}
+	if !slices.Contains(album.tracks, songId) {
+		return ErrTrackNotFound
+	}
+
+	album.tracks = slices.DeleteFunc(album.tracks, func(id int64) bool { return id == songId })
+	return nil
+}
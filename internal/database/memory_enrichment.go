@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+type memoryJob struct {
+	id          int64
+	songId      int64
+	attempts    int
+	runAfter    time.Time
+	lockedUntil time.Time
+	lastError   string
+}
+
+func (m *MemoryStore) enqueueJob(songId int64) {
+	m.data.lastJobId++
+	m.data.jobs[m.data.lastJobId] = &memoryJob{id: m.data.lastJobId, songId: songId, runAfter: time.Now()}
+}
+
+func (m *MemoryStore) dequeueJobs(songId int64) {
+	for id, job := range m.data.jobs {
+		if job.songId == songId {
+			delete(m.data.jobs, id)
+		}
+	}
+}
+
+// Locks up to limit due jobs for lease duration, increasing their attempt counters.
+// Jobs whose lease ran out can be claimed again
+func (m *MemoryStore) ClaimEnrichmentJobs(ctx context.Context, limit int, lease time.Duration) ([]models.EnrichmentJob, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	now := time.Now()
+	var due []*memoryJob
+	for _, job := range m.data.jobs {
+		if m.data.songs[job.songId].DeletedAt.Valid {
+			continue
+		}
+		if !job.runAfter.After(now) && job.lockedUntil.Before(now) {
+			due = append(due, job)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].runAfter.Before(due[j].runAfter) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var jobs []models.EnrichmentJob
+	for _, job := range due {
+		song := m.data.songs[job.songId]
+		job.attempts++
+		job.lockedUntil = now.Add(lease)
+		jobs = append(jobs, models.EnrichmentJob{
+			Id:        job.id,
+			SongId:    job.songId,
+			GroupName: song.GroupName,
+			SongName:  song.SongName,
+			Attempts:  job.attempts,
+		})
+	}
+	return jobs, nil
+}
+
+// Releases the job so it can be claimed again after runAfter
+func (m *MemoryStore) RetryEnrichmentJob(ctx context.Context, jobId int64, runAfter time.Time, reason string) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if job, exists := m.data.jobs[jobId]; exists {
+		job.runAfter = runAfter
+		job.lockedUntil = time.Time{}
+		job.lastError = reason
+	}
+	return nil
+}
+
+// Drops the job and marks its song as failed to enrich
+func (m *MemoryStore) FailEnrichmentJob(ctx context.Context, jobId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	job, exists := m.data.jobs[jobId]
+	if !exists {
+		return nil
+	}
+
+	delete(m.data.jobs, jobId)
+	if song, exists := m.data.songs[job.songId]; exists {
+		song.EnrichmentStatus = models.EnrichmentFailed
+		touchSong(song)
+	}
+	return nil
+}
+
+// Reports whether job is still queued and its song still has the names job was claimed with
+func (m *MemoryStore) IsEnrichmentJobCurrent(ctx context.Context, job models.EnrichmentJob) (bool, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return false, err
+	}
+
+	defer m.lock()()
+
+	queued, exists := m.data.jobs[job.Id]
+	if !exists {
+		return false, nil
+	}
+	song := m.data.songs[queued.songId]
+	return song.GroupName == job.GroupName && song.SongName == job.SongName, nil
+}
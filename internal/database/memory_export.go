@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Calls fn for every song of the list in the list order, cursor, limit and offset of the filter are ignored.
+// fn is called on a snapshot of the list taken when the export starts, so it may use the store.
+// Errors returned by fn stop the export and are returned as is
+func (m *MemoryStore) ExportSongs(ctx context.Context, filter *ListFilter, fn func(song *models.FullSongInfo) error) error {
+	unpaged := *filter
+	unpaged.Cursor, unpaged.Limit, unpaged.Offset = nil, -1, 0
+
+	songs, err := m.GetFilteredList(ctx, &unpaged)
+	if err != nil {
+		return err
+	}
+
+	for i := range songs {
+		err := fn(&songs[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Returns id of the group with the name, creating it if needed, like sync_song_group() trigger does
+func (m *MemoryStore) joinGroup(name string) int64 {
+	if group := m.findGroup(name); group != nil {
+		return group.Id
+	}
+	m.data.lastGroupId++
+	m.data.groups[m.data.lastGroupId] = &models.Group{Id: m.data.lastGroupId, Name: name, CreatedAt: time.Now()}
+	return m.data.lastGroupId
+}
+
+func (m *MemoryStore) findGroup(name string) *models.Group {
+	for _, group := range m.data.groups {
+		if group.Name == name {
+			return group
+		}
+	}
+	return nil
+}
+
+// Returns copy of the group with its song count
+func (m *MemoryStore) groupInfo(group *models.Group) models.Group {
+	info := *group
+	for _, song := range m.data.songs {
+		if song.GroupId == group.Id && !song.DeletedAt.Valid {
+			info.SongCount++
+		}
+	}
+	return info
+}
+
+// Returns [ErrGroupAlreadyExists] if the name is taken
+func (m *MemoryStore) AddGroup(ctx context.Context, name string) (int64, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	defer m.lock()()
+
+	if m.findGroup(name) != nil {
+		return 0, ErrGroupAlreadyExists
+	}
+	return m.joinGroup(name), nil
+}
+
+// Returns [ErrGroupNotFound] if there's no such group
+func (m *MemoryStore) GetGroup(ctx context.Context, groupId int64) (*models.Group, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	group, exists := m.data.groups[groupId]
+	if !exists {
+		return nil, ErrGroupNotFound
+	}
+	info := m.groupInfo(group)
+	return &info, nil
+}
+
+// Returns groups ordered by name
+func (m *MemoryStore) GetGroups(ctx context.Context, filter *GroupFilter) ([]models.Group, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	var groups []models.Group
+	for _, group := range m.data.groups {
+		if matchesText(group.Name, filter.Name) {
+			groups = append(groups, m.groupInfo(group))
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].Id < groups[j].Id
+	})
+
+	return paginate(groups, filter.Limit, filter.Offset), nil
+}
+
+// Renames the group in every of its songs as well.
+// Returns [ErrGroupNotFound] if there's no such group, [ErrGroupAlreadyExists] if the name is taken
+func (m *MemoryStore) RenameGroup(ctx context.Context, groupId int64, name string) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	group, exists := m.data.groups[groupId]
+	if !exists {
+		return ErrGroupNotFound
+	}
+	if other := m.findGroup(name); other != nil && other.Id != groupId {
+		return ErrGroupAlreadyExists
+	}
+
+	group.Name = name
+	for _, id := range m.sortedSongIds() {
+		song := m.data.songs[id]
+		if song.GroupId != groupId || song.GroupName == name {
+			continue
+		}
+		before := *song
+		song.GroupName = name
+		touchSong(song)
+		m.recordChange(ctx, &before, song)
+	}
+	return nil
+}
+
+// Returns [ErrGroupNotFound] if there's no such group, [ErrGroupNotEmpty] if any album or song belongs to it
+func (m *MemoryStore) DeleteGroup(ctx context.Context, groupId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if _, exists := m.data.groups[groupId]; !exists {
+		return ErrGroupNotFound
+	}
+	for _, song := range m.data.songs {
+		if song.GroupId == groupId {
+			return ErrGroupNotEmpty
+		}
+	}
+	for _, album := range m.data.albums {
+		if album.GroupId == groupId {
+			return ErrGroupNotEmpty
+		}
+	}
+	delete(m.data.groups, groupId)
+	return nil
+}
+
+// Moves albums and songs of sources (trashed ones too) into the target group and deletes sources.
+// Returns [ErrGroupNotFound] if any of the groups is missing,
+// [ErrSongAlreadyExists] or [ErrAlbumAlreadyExists] if the target group already has a song
+// or an album named like one being moved
+func (m *MemoryStore) MergeGroups(ctx context.Context, targetId int64, sourceIds []int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	target, exists := m.data.groups[targetId]
+	if !exists {
+		return ErrGroupNotFound
+	}
+	sources := slices.DeleteFunc(slices.Clone(sourceIds), func(id int64) bool { return id == targetId })
+	for _, id := range sources {
+		if _, exists := m.data.groups[id]; !exists {
+			return ErrGroupNotFound
+		}
+	}
+
+	// names must stay unique within the target group, check every move before making any
+	titles := make(map[string]bool)
+	var movedAlbums []*memoryAlbum
+	for _, album := range m.data.albums {
+		switch {
+		case album.GroupId == targetId:
+		case slices.Contains(sources, album.GroupId):
+			movedAlbums = append(movedAlbums, album)
+		default:
+			continue
+		}
+		if titles[album.Title] {
+			return ErrAlbumAlreadyExists
+		}
+		titles[album.Title] = true
+	}
+
+	names := make(map[string]bool)
+	var moved []int64
+	for _, id := range m.sortedSongIds() {
+		song := m.data.songs[id]
+		switch {
+		case song.GroupId == targetId:
+		case slices.Contains(sources, song.GroupId):
+			moved = append(moved, id)
+		default:
+			continue
+		}
+		if names[song.SongName] {
+			return ErrSongAlreadyExists
+		}
+		names[song.SongName] = true
+	}
+
+	for _, album := range movedAlbums {
+		album.GroupId = targetId
+	}
+	for _, id := range moved {
+		song := m.data.songs[id]
+		before := *song
+		song.GroupId, song.GroupName = targetId, target.Name
+		touchSong(song)
+		m.recordChange(ctx, &before, song)
+	}
+	for _, id := range sources {
+		delete(m.data.groups, id)
+	}
+	return nil
+}
+
+// Song ids in ascending order, so that changes of several songs are recorded in a stable order
+func (m *MemoryStore) sortedSongIds() []int64 {
+	ids := make([]int64, 0, len(m.data.songs))
+	for id := range m.data.songs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Mirrors record_song_history trigger, before is nil for created songs and after is nil for purged ones.
+// Updates that don't change tracked fields are not recorded
+func (m *MemoryStore) recordChange(ctx context.Context, before, after *models.FullSongInfo) {
+	revision := models.SongRevision{Actor: ActorFrom(ctx), ChangedAt: time.Now()}
+
+	switch {
+	case before == nil:
+		revision.SongId, revision.Version = after.Id, after.Version
+		revision.Operation = models.HistoryCreate
+		revision.New = songValues(after)
+	case after == nil:
+		revision.SongId, revision.Version = before.Id, before.Version
+		revision.Operation = models.HistoryPurge
+		revision.Old = songValues(before)
+	default:
+		revision.SongId, revision.Version = after.Id, after.Version
+		revision.Old, revision.New = songValues(before), songValues(after)
+		switch {
+		case !before.DeletedAt.Valid && after.DeletedAt.Valid:
+			revision.Operation = models.HistoryDelete
+		case before.DeletedAt.Valid && !after.DeletedAt.Valid:
+			revision.Operation = models.HistoryRestore
+		case !sameSongValues(revision.Old, revision.New):
+			revision.Operation = models.HistoryUpdate
+		default:
+			return
+		}
+	}
+
+	m.data.lastRevisionId++
+	revision.Id = m.data.lastRevisionId
+	m.data.history = append(m.data.history, revision)
+}
+
+func songValues(song *models.FullSongInfo) *models.SongValues {
+	return &models.SongValues{
+		GroupName:   song.GroupName,
+		SongName:    song.SongName,
+		ReleaseDate: song.ReleaseDate,
+		SongLyrics:  song.SongLyrics,
+		Link:        song.Link,
+	}
+}
+
+func sameSongValues(a, b *models.SongValues) bool {
+	return a.GroupName == b.GroupName &&
+		a.SongName == b.SongName &&
+		a.ReleaseDate.Valid == b.ReleaseDate.Valid && a.ReleaseDate.Time.Equal(b.ReleaseDate.Time) &&
+		a.SongLyrics == b.SongLyrics &&
+		a.Link == b.Link
+}
+
+// Returns revisions of the song oldest first
+func (m *MemoryStore) GetSongHistory(ctx context.Context, songId int64, limit, offset int32) ([]models.SongRevision, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	var revisions []models.SongRevision
+	for _, revision := range m.data.history {
+		if revision.SongId == songId {
+			revisions = append(revisions, revision)
+		}
+	}
+
+	return paginate(revisions, limit, offset), nil
+}
+
+// Returns [ErrRevisionNotFound] if there's no such revision
+func (m *MemoryStore) GetRevision(ctx context.Context, revisionId int64) (*models.SongRevision, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	for _, revision := range m.data.history {
+		if revision.Id == revisionId {
+			return &revision, nil
+		}
+	}
+	return nil, ErrRevisionNotFound
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Returns [ErrIdempotencyKeyNotFound] if no response is saved for the key or it expired
+func (m *MemoryStore) GetIdempotentResponse(ctx context.Context, key string) (*models.IdempotentResponse, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	response, exists := m.data.idempotencyKeys[key]
+	if !exists || !response.ExpiresAt.After(time.Now()) || response.StatusCode == 0 {
+		return nil, ErrIdempotencyKeyNotFound
+	}
+
+	responseCopy := *response
+	responseCopy.Body = slices.Clone(response.Body)
+	return &responseCopy, nil
+}
+
+// Saves the key without a response or takes it over if it expired, transactions hold the store lock,
+// so concurrent requests with the key wait for the one that claimed it.
+// Returns [ErrIdempotencyKeyExists] if the key is taken and hasn't expired
+func (m *MemoryStore) ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, expiresAt time.Time) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	claimed, exists := m.data.idempotencyKeys[key]
+	if exists && claimed.ExpiresAt.After(time.Now()) {
+		return ErrIdempotencyKeyExists
+	}
+
+	m.data.idempotencyKeys[key] = &models.IdempotentResponse{Key: key, Fingerprint: fingerprint, ExpiresAt: expiresAt}
+	return nil
+}
+
+// Returns [ErrIdempotencyKeyNotFound] if the key isn't claimed
+func (m *MemoryStore) CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, body []byte) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	claimed, exists := m.data.idempotencyKeys[key]
+	if !exists {
+		return ErrIdempotencyKeyNotFound
+	}
+
+	// entries are replaced rather than modified, snapshots of transactions share them
+	completed := *claimed
+	completed.StatusCode, completed.Body = statusCode, slices.Clone(body)
+	m.data.idempotencyKeys[key] = &completed
+	return nil
+}
+
+// Deletes up to limit keys expired before expiredBefore, oldest first
+func (m *MemoryStore) PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time, limit int) (int64, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	defer m.lock()()
+
+	var expired []*models.IdempotentResponse
+	for _, response := range m.data.idempotencyKeys {
+		if response.ExpiresAt.Before(expiredBefore) {
+			expired = append(expired, response)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ExpiresAt.Before(expired[j].ExpiresAt) })
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+
+	for _, response := range expired {
+		delete(m.data.idempotencyKeys, response.Key)
+	}
+	return int64(len(expired)), nil
+}
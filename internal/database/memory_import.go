@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Adds songs, songs with details are saved as enriched and enrichment jobs are queued for the rest.
+// Songs that already exist are skipped, unless update is set, then their details are replaced by the given ones.
+// Returns results in the order of songs, which should name distinct songs
+func (m *MemoryStore) ImportSongs(ctx context.Context, songs []models.ImportSong, update bool) ([]models.ImportResult, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	results := make([]models.ImportResult, 0, len(songs))
+	for _, imported := range songs {
+		song := m.findByNames(imported.GroupName, imported.SongName)
+		if song == nil {
+			results = append(results, models.ImportResult{Status: models.ImportCreated, SongId: m.importSong(ctx, &imported)})
+			continue
+		}
+
+		if !update || imported.Details == nil || song.DeletedAt.Valid {
+			results = append(results, importSkipped(song.Id, song.DeletedAt.Valid, update, imported.Details != nil))
+			continue
+		}
+
+		before := *song
+		setSongDetails(song, imported.Details)
+		m.dequeueJobs(song.Id)
+		touchSong(song)
+		m.recordChange(ctx, &before, song)
+		results = append(results, models.ImportResult{Status: models.ImportUpdated, SongId: song.Id})
+	}
+
+	return results, nil
+}
+
+// Inserts the song like AddSong, songs with details don't need enrichment
+func (m *MemoryStore) importSong(ctx context.Context, imported *models.ImportSong) int64 {
+	m.data.lastId++
+	song := &models.FullSongInfo{
+		Id:               m.data.lastId,
+		GroupId:          m.joinGroup(imported.GroupName),
+		GroupName:        imported.GroupName,
+		SongName:         imported.SongName,
+		EnrichmentStatus: models.EnrichmentPending,
+		UpdatedAt:        time.Now(),
+		Version:          1,
+	}
+	m.data.songs[song.Id] = song
+
+	if imported.Details != nil {
+		setSongDetails(song, imported.Details)
+	} else {
+		m.enqueueJob(song.Id)
+	}
+	m.recordChange(ctx, nil, song)
+	return song.Id
+}
@@ -0,0 +1,291 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+type memoryPlaylist struct {
+	models.Playlist
+	items []memoryItem // Ordered by rank
+}
+
+type memoryItem struct {
+	id      int64
+	songId  int64
+	rank    int64
+	addedAt time.Time
+}
+
+// Returns copy of the playlist with its item count
+func (p *memoryPlaylist) info() models.Playlist {
+	info := p.Playlist
+	info.ItemCount = int64(len(p.items))
+	return info
+}
+
+func (p *memoryPlaylist) itemIndex(itemId int64) int {
+	return slices.IndexFunc(p.items, func(item memoryItem) bool { return item.id == itemId })
+}
+
+// Puts item at the position changing only its rank, renumbering the playlist
+// if neighbours at the position have no free rank between them, like [Queries.freeRank] does
+func (p *memoryPlaylist) place(item memoryItem, position int) {
+	if i := p.itemIndex(item.id); i >= 0 {
+		p.items = slices.Delete(p.items, i, i+1)
+	}
+
+	rank, ok := rankBetween(p.neighbourRanks(position))
+	if !ok {
+		for i := range p.items {
+			p.items[i].rank = int64(i+1) * rankGap
+		}
+		rank, _ = rankBetween(p.neighbourRanks(position))
+	}
+
+	item.rank = rank
+	i, _ := slices.BinarySearchFunc(p.items, rank, func(item memoryItem, rank int64) int { return cmp.Compare(item.rank, rank) })
+	p.items = slices.Insert(p.items, i, item)
+}
+
+func (p *memoryPlaylist) neighbourRanks(position int) (prev, next *int64) {
+	if position <= 0 || position > len(p.items) {
+		position = len(p.items) + 1
+	}
+	if position >= 2 {
+		prev = &p.items[position-2].rank
+	}
+	if position <= len(p.items) {
+		next = &p.items[position-1].rank
+	}
+	return prev, next
+}
+
+func (m *MemoryStore) findPlaylist(owner, name string) *memoryPlaylist {
+	for _, playlist := range m.data.playlists {
+		if playlist.Owner == owner && playlist.Name == name {
+			return playlist
+		}
+	}
+	return nil
+}
+
+// Applies the delete policy to playlists of a song being deleted
+func (m *MemoryStore) detachFromPlaylists(songId int64) error {
+	for _, playlist := range m.data.playlists {
+		if !slices.ContainsFunc(playlist.items, func(item memoryItem) bool { return item.songId == songId }) {
+			continue
+		}
+		if m.deletePolicy == DeletePolicyRestrict {
+			return ErrSongInPlaylist
+		}
+	}
+
+	for _, playlist := range m.data.playlists {
+		playlist.items = slices.DeleteFunc(playlist.items, func(item memoryItem) bool { return item.songId == songId })
+	}
+	return nil
+}
+
+// Writes assigned id into playlist.Id.
+// Returns [ErrPlaylistAlreadyExists] if the owner already has a playlist with the name
+func (m *MemoryStore) AddPlaylist(ctx context.Context, playlist *models.Playlist) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if m.findPlaylist(playlist.Owner, playlist.Name) != nil {
+		return ErrPlaylistAlreadyExists
+	}
+
+	m.data.lastPlaylistId++
+	playlist.Id = m.data.lastPlaylistId
+	stored := &memoryPlaylist{Playlist: *playlist}
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = stored.CreatedAt
+	m.data.playlists[playlist.Id] = stored
+	return nil
+}
+
+// Returns [ErrPlaylistNotFound] if there's no such playlist
+func (m *MemoryStore) GetPlaylist(ctx context.Context, playlistId int64) (*models.Playlist, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	playlist, exists := m.data.playlists[playlistId]
+	if !exists {
+		return nil, ErrPlaylistNotFound
+	}
+	info := playlist.info()
+	return &info, nil
+}
+
+// Returns playlists ordered by owner and name
+func (m *MemoryStore) GetPlaylists(ctx context.Context, filter *PlaylistFilter) ([]models.Playlist, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	var playlists []models.Playlist
+	for _, playlist := range m.data.playlists {
+		if filter.Owner == "" || playlist.Owner == filter.Owner {
+			playlists = append(playlists, playlist.info())
+		}
+	}
+	sort.Slice(playlists, func(i, j int) bool {
+		a, b := playlists[i], playlists[j]
+		if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Id < b.Id
+	})
+
+	return paginate(playlists, filter.Limit, filter.Offset), nil
+}
+
+// Returns [ErrPlaylistNotFound] if there's no such playlist,
+// [ErrPlaylistAlreadyExists] if the owner already has another playlist with the name
+func (m *MemoryStore) UpdatePlaylist(ctx context.Context, playlistId int64, name, description string) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	playlist, exists := m.data.playlists[playlistId]
+	if !exists {
+		return ErrPlaylistNotFound
+	}
+	if other := m.findPlaylist(playlist.Owner, name); other != nil && other.Id != playlistId {
+		return ErrPlaylistAlreadyExists
+	}
+
+	playlist.Name, playlist.Description = name, description
+	playlist.UpdatedAt = time.Now()
+	return nil
+}
+
+// Deletes playlist with its items, returns [ErrPlaylistNotFound] if there's no such playlist
+func (m *MemoryStore) DeletePlaylist(ctx context.Context, playlistId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if _, exists := m.data.playlists[playlistId]; !exists {
+		return ErrPlaylistNotFound
+	}
+	delete(m.data.playlists, playlistId)
+	return nil
+}
+
+// Returns items ordered by position, [ErrPlaylistNotFound] if there's no such playlist
+func (m *MemoryStore) GetPlaylistItems(ctx context.Context, playlistId int64) ([]models.PlaylistItem, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	playlist, exists := m.data.playlists[playlistId]
+	if !exists {
+		return nil, ErrPlaylistNotFound
+	}
+
+	var items []models.PlaylistItem
+	for _, item := range playlist.items {
+		if song, active := m.activeSong(item.songId); active {
+			items = append(items, models.PlaylistItem{
+				Id:       item.id,
+				Position: len(items) + 1,
+				AddedAt:  item.addedAt,
+				Song:     *song,
+			})
+		}
+	}
+	return items, nil
+}
+
+// Inserts song at the position, 0 or positions past the end append it. Returns id of the new item,
+// [ErrPlaylistNotFound], [ErrSongNotFound] if the playlist or the song is missing
+func (m *MemoryStore) AddPlaylistItem(ctx context.Context, playlistId int64, songId int64, position int) (int64, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	defer m.lock()()
+
+	playlist, exists := m.data.playlists[playlistId]
+	if !exists {
+		return 0, ErrPlaylistNotFound
+	}
+	if _, active := m.activeSong(songId); !active {
+		return 0, ErrSongNotFound
+	}
+
+	m.data.lastItemId++
+	playlist.place(memoryItem{id: m.data.lastItemId, songId: songId, addedAt: time.Now()}, position)
+	playlist.UpdatedAt = time.Now()
+	return m.data.lastItemId, nil
+}
+
+// Puts item at the position changing only its rank, 0 or positions past the end move it to the end.
+// Returns [ErrPlaylistNotFound] if there's no such playlist, [ErrItemNotFound] if the item isn't in it
+func (m *MemoryStore) MovePlaylistItem(ctx context.Context, playlistId int64, itemId int64, position int) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	playlist, exists := m.data.playlists[playlistId]
+	if !exists {
+		return ErrPlaylistNotFound
+	}
+	i := playlist.itemIndex(itemId)
+	if i < 0 {
+		return ErrItemNotFound
+	}
+
+	playlist.place(playlist.items[i], position)
+	playlist.UpdatedAt = time.Now()
+	return nil
+}
+
+// Returns [ErrPlaylistNotFound] if there's no such playlist, [ErrItemNotFound] if the item isn't in it
+func (m *MemoryStore) RemovePlaylistItem(ctx context.Context, playlistId int64, itemId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	playlist, exists := m.data.playlists[playlistId]
+	if !exists {
+		return ErrPlaylistNotFound
+	}
+	i := playlist.itemIndex(itemId)
+	if i < 0 {
+		return ErrItemNotFound
+	}
+
+	playlist.items = slices.Delete(playlist.items, i, i+1)
+	playlist.UpdatedAt = time.Now()
+	return nil
+}
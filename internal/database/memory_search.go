@@ -0,0 +1,134 @@
+package database
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Approximation of websearch_to_tsquery used by [MemoryStore]: alternatives separated by "or",
+// each one is a set of words that all have to be present, words prefixed with "-" must be absent.
+// Words are compared case-insensitively without stemming, whatever the search language is
+type textSearch [][]searchTerm
+
+type searchTerm struct {
+	word    string
+	negated bool
+}
+
+func parseTextSearch(query string) textSearch {
+	var (
+		search      textSearch
+		alternative []searchTerm
+	)
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		if field == "or" {
+			search = append(search, alternative)
+			alternative = nil
+			continue
+		}
+
+		negated := strings.HasPrefix(field, "-")
+		for _, word := range searchWords(field) {
+			alternative = append(alternative, searchTerm{word: word, negated: negated})
+		}
+	}
+	return append(search, alternative)
+}
+
+// Splits text into lowercase words, punctuation is dropped like by postgres parser
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns nil if lyrics don't match, rank is 0.1 per occurrence of a matched word
+// (the weight ts_rank gives to lexemes by default)
+func (s textSearch) match(lyrics string) *models.SearchMatch {
+	words := searchWords(lyrics)
+
+	var match *models.SearchMatch
+	for _, alternative := range s {
+		if !matchesTerms(words, alternative) {
+			continue
+		}
+		if match == nil {
+			match = &models.SearchMatch{}
+		}
+
+		for _, word := range words {
+			for _, term := range alternative {
+				if !term.negated && term.word == word {
+					match.Rank += 0.1
+				}
+			}
+		}
+
+		for i, verse := range strings.Split(lyrics, "\n\n") {
+			if match.Verse != 0 && i+1 >= match.Verse {
+				break
+			}
+			if matchesTerms(searchWords(verse), alternative) {
+				match.Verse = i + 1
+				match.Snippet = highlightTerms(verse, alternative)
+				break
+			}
+		}
+		if match.Snippet == "" {
+			match.Snippet = highlightTerms(lyrics, alternative)
+		}
+	}
+	return match
+}
+
+func matchesTerms(words []string, terms []searchTerm) bool {
+	positive := 0
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if word == term.word {
+				found = true
+				break
+			}
+		}
+		if found == term.negated {
+			return false
+		}
+		if !term.negated {
+			positive++
+		}
+	}
+	return positive > 0
+}
+
+// Wraps words of text matching terms into <b></b> like ts_headline does
+func highlightTerms(text string, terms []searchTerm) string {
+	var (
+		result strings.Builder
+		word   strings.Builder
+	)
+	flush := func() {
+		w := word.String()
+		word.Reset()
+		for _, term := range terms {
+			if !term.negated && strings.ToLower(w) == term.word {
+				result.WriteString("<b>" + w + "</b>")
+				return
+			}
+		}
+		result.WriteString(w)
+	}
+
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		result.WriteRune(r)
+	}
+	flush()
+	return result.String()
+}
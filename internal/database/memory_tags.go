@@ -0,0 +1,131 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+func (m *MemoryStore) findTag(kind, name string) *models.Tag {
+	for _, tag := range m.data.tags {
+		if tag.Kind == kind && tag.Name == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) hasTags(songId int64, tags []models.Tag, all bool) bool {
+	matched := 0
+	for _, tag := range tags {
+		found := m.findTag(tag.Kind, tag.Name)
+		if found != nil && slices.Contains(m.data.songTags[songId], found.Id) {
+			matched++
+		}
+	}
+	if all {
+		return matched == len(tags)
+	}
+	return matched > 0
+}
+
+func compareTags(a, b models.Tag) int {
+	return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Name, b.Name))
+}
+
+// Returns tags ordered by song count, most used first
+func (m *MemoryStore) GetTags(ctx context.Context, filter *TagFilter) ([]models.Tag, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	var tags []models.Tag
+	for _, tag := range m.data.tags {
+		if filter.Kind != "" && tag.Kind != filter.Kind {
+			continue
+		}
+		info := *tag
+		for songId, tagIds := range m.data.songTags {
+			if _, active := m.activeSong(songId); active && slices.Contains(tagIds, tag.Id) {
+				info.SongCount++
+			}
+		}
+		tags = append(tags, info)
+	}
+	slices.SortFunc(tags, func(a, b models.Tag) int {
+		return cmp.Or(cmp.Compare(b.SongCount, a.SongCount), compareTags(a, b))
+	})
+
+	return paginate(tags, filter.Limit, filter.Offset), nil
+}
+
+// Returns tags of the song ordered by kind and name, [ErrSongNotFound] if there's no such song
+func (m *MemoryStore) GetSongTags(ctx context.Context, songId int64) ([]models.Tag, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	defer m.lock()()
+
+	if _, active := m.activeSong(songId); !active {
+		return nil, ErrSongNotFound
+	}
+
+	var tags []models.Tag
+	for _, tagId := range m.data.songTags[songId] {
+		tags = append(tags, *m.data.tags[tagId])
+	}
+	slices.SortFunc(tags, compareTags)
+	return tags, nil
+}
+
+// Attaches tags given by kind and name to the song, tags it already has are skipped.
+// Returns [ErrSongNotFound] if there's no such song
+func (m *MemoryStore) AttachTags(ctx context.Context, songId int64, tags []models.Tag) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if _, active := m.activeSong(songId); !active {
+		return ErrSongNotFound
+	}
+
+	for _, tag := range tags {
+		found := m.findTag(tag.Kind, tag.Name)
+		if found == nil {
+			m.data.lastTagId++
+			found = &models.Tag{Id: m.data.lastTagId, Kind: tag.Kind, Name: tag.Name}
+			m.data.tags[found.Id] = found
+		}
+		if !slices.Contains(m.data.songTags[songId], found.Id) {
+			m.data.songTags[songId] = append(m.data.songTags[songId], found.Id)
+		}
+	}
+	return nil
+}
+
+// Returns [ErrSongNotFound] if there's no such song, [ErrTagNotFound] if the song doesn't have the tag
+func (m *MemoryStore) DetachTag(ctx context.Context, songId int64, tagId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	if _, active := m.activeSong(songId); !active {
+		return ErrSongNotFound
+	}
+
+	i := slices.Index(m.data.songTags[songId], tagId)
+	if i < 0 {
+		return ErrTagNotFound
+	}
+	m.data.songTags[songId] = slices.Delete(m.data.songTags[songId], i, i+1)
+	return nil
+}
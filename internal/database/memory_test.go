@@ -0,0 +1,293 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Adds songs of the group, returns their ids in the same order
+func addSongs(t *testing.T, m *database.MemoryStore, group string, songs ...string) []int64 {
+	t.Helper()
+	var ids []int64
+	for _, name := range songs {
+		song := models.BasicSongInfo{GroupName: group, SongName: name}
+		err := m.AddSong(context.Background(), &song)
+		if err != nil {
+			t.Fatalf("failed to add %s - %s: %v", group, name, err)
+		}
+		ids = append(ids, song.Id)
+	}
+	return ids
+}
+
+func setDetails(t *testing.T, m *database.MemoryStore, songId int64, releaseDate, lyrics string) {
+	t.Helper()
+	rd, err := time.Parse("02.01.2006", releaseDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.UpdateSongInfo(context.Background(), songId, &models.AdditionalSongInfo{ReleaseDate: rd, SongLyrics: lyrics, Link: "https://example.com"}, 0)
+	if err != nil {
+		t.Fatalf("failed to update song %d: %v", songId, err)
+	}
+}
+
+// Song names of the list in its order
+func listSongs(t *testing.T, m *database.MemoryStore, filter database.ListFilter) []string {
+	t.Helper()
+	if filter.Limit == 0 {
+		filter.Limit = 100
+	}
+	rows, err := m.GetFilteredList(context.Background(), &filter)
+	if err != nil {
+		t.Fatalf("failed to list songs: %v", err)
+	}
+
+	var names []string
+	for _, row := range rows {
+		names = append(names, row.SongName)
+	}
+	return names
+}
+
+func TestMemoryStoreKeepsGroupSongPairsUnique(t *testing.T) {
+	m := database.NewMemoryStore()
+	ids := addSongs(t, m, "Muse", "Uprising", "Starlight")
+	if ids[0] == ids[1] {
+		t.Fatalf("songs got the same id %d", ids[0])
+	}
+
+	err := m.AddSong(context.Background(), &models.BasicSongInfo{GroupName: "Muse", SongName: "Uprising"})
+	if !errors.Is(err, database.ErrSongAlreadyExists) {
+		t.Errorf("adding song twice: got error %v, want %v", err, database.ErrSongAlreadyExists)
+	}
+
+	// like the unique constraint, names are compared as they are
+	addSongs(t, m, "muse", "Uprising")
+	addSongs(t, m, "Placebo", "Starlight")
+
+	id, err := m.FindSong(context.Background(), "Muse", "Starlight")
+	if err != nil || id != ids[1] {
+		t.Errorf("got id %d and error %v, want %d", id, err, ids[1])
+	}
+	_, err = m.FindSong(context.Background(), "Muse", "starlight")
+	if !errors.Is(err, database.ErrSongNotFound) {
+		t.Errorf("finding missing song: got error %v, want %v", err, database.ErrSongNotFound)
+	}
+}
+
+func TestMemoryStoreRevertsFailedTransactions(t *testing.T) {
+	ctx := context.Background()
+	m := database.NewMemoryStore()
+	id := addSongs(t, m, "Muse", "Uprising")[0]
+
+	errStop := errors.New("stop")
+	err := m.WithTx(ctx, func(tx database.SongStore) error {
+		err := tx.AddSong(ctx, &models.BasicSongInfo{GroupName: "Muse", SongName: "Starlight"})
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteSong(ctx, id, 0)
+		if err != nil {
+			return err
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Fatalf("got error %v, want the one returned by fn", err)
+	}
+	if got := listSongs(t, m, database.ListFilter{}); !slices.Equal(got, []string{"Uprising"}) {
+		t.Errorf("got songs %v after reverted transaction, want Uprising only", got)
+	}
+
+	err = m.WithTx(ctx, func(tx database.SongStore) error {
+		return tx.AddSong(ctx, &models.BasicSongInfo{GroupName: "Muse", SongName: "Starlight"})
+	})
+	if err != nil {
+		t.Fatalf("failed to commit transaction: %v", err)
+	}
+	// ids handed out inside the reverted transaction aren't reused
+	if starlight, _ := m.FindSong(ctx, "Muse", "Starlight"); starlight != id+2 {
+		t.Errorf("got id %d, want %d", starlight, id+2)
+	}
+}
+
+func TestMemoryStoreReportsMissingSongs(t *testing.T) {
+	ctx := context.Background()
+	m := database.NewMemoryStore()
+	id := addSongs(t, m, "Muse", "Uprising")[0]
+
+	var lyrics string
+	err := m.GetLyrics(ctx, id, &lyrics)
+	if !errors.Is(err, database.ErrSongHasNoLyrics) {
+		t.Errorf("lyrics of song without details: got error %v, want %v", err, database.ErrSongHasNoLyrics)
+	}
+
+	setDetails(t, m, id, "07.09.2009", "Paranoia is in bloom")
+	err = m.GetLyrics(ctx, id, &lyrics)
+	if err != nil || lyrics != "Paranoia is in bloom" {
+		t.Errorf("got lyrics %q and error %v", lyrics, err)
+	}
+
+	err = m.DeleteSong(ctx, id, 0)
+	if err != nil {
+		t.Fatalf("failed to delete song: %v", err)
+	}
+
+	missing := []struct {
+		name string
+		err  error
+	}{
+		{"update", m.UpdateSongInfo(ctx, id, &models.AdditionalSongInfo{}, 0)},
+		{"delete", m.DeleteSong(ctx, id, 0)},
+		{"lyrics", m.GetLyrics(ctx, id, &lyrics)},
+		{"update of unknown id", m.UpdateSongInfo(ctx, id+1, &models.AdditionalSongInfo{}, 0)},
+	}
+	for _, tt := range missing {
+		if !errors.Is(tt.err, database.ErrSongNotFound) {
+			t.Errorf("%s: got error %v, want %v", tt.name, tt.err, database.ErrSongNotFound)
+		}
+	}
+}
+
+func TestMemoryStoreFiltersLikeILike(t *testing.T) {
+	m := database.NewMemoryStore()
+	ids := addSongs(t, m, "Muse", "Uprising", "Starlight", "100% Pure")
+	addSongs(t, m, "Placebo", "Pure Morning")
+	setDetails(t, m, ids[0], "07.09.2009", "Paranoia is in bloom")
+	setDetails(t, m, ids[1], "04.09.2006", "Far away, this ship is taking me far away")
+
+	contains := func(s string) []database.TextFilter { return []database.TextFilter{{Values: []string{s}}} }
+	date := func(s string) sql.NullTime {
+		d, _ := time.Parse("02.01.2006", s)
+		return sql.NullTime{Time: d, Valid: true}
+	}
+
+	tests := []struct {
+		name   string
+		filter database.ListFilter
+		want   []string
+	}{
+		{"no filters", database.ListFilter{}, []string{"Uprising", "Starlight", "100% Pure", "Pure Morning"}},
+		{"ignores case", database.ListFilter{GroupName: contains("mUSE")}, []string{"Uprising", "Starlight", "100% Pure"}},
+		{"substring", database.ListFilter{SongName: contains("pure")}, []string{"100% Pure", "Pure Morning"}},
+		{"percent wildcard", database.ListFilter{SongName: contains("s%light")}, []string{"Starlight"}},
+		{"underscore wildcard", database.ListFilter{SongName: contains("up_ising")}, []string{"Uprising"}},
+		{"escaped percent", database.ListFilter{SongName: contains(`0\% p`)}, []string{"100% Pure"}},
+		{"lyrics", database.ListFilter{Lyrics: contains("FAR AWAY")}, []string{"Starlight"}},
+		{"release date bounds are inclusive",
+			database.ListFilter{ReleaseDateLowerBound: date("04.09.2006"), ReleaseDateUpperBound: date("04.09.2006")},
+			[]string{"Starlight"}},
+		{"release date excludes songs without it", database.ListFilter{ReleaseDateUpperBound: date("01.01.2100")},
+			[]string{"Uprising", "Starlight"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listSongs(t, m, tt.filter)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got songs %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreFiltersByOperators(t *testing.T) {
+	m := database.NewMemoryStore()
+	ids := addSongs(t, m, "Muse", "Uprising", "Starlight", "Supermassive Black Hole")
+	addSongs(t, m, "Musée Mécanique", "Sparrow")
+	addSongs(t, m, "Placebo", "Pure Morning")
+	setDetails(t, m, ids[0], "07.09.2009", "Paranoia is in bloom")
+	setDetails(t, m, ids[1], "04.09.2006", "")
+
+	filter := func(mode string, negate bool, values ...string) []database.TextFilter {
+		return []database.TextFilter{{Mode: mode, Values: values, Negate: negate}}
+	}
+	has := func(b bool) sql.NullBool { return sql.NullBool{Bool: b, Valid: true} }
+
+	tests := []struct {
+		name   string
+		filter database.ListFilter
+		want   []string
+	}{
+		{"exact ignores case", database.ListFilter{GroupName: filter(database.MatchExact, false, "MUSE")},
+			[]string{"Uprising", "Starlight", "Supermassive Black Hole"}},
+		{"exact doesn't match substrings", database.ListFilter{SongName: filter(database.MatchExact, false, "Star")}, nil},
+		{"prefix", database.ListFilter{SongName: filter(database.MatchPrefix, false, "s")},
+			[]string{"Starlight", "Supermassive Black Hole", "Sparrow"}},
+		{"prefix keeps wildcards", database.ListFilter{SongName: filter(database.MatchPrefix, false, "_p")},
+			[]string{"Uprising", "Sparrow"}},
+		{"not", database.ListFilter{GroupName: filter(database.MatchContains, true, "muse")},
+			[]string{"Pure Morning"}},
+		{"multi-value matches any of values", database.ListFilter{SongName: filter(database.MatchExact, false, "uprising", "sparrow", "hysteria")},
+			[]string{"Uprising", "Sparrow"}},
+		{"negated multi-value matches none of values", database.ListFilter{SongName: filter(database.MatchPrefix, true, "s", "p")},
+			[]string{"Uprising"}},
+		{"filters of a field are combined", database.ListFilter{SongName: []database.TextFilter{
+			{Mode: database.MatchPrefix, Values: []string{"s"}},
+			{Mode: database.MatchContains, Values: []string{"black"}, Negate: true},
+		}}, []string{"Starlight", "Sparrow"}},
+		{"unaccent", database.ListFilter{GroupName: filter(database.MatchExact, false, "musee mecanique")}, []string{"Sparrow"}},
+		{"unaccent of pattern", database.ListFilter{GroupName: filter(database.MatchPrefix, false, "MÜSE")},
+			[]string{"Uprising", "Starlight", "Supermassive Black Hole", "Sparrow"}},
+		{"negated lyrics match songs without them", database.ListFilter{Lyrics: filter(database.MatchContains, true, "bloom")},
+			[]string{"Starlight", "Supermassive Black Hole", "Sparrow", "Pure Morning"}},
+		{"has lyrics", database.ListFilter{HasLyrics: has(true)}, []string{"Uprising"}},
+		// empty lyrics count as missing
+		{"has no lyrics", database.ListFilter{HasLyrics: has(false), GroupName: filter(database.MatchExact, false, "muse")},
+			[]string{"Starlight", "Supermassive Black Hole"}},
+		{"has link", database.ListFilter{HasLink: has(true)}, []string{"Uprising", "Starlight"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listSongs(t, m, tt.filter)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got songs %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStorePaginates(t *testing.T) {
+	m := database.NewMemoryStore()
+	for i := 0; i < 5; i++ {
+		addSongs(t, m, "Muse", fmt.Sprintf("Song %d", i))
+	}
+
+	got := listSongs(t, m, database.ListFilter{Limit: 2, Offset: 2})
+	if want := []string{"Song 2", "Song 3"}; !slices.Equal(got, want) {
+		t.Errorf("got songs %v, want %v", got, want)
+	}
+	if got := listSongs(t, m, database.ListFilter{Limit: 2, Offset: 5}); len(got) != 0 {
+		t.Errorf("got songs %v past the end", got)
+	}
+}
+
+func TestMemoryStoreReportsFinishedContexts(t *testing.T) {
+	m := database.NewMemoryStore()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.AddSong(canceled, &models.BasicSongInfo{GroupName: "Muse", SongName: "Uprising"})
+	if !errors.Is(err, database.ErrQueryCanceled) {
+		t.Errorf("canceled context: got error %v, want %v", err, database.ErrQueryCanceled)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = m.GetFilteredList(expired, &database.ListFilter{Limit: 10})
+	if !errors.Is(err, database.ErrQueryTimeout) {
+		t.Errorf("expired context: got error %v, want %v", err, database.ErrQueryTimeout)
+	}
+
+	if got := listSongs(t, m, database.ListFilter{}); len(got) != 0 {
+		t.Errorf("got songs %v added with canceled context", got)
+	}
+}
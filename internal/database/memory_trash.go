@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// Returns [ErrSongNotFound] if the song is not in trash
+func (m *MemoryStore) RestoreSong(ctx context.Context, songId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	song, exists := m.data.songs[songId]
+	if !exists || !song.DeletedAt.Valid {
+		return ErrSongNotFound
+	}
+
+	before := *song
+
+	song.DeletedAt = sql.NullTime{}
+	touchSong(song)
+	m.recordChange(ctx, &before, song)
+	return nil
+}
+
+// Deletes trashed song permanently together with its enrichment job.
+// Returns [ErrSongNotFound] if the song is not in trash
+func (m *MemoryStore) PurgeSong(ctx context.Context, songId int64) error {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	defer m.lock()()
+
+	song, exists := m.data.songs[songId]
+	if !exists || !song.DeletedAt.Valid {
+		return ErrSongNotFound
+	}
+
+	m.purge(ctx, songId)
+	return nil
+}
+
+// Permanently deletes up to limit songs trashed before deletedBefore, oldest first
+func (m *MemoryStore) PurgeTrash(ctx context.Context, deletedBefore time.Time, limit int) (int64, error) {
+	if err := contextErr(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	defer m.lock()()
+
+	var expired []*models.FullSongInfo
+	for _, song := range m.data.songs {
+		if song.DeletedAt.Valid && song.DeletedAt.Time.Before(deletedBefore) {
+			expired = append(expired, song)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].DeletedAt.Time.Before(expired[j].DeletedAt.Time) })
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+
+	for _, song := range expired {
+		m.purge(ctx, song.Id)
+	}
+	return int64(len(expired)), nil
+}
+
+func (m *MemoryStore) purge(ctx context.Context, songId int64) {
+	m.recordChange(ctx, m.data.songs[songId], nil)
+	delete(m.data.songs, songId)
+	m.dequeueJobs(songId)
+	for _, album := range m.data.albums {
+		album.tracks = slices.DeleteFunc(album.tracks, func(id int64) bool { return id == songId })
+	}
+	for _, playlist := range m.data.playlists {
+		playlist.items = slices.DeleteFunc(playlist.items, func(item memoryItem) bool { return item.songId == songId })
+	}
+	delete(m.data.songTags, songId)
+}
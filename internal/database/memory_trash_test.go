@@ -0,0 +1,135 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+func trashSongs(t *testing.T, m *database.MemoryStore, ids ...int64) {
+	t.Helper()
+	for _, id := range ids {
+		err := m.DeleteSong(context.Background(), id, 0)
+		if err != nil {
+			t.Fatalf("failed to delete song %d: %v", id, err)
+		}
+	}
+}
+
+func TestMemoryStoreTrashesDeletedSongs(t *testing.T) {
+	ctx := context.Background()
+	m := database.NewMemoryStore()
+	ids := addSongs(t, m, "Muse", "Uprising", "Starlight")
+	trashSongs(t, m, ids[0])
+
+	if got := listSongs(t, m, database.ListFilter{}); !slices.Equal(got, []string{"Starlight"}) {
+		t.Errorf("got songs %v, want trashed song hidden from the library", got)
+	}
+	if got := listSongs(t, m, database.ListFilter{Trashed: true}); !slices.Equal(got, []string{"Uprising"}) {
+		t.Errorf("got trash %v, want Uprising", got)
+	}
+
+	_, err := m.GetSong(ctx, ids[0])
+	if !errors.Is(err, database.ErrSongNotFound) {
+		t.Errorf("getting trashed song: got error %v, want %v", err, database.ErrSongNotFound)
+	}
+	err = m.UpdateSongInfo(ctx, ids[0], &models.AdditionalSongInfo{}, 0)
+	if !errors.Is(err, database.ErrSongNotFound) {
+		t.Errorf("updating trashed song: got error %v, want %v", err, database.ErrSongNotFound)
+	}
+
+	// names of trashed songs stay taken until they are purged
+	err = m.AddSong(ctx, &models.BasicSongInfo{GroupName: "Muse", SongName: "Uprising"})
+	if !errors.Is(err, database.ErrSongTrashed) {
+		t.Errorf("adding trashed song: got error %v, want %v", err, database.ErrSongTrashed)
+	}
+	id, err := m.FindSong(ctx, "Muse", "Uprising")
+	if id != ids[0] || !errors.Is(err, database.ErrSongTrashed) {
+		t.Errorf("finding trashed song: got id %d and error %v, want %d and %v", id, err, ids[0], database.ErrSongTrashed)
+	}
+
+	// trashed songs aren't enriched
+	jobs, err := m.ClaimEnrichmentJobs(ctx, 10, time.Minute)
+	if err != nil || len(jobs) != 1 || jobs[0].SongId != ids[1] {
+		t.Errorf("got jobs %+v and error %v, want the job of Starlight only", jobs, err)
+	}
+}
+
+func TestMemoryStoreRestoresSongs(t *testing.T) {
+	ctx := context.Background()
+	m := database.NewMemoryStore()
+	ids := addSongs(t, m, "Muse", "Uprising", "Starlight")
+	trashSongs(t, m, ids[0])
+
+	deleted, err := m.GetFilteredList(ctx, &database.ListFilter{Trashed: true, Limit: 10})
+	if err != nil || len(deleted) != 1 || !deleted[0].DeletedAt.Valid {
+		t.Fatalf("got trash %+v and error %v, want Uprising with deletion time", deleted, err)
+	}
+
+	err = m.RestoreSong(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("failed to restore song: %v", err)
+	}
+	song, err := m.GetSong(ctx, ids[0])
+	if err != nil || song.DeletedAt.Valid || song.Version <= deleted[0].Version {
+		t.Errorf("got song %+v and error %v, want it restored with a new version", song, err)
+	}
+
+	missing := []struct {
+		name string
+		err  error
+	}{
+		{"restore of song in library", m.RestoreSong(ctx, ids[1])},
+		{"restore of unknown song", m.RestoreSong(ctx, ids[1]+1)},
+		{"purge of song in library", m.PurgeSong(ctx, ids[1])},
+		{"purge of unknown song", m.PurgeSong(ctx, ids[1]+1)},
+	}
+	for _, tt := range missing {
+		if !errors.Is(tt.err, database.ErrSongNotFound) {
+			t.Errorf("%s: got error %v, want %v", tt.name, tt.err, database.ErrSongNotFound)
+		}
+	}
+}
+
+func TestMemoryStorePurgesTrash(t *testing.T) {
+	ctx := context.Background()
+	m := database.NewMemoryStore()
+	ids := addSongs(t, m, "Muse", "Uprising", "Starlight", "Hysteria", "Madness")
+	for _, id := range []int64{ids[2], ids[0], ids[1]} {
+		trashSongs(t, m, id)
+		time.Sleep(time.Millisecond)
+	}
+	deletedBefore := time.Now()
+	trashSongs(t, m, ids[3])
+
+	err := m.PurgeSong(ctx, ids[3])
+	if err != nil {
+		t.Fatalf("failed to purge song: %v", err)
+	}
+	addSongs(t, m, "Muse", "Madness")
+	addSongs(t, m, "Muse", "Time Is Running Out")
+
+	// songs trashed first are purged first
+	purged, err := m.PurgeTrash(ctx, deletedBefore, 2)
+	if err != nil || purged != 2 {
+		t.Fatalf("got %d purged songs and error %v, want 2", purged, err)
+	}
+	if got := listSongs(t, m, database.ListFilter{Trashed: true}); !slices.Equal(got, []string{"Starlight"}) {
+		t.Errorf("got trash %v, want the song trashed last", got)
+	}
+
+	purged, err = m.PurgeTrash(ctx, deletedBefore, 2)
+	if err != nil || purged != 1 {
+		t.Fatalf("got %d purged songs and error %v, want 1", purged, err)
+	}
+	if got := listSongs(t, m, database.ListFilter{}); !slices.Equal(got, []string{"Madness", "Time Is Running Out"}) {
+		t.Errorf("got songs %v, want the library untouched", got)
+	}
+	// names of purged songs are free again
+	addSongs(t, m, "Muse", "Uprising")
+}
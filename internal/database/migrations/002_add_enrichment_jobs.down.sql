@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS enrichment_jobs;
+
+ALTER TABLE music_library DROP COLUMN IF EXISTS enrichment_status;
\ No newline at end of file
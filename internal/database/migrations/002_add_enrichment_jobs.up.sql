@@ -0,0 +1,17 @@
+ALTER TABLE music_library
+    ADD COLUMN IF NOT EXISTS enrichment_status TEXT NOT NULL DEFAULT 'pending'
+    CONSTRAINT valid_enrichment_status CHECK (enrichment_status IN ('pending', 'enriched', 'failed'));
+
+UPDATE music_library
+SET enrichment_status = CASE WHEN song_lyrics IS NULL THEN 'failed' ELSE 'enriched' END;
+
+CREATE TABLE IF NOT EXISTS enrichment_jobs (
+    job_id SERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL UNIQUE REFERENCES music_library (song_id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    run_after TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ DEFAULT NULL,
+    last_error TEXT DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS enrichment_jobs_run_after_idx ON enrichment_jobs (run_after);
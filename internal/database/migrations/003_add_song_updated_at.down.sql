@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS music_library_touch_updated_at ON music_library;
+
+DROP FUNCTION IF EXISTS touch_updated_at();
+
+ALTER TABLE music_library DROP COLUMN IF EXISTS updated_at;
\ No newline at end of file
@@ -0,0 +1,13 @@
+ALTER TABLE music_library
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER music_library_touch_updated_at
+    BEFORE UPDATE ON music_library
+    FOR EACH ROW EXECUTE FUNCTION touch_updated_at();
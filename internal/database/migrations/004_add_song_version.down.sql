@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS music_library_bump_version ON music_library;
+
+DROP FUNCTION IF EXISTS bump_version();
+
+ALTER TABLE music_library DROP COLUMN IF EXISTS version;
\ No newline at end of file
@@ -0,0 +1,13 @@
+ALTER TABLE music_library
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION bump_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER music_library_bump_version
+    BEFORE UPDATE ON music_library
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
@@ -0,0 +1,5 @@
+DELETE FROM music_library WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS music_library_deleted_at_idx;
+
+ALTER TABLE music_library DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+ALTER TABLE music_library
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS music_library_deleted_at_idx ON music_library (deleted_at)
+    WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS music_library_record_history ON music_library;
+
+DROP FUNCTION IF EXISTS record_song_history();
+
+DROP FUNCTION IF EXISTS song_history_values(music_library);
+
+DROP TABLE IF EXISTS song_history;
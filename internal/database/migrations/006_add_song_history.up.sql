@@ -0,0 +1,76 @@
+CREATE TABLE IF NOT EXISTS song_history (
+    revision_id BIGSERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL,
+    version BIGINT NOT NULL,
+    operation TEXT NOT NULL
+    CONSTRAINT valid_history_operation CHECK (operation IN ('create', 'update', 'delete', 'restore', 'purge')),
+    actor TEXT NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    old_values JSONB DEFAULT NULL,
+    new_values JSONB DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS song_history_song_id_idx ON song_history (song_id, revision_id);
+
+-- fields of a song tracked by history, changes of other columns are not recorded
+CREATE OR REPLACE FUNCTION song_history_values(song music_library) RETURNS JSONB AS $$
+    SELECT jsonb_build_object(
+        'group', song.group_name,
+        'song', song.song_name,
+        'releaseDate', song.release_date,
+        'text', song.song_lyrics,
+        'link', song.link);
+$$ LANGUAGE sql IMMUTABLE;
+
+-- actor is taken from music_library.actor setting of the transaction that made the change
+CREATE OR REPLACE FUNCTION record_song_history() RETURNS TRIGGER AS $$
+DECLARE
+    changed_song_id INTEGER;
+    changed_version BIGINT;
+    change_operation TEXT;
+    old_song_values JSONB;
+    new_song_values JSONB;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        changed_song_id := NEW.song_id;
+        changed_version := NEW.version;
+        change_operation := 'create';
+        new_song_values := song_history_values(NEW);
+    ELSIF TG_OP = 'DELETE' THEN
+        changed_song_id := OLD.song_id;
+        changed_version := OLD.version;
+        change_operation := 'purge';
+        old_song_values := song_history_values(OLD);
+    ELSE
+        changed_song_id := NEW.song_id;
+        changed_version := NEW.version;
+        old_song_values := song_history_values(OLD);
+        new_song_values := song_history_values(NEW);
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            change_operation := 'delete';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            change_operation := 'restore';
+        ELSIF old_song_values IS DISTINCT FROM new_song_values THEN
+            change_operation := 'update';
+        ELSE
+            RETURN NULL;
+        END IF;
+    END IF;
+
+    INSERT INTO song_history (song_id, version, operation, actor, old_values, new_values)
+    VALUES (changed_song_id, changed_version, change_operation,
+        COALESCE(NULLIF(current_setting('music_library.actor', true), ''), 'system'),
+        old_song_values, new_song_values);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER music_library_record_history
+    AFTER INSERT OR UPDATE OR DELETE ON music_library
+    FOR EACH ROW EXECUTE FUNCTION record_song_history();
+
+-- existing songs start their history from the current state
+INSERT INTO song_history (song_id, version, operation, actor, changed_at, new_values)
+SELECT song_id, version, 'create', 'system', updated_at, song_history_values(music_library)
+FROM music_library
+ORDER BY song_id;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS music_library_lyrics_search_idx;
+
+ALTER TABLE music_library DROP COLUMN IF EXISTS lyrics_search;
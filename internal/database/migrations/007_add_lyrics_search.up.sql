@@ -0,0 +1,10 @@
+-- lyrics are indexed with every supported search language at once,
+-- so a query stems its words correctly whichever language it's made in
+ALTER TABLE music_library
+    ADD COLUMN IF NOT EXISTS lyrics_search TSVECTOR GENERATED ALWAYS AS (
+        to_tsvector('english', coalesce(song_lyrics, '')) ||
+        to_tsvector('russian', coalesce(song_lyrics, '')) ||
+        to_tsvector('simple', coalesce(song_lyrics, ''))
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS music_library_lyrics_search_idx ON music_library USING GIN (lyrics_search);
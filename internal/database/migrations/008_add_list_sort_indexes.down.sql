@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS music_library_release_date_sort_idx;
+
+DROP INDEX IF EXISTS music_library_song_name_sort_idx;
+
+DROP INDEX IF EXISTS music_library_group_name_sort_idx;
@@ -0,0 +1,8 @@
+-- song_id breaks ties of every sort, so each index ends with it to serve keyset pages.
+-- Missing release dates count as later than any date, the list query sorts by the same expression
+CREATE INDEX IF NOT EXISTS music_library_group_name_sort_idx ON music_library (group_name, song_id);
+
+CREATE INDEX IF NOT EXISTS music_library_song_name_sort_idx ON music_library (song_name, song_id);
+
+CREATE INDEX IF NOT EXISTS music_library_release_date_sort_idx
+    ON music_library ((coalesce(release_date, 'infinity'::date)), song_id);
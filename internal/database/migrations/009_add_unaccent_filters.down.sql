@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS music_library_song_name_folded_idx;
+
+DROP INDEX IF EXISTS music_library_group_name_folded_idx;
+
+DROP FUNCTION IF EXISTS f_unaccent(TEXT);
+
+DROP EXTENSION IF EXISTS unaccent;
@@ -0,0 +1,14 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unaccent is only stable since its dictionary can be changed,
+-- the wrapper pins the dictionary so that folded names can be indexed
+CREATE OR REPLACE FUNCTION f_unaccent(TEXT) RETURNS TEXT AS $$
+    SELECT public.unaccent('public.unaccent'::regdictionary, $1)
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT;
+
+-- text_pattern_ops serves both exact and prefix matches of the list filters
+CREATE INDEX IF NOT EXISTS music_library_group_name_folded_idx
+    ON music_library (lower(f_unaccent(group_name)) text_pattern_ops);
+
+CREATE INDEX IF NOT EXISTS music_library_song_name_folded_idx
+    ON music_library (lower(f_unaccent(song_name)) text_pattern_ops);
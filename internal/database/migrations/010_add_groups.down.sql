@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS groups_rename_songs ON groups;
+
+DROP FUNCTION IF EXISTS rename_group_songs();
+
+DROP TRIGGER IF EXISTS music_library_sync_group ON music_library;
+
+DROP FUNCTION IF EXISTS sync_song_group();
+
+DROP INDEX IF EXISTS music_library_group_id_idx;
+
+ALTER TABLE music_library DROP COLUMN IF EXISTS group_id;
+
+DROP TABLE IF EXISTS groups;
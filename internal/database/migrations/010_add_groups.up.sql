@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS groups (
+    group_id BIGSERIAL PRIMARY KEY,
+    group_name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT unique_group_name UNIQUE (group_name)
+);
+
+INSERT INTO groups (group_name)
+SELECT DISTINCT group_name FROM music_library
+ORDER BY group_name
+ON CONFLICT ON CONSTRAINT unique_group_name DO NOTHING;
+
+ALTER TABLE music_library
+    ADD COLUMN IF NOT EXISTS group_id BIGINT REFERENCES groups (group_id);
+
+UPDATE music_library AS m
+SET group_id = g.group_id
+FROM groups AS g
+WHERE g.group_name = m.group_name;
+
+ALTER TABLE music_library ALTER COLUMN group_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS music_library_group_id_idx ON music_library (group_id);
+
+-- group_name of a song is a copy of its group's name kept for filters, sorting and history.
+-- Songs given a group name join that group, which is created if it doesn't exist yet,
+-- songs given a group_id take the name of that group
+CREATE OR REPLACE FUNCTION sync_song_group() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.group_id IS DISTINCT FROM OLD.group_id THEN
+        SELECT group_name INTO NEW.group_name FROM groups WHERE group_id = NEW.group_id;
+    ELSIF TG_OP = 'INSERT' OR NEW.group_name IS DISTINCT FROM OLD.group_name THEN
+        INSERT INTO groups (group_name) VALUES (NEW.group_name)
+        ON CONFLICT ON CONSTRAINT unique_group_name DO NOTHING;
+        SELECT group_id INTO NEW.group_id FROM groups WHERE group_name = NEW.group_name;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER music_library_sync_group
+    BEFORE INSERT OR UPDATE OF group_id, group_name ON music_library
+    FOR EACH ROW EXECUTE FUNCTION sync_song_group();
+
+-- renaming a group renames it in every song of the group, which is recorded in their history
+CREATE OR REPLACE FUNCTION rename_group_songs() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE music_library SET group_name = NEW.group_name WHERE group_id = NEW.group_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER groups_rename_songs
+    AFTER UPDATE OF group_name ON groups
+    FOR EACH ROW WHEN (OLD.group_name IS DISTINCT FROM NEW.group_name)
+    EXECUTE FUNCTION rename_group_songs();
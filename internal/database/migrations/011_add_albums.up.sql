@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS albums (
+    album_id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups (group_id),
+    title TEXT NOT NULL,
+    release_date DATE DEFAULT NULL,
+    cover_link TEXT DEFAULT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT unique_group_album_title UNIQUE (group_id, title)
+);
+
+-- positions are rewritten as a whole when tracks are added, moved or removed,
+-- so uniqueness of positions is only checked once the transaction commits
+CREATE TABLE IF NOT EXISTS album_tracks (
+    album_id BIGINT NOT NULL REFERENCES albums (album_id) ON DELETE CASCADE,
+    song_id INTEGER NOT NULL REFERENCES music_library (song_id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (album_id, song_id),
+    CONSTRAINT unique_album_track_position UNIQUE (album_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX IF NOT EXISTS album_tracks_song_id_idx ON album_tracks (song_id);
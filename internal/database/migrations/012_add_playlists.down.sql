@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS playlist_items;
+
+DROP TABLE IF EXISTS playlists;
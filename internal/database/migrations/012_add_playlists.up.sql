@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS playlists (
+    playlist_id BIGSERIAL PRIMARY KEY,
+    owner TEXT NOT NULL,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT unique_owner_playlist_name UNIQUE (owner, name)
+);
+
+-- items are ordered by rank, ranks are spread with gaps so that an item is put between two others
+-- by changing only its own rank, the whole playlist is renumbered when a gap runs out.
+-- renumbering updates every rank in one statement, so uniqueness is checked at its end
+CREATE TABLE IF NOT EXISTS playlist_items (
+    item_id BIGSERIAL PRIMARY KEY,
+    playlist_id BIGINT NOT NULL REFERENCES playlists (playlist_id) ON DELETE CASCADE,
+    song_id INTEGER NOT NULL REFERENCES music_library (song_id) ON DELETE CASCADE,
+    rank BIGINT NOT NULL,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT unique_playlist_item_rank UNIQUE (playlist_id, rank) DEFERRABLE INITIALLY IMMEDIATE
+);
+
+CREATE INDEX IF NOT EXISTS playlist_items_song_id_idx ON playlist_items (song_id);
@@ -0,0 +1,16 @@
+-- genres and free-form tags share the table, names are unique within a kind
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('genre', 'tag')),
+    name TEXT NOT NULL,
+    CONSTRAINT unique_tag_kind_name UNIQUE (kind, name)
+);
+
+CREATE TABLE IF NOT EXISTS song_tags (
+    song_id INTEGER NOT NULL REFERENCES music_library (song_id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tags (tag_id) ON DELETE CASCADE,
+    PRIMARY KEY (song_id, tag_id)
+);
+
+-- songs of a tag, used by tag filters of the list and song counts of tags
+CREATE INDEX IF NOT EXISTS song_tags_tag_id_song_id_idx ON song_tags (tag_id, song_id);
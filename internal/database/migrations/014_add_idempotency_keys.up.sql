@@ -0,0 +1,14 @@
+-- responses of requests made with Idempotency-Key header, a retry with the same key gets the saved response
+-- instead of running the request again. The request claims its key first, with no response yet, and saves the response
+-- before its transaction commits, so concurrent requests with the key wait for it on the key's row.
+-- Keys are taken again once they expire and purged in background
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    status_code INTEGER,
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,412 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
+)
+
+// What DeleteSong does to playlists the song is in
+const (
+	DeletePolicyCascade  = "cascade"  // Song is removed from the playlists
+	DeletePolicyRestrict = "restrict" // Song can't be deleted, see [ErrSongInPlaylist]
+)
+
+func validateDeletePolicy(policy string) error {
+	if policy != DeletePolicyCascade && policy != DeletePolicyRestrict {
+		return fmt.Errorf("unknown playlist song delete policy %q, expected %s or %s",
+			policy, DeletePolicyCascade, DeletePolicyRestrict)
+	}
+	return nil
+}
+
+// Distance between ranks of neighbouring items after the playlist is renumbered,
+// about log2(rankGap) inserts fit between two items before it's needed again
+const rankGap = 1 << 16
+
+type PlaylistFilter struct {
+	Owner  string // Playlists of every owner are listed when empty
+	Limit  int32
+	Offset int32
+}
+
+// Returns rank for an item put between items ranked prev and next, nil for missing neighbours.
+// Reports false when there's no free rank between them and the playlist has to be renumbered
+func rankBetween(prev, next *int64) (int64, bool) {
+	switch {
+	case prev == nil && next == nil:
+		return rankGap, true
+	case next == nil:
+		return *prev + rankGap, true
+	case prev == nil:
+		return *next - rankGap, true
+	case *next-*prev < 2:
+		return 0, false
+	default:
+		return *prev + (*next-*prev)/2, true
+	}
+}
+
+// Reports whether err is a violation of the unique playlist names of an owner constraint
+func isPlaylistConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) &&
+		pqErr.Code == "23505" && pqErr.Constraint == "unique_owner_playlist_name"
+}
+
+// Writes assigned id into playlist.Id.
+// Returns [ErrPlaylistAlreadyExists] if the owner already has a playlist with the name
+func (q *Queries) AddPlaylist(ctx context.Context, playlist *models.Playlist) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{playlist.Owner, playlist.Name, playlist.Description}
+
+	err = q.stmt(ctx, "AddPlaylist").QueryRowContext(ctx, args...).Scan(&playlist.Id)
+	if err == sql.ErrNoRows {
+		return ErrPlaylistAlreadyExists
+	}
+	return err
+}
+
+// Returns [ErrPlaylistNotFound] if there's no such playlist
+func (q *Queries) GetPlaylist(ctx context.Context, playlistId int64) (_ *models.Playlist, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{playlistId}
+
+	playlist, err := scanPlaylist(q.stmt(ctx, "GetPlaylist").QueryRowContext(ctx, args...))
+	if err == sql.ErrNoRows {
+		return nil, ErrPlaylistNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// Returns playlists ordered by owner and name
+func (q *Queries) GetPlaylists(ctx context.Context, filter *PlaylistFilter) (_ []models.Playlist, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{filter.Owner, filter.Limit, filter.Offset}
+
+	rows, err := q.stmt(ctx, "GetPlaylists").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []models.Playlist
+	for rows.Next() {
+		playlist, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+
+	return playlists, rows.Err()
+}
+
+func scanPlaylist(row interface{ Scan(...any) error }) (models.Playlist, error) {
+	var playlist models.Playlist
+	err := row.Scan(
+		&playlist.Id,
+		&playlist.Owner,
+		&playlist.Name,
+		&playlist.Description,
+		&playlist.CreatedAt,
+		&playlist.UpdatedAt,
+		&playlist.ItemCount,
+	)
+	return playlist, err
+}
+
+// Returns [ErrPlaylistNotFound] if there's no such playlist,
+// [ErrPlaylistAlreadyExists] if the owner already has another playlist with the name
+func (q *Queries) UpdatePlaylist(ctx context.Context, playlistId int64, name, description string) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{playlistId, name, description}
+
+	result, err := q.stmt(ctx, "UpdatePlaylist").ExecContext(ctx, args...)
+	if isPlaylistConflict(err) {
+		return ErrPlaylistAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	return playlistAffectedErr(result)
+}
+
+// Deletes playlist with its items, returns [ErrPlaylistNotFound] if there's no such playlist
+func (q *Queries) DeletePlaylist(ctx context.Context, playlistId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{playlistId}
+
+	result, err := q.stmt(ctx, "DeletePlaylist").ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	return playlistAffectedErr(result)
+}
+
+func playlistAffectedErr(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPlaylistNotFound
+	}
+	return nil
+}
+
+// Returns items ordered by position, [ErrPlaylistNotFound] if there's no such playlist
+func (q *Queries) GetPlaylistItems(ctx context.Context, playlistId int64) (_ []models.PlaylistItem, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	_, err = q.GetPlaylist(ctx, playlistId)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{playlistId}
+
+	rows, err := q.stmt(ctx, "GetPlaylistItems").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.PlaylistItem
+	for rows.Next() {
+		var item models.PlaylistItem
+		err := rows.Scan(
+			&item.Id,
+			&item.Position,
+			&item.AddedAt,
+			&item.Song.Id,
+			&item.Song.GroupId,
+			&item.Song.GroupName,
+			&item.Song.SongName,
+			&item.Song.ReleaseDate,
+			&item.Song.SongLyrics,
+			&item.Song.Link,
+			&item.Song.EnrichmentStatus,
+			&item.Song.UpdatedAt,
+			&item.Song.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Inserts song at the position, 0 or positions past the end append it. Returns id of the new item,
+// [ErrPlaylistNotFound], [ErrSongNotFound] if the playlist or the song is missing
+func (q *Queries) AddPlaylistItem(ctx context.Context, playlistId int64, songId int64, position int) (itemId int64, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	err = q.inTx(ctx, func(tx *Queries) error {
+		err := tx.touchPlaylist(ctx, playlistId)
+		if err != nil {
+			return err
+		}
+
+		exists, err := tx.isSongIdPresent(ctx, songId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSongNotFound
+		}
+
+		rank, err := tx.freeRank(ctx, playlistId, 0, position)
+		if err != nil {
+			return err
+		}
+
+		return tx.stmt(ctx, "AddPlaylistItem").QueryRowContext(ctx, playlistId, songId, rank).Scan(&itemId)
+	})
+	return itemId, err
+}
+
+// Puts item at the position changing only its rank, 0 or positions past the end move it to the end.
+// Returns [ErrPlaylistNotFound] if there's no such playlist, [ErrItemNotFound] if the item isn't in it
+func (q *Queries) MovePlaylistItem(ctx context.Context, playlistId int64, itemId int64, position int) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		err := tx.touchPlaylist(ctx, playlistId)
+		if err != nil {
+			return err
+		}
+
+		var exists bool
+		err = tx.stmt(ctx, "isItemPresent").QueryRowContext(ctx, playlistId, itemId).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrItemNotFound
+		}
+
+		rank, err := tx.freeRank(ctx, playlistId, itemId, position)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.stmt(ctx, "SetItemRank").ExecContext(ctx, itemId, rank)
+		return err
+	})
+}
+
+// Returns [ErrPlaylistNotFound] if there's no such playlist, [ErrItemNotFound] if the item isn't in it
+func (q *Queries) RemovePlaylistItem(ctx context.Context, playlistId int64, itemId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		err := tx.touchPlaylist(ctx, playlistId)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.stmt(ctx, "RemovePlaylistItem").ExecContext(ctx, playlistId, itemId)
+		if err != nil {
+			return err
+		}
+
+		removed, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return ErrItemNotFound
+		}
+		return nil
+	})
+}
+
+// Marks the playlist as updated, which also locks it against concurrent changes of its items.
+// Returns [ErrPlaylistNotFound] if there's no such playlist
+func (q *Queries) touchPlaylist(ctx context.Context, playlistId int64) error {
+	result, err := q.stmt(ctx, "TouchPlaylist").ExecContext(ctx, playlistId)
+	if err != nil {
+		return err
+	}
+	return playlistAffectedErr(result)
+}
+
+// Returns rank that puts an item at the position among the other items of the playlist,
+// renumbering the playlist if neighbours at the position have no free rank between them.
+// itemId is the item being moved, 0 for new items
+func (q *Queries) freeRank(ctx context.Context, playlistId int64, itemId int64, position int) (int64, error) {
+	prev, next, err := q.neighbourRanks(ctx, playlistId, itemId, position)
+	if err != nil {
+		return 0, err
+	}
+
+	rank, ok := rankBetween(prev, next)
+	if ok {
+		return rank, nil
+	}
+
+	_, err = q.stmt(ctx, "RenumberPlaylist").ExecContext(ctx, playlistId, rankGap)
+	if err != nil {
+		return 0, err
+	}
+
+	prev, next, err = q.neighbourRanks(ctx, playlistId, itemId, position)
+	if err != nil {
+		return 0, err
+	}
+	rank, _ = rankBetween(prev, next)
+	return rank, nil
+}
+
+// Returns ranks of items that would come before and after an item put at the position,
+// nil when there's no such item. Item being moved is not counted
+func (q *Queries) neighbourRanks(ctx context.Context, playlistId int64, itemId int64, position int) (prev, next *int64, err error) {
+	var ranks []int64
+	if position > 0 {
+		// the first item is at offset 0, so for position 1 only the next item is read
+		offset, limit := max(position-2, 0), min(position, 2)
+
+		rows, err := q.stmt(ctx, "GetRanksAt").QueryContext(ctx, playlistId, itemId, offset, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var rank int64
+			err := rows.Scan(&rank)
+			if err != nil {
+				return nil, nil, err
+			}
+			ranks = append(ranks, rank)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch {
+	case position == 1 && len(ranks) > 0:
+		return nil, &ranks[0], nil
+	case position == 1:
+		return nil, nil, nil
+	case len(ranks) == 2:
+		return &ranks[0], &ranks[1], nil
+	}
+
+	// appending or the position is past the end
+	var last sql.NullInt64
+	err = q.stmt(ctx, "GetLastRank").QueryRowContext(ctx, playlistId, itemId).Scan(&last)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !last.Valid {
+		return nil, nil, nil
+	}
+	return &last.Int64, nil, nil
+}
+
+// Applies the delete policy to playlists of a song being deleted
+func (q *Queries) detachFromPlaylists(ctx context.Context, songId int64) error {
+	if q.deletePolicy == DeletePolicyRestrict {
+		var listed bool
+		err := q.stmt(ctx, "isSongInPlaylist").QueryRowContext(ctx, songId).Scan(&listed)
+		if err != nil {
+			return err
+		}
+		if listed {
+			return ErrSongInPlaylist
+		}
+		return nil
+	}
+
+	_, err := q.stmt(ctx, "RemoveSongFromPlaylists").ExecContext(ctx, songId)
+	return err
+}
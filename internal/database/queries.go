@@ -3,62 +3,448 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
 	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
 )
 
 type Queries struct {
 	db       *sql.DB
+	tx       *sql.Tx              // Set when queries are bound to a transaction, see [Queries.WithTx]
 	prepared map[string]*sql.Stmt // A map of prepared statements for use in database package functions
+	timeout  time.Duration        // Time limit applied to every query on top of the caller's context
+
+	deletePolicy string // What DeleteSong does to playlists of the song, see [DeletePolicyCascade]
 }
 
 // Maps function names of database package to respective queries,
 // used for preparing statements when Queries gets initialized
 var queryMap = map[string]string{
 	"AddSong": `
-		INSERT INTO music_library (group_name, song_name)
-		VALUES ($1, $2)
-		ON CONFLICT DO NOTHING
-		RETURNING song_id`,
+		WITH inserted AS (
+			INSERT INTO music_library (group_name, song_name)
+			VALUES ($1, $2)
+			ON CONFLICT ON CONSTRAINT unique_group_song_combination DO NOTHING
+			RETURNING song_id),
+		enqueued AS (
+			INSERT INTO enrichment_jobs (song_id)
+			SELECT song_id FROM inserted)
+		SELECT song_id FROM inserted`,
+	"UpsertSong": `
+		WITH upserted AS (
+			INSERT INTO music_library (group_name, song_name, release_date, song_lyrics, link, enrichment_status)
+			VALUES ($1, $2, $3, $4, $5, 'enriched')
+			ON CONFLICT ON CONSTRAINT unique_group_song_combination DO UPDATE
+			SET release_date=excluded.release_date, song_lyrics=excluded.song_lyrics, link=excluded.link,
+				enrichment_status='enriched'
+			WHERE music_library.deleted_at IS NULL
+			RETURNING song_id, xmax=0 AS created),
+		dequeued AS (
+			DELETE FROM enrichment_jobs
+			WHERE song_id IN (SELECT song_id FROM upserted WHERE NOT created))
+		SELECT song_id, created FROM upserted`,
 	"UpdateSongInfo": `
-		UPDATE music_library
-		SET release_date=$2, song_lyrics=$3, link=$4
-		WHERE song_id=$1`,
+		WITH updated AS (
+			UPDATE music_library
+			SET release_date=$2, song_lyrics=$3, link=$4, enrichment_status='enriched'
+			WHERE song_id=$1 AND deleted_at IS NULL AND ($5=0 OR version=$5)
+			RETURNING song_id),
+		dequeued AS (
+			DELETE FROM enrichment_jobs
+			WHERE song_id IN (SELECT song_id FROM updated))
+		SELECT count(*) FROM updated`,
+	"FindSong": `
+		SELECT song_id, deleted_at IS NOT NULL FROM music_library
+		WHERE group_name=$1 AND song_name=$2`,
+	"PatchSong": `
+		WITH updated AS (
+			UPDATE music_library
+			SET group_name=CASE WHEN $2 THEN $3 ELSE group_name END,
+				song_name=CASE WHEN $4 THEN $5 ELSE song_name END,
+				release_date=CASE WHEN $6 THEN $7::date ELSE release_date END,
+				song_lyrics=CASE WHEN $8 THEN $9 ELSE song_lyrics END,
+				link=CASE WHEN $10 THEN $11 ELSE link END,
+				enrichment_status=CASE WHEN $12 THEN 'enriched' ELSE enrichment_status END
+			WHERE song_id=$1 AND deleted_at IS NULL AND ($13=0 OR version=$13)
+			RETURNING song_id),
+		dequeued AS (
+			DELETE FROM enrichment_jobs
+			WHERE $12 AND song_id IN (SELECT song_id FROM updated))
+		SELECT count(*) FROM updated`,
+	"ImportSongs": `
+		WITH input AS (
+			SELECT * FROM unnest($1::text[], $2::text[], $3::date[], $4::text[], $5::text[])
+			WITH ORDINALITY AS i(group_name, song_name, release_date, song_lyrics, link, n)),
+		existing AS (
+			SELECT i.n, m.song_id, m.deleted_at IS NOT NULL AS trashed
+			FROM input AS i
+			JOIN music_library AS m ON m.group_name=i.group_name AND m.song_name=i.song_name),
+		inserted AS (
+			INSERT INTO music_library (group_name, song_name, release_date, song_lyrics, link, enrichment_status)
+			SELECT group_name, song_name, release_date, song_lyrics, link,
+				CASE WHEN link IS NULL THEN 'pending' ELSE 'enriched' END
+			FROM input
+			WHERE n NOT IN (SELECT n FROM existing)
+			ORDER BY n
+			ON CONFLICT ON CONSTRAINT unique_group_song_combination DO NOTHING
+			RETURNING song_id, group_name, song_name, enrichment_status),
+		updated AS (
+			UPDATE music_library AS m
+			SET release_date=i.release_date, song_lyrics=i.song_lyrics, link=i.link, enrichment_status='enriched'
+			FROM input AS i
+			JOIN existing AS e ON e.n=i.n
+			WHERE $6::boolean AND i.link IS NOT NULL AND NOT e.trashed AND m.song_id=e.song_id
+			RETURNING m.song_id),
+		enqueued AS (
+			INSERT INTO enrichment_jobs (song_id)
+			SELECT song_id FROM inserted WHERE enrichment_status='pending'),
+		dequeued AS (
+			DELETE FROM enrichment_jobs
+			WHERE song_id IN (SELECT song_id FROM updated))
+		SELECT coalesce(ins.song_id, e.song_id, 0), ins.song_id IS NOT NULL,
+			coalesce(e.song_id IN (SELECT song_id FROM updated), false), coalesce(e.trashed, false)
+		FROM input AS i
+		LEFT JOIN inserted AS ins ON ins.group_name=i.group_name AND ins.song_name=i.song_name
+		LEFT JOIN existing AS e ON e.n=i.n
+		ORDER BY i.n`,
+	"setActor": `
+		SELECT set_config('music_library.actor', $1, true)`,
 	"isSongIdPresent": `
 		SELECT EXISTS(
 			SELECT 1 FROM music_library
-			WHERE song_id=$1)`,
-	"getSongId": `
-		SELECT song_id FROM music_library
-		WHERE group_name=$1 AND song_name=$2`,
+			WHERE song_id=$1 AND deleted_at IS NULL)`,
 	"DeleteSong": `
+		UPDATE music_library
+		SET deleted_at=now()
+		WHERE song_id=$1 AND deleted_at IS NULL AND ($2=0 OR version=$2)`,
+	"RestoreSong": `
+		UPDATE music_library
+		SET deleted_at=NULL
+		WHERE song_id=$1 AND deleted_at IS NOT NULL`,
+	"PurgeSong": `
 		DELETE FROM music_library
-		WHERE song_id=$1`,
-	"GetLyrics": `
-		SELECT song_lyrics FROM music_library
-		WHERE song_id=$1`,
-	"GetFilteredList": `
+		WHERE song_id=$1 AND deleted_at IS NOT NULL`,
+	"PurgeTrash": `
+		DELETE FROM music_library
+		WHERE song_id IN (
+			SELECT song_id FROM music_library
+			WHERE deleted_at<$1
+			ORDER BY deleted_at ASC
+			LIMIT $2)`,
+	"GetSong": `
 		SELECT song_id,
+			group_id,
 			group_name,
 			song_name,
 			release_date,
 			song_lyrics,
-			link
+			link,
+			enrichment_status,
+			updated_at,
+			version
 		FROM music_library
-		WHERE (group_name ILIKE '%' || $1 || '%' OR $1 IS NULL)
-		AND (song_name ILIKE '%' || $2 || '%' OR $2 IS NULL)
-		AND (release_date>=$3 OR $3 IS NULL)
-		AND (release_date<=$4 OR $4 IS NULL)
-		AND (song_lyrics ILIKE '%' || $5 || '%' OR $5 IS NULL)
-		ORDER BY song_id ASC
-		LIMIT $6 OFFSET $7`,
+		WHERE song_id=$1 AND deleted_at IS NULL`,
+	"GetLyrics": `
+		SELECT song_lyrics FROM music_library
+		WHERE song_id=$1 AND deleted_at IS NULL`,
+	"AddGroup": `
+		INSERT INTO groups (group_name)
+		VALUES ($1)
+		ON CONFLICT ON CONSTRAINT unique_group_name DO NOTHING
+		RETURNING group_id`,
+	"GetGroup": `
+		SELECT group_id,
+			group_name,
+			created_at,
+			(SELECT count(*) FROM music_library AS m WHERE m.group_id=g.group_id AND m.deleted_at IS NULL)
+		FROM groups AS g
+		WHERE group_id=$1`,
+	"RenameGroup": `
+		UPDATE groups
+		SET group_name=$2
+		WHERE group_id=$1`,
+	"DeleteGroup": `
+		DELETE FROM groups
+		WHERE group_id=$1
+		AND NOT EXISTS(SELECT 1 FROM music_library WHERE group_id=$1)
+		AND NOT EXISTS(SELECT 1 FROM albums WHERE group_id=$1)`,
+	"LockGroups": `
+		WITH locked AS (
+			SELECT group_id FROM groups
+			WHERE group_id=ANY($1)
+			FOR UPDATE)
+		SELECT count(*) FROM locked`,
+	"MoveGroupSongs": `
+		UPDATE music_library
+		SET group_id=$1
+		WHERE group_id=ANY($2)`,
+	"DeleteGroups": `
+		DELETE FROM groups
+		WHERE group_id=ANY($1)`,
+	"AddAlbum": `
+		INSERT INTO albums (group_id, title, release_date, cover_link)
+		SELECT group_id, $2::text, $3::date, $4::text FROM groups
+		WHERE group_id=$1
+		ON CONFLICT ON CONSTRAINT unique_group_album_title DO NOTHING
+		RETURNING album_id`,
+	"GetAlbum": `
+		SELECT a.album_id,
+			a.group_id,
+			g.group_name,
+			a.title,
+			a.release_date,
+			a.cover_link,
+			a.created_at
+		FROM albums AS a
+		JOIN groups AS g ON g.group_id=a.group_id
+		WHERE a.album_id=$1`,
+	"DeleteAlbum": `
+		DELETE FROM albums
+		WHERE album_id=$1`,
+	"GetAlbumTracks": `
+		SELECT t.number,
+			m.song_id,
+			m.group_id,
+			m.group_name,
+			m.song_name,
+			m.release_date,
+			m.song_lyrics,
+			m.link,
+			m.enrichment_status,
+			m.updated_at,
+			m.version
+		FROM (
+			SELECT song_id, row_number() OVER (ORDER BY position) AS number
+			FROM album_tracks
+			WHERE album_id=$1) AS t
+		JOIN music_library AS m ON m.song_id=t.song_id
+		WHERE m.deleted_at IS NULL
+		ORDER BY t.number`,
+	"LockAlbum": `
+		SELECT album_id FROM albums
+		WHERE album_id=$1
+		FOR UPDATE`,
+	"GetTrackOrder": `
+		SELECT t.song_id, m.deleted_at IS NOT NULL
+		FROM album_tracks AS t
+		JOIN music_library AS m ON m.song_id=t.song_id
+		WHERE t.album_id=$1
+		ORDER BY t.position`,
+	"WriteTrackOrder": `
+		WITH removed AS (
+			DELETE FROM album_tracks
+			WHERE album_id=$1 AND song_id <> ALL($2::bigint[]))
+		INSERT INTO album_tracks (album_id, song_id, position)
+		SELECT $1::bigint, song_id, number
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS track(song_id, number)
+		ON CONFLICT (album_id, song_id) DO UPDATE SET position=EXCLUDED.position`,
+	"MoveGroupAlbums": `
+		UPDATE albums
+		SET group_id=$1
+		WHERE group_id=ANY($2)`,
+	"AddPlaylist": `
+		INSERT INTO playlists (owner, name, description)
+		VALUES ($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT unique_owner_playlist_name DO NOTHING
+		RETURNING playlist_id`,
+	"GetPlaylist": `
+		SELECT playlist_id,
+			owner,
+			name,
+			description,
+			created_at,
+			updated_at,
+			(SELECT count(*) FROM playlist_items AS i WHERE i.playlist_id=p.playlist_id)
+		FROM playlists AS p
+		WHERE playlist_id=$1`,
+	"GetPlaylists": `
+		SELECT playlist_id,
+			owner,
+			name,
+			description,
+			created_at,
+			updated_at,
+			(SELECT count(*) FROM playlist_items AS i WHERE i.playlist_id=p.playlist_id)
+		FROM playlists AS p
+		WHERE $1='' OR owner=$1
+		ORDER BY owner, name, playlist_id
+		LIMIT $2 OFFSET $3`,
+	"UpdatePlaylist": `
+		UPDATE playlists
+		SET name=$2, description=$3, updated_at=now()
+		WHERE playlist_id=$1`,
+	"DeletePlaylist": `
+		DELETE FROM playlists
+		WHERE playlist_id=$1`,
+	"TouchPlaylist": `
+		UPDATE playlists
+		SET updated_at=now()
+		WHERE playlist_id=$1`,
+	"GetPlaylistItems": `
+		SELECT i.item_id,
+			row_number() OVER (ORDER BY i.rank),
+			i.added_at,
+			m.song_id,
+			m.group_id,
+			m.group_name,
+			m.song_name,
+			m.release_date,
+			m.song_lyrics,
+			m.link,
+			m.enrichment_status,
+			m.updated_at,
+			m.version
+		FROM playlist_items AS i
+		JOIN music_library AS m ON m.song_id=i.song_id
+		WHERE i.playlist_id=$1 AND m.deleted_at IS NULL
+		ORDER BY i.rank`,
+	"AddPlaylistItem": `
+		INSERT INTO playlist_items (playlist_id, song_id, rank)
+		VALUES ($1, $2, $3)
+		RETURNING item_id`,
+	"isItemPresent": `
+		SELECT EXISTS(
+			SELECT 1 FROM playlist_items
+			WHERE playlist_id=$1 AND item_id=$2)`,
+	"SetItemRank": `
+		UPDATE playlist_items
+		SET rank=$2
+		WHERE item_id=$1`,
+	"RemovePlaylistItem": `
+		DELETE FROM playlist_items
+		WHERE playlist_id=$1 AND item_id=$2`,
+	"GetRanksAt": `
+		SELECT rank FROM playlist_items
+		WHERE playlist_id=$1 AND item_id<>$2
+		ORDER BY rank
+		OFFSET $3 LIMIT $4`,
+	"GetLastRank": `
+		SELECT max(rank) FROM playlist_items
+		WHERE playlist_id=$1 AND item_id<>$2`,
+	"RenumberPlaylist": `
+		UPDATE playlist_items AS i
+		SET rank=r.number*$2
+		FROM (
+			SELECT item_id, row_number() OVER (ORDER BY rank) AS number
+			FROM playlist_items
+			WHERE playlist_id=$1) AS r
+		WHERE i.item_id=r.item_id`,
+	"isSongInPlaylist": `
+		SELECT EXISTS(
+			SELECT 1 FROM playlist_items
+			WHERE song_id=$1)`,
+	"RemoveSongFromPlaylists": `
+		DELETE FROM playlist_items
+		WHERE song_id=$1`,
+	"GetTags": `
+		SELECT t.tag_id,
+			t.kind,
+			t.name,
+			(SELECT count(*) FROM song_tags AS st
+				JOIN music_library AS m ON m.song_id=st.song_id
+				WHERE st.tag_id=t.tag_id AND m.deleted_at IS NULL) AS song_count
+		FROM tags AS t
+		WHERE $1='' OR t.kind=$1
+		ORDER BY song_count DESC, t.kind, t.name
+		LIMIT $2 OFFSET $3`,
+	"GetSongTags": `
+		SELECT t.tag_id, t.kind, t.name
+		FROM song_tags AS st
+		JOIN tags AS t ON t.tag_id=st.tag_id
+		WHERE st.song_id=$1
+		ORDER BY t.kind, t.name`,
+	"AddTags": `
+		INSERT INTO tags (kind, name)
+		SELECT * FROM unnest($1::text[], $2::text[])
+		ON CONFLICT ON CONSTRAINT unique_tag_kind_name DO NOTHING`,
+	"AttachTags": `
+		INSERT INTO song_tags (song_id, tag_id)
+		SELECT $1::integer, t.tag_id
+		FROM tags AS t
+		JOIN unnest($2::text[], $3::text[]) AS f(kind, name) ON t.kind=f.kind AND t.name=f.name
+		ON CONFLICT (song_id, tag_id) DO NOTHING`,
+	"DetachTag": `
+		DELETE FROM song_tags
+		WHERE song_id=$1 AND tag_id=$2`,
+	"EstimateSongCount": `
+		SELECT
+			(SELECT reltuples FROM pg_class WHERE oid='music_library'::regclass)::float8,
+			(SELECT reltuples FROM pg_class WHERE oid='music_library_deleted_at_idx'::regclass)::float8`,
+	"ClaimEnrichmentJobs": `
+		WITH claimed AS (
+			SELECT job_id FROM enrichment_jobs
+			WHERE run_after<=now() AND (locked_until IS NULL OR locked_until<now())
+			AND song_id NOT IN (SELECT song_id FROM music_library WHERE deleted_at IS NOT NULL)
+			ORDER BY run_after ASC
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		UPDATE enrichment_jobs AS j
+		SET attempts=j.attempts+1, locked_until=now() + $2 * interval '1 millisecond'
+		FROM claimed, music_library AS m
+		WHERE j.job_id=claimed.job_id AND m.song_id=j.song_id
+		RETURNING j.job_id, j.song_id, m.group_name, m.song_name, j.attempts`,
+	"RetryEnrichmentJob": `
+		UPDATE enrichment_jobs
+		SET run_after=$2, locked_until=NULL, last_error=$3
+		WHERE job_id=$1`,
+	"FailEnrichmentJob": `
+		WITH failed AS (
+			DELETE FROM enrichment_jobs
+			WHERE job_id=$1
+			RETURNING song_id)
+		UPDATE music_library
+		SET enrichment_status='failed'
+		WHERE song_id IN (SELECT song_id FROM failed)`,
+	"IsEnrichmentJobCurrent": `
+		SELECT j.job_id FROM enrichment_jobs AS j
+		JOIN music_library AS m ON m.song_id=j.song_id
+		WHERE j.job_id=$1 AND m.group_name=$2 AND m.song_name=$3
+		FOR UPDATE`,
+	"GetSongHistory": `
+		SELECT revision_id, song_id, version, operation, actor, changed_at, old_values, new_values
+		FROM song_history
+		WHERE song_id=$1
+		ORDER BY revision_id ASC
+		LIMIT $2 OFFSET $3`,
+	"GetRevision": `
+		SELECT revision_id, song_id, version, operation, actor, changed_at, old_values, new_values
+		FROM song_history
+		WHERE revision_id=$1`,
+	"GetIdempotentResponse": `
+		SELECT idempotency_key, fingerprint, status_code, response_body, expires_at
+		FROM idempotency_keys
+		WHERE idempotency_key=$1 AND expires_at>now() AND status_code IS NOT NULL`,
+	"ClaimIdempotencyKey": `
+		INSERT INTO idempotency_keys (idempotency_key, fingerprint, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET fingerprint=excluded.fingerprint, status_code=NULL, response_body=NULL,
+			created_at=now(), expires_at=excluded.expires_at
+		WHERE idempotency_keys.expires_at<=now()`,
+	"CompleteIdempotencyKey": `
+		UPDATE idempotency_keys
+		SET status_code=$2, response_body=$3
+		WHERE idempotency_key=$1`,
+	"PurgeIdempotencyKeys": `
+		DELETE FROM idempotency_keys
+		WHERE idempotency_key IN (
+			SELECT idempotency_key FROM idempotency_keys
+			WHERE expires_at<$1
+			ORDER BY expires_at ASC
+			LIMIT $2)`,
 }
 
 // Prepares statements from pre-written queries
-func NewQueries(db *sql.DB) (*Queries, error) {
-	var err error
+func NewQueries(db *sql.DB, cfg config.Config) (*Queries, error) {
+	err := validateDeletePolicy(cfg.PlaylistSongDeletePolicy)
+	if err != nil {
+		return nil, err
+	}
+
 	prepared := make(map[string]*sql.Stmt)
 	for functionName, query := range queryMap {
 		prepared[functionName], err = db.Prepare(query)
@@ -67,193 +453,381 @@ func NewQueries(db *sql.DB) (*Queries, error) {
 		}
 	}
 
-	return &Queries{db: db, prepared: prepared}, nil
+	return &Queries{
+		db:           db,
+		prepared:     prepared,
+		timeout:      cfg.DBQueryTimeout,
+		deletePolicy: cfg.PlaylistSongDeletePolicy,
+	}, nil
 }
 
-// Returns [ErrSongAlreadyExists] if song already in the database
-func (q *Queries) AddSong(song *models.BasicSongInfo) error {
-	err := q.getSongId(song)
-	if err != ErrSongNotFound {
-		return ErrSongAlreadyExists
+// Bounds ctx with the query timeout, returned func must be deferred with the method's error,
+// it replaces context errors with [ErrQueryTimeout] or [ErrQueryCanceled] and releases ctx
+func (q *Queries) withTimeout(ctx context.Context) (context.Context, func(*error)) {
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	return ctx, func(err *error) {
+		*err = contextErr(ctx, *err)
+		cancel()
 	}
-	args := []any{song.GroupName, song.SongName}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	err = q.prepared["AddSong"].QueryRowContext(ctx, args...).Scan(&song.Id)
-	return err
 }
 
-// Returns [ErrSongNotFound] if there's no matching song in the database
-func (q *Queries) UpdateSongInfo(songId int64, info *models.AdditionalSongInfo) error {
-	exists, err := q.isSongIdPresent(songId)
-	if err != nil {
-		return err
-	}
-	if !exists {
-		return ErrSongNotFound
+// Returns prepared statement by its function name, bound to the transaction if there is one
+func (q *Queries) stmt(ctx context.Context, name string) *sql.Stmt {
+	if q.tx != nil {
+		return q.tx.StmtContext(ctx, q.prepared[name])
 	}
+	return q.prepared[name]
+}
 
-	args := []any{songId, info.ReleaseDate, info.SongLyrics, info.Link}
+// Runs query built at runtime, e.g. by [buildListQuery], in the transaction if there is one
+func (q *Queries) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if q.tx != nil {
+		return q.tx.QueryContext(ctx, query, args...)
+	}
+	return q.db.QueryContext(ctx, query, args...)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+// Same as [Queries.query] for queries returning a single row
+func (q *Queries) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	if q.tx != nil {
+		return q.tx.QueryRowContext(ctx, query, args...)
+	}
+	return q.db.QueryRowContext(ctx, query, args...)
+}
 
-	_, err = q.prepared["UpdateSongInfo"].ExecContext(ctx, args...)
-	return err
+// Runs fn inside a transaction, queries passed to fn are bound to it.
+// Transaction is committed if fn returns nil and rolled back otherwise,
+// calls on queries that are already bound to a transaction run fn in a savepoint of it
+func (q *Queries) WithTx(ctx context.Context, fn func(tx SongStore) error) error {
+	if q.tx != nil {
+		return q.inSavepoint(ctx, func() error { return fn(q) })
+	}
+	return q.inTx(ctx, func(tx *Queries) error { return fn(tx) })
 }
 
-// Returns whether a song with given id exists in the database
-func (q *Queries) isSongIdPresent(songId int64) (bool, error) {
-	if songId == 0 {
-		return false, nil
+// Rolls the transaction back to the state before fn if fn returns an error.
+// Savepoints of nested calls share the name, postgres resolves it to the latest one
+func (q *Queries) inSavepoint(ctx context.Context, fn func() error) error {
+	_, err := q.tx.ExecContext(ctx, "SAVEPOINT nested_tx")
+	if err != nil {
+		return contextErr(ctx, err)
 	}
 
-	args := []any{songId}
+	err = fn()
+	if err != nil {
+		_, rollbackErr := q.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT nested_tx")
+		if rollbackErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %s)", err, rollbackErr)
+		}
+		_, rollbackErr = q.tx.ExecContext(ctx, "RELEASE SAVEPOINT nested_tx")
+		if rollbackErr != nil {
+			return fmt.Errorf("%w (release of savepoint failed: %s)", err, rollbackErr)
+		}
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	_, err = q.tx.ExecContext(ctx, "RELEASE SAVEPOINT nested_tx")
+	return contextErr(ctx, err)
+}
 
-	exists := false
-	err := q.prepared["isSongIdPresent"].QueryRowContext(ctx, args...).Scan(&exists)
-	return exists, err
+// Runs fn inside a transaction where song history records changes as made by actor from ctx
+func (q *Queries) asActor(ctx context.Context, fn func(tx *Queries) error) error {
+	return q.inTx(ctx, func(tx *Queries) error {
+		_, err := tx.stmt(ctx, "setActor").ExecContext(ctx, ActorFrom(ctx))
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	})
 }
 
-// Returns [ErrSongNotFound] if song is not present in the database,
-// first checks id, then names,
-// writes song_id into song.Id
-func (q *Queries) getSongId(song *models.BasicSongInfo) error {
-	exists, err := q.isSongIdPresent(song.Id)
+func (q *Queries) inTx(ctx context.Context, fn func(tx *Queries) error) error {
+	if q.tx != nil {
+		return fn(q)
+	}
+
+	tx, err := q.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return contextErr(ctx, err)
 	}
-	if exists {
-		return nil
+
+	err = fn(&Queries{db: q.db, tx: tx, prepared: q.prepared, timeout: q.timeout, deletePolicy: q.deletePolicy})
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			return fmt.Errorf("%w (rollback failed: %s)", err, rollbackErr)
+		}
+		return err
 	}
 
+	return contextErr(ctx, tx.Commit())
+}
+
+// Writes song_id into song.Id and queues enrichment job for the song.
+// Returns [ErrSongAlreadyExists] if song already in the database, [ErrSongTrashed] if it's in trash,
+// concurrent inserts of the same song are resolved by the unique constraint
+func (q *Queries) AddSong(ctx context.Context, song *models.BasicSongInfo) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
 	args := []any{song.GroupName, song.SongName}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	return q.asActor(ctx, func(tx *Queries) error {
+		err := tx.stmt(ctx, "AddSong").QueryRowContext(ctx, args...).Scan(&song.Id)
+		if err == sql.ErrNoRows {
+			_, err = tx.FindSong(ctx, song.GroupName, song.SongName)
+			if err == ErrSongTrashed {
+				return err
+			}
+			return ErrSongAlreadyExists
+		}
+		return err
+	})
+}
+
+// Returns [ErrSongNotFound] if there's no song with given names in the database,
+// [ErrSongTrashed] along with its id if the song is in trash
+func (q *Queries) FindSong(ctx context.Context, groupName, songName string) (_ int64, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
 
-	err = q.prepared["getSongId"].QueryRowContext(ctx, args...).Scan(&song.Id)
+	args := []any{groupName, songName}
+
+	var (
+		songId  int64
+		trashed bool
+	)
+	err = q.stmt(ctx, "FindSong").QueryRowContext(ctx, args...).Scan(&songId, &trashed)
 	if err == sql.ErrNoRows {
-		return ErrSongNotFound
+		return 0, ErrSongNotFound
 	}
-
-	return nil
+	if err == nil && trashed {
+		return songId, ErrSongTrashed
+	}
+	return songId, err
 }
 
-// Returns [ErrSongNotFound] if there's no song in the database
-func (q *Queries) DeleteSong(songId int64) error {
-	exists, err := q.isSongIdPresent(songId)
-	if err != nil {
+// Adds song with its details or replaces details of the existing song in a single statement,
+// so concurrent upserts of the same song don't conflict. Writes song_id into song.Id and reports whether it was created.
+// Returns [ErrSongTrashed] if the song is in trash
+func (q *Queries) UpsertSong(ctx context.Context, song *models.BasicSongInfo, info *models.AdditionalSongInfo) (_ bool, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{song.GroupName, song.SongName, info.ReleaseDate, info.SongLyrics, info.Link}
+
+	var created bool
+	err = q.asActor(ctx, func(tx *Queries) error {
+		err := tx.stmt(ctx, "UpsertSong").QueryRowContext(ctx, args...).Scan(&song.Id, &created)
+		if err == sql.ErrNoRows {
+			// conflicting song is in trash, so it wasn't updated
+			return ErrSongTrashed
+		}
 		return err
-	}
-	if !exists {
-		return ErrSongNotFound
-	}
+	})
+	return created, err
+}
 
-	args := []any{songId}
+// Marks song as enriched, dropping its pending enrichment job.
+// If ifVersion isn't 0, song is only updated if its version still matches.
+// Returns [ErrSongNotFound] if there's no matching song in the database,
+// [ErrVersionMismatch] if song's version differs from ifVersion
+func (q *Queries) UpdateSongInfo(ctx context.Context, songId int64, info *models.AdditionalSongInfo, ifVersion int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	args := []any{songId, info.ReleaseDate, info.SongLyrics, info.Link, ifVersion}
 
-	_, err = q.prepared["DeleteSong"].ExecContext(ctx, args...)
-	return err
+	return q.asActor(ctx, func(tx *Queries) error {
+		var updated int64
+		err := tx.stmt(ctx, "UpdateSongInfo").QueryRowContext(ctx, args...).Scan(&updated)
+		if err != nil {
+			return err
+		}
+
+		return tx.compareAndSwapErr(ctx, songId, updated)
+	})
 }
 
-// Writes lyrics into [info.SongLyrics].
-// Returns [ErrSongNotFound] if there's no song in the database.
-// Returns [ErrSongHasNoLyrics] if no lyrics were provided.
-func (q *Queries) GetLyrics(songId int64, lyrics *string) error {
-	exists, err := q.isSongIdPresent(songId)
+// Explains why a conditional write changed no rows:
+// [ErrSongNotFound] if the song is missing, [ErrVersionMismatch] otherwise
+func (q *Queries) compareAndSwapErr(ctx context.Context, songId int64, affected int64) error {
+	if affected > 0 {
+		return nil
+	}
+
+	exists, err := q.isSongIdPresent(ctx, songId)
 	if err != nil {
 		return err
 	}
 	if !exists {
 		return ErrSongNotFound
 	}
+	return ErrVersionMismatch
+}
 
-	args := []any{songId}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	var lyricsOrNull sql.NullString
-	err = q.prepared["GetLyrics"].QueryRowContext(ctx, args...).Scan(&lyricsOrNull)
-	if err != nil {
-		return err
+// Changes only the fields set in patch, if patch contains details
+// song is marked as enriched and its pending enrichment job is dropped.
+// If ifVersion isn't 0, song is only updated if its version still matches.
+// Returns [ErrSongNotFound] if there's no matching song in the database,
+// [ErrSongAlreadyExists] if new group/song names are taken by another song,
+// [ErrVersionMismatch] if song's version differs from ifVersion
+func (q *Queries) PatchSong(ctx context.Context, songId int64, patch *models.SongPatch, ifVersion int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	var (
+		groupName   sql.NullString
+		songName    sql.NullString
+		releaseDate sql.NullTime
+		lyrics      sql.NullString
+		link        sql.NullString
+	)
+	if patch.GroupName != nil {
+		groupName = sql.NullString{String: *patch.GroupName, Valid: true}
+	}
+	if patch.SongName != nil {
+		songName = sql.NullString{String: *patch.SongName, Valid: true}
+	}
+	if patch.ReleaseDate != nil {
+		releaseDate = *patch.ReleaseDate
+	}
+	if patch.SongLyrics != nil {
+		lyrics = *patch.SongLyrics
+	}
+	if patch.Link != nil {
+		link = *patch.Link
 	}
 
-	if !lyricsOrNull.Valid {
-		return ErrSongHasNoLyrics
+	args := []any{songId,
+		patch.GroupName != nil, groupName,
+		patch.SongName != nil, songName,
+		patch.ReleaseDate != nil, releaseDate,
+		patch.SongLyrics != nil, lyrics,
+		patch.Link != nil, link,
+		patch.HasDetails(),
+		ifVersion,
 	}
-	*lyrics = lyricsOrNull.String
-	return nil
+
+	return q.asActor(ctx, func(tx *Queries) error {
+		var updated int64
+		err := tx.stmt(ctx, "PatchSong").QueryRowContext(ctx, args...).Scan(&updated)
+		if isSongConflict(err) {
+			return ErrSongAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.compareAndSwapErr(ctx, songId, updated)
+	})
 }
 
-type ListFilter struct {
-	GroupName             sql.NullString
-	SongName              sql.NullString
-	ReleaseDateLowerBound sql.NullTime
-	ReleaseDateUpperBound sql.NullTime
-	Lyrics                sql.NullString
-	Limit                 int32
-	Offset                int32
+// Reports whether err is a violation of the unique group/song names constraint
+func isSongConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) &&
+		pqErr.Code == "23505" && pqErr.Constraint == "unique_group_song_combination"
 }
 
-func (q *Queries) GetFilteredList(filter *ListFilter) ([]models.FullSongInfo, error) {
-	args := []any{
-		filter.GroupName,
-		filter.SongName,
-		filter.ReleaseDateLowerBound,
-		filter.ReleaseDateUpperBound,
-		filter.Lyrics,
-		filter.Limit,
-		filter.Offset,
+// Returns whether a song with given id exists in the database
+func (q *Queries) isSongIdPresent(ctx context.Context, songId int64) (bool, error) {
+	if songId == 0 {
+		return false, nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
+	args := []any{songId}
 
-	rows, err := q.prepared["GetFilteredList"].QueryContext(ctx, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	exists := false
+	err := q.stmt(ctx, "isSongIdPresent").QueryRowContext(ctx, args...).Scan(&exists)
+	return exists, err
+}
 
-	var result []models.FullSongInfo
+// Moves song to trash, see [Trash], and applies the delete policy to its playlists.
+// If ifVersion isn't 0, song is only deleted if its version still matches.
+// Returns [ErrSongNotFound] if there's no song in the database,
+// [ErrVersionMismatch] if song's version differs from ifVersion,
+// [ErrSongInPlaylist] if the song is in a playlist and the policy is [DeletePolicyRestrict]
+func (q *Queries) DeleteSong(ctx context.Context, songId int64, ifVersion int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
 
-	for rows.Next() {
-		var row models.FullSongInfo
+	args := []any{songId, ifVersion}
 
-		err := rows.Scan(
-			&row.Id,
-			&row.GroupName,
-			&row.SongName,
-			&row.ReleaseDate,
-			&row.SongLyrics,
-			&row.Link,
-		)
+	return q.asActor(ctx, func(tx *Queries) error {
+		result, err := tx.stmt(ctx, "DeleteSong").ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
 
+		deleted, err := result.RowsAffected()
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if deleted > 0 {
+			return tx.detachFromPlaylists(ctx, songId)
 		}
 
-		result = append(result, row)
-	}
+		return tx.compareAndSwapErr(ctx, songId, deleted)
+	})
+}
 
-	err = rows.Close()
+// Returns [ErrSongNotFound] if there's no song in the database
+func (q *Queries) GetSong(ctx context.Context, songId int64) (_ *models.FullSongInfo, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{songId}
+
+	var song models.FullSongInfo
+	err = q.stmt(ctx, "GetSong").QueryRowContext(ctx, args...).Scan(
+		&song.Id,
+		&song.GroupId,
+		&song.GroupName,
+		&song.SongName,
+		&song.ReleaseDate,
+		&song.SongLyrics,
+		&song.Link,
+		&song.EnrichmentStatus,
+		&song.UpdatedAt,
+		&song.Version,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrSongNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	err = rows.Err()
+	return &song, nil
+}
+
+// Writes lyrics into [info.SongLyrics].
+// Returns [ErrSongNotFound] if there's no song in the database.
+// Returns [ErrSongHasNoLyrics] if no lyrics were provided.
+func (q *Queries) GetLyrics(ctx context.Context, songId int64, lyrics *string) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	exists, err := q.isSongIdPresent(ctx, songId)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if !exists {
+		return ErrSongNotFound
 	}
 
-	return result, nil
+	args := []any{songId}
+
+	var lyricsOrNull sql.NullString
+	err = q.stmt(ctx, "GetLyrics").QueryRowContext(ctx, args...).Scan(&lyricsOrNull)
+	if err != nil {
+		return err
+	}
+
+	if !lyricsOrNull.Valid {
+		return ErrSongHasNoLyrics
+	}
+	*lyrics = lyricsOrNull.String
+	return nil
 }
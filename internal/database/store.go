@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// SongStore describes the song storage used by the handlers,
+// implemented by [Queries] (postgres) and [MemoryStore] (in-memory).
+// Every method is bound to the caller's context
+// and reports finished contexts as [ErrQueryTimeout] or [ErrQueryCanceled],
+// changes of songs are recorded in [History] as made by actor of the context, see [WithActor]
+type SongStore interface {
+	// Runs fn as a single unit of work, fn must only use the store it's given.
+	// Changes are discarded if fn returns an error, which is then returned as is.
+	// Calls on the store given to fn act as savepoints, discarding only the changes of their own fn
+	WithTx(ctx context.Context, fn func(tx SongStore) error) error
+
+	AddSong(ctx context.Context, song *models.BasicSongInfo) error
+	// Returns id of the song with given group and song names, [ErrSongNotFound] if there's none,
+	// [ErrSongTrashed] along with the id if the song is in trash
+	FindSong(ctx context.Context, groupName, songName string) (int64, error)
+	// Adds song with its details or replaces details of the existing one atomically, reports whether it was created.
+	// Returns [ErrSongTrashed] if the song is in [Trash]
+	UpsertSong(ctx context.Context, song *models.BasicSongInfo, info *models.AdditionalSongInfo) (bool, error)
+	// Writes below apply only while song's version equals ifVersion,
+	// they fail with [ErrVersionMismatch] otherwise, 0 disables the check
+	UpdateSongInfo(ctx context.Context, songId int64, info *models.AdditionalSongInfo, ifVersion int64) error
+	PatchSong(ctx context.Context, songId int64, patch *models.SongPatch, ifVersion int64) error
+	DeleteSong(ctx context.Context, songId int64, ifVersion int64) error
+
+	// Adds songs in bulk, existing songs are skipped unless update is set, then their details are replaced.
+	// Returns a result for every song in their order, songs should be distinct
+	ImportSongs(ctx context.Context, songs []models.ImportSong, update bool) ([]models.ImportResult, error)
+
+	GetSong(ctx context.Context, songId int64) (*models.FullSongInfo, error)
+	GetLyrics(ctx context.Context, songId int64, lyrics *string) error
+	GetFilteredList(ctx context.Context, filter *ListFilter) ([]models.FullSongInfo, error)
+	// Counts songs of the list ignoring its pagination, estimate allows approximate numbers for large tables.
+	// Reports whether the count is approximate
+	CountFilteredList(ctx context.Context, filter *ListFilter, estimate bool) (int64, bool, error)
+	// Calls fn for every song of the list in the list order, cursor, limit and offset of the filter are ignored.
+	// Errors returned by fn stop the export and are returned as is
+	ExportSongs(ctx context.Context, filter *ListFilter, fn func(song *models.FullSongInfo) error) error
+
+	EnrichmentQueue
+	Trash
+	History
+	Groups
+	Albums
+	Playlists
+	Tags
+	IdempotencyKeys
+}
+
+// EnrichmentQueue holds jobs for acquiring song details in background,
+// a job is queued by AddSong and dropped once UpdateSongInfo provides the details
+type EnrichmentQueue interface {
+	ClaimEnrichmentJobs(ctx context.Context, limit int, lease time.Duration) ([]models.EnrichmentJob, error)
+	RetryEnrichmentJob(ctx context.Context, jobId int64, runAfter time.Time, reason string) error
+	FailEnrichmentJob(ctx context.Context, jobId int64) error
+	// Reports whether job is still queued and its song still has the names job was claimed with,
+	// inside a transaction the song stays locked until it ends
+	IsEnrichmentJobCurrent(ctx context.Context, job models.EnrichmentJob) (bool, error)
+}
+
+var (
+	_ SongStore = (*Queries)(nil)
+	_ SongStore = (*MemoryStore)(nil)
+)
+
+// Trash holds songs removed by DeleteSong until they are restored or purged,
+// other methods of [SongStore] treat trashed songs as missing
+type Trash interface {
+	// Returns [ErrSongNotFound] if the song is not in trash
+	RestoreSong(ctx context.Context, songId int64) error
+	// Deletes trashed song permanently, returns [ErrSongNotFound] if the song is not in trash
+	PurgeSong(ctx context.Context, songId int64) error
+	// Permanently deletes up to limit songs trashed before deletedBefore, returns how many were deleted
+	PurgeTrash(ctx context.Context, deletedBefore time.Time, limit int) (int64, error)
+}
+
+// History is an append-only log of song changes, kept even after songs are purged
+type History interface {
+	// Returns revisions of the song oldest first
+	GetSongHistory(ctx context.Context, songId int64, limit, offset int32) ([]models.SongRevision, error)
+	// Returns [ErrRevisionNotFound] if there's no such revision
+	GetRevision(ctx context.Context, revisionId int64) (*models.SongRevision, error)
+}
+
+// Groups are artists songs belong to. Songs added or patched with a group name join the group of that name,
+// which is created when it doesn't exist yet
+type Groups interface {
+	// Returns [ErrGroupAlreadyExists] if the name is taken
+	AddGroup(ctx context.Context, name string) (int64, error)
+	// Returns [ErrGroupNotFound] if there's no such group
+	GetGroup(ctx context.Context, groupId int64) (*models.Group, error)
+	// Returns groups ordered by name
+	GetGroups(ctx context.Context, filter *GroupFilter) ([]models.Group, error)
+	// Renames the group in every of its songs as well.
+	// Returns [ErrGroupNotFound] if there's no such group, [ErrGroupAlreadyExists] if the name is taken
+	RenameGroup(ctx context.Context, groupId int64, name string) error
+	// Returns [ErrGroupNotFound] if there's no such group, [ErrGroupNotEmpty] if any album or song belongs to it
+	DeleteGroup(ctx context.Context, groupId int64) error
+	// Moves albums and songs of sources into the target group and deletes sources.
+	// Returns [ErrGroupNotFound] if any of the groups is missing,
+	// [ErrSongAlreadyExists] or [ErrAlbumAlreadyExists] if the target group already has a song
+	// or an album named like one being moved
+	MergeGroups(ctx context.Context, targetId int64, sourceIds []int64) error
+}
+
+// Albums list songs in order of their tracks, a song can be a track of several albums.
+// Tracks of trashed songs keep their positions but aren't listed
+type Albums interface {
+	// Writes assigned id into album.Id.
+	// Returns [ErrGroupNotFound] if there's no such group, [ErrAlbumAlreadyExists] if the title is taken
+	AddAlbum(ctx context.Context, album *models.Album) error
+	// Returns [ErrAlbumNotFound] if there's no such album
+	GetAlbum(ctx context.Context, albumId int64) (*models.Album, error)
+	// Deletes album with its tracks, songs stay in the library.
+	// Returns [ErrAlbumNotFound] if there's no such album
+	DeleteAlbum(ctx context.Context, albumId int64) error
+	// Returns tracks ordered by position, [ErrAlbumNotFound] if there's no such album
+	GetAlbumTracks(ctx context.Context, albumId int64) ([]models.AlbumTrack, error)
+	// Inserts song at the position shifting the following tracks, 0 or positions past the end append it.
+	// Returns [ErrAlbumNotFound], [ErrSongNotFound] if the album or the song is missing,
+	// [ErrTrackAlreadyExists] if the song is already on the album
+	AddAlbumTrack(ctx context.Context, albumId int64, songId int64, position int) error
+	// Puts tracks in the order of songIds, which should list every listed track once, tracks of trashed songs go last.
+	// Returns [ErrAlbumNotFound] if there's no such album, [ErrInvalidTrackOrder] if songIds don't match the tracks
+	ReorderAlbumTracks(ctx context.Context, albumId int64, songIds []int64) error
+	// Removes the track, following tracks move up.
+	// Returns [ErrAlbumNotFound] if there's no such album, [ErrTrackNotFound] if the song isn't on the album
+	RemoveAlbumTrack(ctx context.Context, albumId int64, songId int64) error
+}
+
+// Playlists are ordered song picks of users. Depending on the delete policy the store was created with,
+// DeleteSong removes the song from every playlist or fails with [ErrSongInPlaylist] while it's in any
+type Playlists interface {
+	// Writes assigned id into playlist.Id.
+	// Returns [ErrPlaylistAlreadyExists] if the owner already has a playlist with the name
+	AddPlaylist(ctx context.Context, playlist *models.Playlist) error
+	// Returns [ErrPlaylistNotFound] if there's no such playlist
+	GetPlaylist(ctx context.Context, playlistId int64) (*models.Playlist, error)
+	// Returns playlists ordered by owner and name
+	GetPlaylists(ctx context.Context, filter *PlaylistFilter) ([]models.Playlist, error)
+	// Returns [ErrPlaylistNotFound] if there's no such playlist,
+	// [ErrPlaylistAlreadyExists] if the owner already has another playlist with the name
+	UpdatePlaylist(ctx context.Context, playlistId int64, name, description string) error
+	// Deletes playlist with its items, returns [ErrPlaylistNotFound] if there's no such playlist
+	DeletePlaylist(ctx context.Context, playlistId int64) error
+	// Returns items ordered by position, [ErrPlaylistNotFound] if there's no such playlist
+	GetPlaylistItems(ctx context.Context, playlistId int64) ([]models.PlaylistItem, error)
+	// Inserts song at the position, 0 or positions past the end append it. Returns id of the new item,
+	// [ErrPlaylistNotFound], [ErrSongNotFound] if the playlist or the song is missing
+	AddPlaylistItem(ctx context.Context, playlistId int64, songId int64, position int) (int64, error)
+	// Puts item at the position, 0 or positions past the end move it to the end.
+	// Returns [ErrPlaylistNotFound] if there's no such playlist, [ErrItemNotFound] if the item isn't in it
+	MovePlaylistItem(ctx context.Context, playlistId int64, itemId int64, position int) error
+	// Returns [ErrPlaylistNotFound] if there's no such playlist, [ErrItemNotFound] if the item isn't in it
+	RemovePlaylistItem(ctx context.Context, playlistId int64, itemId int64) error
+}
+
+// Tags are genres and free-form labels of songs, a song can have any number of them.
+// Tags are created when they're first attached to a song
+type Tags interface {
+	// Returns tags ordered by song count, most used first
+	GetTags(ctx context.Context, filter *TagFilter) ([]models.Tag, error)
+	// Returns tags of the song ordered by kind and name, [ErrSongNotFound] if there's no such song
+	GetSongTags(ctx context.Context, songId int64) ([]models.Tag, error)
+	// Attaches tags given by kind and name to the song, tags it already has are skipped.
+	// Returns [ErrSongNotFound] if there's no such song
+	AttachTags(ctx context.Context, songId int64, tags []models.Tag) error
+	// Returns [ErrSongNotFound] if there's no such song, [ErrTagNotFound] if the song doesn't have the tag
+	DetachTag(ctx context.Context, songId int64, tagId int64) error
+}
+
+// IdempotencyKeys keep responses of requests made with an idempotency key until they expire,
+// expired keys can be claimed again. A request claims its key and completes it with the response in one transaction
+type IdempotencyKeys interface {
+	// Returns [ErrIdempotencyKeyNotFound] if no response is saved for the key or it expired
+	GetIdempotentResponse(ctx context.Context, key string) (*models.IdempotentResponse, error)
+	// Takes the key for the request with the fingerprint until expiresAt. While a concurrent transaction
+	// holds the key this waits for it to finish. Returns [ErrIdempotencyKeyExists] if the key is taken and hasn't expired
+	ClaimIdempotencyKey(ctx context.Context, key, fingerprint string, expiresAt time.Time) error
+	// Saves the response of the request the key was claimed for.
+	// Returns [ErrIdempotencyKeyNotFound] if the key isn't claimed
+	CompleteIdempotencyKey(ctx context.Context, key string, statusCode int, body []byte) error
+	// Deletes up to limit keys expired before expiredBefore, returns how many were deleted
+	PurgeIdempotencyKeys(ctx context.Context, expiredBefore time.Time, limit int) (int64, error)
+}
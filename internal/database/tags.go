@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+	"github.com/lib/pq"
+)
+
+type TagFilter struct {
+	Kind   string // Tags of every kind are listed when empty
+	Limit  int32
+	Offset int32
+}
+
+// Returns tags ordered by song count, most used first
+func (q *Queries) GetTags(ctx context.Context, filter *TagFilter) (_ []models.Tag, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{filter.Kind, filter.Limit, filter.Offset}
+
+	rows, err := q.stmt(ctx, "GetTags").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var tag models.Tag
+		err := rows.Scan(&tag.Id, &tag.Kind, &tag.Name, &tag.SongCount)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// Returns tags of the song ordered by kind and name, [ErrSongNotFound] if there's no such song
+func (q *Queries) GetSongTags(ctx context.Context, songId int64) (_ []models.Tag, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	exists, err := q.isSongIdPresent(ctx, songId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrSongNotFound
+	}
+
+	args := []any{songId}
+
+	rows, err := q.stmt(ctx, "GetSongTags").QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.Tag
+	for rows.Next() {
+		var tag models.Tag
+		err := rows.Scan(&tag.Id, &tag.Kind, &tag.Name)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// Attaches tags given by kind and name to the song, tags it already has are skipped.
+// Missing tags are created, concurrent creations of the same tag are resolved by the unique constraint.
+// Returns [ErrSongNotFound] if there's no such song
+func (q *Queries) AttachTags(ctx context.Context, songId int64, tags []models.Tag) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	kinds, names := make([]string, len(tags)), make([]string, len(tags))
+	for i, tag := range tags {
+		kinds[i], names[i] = tag.Kind, tag.Name
+	}
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		exists, err := tx.isSongIdPresent(ctx, songId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSongNotFound
+		}
+
+		_, err = tx.stmt(ctx, "AddTags").ExecContext(ctx, pq.Array(kinds), pq.Array(names))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.stmt(ctx, "AttachTags").ExecContext(ctx, songId, pq.Array(kinds), pq.Array(names))
+		return err
+	})
+}
+
+// Returns [ErrSongNotFound] if there's no such song, [ErrTagNotFound] if the song doesn't have the tag
+func (q *Queries) DetachTag(ctx context.Context, songId int64, tagId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	return q.inTx(ctx, func(tx *Queries) error {
+		exists, err := tx.isSongIdPresent(ctx, songId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrSongNotFound
+		}
+
+		result, err := tx.stmt(ctx, "DetachTag").ExecContext(ctx, songId, tagId)
+		if err != nil {
+			return err
+		}
+
+		detached, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if detached == 0 {
+			return ErrTagNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Returns [ErrSongNotFound] if the song is not in trash
+func (q *Queries) RestoreSong(ctx context.Context, songId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{songId}
+
+	return q.asActor(ctx, func(tx *Queries) error {
+		result, err := tx.stmt(ctx, "RestoreSong").ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+
+		restored, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if restored == 0 {
+			return ErrSongNotFound
+		}
+		return nil
+	})
+}
+
+// Deletes trashed song permanently together with its enrichment job.
+// Returns [ErrSongNotFound] if the song is not in trash
+func (q *Queries) PurgeSong(ctx context.Context, songId int64) (err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{songId}
+
+	return q.asActor(ctx, func(tx *Queries) error {
+		result, err := tx.stmt(ctx, "PurgeSong").ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+
+		purged, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if purged == 0 {
+			return ErrSongNotFound
+		}
+		return nil
+	})
+}
+
+// Permanently deletes up to limit songs trashed before deletedBefore, oldest first
+func (q *Queries) PurgeTrash(ctx context.Context, deletedBefore time.Time, limit int) (_ int64, err error) {
+	ctx, done := q.withTimeout(ctx)
+	defer done(&err)
+
+	args := []any{deletedBefore, limit}
+
+	var purged int64
+	err = q.asActor(ctx, func(tx *Queries) error {
+		result, err := tx.stmt(ctx, "PurgeTrash").ExecContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return purged, err
+}
@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops calling the wrapped provider after threshold consecutive failures,
+// calls fail with [ErrCircuitOpen] until cooldown passes, then a single trial call is let through:
+// its success closes the circuit, its failure opens it for another cooldown.
+// [ErrSongUnknown] and canceled calls are not counted as failures
+type CircuitBreaker struct {
+	provider  Provider
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu          sync.Mutex
+	failures    int
+	openedAt    time.Time
+	open        bool
+	trialActive bool
+}
+
+func NewCircuitBreaker(provider Provider, threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{provider: provider, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (b *CircuitBreaker) SongDetails(ctx context.Context, group, song string) (*SongDetails, error) {
+	trial, allowed := b.allow()
+	if !allowed {
+		return nil, ErrCircuitOpen
+	}
+
+	details, err := b.provider.SongDetails(ctx, group, song)
+	b.record(trial, err == nil || errors.Is(err, ErrSongUnknown), ctx.Err() != nil)
+	return details, err
+}
+
+// Reports whether a call may go through and if it's the trial call of a half-open circuit
+func (b *CircuitBreaker) allow() (trial bool, allowed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return false, true
+	}
+	if b.trialActive || b.now().Sub(b.openedAt) < b.cooldown {
+		return false, false
+	}
+
+	b.trialActive = true
+	return true, true
+}
+
+func (b *CircuitBreaker) record(trial, succeeded, canceled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trialActive = false
+	}
+
+	switch {
+	case succeeded:
+		b.failures = 0
+		b.open = false
+	case canceled:
+		// caller went away, says nothing about the provider
+	default:
+		b.failures++
+		if trial || (b.threshold > 0 && b.failures >= b.threshold) {
+			b.open = true
+			b.openedAt = b.now()
+		}
+	}
+}
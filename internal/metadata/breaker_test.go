@@ -0,0 +1,241 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// External api responding with status, details are sent with 200
+type fakeAPI struct {
+	server *httptest.Server
+	status atomic.Int32
+	calls  atomic.Int32
+
+	mu sync.Mutex
+	// if set, requests wait for it to be closed before responding
+	block chan struct{}
+}
+
+// Makes following requests wait until the returned channel is closed
+func (api *fakeAPI) blockRequests() chan struct{} {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.block = make(chan struct{})
+	return api.block
+}
+
+func (api *fakeAPI) blocked() chan struct{} {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.block
+}
+
+func newFakeAPI(t *testing.T, status int) *fakeAPI {
+	api := &fakeAPI{}
+	api.status.Store(int32(status))
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.calls.Add(1)
+		if block := api.blocked(); block != nil {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		status := int(api.status.Load())
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"releaseDate":"16.07.2006","text":"Ooh baby","link":"https://example.com"}`))
+		}
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func newTestBreaker(t *testing.T, api *fakeAPI, threshold int, cooldown time.Duration) (*CircuitBreaker, *fakeClock) {
+	t.Helper()
+	p, err := NewHTTPProvider(api.server.URL, api.server.Client())
+	if err != nil {
+		t.Fatalf("NewHTTPProvider: %v", err)
+	}
+
+	clock := newFakeClock()
+	b := NewCircuitBreaker(p, threshold, cooldown)
+	b.now = clock.now
+	return b, clock
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	api := newFakeAPI(t, http.StatusInternalServerError)
+	b, _ := newTestBreaker(t, api, 2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := b.SongDetails(context.Background(), "Muse", "Supermassive Black Hole")
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			t.Fatalf("call %d: got error %v, want *StatusError", i+1, err)
+		}
+	}
+
+	_, err := b.SongDetails(context.Background(), "Muse", "Supermassive Black Hole")
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got error %v, want ErrCircuitOpen", err)
+	}
+	if calls := api.calls.Load(); calls != 2 {
+		t.Errorf("api was called %d times, want 2", calls)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	api := newFakeAPI(t, http.StatusInternalServerError)
+	b, _ := newTestBreaker(t, api, 2, time.Minute)
+
+	b.SongDetails(context.Background(), "Muse", "Uprising")
+	api.status.Store(http.StatusOK)
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	api.status.Store(http.StatusInternalServerError)
+	b.SongDetails(context.Background(), "Muse", "Uprising")
+	_, err := b.SongDetails(context.Background(), "Muse", "Uprising")
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("circuit opened before threshold consecutive failures")
+	}
+}
+
+func TestCircuitBreakerUnknownSongIsNotFailure(t *testing.T) {
+	api := newFakeAPI(t, http.StatusNotFound)
+	b, _ := newTestBreaker(t, api, 1, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		_, err := b.SongDetails(context.Background(), "Muse", "Unknown")
+		if !errors.Is(err, ErrSongUnknown) {
+			t.Fatalf("call %d: got error %v, want ErrSongUnknown", i+1, err)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenTrial(t *testing.T) {
+	api := newFakeAPI(t, http.StatusInternalServerError)
+	b, clock := newTestBreaker(t, api, 1, time.Minute)
+
+	b.SongDetails(context.Background(), "Muse", "Uprising")
+	clock.advance(time.Minute - time.Second)
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("before cooldown: got error %v, want ErrCircuitOpen", err)
+	}
+
+	clock.advance(time.Second)
+	api.status.Store(http.StatusOK)
+	release := api.blockRequests()
+	trialErr := make(chan error)
+	go func() {
+		_, err := b.SongDetails(context.Background(), "Muse", "Uprising")
+		trialErr <- err
+	}()
+
+	for api.calls.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("during trial: got error %v, want ErrCircuitOpen", err)
+	}
+
+	close(release)
+	if err := <-trialErr; err != nil {
+		t.Fatalf("trial: got error %v, want nil", err)
+	}
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Fatalf("after successful trial: got error %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerFailedTrialReopens(t *testing.T) {
+	api := newFakeAPI(t, http.StatusInternalServerError)
+	b, clock := newTestBreaker(t, api, 3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		b.SongDetails(context.Background(), "Muse", "Uprising")
+	}
+	clock.advance(time.Minute)
+
+	var statusErr *StatusError
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); !errors.As(err, &statusErr) {
+		t.Fatalf("trial: got error %v, want *StatusError", err)
+	}
+	// a single failed trial opens the circuit again, regardless of threshold
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after failed trial: got error %v, want ErrCircuitOpen", err)
+	}
+
+	clock.advance(time.Minute)
+	api.status.Store(http.StatusOK)
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Fatalf("second trial: got error %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerCancellationIsNotFailure(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK)
+	release := api.blockRequests()
+	b, _ := newTestBreaker(t, api, 1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for api.calls.Load() < 1 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if _, err := b.SongDetails(ctx, "Muse", "Uprising"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Fatalf("after canceled call: got error %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerCanceledTrialAllowsAnother(t *testing.T) {
+	api := newFakeAPI(t, http.StatusInternalServerError)
+	b, clock := newTestBreaker(t, api, 1, time.Minute)
+
+	b.SongDetails(context.Background(), "Muse", "Uprising")
+	clock.advance(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.SongDetails(ctx, "Muse", "Uprising"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled trial: got error %v, want context.Canceled", err)
+	}
+
+	api.status.Store(http.StatusOK)
+	if _, err := b.SongDetails(context.Background(), "Muse", "Uprising"); err != nil {
+		t.Fatalf("trial after canceled one: got error %v, want nil", err)
+	}
+}
@@ -0,0 +1,98 @@
+package metadata
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache keeps successful responses of the wrapped provider,
+// least recently used entries are evicted once size is reached, entries expire after ttl
+type Cache struct {
+	provider Provider
+	size     int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // Front is the most recently used entry
+	entries map[cacheKey]*list.Element
+}
+
+type cacheKey struct {
+	group string
+	song  string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	details SongDetails
+	expires time.Time
+}
+
+func NewCache(provider Provider, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		provider: provider,
+		size:     size,
+		ttl:      ttl,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *Cache) SongDetails(ctx context.Context, group, song string) (*SongDetails, error) {
+	key := cacheKey{group: group, song: song}
+	if details, ok := c.get(key); ok {
+		return details, nil
+	}
+
+	details, err := c.provider.SongDetails(ctx, group, song)
+	if err != nil {
+		return nil, err
+	}
+
+	c.put(key, details)
+	return details, nil
+}
+
+func (c *Cache) get(key cacheKey) (*SongDetails, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	details := entry.details
+	return &details, true
+}
+
+func (c *Cache) put(key cacheKey, details *SongDetails) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry{key: key, details: *details, expires: c.now().Add(c.ttl)}
+	if elem, exists := c.entries[key]; exists {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
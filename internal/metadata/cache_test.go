@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Provider answering with the song name as text, counting calls per song
+type countingProvider struct {
+	calls map[string]int
+	err   error
+}
+
+func (p *countingProvider) SongDetails(_ context.Context, group, song string) (*SongDetails, error) {
+	p.calls[song]++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &SongDetails{ReleaseDate: "16.07.2006", Text: song, Link: "https://example.com"}, nil
+}
+
+func newTestCache(size int, ttl time.Duration) (*Cache, *countingProvider, *fakeClock) {
+	p := &countingProvider{calls: map[string]int{}}
+	clock := newFakeClock()
+	c := NewCache(p, size, ttl)
+	c.now = clock.now
+	return c, p, clock
+}
+
+func mustGet(t *testing.T, c *Cache, song string) *SongDetails {
+	t.Helper()
+	details, err := c.SongDetails(context.Background(), "Muse", song)
+	if err != nil {
+		t.Fatalf("SongDetails(%q): %v", song, err)
+	}
+	if details.Text != song {
+		t.Fatalf("SongDetails(%q) returned details of %q", song, details.Text)
+	}
+	return details
+}
+
+func TestCacheReturnsCachedDetails(t *testing.T) {
+	c, p, _ := newTestCache(2, time.Minute)
+
+	first := mustGet(t, c, "Uprising")
+	// callers get copies, changing them does not change the cached entry
+	first.Text = "changed by caller"
+	mustGet(t, c, "Uprising")
+
+	if p.calls["Uprising"] != 1 {
+		t.Errorf("provider was called %d times, want 1", p.calls["Uprising"])
+	}
+}
+
+func TestCacheEntriesExpire(t *testing.T) {
+	c, p, clock := newTestCache(2, time.Minute)
+
+	mustGet(t, c, "Uprising")
+	clock.advance(time.Minute - time.Second)
+	mustGet(t, c, "Uprising")
+	if p.calls["Uprising"] != 1 {
+		t.Fatalf("before ttl: provider was called %d times, want 1", p.calls["Uprising"])
+	}
+
+	clock.advance(time.Second)
+	mustGet(t, c, "Uprising")
+	if p.calls["Uprising"] != 2 {
+		t.Fatalf("after ttl: provider was called %d times, want 2", p.calls["Uprising"])
+	}
+
+	// refetched entry gets a fresh ttl
+	clock.advance(time.Minute - time.Second)
+	mustGet(t, c, "Uprising")
+	if p.calls["Uprising"] != 2 {
+		t.Errorf("before refreshed ttl: provider was called %d times, want 2", p.calls["Uprising"])
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, p, _ := newTestCache(2, time.Minute)
+
+	mustGet(t, c, "Uprising")
+	mustGet(t, c, "Starlight")
+	// Uprising becomes the most recently used, so Starlight is evicted instead
+	mustGet(t, c, "Uprising")
+	mustGet(t, c, "Hysteria")
+
+	mustGet(t, c, "Uprising")
+	mustGet(t, c, "Hysteria")
+	mustGet(t, c, "Starlight")
+
+	want := map[string]int{"Uprising": 1, "Hysteria": 1, "Starlight": 2}
+	for song, calls := range want {
+		if p.calls[song] != calls {
+			t.Errorf("provider was called %d times for %q, want %d", p.calls[song], song, calls)
+		}
+	}
+	if len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Errorf("cache holds %d entries and %d list elements, want 2", len(c.entries), c.order.Len())
+	}
+}
+
+func TestCacheDoesNotKeepErrors(t *testing.T) {
+	c, p, _ := newTestCache(2, time.Minute)
+	p.err = &StatusError{Status: "500 Internal Server Error"}
+
+	for i := 0; i < 2; i++ {
+		_, err := c.SongDetails(context.Background(), "Muse", "Uprising")
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) {
+			t.Fatalf("call %d: got error %v, want *StatusError", i+1, err)
+		}
+	}
+
+	p.err = nil
+	mustGet(t, c, "Uprising")
+	if p.calls["Uprising"] != 3 {
+		t.Errorf("provider was called %d times, want 3", p.calls["Uprising"])
+	}
+}
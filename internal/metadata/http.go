@@ -0,0 +1,83 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPProvider queries external api with GET <baseURL>/info?group=<group>&song=<song>,
+// expecting [SongDetails] as json in response
+type HTTPProvider struct {
+	baseURL *url.URL
+	client  *http.Client
+}
+
+// Status code returned by external api other than 200 and 404
+type StatusError struct {
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API returned status: %s", e.Status)
+}
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout}
+}
+
+// Fails with [ErrInvalidProvider] if baseURL is not an absolute http(s) url
+func NewHTTPProvider(baseURL string, client *http.Client) (*HTTPProvider, error) {
+	parsedURL, err := url.Parse(baseURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return nil, fmt.Errorf("%w: unsupported URL scheme: %s", ErrInvalidProvider, baseURL)
+	}
+
+	if parsedURL.Host == "" {
+		return nil, fmt.Errorf("%w: URL must have a valid host: %s", ErrInvalidProvider, baseURL)
+	}
+
+	return &HTTPProvider{baseURL: parsedURL, client: client}, nil
+}
+
+// Returns [ErrSongUnknown] if api responded with 404, [*StatusError] for other unexpected statuses
+func (p *HTTPProvider) SongDetails(ctx context.Context, group, song string) (*SongDetails, error) {
+	fullURL := p.baseURL.JoinPath("info")
+	fullURL.RawQuery = url.Values{"group": {group}, "song": {song}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrSongUnknown
+	default:
+		return nil, &StatusError{Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var details SongDetails
+	err = json.Unmarshal(body, &details)
+	if err != nil {
+		return nil, err
+	}
+
+	return &details, nil
+}
@@ -0,0 +1,96 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+)
+
+var (
+	ErrSongUnknown     = errors.New("song is unknown to metadata provider")
+	ErrCircuitOpen     = errors.New("metadata provider is unavailable after repeated failures")
+	ErrNotConfigured   = errors.New("no metadata provider is configured")
+	ErrInvalidProvider = errors.New("invalid metadata provider")
+)
+
+// Song details as returned by a provider, they are not validated
+type SongDetails struct {
+	ReleaseDate string `json:"releaseDate"`
+	Text        string `json:"text"`
+	Link        string `json:"link"`
+}
+
+// Provider acquires details of a song by its group and song names
+type Provider interface {
+	SongDetails(ctx context.Context, group, song string) (*SongDetails, error)
+}
+
+// Builds provider from config: every url (EXTERNAL_API_URL first, then EXTERNAL_API_FALLBACK_URLS)
+// is queried over http behind its own circuit breaker, they are tried in order
+// and successful responses are cached.
+// If no urls are configured, returned provider fails every request with [ErrNotConfigured]
+func New(cfg config.Config) (Provider, error) {
+	var urls []string
+	for _, u := range append([]string{cfg.ExternalAPIURL}, cfg.ExternalAPIFallbackURLs...) {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return unconfigured{}, nil
+	}
+
+	providers := make([]Provider, 0, len(urls))
+	for _, u := range urls {
+		p, err := NewHTTPProvider(u, newHTTPClient(cfg.ExternalAPITimeout))
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers,
+			NewCircuitBreaker(p, cfg.ExternalAPIBreakerThreshold, cfg.ExternalAPIBreakerCooldown))
+	}
+
+	var p Provider = NewChain(providers...)
+	if cfg.ExternalAPICacheSize > 0 {
+		p = NewCache(p, cfg.ExternalAPICacheSize, cfg.ExternalAPICacheTTL)
+	}
+	return p, nil
+}
+
+type unconfigured struct{}
+
+func (unconfigured) SongDetails(context.Context, string, string) (*SongDetails, error) {
+	return nil, ErrNotConfigured
+}
+
+// Chain tries providers in order and returns the first successful response
+type Chain struct {
+	providers []Provider
+}
+
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{providers: providers}
+}
+
+// Returns errors of every provider joined together if all of them failed
+func (c *Chain) SongDetails(ctx context.Context, group, song string) (*SongDetails, error) {
+	var errs []error
+	for i, p := range c.providers {
+		details, err := p.SongDetails(ctx, group, song)
+		if err == nil {
+			return details, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("provider %d: %w", i+1, err))
+	}
+
+	if len(errs) == 0 {
+		return nil, ErrNotConfigured
+	}
+	return nil, errors.Join(errs...)
+}
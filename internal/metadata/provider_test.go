@@ -0,0 +1,142 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+)
+
+func TestChainFallsBackToNextProvider(t *testing.T) {
+	failing := &countingProvider{calls: map[string]int{}, err: &StatusError{Status: "500 Internal Server Error"}}
+	missing := &countingProvider{calls: map[string]int{}, err: ErrSongUnknown}
+	answering := &countingProvider{calls: map[string]int{}}
+	unused := &countingProvider{calls: map[string]int{}}
+
+	c := NewChain(failing, missing, answering, unused)
+	details, err := c.SongDetails(context.Background(), "Muse", "Uprising")
+	if err != nil || details.Text != "Uprising" {
+		t.Fatalf("got details %+v and error %v, want the ones of the third provider", details, err)
+	}
+
+	for i, p := range []*countingProvider{failing, missing, answering, unused} {
+		want := 1
+		if p == unused {
+			want = 0
+		}
+		if p.calls["Uprising"] != want {
+			t.Errorf("provider %d was called %d times, want %d", i+1, p.calls["Uprising"], want)
+		}
+	}
+}
+
+func TestChainJoinsErrorsOfEveryProvider(t *testing.T) {
+	c := NewChain(
+		&countingProvider{calls: map[string]int{}, err: &StatusError{Status: "502 Bad Gateway"}},
+		&countingProvider{calls: map[string]int{}, err: ErrSongUnknown},
+	)
+
+	_, err := c.SongDetails(context.Background(), "Muse", "Uprising")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || !errors.Is(err, ErrSongUnknown) {
+		t.Errorf("got error %v, want errors of both providers", err)
+	}
+
+	_, err = NewChain().SongDetails(context.Background(), "Muse", "Uprising")
+	if !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("empty chain: got error %v, want %v", err, ErrNotConfigured)
+	}
+}
+
+func TestChainStopsOnceCallerIsGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	next := &countingProvider{calls: map[string]int{}}
+
+	c := NewChain(&countingProvider{calls: map[string]int{}, err: context.Canceled}, next)
+	if _, err := c.SongDetails(ctx, "Muse", "Uprising"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if next.calls["Uprising"] != 0 {
+		t.Errorf("next provider was called %d times after caller went away", next.calls["Uprising"])
+	}
+}
+
+func testProviderConfig(urls ...string) config.Config {
+	cfg := config.Config{
+		ExternalAPITimeout:          time.Second,
+		ExternalAPICacheSize:        10,
+		ExternalAPICacheTTL:         time.Minute,
+		ExternalAPIBreakerThreshold: 1,
+		ExternalAPIBreakerCooldown:  time.Minute,
+	}
+	if len(urls) > 0 {
+		cfg.ExternalAPIURL, cfg.ExternalAPIFallbackURLs = urls[0], urls[1:]
+	}
+	return cfg
+}
+
+func TestNewQueriesConfiguredURLsInOrder(t *testing.T) {
+	primary := newFakeAPI(t, http.StatusInternalServerError)
+	fallback := newFakeAPI(t, http.StatusOK)
+
+	p, err := New(testProviderConfig(primary.server.URL, " ", fallback.server.URL))
+	if err != nil {
+		t.Fatalf("failed to configure providers: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		details, err := p.SongDetails(context.Background(), "Muse", "Uprising")
+		if err != nil || details.Text != "Ooh baby" {
+			t.Fatalf("call %d: got details %+v and error %v, want the ones of fallback", i+1, details, err)
+		}
+	}
+	// breaker of the primary opened after its first failure, later calls are answered by the cache
+	if calls := primary.calls.Load(); calls != 1 {
+		t.Errorf("primary was called %d times, want 1", calls)
+	}
+	if calls := fallback.calls.Load(); calls != 1 {
+		t.Errorf("fallback was called %d times, want 1", calls)
+	}
+}
+
+func TestNewWithoutCache(t *testing.T) {
+	api := newFakeAPI(t, http.StatusOK)
+	cfg := testProviderConfig(api.server.URL)
+	cfg.ExternalAPICacheSize = 0
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatalf("failed to configure providers: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.SongDetails(context.Background(), "Muse", "Uprising"); err != nil {
+			t.Fatalf("call %d: got error %v", i+1, err)
+		}
+	}
+	if calls := api.calls.Load(); calls != 2 {
+		t.Errorf("api was called %d times, want every call to reach it", calls)
+	}
+}
+
+func TestNewWithoutURLs(t *testing.T) {
+	p, err := New(testProviderConfig())
+	if err != nil {
+		t.Fatalf("failed to configure providers: %v", err)
+	}
+	if _, err := p.SongDetails(context.Background(), "Muse", "Uprising"); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("got error %v, want %v", err, ErrNotConfigured)
+	}
+}
+
+func TestNewRejectsInvalidURLs(t *testing.T) {
+	for _, u := range []string{"ftp://example.com", "http://", "example.com"} {
+		_, err := New(testProviderConfig(u))
+		if !errors.Is(err, ErrInvalidProvider) {
+			t.Errorf("url %q: got error %v, want %v", u, err, ErrInvalidProvider)
+		}
+	}
+}
@@ -5,6 +5,13 @@ import (
 	"time"
 )
 
+// Enrichment statuses of a song, details are acquired from external api in background
+const (
+	EnrichmentPending  = "pending"
+	EnrichmentEnriched = "enriched"
+	EnrichmentFailed   = "failed"
+)
+
 type BasicSongInfo struct {
 	Id        int64
 	GroupName string
@@ -18,12 +25,169 @@ type AdditionalSongInfo struct {
 }
 
 type FullSongInfo struct {
-	Id          int64          `json:"id"`
-	GroupName   string         `json:"group"`
-	SongName    string         `json:"song"`
-	ReleaseDate sql.NullTime   `json:"releaseDate"`
-	SongLyrics  sql.NullString `json:"text"`
-	Link        sql.NullString `json:"link"`
+	Id               int64          `json:"id"`
+	GroupId          int64          `json:"groupId"`
+	GroupName        string         `json:"group"`
+	SongName         string         `json:"song"`
+	ReleaseDate      sql.NullTime   `json:"releaseDate"`
+	SongLyrics       sql.NullString `json:"text"`
+	Link             sql.NullString `json:"link"`
+	EnrichmentStatus string         `json:"enrichmentStatus"`
+	UpdatedAt        time.Time      `json:"updatedAt"`
+	Version          int64          `json:"version"`   // Increased by every change of the song
+	DeletedAt        sql.NullTime   `json:"deletedAt"` // Set while the song is in trash
+	Match            *SearchMatch   `json:"match"`     // Set for songs found by full-text search
+}
+
+// Artist songs belong to, songs name their group by GroupName as well
+type Group struct {
+	Id        int64
+	Name      string
+	CreatedAt time.Time
+	SongCount int64 // Songs of the group that aren't in trash
+}
+
+// Release of a group, its songs are listed as [AlbumTrack]
+type Album struct {
+	Id          int64
+	GroupId     int64
+	GroupName   string
+	Title       string
+	ReleaseDate sql.NullTime
+	CoverLink   sql.NullString
+	CreatedAt   time.Time
+}
+
+// Song of an album, positions count from 1 and include trashed songs, which aren't listed
+type AlbumTrack struct {
+	Position int
+	Song     FullSongInfo
+}
+
+// Songs picked by a user, Owner is the actor who created the playlist
+type Playlist struct {
+	Id          int64
+	Owner       string
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ItemCount   int64
+}
+
+// Entry of a playlist, the same song can be added several times as different items.
+// Positions count from 1
+type PlaylistItem struct {
+	Id       int64
+	Position int
+	AddedAt  time.Time
+	Song     FullSongInfo
+}
+
+// Kinds of tags, genres are tags picked from a shared vocabulary and free-form tags are anything else
+const (
+	TagKindGenre = "genre"
+	TagKindTag   = "tag"
+)
+
+// Label of songs, names are unique within a kind
+type Tag struct {
+	Id        int64
+	Kind      string
+	Name      string
+	SongCount int64 // Songs with the tag that aren't in trash, only set for tag listings
+}
+
+// Relevance of a song found by full-text search of lyrics
+type SearchMatch struct {
+	Rank    float64
+	Snippet string // Fragments of lyrics with matched words highlighted by <b></b>
+	Verse   int    // Number of the first matched verse counting from 1, 0 if no single verse matched
+}
+
+// Partial update of a song, nil fields are left as they are,
+// fields set to invalid (null) values are cleared
+type SongPatch struct {
+	GroupName   *string
+	SongName    *string
+	ReleaseDate *sql.NullTime
+	SongLyrics  *sql.NullString
+	Link        *sql.NullString
+}
+
+// Reports whether patch changes details usually acquired from external api
+func (p *SongPatch) HasDetails() bool {
+	return p.ReleaseDate != nil || p.SongLyrics != nil || p.Link != nil
+}
+
+// Queued request to acquire details of a song from external api
+type EnrichmentJob struct {
+	Id        int64
+	SongId    int64
+	GroupName string
+	SongName  string
+	Attempts  int // Including the current one
+}
+
+// Outcomes of songs given to bulk import
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// Song read by bulk import, Details is nil if the source didn't provide them
+type ImportSong struct {
+	BasicSongInfo
+	Details *AdditionalSongInfo
+}
+
+// Outcome of an imported song, Reason explains why it was skipped or failed
+type ImportResult struct {
+	Status string
+	SongId int64
+	Reason string
+}
+
+// Response saved for requests made with an idempotency key, retries with the key get it instead of running again.
+// Fingerprint identifies the request the key was first used with
+type IdempotentResponse struct {
+	Key         string
+	Fingerprint string
+	StatusCode  int // 0 until the request the key was claimed for completes it
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Operations recorded in song history
+const (
+	HistoryCreate  = "create"
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+	HistoryPurge   = "purge"
+)
+
+// Song fields tracked by history
+type SongValues struct {
+	GroupName   string
+	SongName    string
+	ReleaseDate sql.NullTime
+	SongLyrics  sql.NullString
+	Link        sql.NullString
+}
+
+// Single change of a song, Old is nil for created songs and New is nil for purged ones
+type SongRevision struct {
+	Id        int64
+	SongId    int64
+	Version   int64 // Version of the song after the change
+	Operation string
+	Actor     string
+	ChangedAt time.Time
+	Old       *SongValues
+	New       *SongValues
 }
 
 type ErrorResponse struct {
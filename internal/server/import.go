@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/api/handlers"
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+)
+
+const importUsage = "usage: music-library import [flags] <file.csv|file.ndjson|->"
+
+// Imports songs from a CSV or NDJSON file like POST /music-library/import
+// and prints the report as JSON to stdout, "-" reads the songs from stdin
+func Import(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), importUsage)
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "", "csv or ndjson, taken from the file extension by default")
+	onConflict := flags.String("on-conflict", "skip", "skip or update existing songs")
+	actor := flags.String("actor", "import", "actor the songs are recorded in history as added by")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	if *format != handlers.FormatCSV && *format != handlers.FormatNDJSON {
+		log.Fatalf("unknown format %q, expected %s or %s", *format, handlers.FormatCSV, handlers.FormatNDJSON)
+	}
+	if *onConflict != "skip" && *onConflict != "update" {
+		log.Fatalf("unknown on-conflict %q, expected skip or update", *onConflict)
+	}
+
+	// start logger
+	err := logger.Init()
+	if err != nil {
+		log.Fatal(fmt.Errorf("failed to initialize logger: %w", err))
+	}
+
+	// load config
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Zap.Fatal(fmt.Errorf("failed to load config: %w", err))
+	}
+
+	var src io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			logger.Zap.Fatal(fmt.Errorf("failed to open import file: %w", err))
+		}
+		defer file.Close()
+		src = file
+	}
+
+	db, queries := openDatabase(cfg)
+	defer db.Close()
+
+	// interrupted import keeps the batches saved so far
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx = database.WithActor(ctx, *actor)
+
+	logger.Zap.Info(fmt.Sprintf("Importing songs from %s", path))
+	opts := handlers.ImportOptions{Format: *format, Update: *onConflict == "update"}
+	report, importErr := handlers.Import(ctx, queries, &cfg, src, opts)
+	if report == nil {
+		logger.Zap.Fatal(fmt.Errorf("failed to import songs: %w", importErr))
+	}
+
+	// report of a failed import tells which rows were saved before it stopped
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(report)
+	if err != nil {
+		logger.Zap.Fatal(fmt.Errorf("failed writing report: %w", err))
+	}
+	if importErr != nil {
+		logger.Zap.Fatal(fmt.Errorf("failed to import songs, %d created and %d updated before: %w",
+			report.Created, report.Updated, importErr))
+	}
+
+	logger.Zap.Info(fmt.Sprintf("Import complete: %d created, %d updated, %d skipped, %d failed",
+		report.Created, report.Updated, report.Skipped, report.Failed))
+}
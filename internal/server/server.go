@@ -2,11 +2,14 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,6 +18,8 @@ import (
 	"github.com/Scorzoner/effective-mobile-test/internal/config"
 	"github.com/Scorzoner/effective-mobile-test/internal/database"
 	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+	"github.com/Scorzoner/effective-mobile-test/internal/metadata"
+	"github.com/Scorzoner/effective-mobile-test/internal/worker"
 	"github.com/golang-migrate/migrate/v4"
 )
 
@@ -35,37 +40,56 @@ func Run() {
 	}
 	logger.Zap.Info("Config loaded: ", fmt.Sprintf("%+v", cfg))
 
-	// open db connection
-	logger.Zap.Info("Opening database connection")
-	db, err := database.Open(cfg)
-	if err != nil {
-		logger.Zap.Fatal(fmt.Errorf("failed to open pgsql connection: %w", err))
-	}
+	db, queries := openDatabase(cfg)
 	defer db.Close()
 
-	// run migrations
-	logger.Zap.Info("Running migrations")
-	err = database.RunMigrations(db)
-	if err != nil && err != migrate.ErrNoChange {
-		logger.Zap.Fatal(fmt.Errorf("failed to run migrations: %w", err))
+	// configure external api clients
+	logger.Zap.Info("Configuring song metadata providers")
+	details, err := metadata.New(cfg)
+	if err != nil {
+		logger.Zap.Fatal(fmt.Errorf("failed to configure song metadata providers: %w", err))
 	}
 
 	// initialize router/handlers
 	logger.Zap.Info("Initializing handlers")
-	hq, err := handlers.NewHandlerQueries(db, cfg)
-	if err != nil {
-		logger.Zap.Fatal(fmt.Errorf("failed to initialize queries: %w", err))
-	}
+	hq := handlers.NewHandlerQueries(queries, details, cfg)
 
 	r := router.New(hq)
 
-	// start server
+	// start background enrichment, trash and idempotency key purging, workers are stopped after the server shuts down
+	logger.Zap.Info("Starting background workers")
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(3)
+	go func() {
+		defer workers.Done()
+		worker.NewEnrichmentPool(queries, hq.EnrichSong, cfg).Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		worker.NewTrashPurger(queries, cfg).Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		worker.NewIdempotencyKeyPurger(queries, cfg).Run(workersCtx)
+	}()
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+	}()
+
+	// start server, every request context is derived from requestsCtx
+	// so requests still running after graceful shutdown times out get their queries canceled
 	logger.Zap.Info("Configuring and starting the server")
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	srv := http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
 		Handler:      r,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 20 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return requestsCtx },
 	}
 
 	// graceful shutdown
@@ -82,7 +106,9 @@ func Run() {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
+		err := srv.Shutdown(ctx)
+		cancelRequests()
+		shutdownError <- err
 	}()
 
 	logger.Zap.Info(fmt.Sprintf("Server is running on port: %d", cfg.Port))
@@ -99,3 +125,29 @@ func Run() {
 	}
 	logger.Zap.Info("Graceful shutdown complete")
 }
+
+// Opens database connection, runs migrations and prepares queries, exits on failure
+func openDatabase(cfg config.Config) (*sql.DB, *database.Queries) {
+	// open db connection
+	logger.Zap.Info("Opening database connection")
+	db, err := database.Open(cfg)
+	if err != nil {
+		logger.Zap.Fatal(fmt.Errorf("failed to open pgsql connection: %w", err))
+	}
+
+	// run migrations
+	logger.Zap.Info("Running migrations")
+	err = database.RunMigrations(db)
+	if err != nil && err != migrate.ErrNoChange {
+		logger.Zap.Fatal(fmt.Errorf("failed to run migrations: %w", err))
+	}
+
+	// prepare queries
+	logger.Zap.Info("Preparing queries")
+	queries, err := database.NewQueries(db, cfg)
+	if err != nil {
+		logger.Zap.Fatal(fmt.Errorf("failed to initialize queries: %w", err))
+	}
+
+	return db, queries
+}
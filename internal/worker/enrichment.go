@@ -0,0 +1,154 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+// EnrichFunc acquires additional info for a song, e.g. from external api
+type EnrichFunc func(ctx context.Context, song models.BasicSongInfo) (*models.AdditionalSongInfo, error)
+
+// EnrichmentPool processes jobs from [database.EnrichmentQueue] in background,
+// failed jobs are retried with exponential backoff until attempts run out
+type EnrichmentPool struct {
+	store  database.SongStore
+	enrich EnrichFunc
+
+	workers      int
+	pollInterval time.Duration
+	lease        time.Duration
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+}
+
+func NewEnrichmentPool(store database.SongStore, enrich EnrichFunc, cfg config.Config) *EnrichmentPool {
+	return &EnrichmentPool{
+		store:        store,
+		enrich:       enrich,
+		workers:      cfg.EnrichmentWorkers,
+		pollInterval: cfg.EnrichmentPollInterval,
+		lease:        cfg.EnrichmentLease,
+		maxAttempts:  cfg.EnrichmentMaxAttempts,
+		backoffBase:  cfg.EnrichmentBackoffBase,
+		backoffMax:   cfg.EnrichmentBackoffMax,
+	}
+}
+
+// Actor recorded in song history for details saved by the pool
+const enrichmentActor = "enrichment-worker"
+
+// Starts workers and blocks until ctx is done and every worker has stopped
+func (p *EnrichmentPool) Run(ctx context.Context) {
+	ctx = database.WithActor(ctx, enrichmentActor)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Claims jobs one by one, waits for pollInterval when there's nothing to do
+func (p *EnrichmentPool) work(ctx context.Context) {
+	for {
+		jobs, err := p.store.ClaimEnrichmentJobs(ctx, 1, p.lease)
+		if err != nil && ctx.Err() == nil {
+			logger.Zap.Error(fmt.Errorf("failed to claim enrichment jobs: %w", err))
+		}
+
+		for _, job := range jobs {
+			p.process(ctx, job)
+		}
+
+		if len(jobs) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+func (p *EnrichmentPool) process(ctx context.Context, job models.EnrichmentJob) {
+	// job has to be finished before its lease runs out, otherwise another worker may claim it
+	jobCtx, cancel := context.WithTimeout(ctx, p.lease)
+	defer cancel()
+
+	song := models.BasicSongInfo{Id: job.SongId, GroupName: job.GroupName, SongName: job.SongName}
+
+	info, err := p.enrich(jobCtx, song)
+	if err == nil {
+		stale := false
+		err = p.store.WithTx(jobCtx, func(tx database.SongStore) error {
+			current, err := tx.IsEnrichmentJobCurrent(jobCtx, job)
+			if err != nil || !current {
+				stale = !current
+				return err
+			}
+			return tx.UpdateSongInfo(jobCtx, job.SongId, info, 0)
+		})
+		if err == nil && stale {
+			// song changed while details were acquired, the change wins: details given by it dropped the job
+			// and a job left in place is claimed again with the new names once its lease runs out
+			logger.Zap.Debug(fmt.Sprintf("song %d changed while being enriched, dropping its details", job.SongId))
+			return
+		}
+	}
+	if err == nil {
+		logger.Zap.Debug(fmt.Sprintf("song %d enriched on attempt %d", job.SongId, job.Attempts))
+		return
+	}
+
+	if ctx.Err() != nil {
+		// shutting down, job will be claimed again once its lease runs out
+		return
+	}
+
+	if job.Attempts >= p.maxAttempts {
+		logger.Zap.Info(fmt.Sprintf("giving up on enriching song %d after %d attempts: %s",
+			job.SongId, job.Attempts, err.Error()))
+		err = p.store.FailEnrichmentJob(ctx, job.Id)
+		if err != nil {
+			logger.Zap.Error(fmt.Errorf("failed to mark enrichment job %d as failed: %w", job.Id, err))
+		}
+		return
+	}
+
+	delay := p.backoff(job.Attempts)
+	logger.Zap.Debug(fmt.Sprintf("enriching song %d failed on attempt %d, retrying in %s: %s",
+		job.SongId, job.Attempts, delay, err.Error()))
+	err = p.store.RetryEnrichmentJob(ctx, job.Id, time.Now().Add(delay), err.Error())
+	if err != nil {
+		logger.Zap.Error(fmt.Errorf("failed to reschedule enrichment job %d: %w", job.Id, err))
+	}
+}
+
+// Returns backoffBase * 2^(attempt-1) capped by backoffMax, with up to 10% of jitter
+func (p *EnrichmentPool) backoff(attempt int) time.Duration {
+	delay := p.backoffBase
+	for i := 1; i < attempt && delay < p.backoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.backoffMax)
+
+	if jitter := int64(delay / 10); jitter > 0 {
+		delay += time.Duration(rand.Int64N(jitter))
+	}
+	return delay
+}
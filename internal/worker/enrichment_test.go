@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/models"
+)
+
+var errUnavailable = errors.New("external api is unavailable")
+
+func testPool(store database.SongStore, enrich EnrichFunc) *EnrichmentPool {
+	return NewEnrichmentPool(store, enrich, config.Config{
+		EnrichmentWorkers:      1,
+		EnrichmentPollInterval: time.Millisecond,
+		EnrichmentLease:        time.Minute,
+		EnrichmentMaxAttempts:  3,
+		EnrichmentBackoffBase:  time.Hour,
+		EnrichmentBackoffMax:   4 * time.Hour,
+	})
+}
+
+// Fake [EnrichFunc] making up lyrics from song names
+func fetchLyrics(ctx context.Context, song models.BasicSongInfo) (*models.AdditionalSongInfo, error) {
+	return &models.AdditionalSongInfo{ReleaseDate: time.Now(), SongLyrics: song.GroupName + " - " + song.SongName}, nil
+}
+
+func addSong(t *testing.T, store database.SongStore, group, name string) int64 {
+	t.Helper()
+	song := models.BasicSongInfo{GroupName: group, SongName: name}
+	err := store.AddSong(context.Background(), &song)
+	if err != nil {
+		t.Fatalf("failed to add %s - %s: %v", group, name, err)
+	}
+	return song.Id
+}
+
+// Claims the only due job, failing the test if there's another number of them
+func claimJob(t *testing.T, p *EnrichmentPool) models.EnrichmentJob {
+	t.Helper()
+	jobs, err := p.store.ClaimEnrichmentJobs(context.Background(), 10, p.lease)
+	if err != nil {
+		t.Fatalf("failed to claim jobs: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("got jobs %+v, want one", jobs)
+	}
+	return jobs[0]
+}
+
+func expectNoDueJobs(t *testing.T, p *EnrichmentPool) {
+	t.Helper()
+	jobs, err := p.store.ClaimEnrichmentJobs(context.Background(), 10, p.lease)
+	if err != nil {
+		t.Fatalf("failed to claim jobs: %v", err)
+	}
+	if len(jobs) != 0 {
+		t.Fatalf("got due jobs %+v, want none", jobs)
+	}
+}
+
+func songOf(t *testing.T, store database.SongStore, songId int64) models.FullSongInfo {
+	t.Helper()
+	rows, err := store.GetFilteredList(context.Background(), &database.ListFilter{Limit: 100})
+	if err != nil {
+		t.Fatalf("failed to list songs: %v", err)
+	}
+	for _, row := range rows {
+		if row.Id == songId {
+			return row
+		}
+	}
+	t.Fatalf("song %d is missing", songId)
+	return models.FullSongInfo{}
+}
+
+func TestEnrichmentPoolEnrichesClaimedSongs(t *testing.T) {
+	store := database.NewMemoryStore()
+	p := testPool(store, fetchLyrics)
+	id := addSong(t, store, "Muse", "Uprising")
+
+	job := claimJob(t, p)
+	if job.SongId != id || job.GroupName != "Muse" || job.SongName != "Uprising" || job.Attempts != 1 {
+		t.Fatalf("got job %+v, want first attempt for Muse - Uprising", job)
+	}
+	// claimed job is leased to its worker
+	expectNoDueJobs(t, p)
+
+	p.process(context.Background(), job)
+
+	song := songOf(t, store, id)
+	if song.EnrichmentStatus != models.EnrichmentEnriched || song.SongLyrics.String != "Muse - Uprising" {
+		t.Errorf("got song %+v, want it enriched", song)
+	}
+	// the job is done and dropped, not just released
+	time.Sleep(time.Millisecond)
+	expectNoDueJobs(t, p)
+}
+
+func TestEnrichmentPoolRetriesWithBackoff(t *testing.T) {
+	store := database.NewMemoryStore()
+	p := testPool(store, func(ctx context.Context, song models.BasicSongInfo) (*models.AdditionalSongInfo, error) {
+		return nil, errUnavailable
+	})
+	p.backoffBase, p.backoffMax = 20*time.Millisecond, 20*time.Millisecond
+	id := addSong(t, store, "Muse", "Uprising")
+
+	p.process(context.Background(), claimJob(t, p))
+	// job is put off for backoffBase
+	expectNoDueJobs(t, p)
+	if song := songOf(t, store, id); song.EnrichmentStatus != models.EnrichmentPending {
+		t.Errorf("got status %q, want it pending until attempts run out", song.EnrichmentStatus)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	job := claimJob(t, p)
+	if job.Attempts != 2 {
+		t.Errorf("got attempt %d, want attempts counted across retries", job.Attempts)
+	}
+}
+
+func TestEnrichmentPoolBackoff(t *testing.T) {
+	p := testPool(database.NewMemoryStore(), nil)
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Hour},
+		{2, 2 * time.Hour},
+		{3, 4 * time.Hour},
+		{10, 4 * time.Hour},
+	}
+	for _, tt := range tests {
+		got := p.backoff(tt.attempt)
+		if got < tt.want || got > tt.want+tt.want/10 {
+			t.Errorf("attempt %d: got delay %s, want %s with up to 10%% of jitter", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestEnrichmentPoolFailsAfterLastAttempt(t *testing.T) {
+	store := database.NewMemoryStore()
+	p := testPool(store, func(ctx context.Context, song models.BasicSongInfo) (*models.AdditionalSongInfo, error) {
+		return nil, errUnavailable
+	})
+	p.backoffBase, p.backoffMax = 0, 0
+	id := addSong(t, store, "Muse", "Uprising")
+
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		job := claimJob(t, p)
+		if job.Attempts != attempt {
+			t.Fatalf("got attempt %d, want %d", job.Attempts, attempt)
+		}
+		p.process(context.Background(), job)
+	}
+
+	expectNoDueJobs(t, p)
+	if song := songOf(t, store, id); song.EnrichmentStatus != models.EnrichmentFailed {
+		t.Errorf("got status %q, want %q", song.EnrichmentStatus, models.EnrichmentFailed)
+	}
+}
+
+func TestEnrichmentPoolSkipsStaleResults(t *testing.T) {
+	ctx := context.Background()
+	store := database.NewMemoryStore()
+	p := testPool(store, nil)
+	p.lease = 20 * time.Millisecond
+	updated := addSong(t, store, "Muse", "Uprising")
+	deleted := addSong(t, store, "Muse", "Starlight")
+	renamed := addSong(t, store, "Muse", "Hysteria")
+
+	// songs change while their details are acquired
+	p.enrich = func(ctx context.Context, song models.BasicSongInfo) (*models.AdditionalSongInfo, error) {
+		var err error
+		switch song.Id {
+		case updated:
+			err = store.UpdateSongInfo(ctx, song.Id, &models.AdditionalSongInfo{SongLyrics: "Paranoia is in bloom"}, 0)
+		case deleted:
+			err = store.DeleteSong(ctx, song.Id, 0)
+		case renamed:
+			name := "Time Is Running Out"
+			err = store.PatchSong(ctx, song.Id, &models.SongPatch{SongName: &name}, 0)
+		}
+		if err != nil {
+			t.Errorf("failed to change song %d: %v", song.Id, err)
+		}
+		return fetchLyrics(ctx, song)
+	}
+
+	jobs, err := store.ClaimEnrichmentJobs(ctx, 10, p.lease)
+	if err != nil || len(jobs) != 3 {
+		t.Fatalf("got jobs %+v and error %v, want every song", jobs, err)
+	}
+	for _, job := range jobs {
+		p.process(ctx, job)
+	}
+
+	if song := songOf(t, store, updated); song.SongLyrics.String != "Paranoia is in bloom" {
+		t.Errorf("got lyrics %q, want the ones given while enriching", song.SongLyrics.String)
+	}
+	var lyrics string
+	if err := store.GetLyrics(ctx, deleted, &lyrics); !errors.Is(err, database.ErrSongNotFound) {
+		t.Errorf("got error %v, want deleted song to stay deleted", err)
+	}
+
+	// details of the old name are dropped, the job is left for the new one
+	if song := songOf(t, store, renamed); song.SongLyrics.Valid || song.EnrichmentStatus != models.EnrichmentPending {
+		t.Errorf("got song %+v, want it waiting for details of the new name", song)
+	}
+	time.Sleep(2 * p.lease)
+	if job := claimJob(t, p); job.SongId != renamed || job.SongName != "Time Is Running Out" {
+		t.Errorf("got job %+v, want renamed song claimed again", job)
+	}
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+)
+
+// Number of keys purged by a single query
+const idempotencyKeyPurgeBatch = 1000
+
+// IdempotencyKeyPurger deletes expired responses of [database.IdempotencyKeys]
+type IdempotencyKeyPurger struct {
+	keys     database.IdempotencyKeys
+	ttl      time.Duration
+	interval time.Duration
+}
+
+func NewIdempotencyKeyPurger(keys database.IdempotencyKeys, cfg config.Config) *IdempotencyKeyPurger {
+	return &IdempotencyKeyPurger{
+		keys:     keys,
+		ttl:      cfg.IdempotencyKeyTTL,
+		interval: cfg.IdempotencyKeyPurgeInterval,
+	}
+}
+
+// Purges expired keys every interval, blocks until ctx is done.
+// Returns right away if ttl is not positive, no keys are saved then
+func (p *IdempotencyKeyPurger) Run(ctx context.Context) {
+	if p.ttl <= 0 {
+		return
+	}
+
+	for {
+		p.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+func (p *IdempotencyKeyPurger) purge(ctx context.Context) {
+	expiredBefore := time.Now()
+
+	var total int64
+	for ctx.Err() == nil {
+		purged, err := p.keys.PurgeIdempotencyKeys(ctx, expiredBefore, idempotencyKeyPurgeBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Zap.Error(fmt.Errorf("failed to purge idempotency keys: %w", err))
+			}
+			break
+		}
+		total += purged
+		if purged < idempotencyKeyPurgeBatch {
+			break
+		}
+	}
+
+	if total > 0 {
+		logger.Zap.Info(fmt.Sprintf("purged %d expired idempotency keys", total))
+	}
+}
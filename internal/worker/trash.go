@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+	"github.com/Scorzoner/effective-mobile-test/internal/logger"
+)
+
+// Number of songs purged by a single query, keeps row locks short on large trash
+const trashPurgeBatch = 500
+
+// Actor recorded in song history for songs purged after retention period
+const trashPurgerActor = "trash-purger"
+
+// TrashPurger permanently deletes songs that stayed in [database.Trash] longer than retention period
+type TrashPurger struct {
+	trash     database.Trash
+	retention time.Duration
+	interval  time.Duration
+}
+
+func NewTrashPurger(trash database.Trash, cfg config.Config) *TrashPurger {
+	return &TrashPurger{
+		trash:     trash,
+		retention: cfg.TrashRetention,
+		interval:  cfg.TrashPurgeInterval,
+	}
+}
+
+// Purges expired songs every interval, blocks until ctx is done.
+// Returns right away if retention is not positive, trash is kept forever then
+func (p *TrashPurger) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		return
+	}
+	ctx = database.WithActor(ctx, trashPurgerActor)
+
+	for {
+		p.purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+func (p *TrashPurger) purge(ctx context.Context) {
+	deletedBefore := time.Now().Add(-p.retention)
+
+	var total int64
+	for ctx.Err() == nil {
+		purged, err := p.trash.PurgeTrash(ctx, deletedBefore, trashPurgeBatch)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Zap.Error(fmt.Errorf("failed to purge trash: %w", err))
+			}
+			break
+		}
+		total += purged
+		if purged < trashPurgeBatch {
+			break
+		}
+	}
+
+	if total > 0 {
+		logger.Zap.Info(fmt.Sprintf("purged %d songs deleted before %s", total, deletedBefore.Format(time.RFC3339)))
+	}
+}
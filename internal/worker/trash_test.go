@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Scorzoner/effective-mobile-test/internal/config"
+	"github.com/Scorzoner/effective-mobile-test/internal/database"
+)
+
+// Trash counting calls of PurgeTrash
+type countingTrash struct {
+	*database.MemoryStore
+	purges int
+}
+
+func (t *countingTrash) PurgeTrash(ctx context.Context, deletedBefore time.Time, limit int) (int64, error) {
+	t.purges++
+	return t.MemoryStore.PurgeTrash(ctx, deletedBefore, limit)
+}
+
+func trashSong(t *testing.T, store database.SongStore, songId int64) {
+	t.Helper()
+	err := store.DeleteSong(context.Background(), songId, 0)
+	if err != nil {
+		t.Fatalf("failed to delete song %d: %v", songId, err)
+	}
+}
+
+func TestTrashPurgerPurgesExpiredSongs(t *testing.T) {
+	store := database.NewMemoryStore()
+	expired := addSong(t, store, "Muse", "Uprising")
+	recent := addSong(t, store, "Muse", "Starlight")
+	kept := addSong(t, store, "Muse", "Hysteria")
+
+	trashSong(t, store, expired)
+	time.Sleep(50 * time.Millisecond)
+	trashSong(t, store, recent)
+
+	p := NewTrashPurger(store, config.Config{TrashRetention: 25 * time.Millisecond})
+	p.purge(context.Background())
+
+	if err := store.RestoreSong(context.Background(), expired); !errors.Is(err, database.ErrSongNotFound) {
+		t.Errorf("got error %v, want expired song purged", err)
+	}
+	// songs deleted within retention period can still be restored
+	if err := store.RestoreSong(context.Background(), recent); err != nil {
+		t.Errorf("failed to restore recently deleted song: %v", err)
+	}
+	songOf(t, store, kept)
+}
+
+func TestTrashPurgerPurgesInBatches(t *testing.T) {
+	store := database.NewMemoryStore()
+	for i := 0; i < trashPurgeBatch+1; i++ {
+		trashSong(t, store, addSong(t, store, "Muse", fmt.Sprintf("Song %d", i)))
+	}
+	trash := &countingTrash{MemoryStore: store}
+
+	p := NewTrashPurger(trash, config.Config{TrashRetention: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	p.purge(context.Background())
+
+	if trash.purges != 2 {
+		t.Errorf("got %d purges, want a full batch and the rest", trash.purges)
+	}
+	if purged, _ := store.PurgeTrash(context.Background(), time.Now(), trashPurgeBatch); purged != 0 {
+		t.Errorf("%d songs were left in trash", purged)
+	}
+}
+
+func TestTrashPurgerKeepsTrashWithoutRetention(t *testing.T) {
+	store := database.NewMemoryStore()
+	id := addSong(t, store, "Muse", "Uprising")
+	trashSong(t, store, id)
+	trash := &countingTrash{MemoryStore: store}
+
+	done := make(chan struct{})
+	go func() {
+		NewTrashPurger(trash, config.Config{TrashPurgeInterval: time.Millisecond}).Run(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purger without retention is still running")
+	}
+	if trash.purges != 0 {
+		t.Errorf("got %d purges, want trash kept forever", trash.purges)
+	}
+}